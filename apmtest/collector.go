@@ -0,0 +1,406 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apmtest
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/solarwinds/apm-go/swo"
+	pb "github.com/solarwinds/apm-proto/go/collectorpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The methods of the TraceCollector service
+const (
+	MethodPostEvents  = "PostEvents"
+	MethodPostMetrics = "PostMetrics"
+	MethodPostStatus  = "PostStatus"
+	MethodGetSettings = "GetSettings"
+	MethodPing        = "Ping"
+)
+
+// DefaultServiceKey is the service key returned by Collector.ServiceKey unless
+// it's changed by SetServiceKey.
+const DefaultServiceKey = "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:apmtest"
+
+// Result is the response of the collector to a request.
+type Result struct {
+	Code pb.ResultCode
+	// The new address of the collector for a REDIRECT
+	Arg string
+	// The user-facing warning message, if any
+	Warning string
+}
+
+// OK is the result of a successful request.
+var OK = Result{Code: pb.ResultCode_OK}
+
+// TryLater asks the agent to retry the request later.
+func TryLater() Result { return Result{Code: pb.ResultCode_TRY_LATER} }
+
+// LimitExceeded tells the agent that it exceeded the rate limit.
+func LimitExceeded() Result { return Result{Code: pb.ResultCode_LIMIT_EXCEEDED} }
+
+// InvalidAPIKey rejects the service key of the agent, which shuts it down.
+func InvalidAPIKey() Result { return Result{Code: pb.ResultCode_INVALID_API_KEY} }
+
+// RedirectTo redirects the agent to the collector at addr, e.g., the Addr of
+// another Collector.
+func RedirectTo(addr string) Result { return Result{Code: pb.ResultCode_REDIRECT, Arg: addr} }
+
+// Message is a BSON message, i.e., an event, a metrics or a status message,
+// decoded into a map. Nested documents are decoded as bson.M and arrays as
+// []interface{}.
+type Message map[string]interface{}
+
+// Request is a request received by the collector.
+type Request struct {
+	// One of the Method constants
+	Method string
+	APIKey string
+	// The host identity of the agent, nil for Ping
+	Identity *pb.HostID
+	// The decoded messages of PostEvents, PostMetrics and PostStatus
+	Messages []Message
+	// The result returned to the agent
+	Result Result
+}
+
+// Collector is a fake collector which runs an in-process gRPC server with TLS.
+// It's safe for concurrent use.
+type Collector struct {
+	tb       testing.TB
+	server   *grpc.Server
+	addr     string
+	certFile string
+
+	mu         sync.Mutex
+	serviceKey string
+	requests   map[string][]Request
+	scripted   map[string][]Result
+	rejected   map[string]bool
+	settings   []Setting
+	queued     [][]Setting
+	// closed and replaced on every request to wake up Wait
+	notify chan struct{}
+
+	pb.UnimplementedTraceCollectorServer
+}
+
+// NewCollector starts a collector listening on a random port of localhost. It's
+// stopped when the test and all its subtests complete.
+func NewCollector(tb testing.TB) *Collector {
+	tb.Helper()
+	certPEM, tlsCert, err := certificate()
+	if err != nil {
+		tb.Fatalf("apmtest: failed to generate the certificate: %v", err)
+	}
+	certFile := filepath.Join(tb.TempDir(), "apmtest.crt")
+	if err = os.WriteFile(certFile, certPEM, 0644); err != nil {
+		tb.Fatalf("apmtest: failed to write the certificate: %v", err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("apmtest: failed to listen: %v", err)
+	}
+
+	c := &Collector{
+		tb:         tb,
+		addr:       net.JoinHostPort("localhost", strconv.Itoa(lis.Addr().(*net.TCPAddr).Port)),
+		certFile:   certFile,
+		serviceKey: DefaultServiceKey,
+		requests:   make(map[string][]Request),
+		scripted:   make(map[string][]Result),
+		rejected:   make(map[string]bool),
+		settings:   []Setting{DefaultSetting()},
+		notify:     make(chan struct{}),
+	}
+	c.server = grpc.NewServer(grpc.Creds(credentials.NewServerTLSFromCert(&tlsCert)))
+	pb.RegisterTraceCollectorServer(c.server, c)
+	go func() { _ = c.server.Serve(lis) }()
+	tb.Cleanup(c.Close)
+	return c
+}
+
+// Close stops the collector and closes the connections of the agents. It's
+// called automatically when the test completes.
+func (c *Collector) Close() {
+	c.server.Stop()
+}
+
+// Addr returns the address of the collector in the form of localhost:port.
+func (c *Collector) Addr() string {
+	return c.addr
+}
+
+// CertFile returns the path of the PEM-encoded certificate of the collector,
+// which is the trusted path of the agent.
+func (c *Collector) CertFile() string {
+	return c.certFile
+}
+
+// ServiceKey returns the service key used by Options and Setenv.
+func (c *Collector) ServiceKey() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.serviceKey
+}
+
+// SetServiceKey changes the service key used by Options and Setenv. It doesn't
+// affect the keys accepted by the collector, see RejectServiceKey.
+func (c *Collector) SetServiceKey(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.serviceKey = key
+}
+
+// Options returns the options pointing the agent to the collector, which can
+// be passed to swo.StartWithOptions or swo.NewAgent along with other options.
+func (c *Collector) Options() []swo.Option {
+	return []swo.Option{
+		swo.WithCollector(c.addr),
+		swo.WithTrustedPath(c.certFile),
+		swo.WithServiceKey(c.ServiceKey()),
+	}
+}
+
+// Setenv points the agent to the collector via the environment variables, for
+// the tests calling swo.Start. The variables are restored when the test
+// completes.
+func (c *Collector) Setenv(t testing.TB) {
+	t.Setenv("SW_APM_COLLECTOR", c.addr)
+	t.Setenv("SW_APM_TRUSTEDPATH", c.certFile)
+	t.Setenv("SW_APM_SERVICE_KEY", c.ServiceKey())
+}
+
+// Respond queues the results of the next requests of method, one result per
+// request. The requests after the queue is drained succeed.
+func (c *Collector) Respond(method string, results ...Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scripted[method] = append(c.scripted[method], results...)
+}
+
+// RejectServiceKey makes the collector respond INVALID_API_KEY to all the
+// requests with the service key.
+func (c *Collector) RejectServiceKey(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rejected[key] = true
+}
+
+// SetSettings sets the settings returned by GetSettings, which is
+// DefaultSetting initially. No settings are returned if it's called without
+// arguments, which leaves the agent not ready.
+func (c *Collector) SetSettings(settings ...Setting) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.settings = settings
+}
+
+// QueueSettings queues the settings returned by the next GetSettings request,
+// after which the ones set by SetSettings are returned again. It can be called
+// multiple times to queue the settings of the next requests.
+func (c *Collector) QueueSettings(settings ...Setting) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.queued = append(c.queued, settings)
+}
+
+// Requests returns the requests of method received so far, including the
+// failed ones.
+func (c *Collector) Requests(method string) []Request {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Request(nil), c.requests[method]...)
+}
+
+// Events returns the events received so far by successful PostEvents requests.
+func (c *Collector) Events() []Message {
+	return c.messages(MethodPostEvents)
+}
+
+// TraceEvents returns the events received so far by successful PostEvents
+// requests, decoded into Events. The messages which are not events are
+// reported as test errors.
+func (c *Collector) TraceEvents() []*Event {
+	var events []*Event
+	for _, m := range c.Events() {
+		e, err := NewEvent(m)
+		if err != nil {
+			c.tb.Errorf("apmtest: %v", err)
+			continue
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// Traces returns the traces rebuilt from the events received so far, see
+// BuildTraces.
+func (c *Collector) Traces() []*Trace {
+	return BuildTraces(c.TraceEvents())
+}
+
+// Metrics returns the metrics messages received so far by successful
+// PostMetrics requests.
+func (c *Collector) Metrics() []Message {
+	return c.messages(MethodPostMetrics)
+}
+
+// Status returns the status messages, e.g., the init message, received so far
+// by successful PostStatus requests.
+func (c *Collector) Status() []Message {
+	return c.messages(MethodPostStatus)
+}
+
+func (c *Collector) messages(method string) []Message {
+	var msgs []Message
+	for _, req := range c.Requests(method) {
+		if req.Result.Code == pb.ResultCode_OK {
+			msgs = append(msgs, req.Messages...)
+		}
+	}
+	return msgs
+}
+
+// Reset clears the requests received so far. The scripted results and
+// settings are kept.
+func (c *Collector) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = make(map[string][]Request)
+}
+
+// Wait waits until at least n requests of method are received, including the
+// failed ones, and returns them. It returns the requests received so far with
+// the error of ctx if ctx is done first.
+func (c *Collector) Wait(ctx context.Context, method string, n int) ([]Request, error) {
+	for {
+		c.mu.Lock()
+		reqs := append([]Request(nil), c.requests[method]...)
+		notify := c.notify
+		c.mu.Unlock()
+		if len(reqs) >= n {
+			return reqs, nil
+		}
+		select {
+		case <-ctx.Done():
+			return reqs, ctx.Err()
+		case <-notify:
+		}
+	}
+}
+
+// result pops the scripted result of the method.
+func (c *Collector) result(method, key string) Result {
+	if c.rejected[key] {
+		return InvalidAPIKey()
+	}
+	if q := c.scripted[method]; len(q) > 0 {
+		c.scripted[method] = q[1:]
+		return q[0]
+	}
+	return OK
+}
+
+// record records the request and wakes up the waiters.
+func (c *Collector) record(req Request) {
+	c.requests[req.Method] = append(c.requests[req.Method], req)
+	close(c.notify)
+	c.notify = make(chan struct{})
+}
+
+func (c *Collector) postMessages(method string, req *pb.MessageRequest) (*pb.MessageResult, error) {
+	msgs := make([]Message, 0, len(req.Messages))
+	for _, b := range req.Messages {
+		m := Message{}
+		if err := bson.Unmarshal(b, &m); err != nil {
+			c.tb.Errorf("apmtest: failed to decode the BSON message of %s: %v", method, err)
+			continue
+		}
+		msgs = append(msgs, m)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := c.result(method, req.ApiKey)
+	c.record(Request{
+		Method:   method,
+		APIKey:   req.ApiKey,
+		Identity: req.Identity,
+		Messages: msgs,
+		Result:   res,
+	})
+	return &pb.MessageResult{Result: res.Code, Arg: res.Arg, Warning: res.Warning}, nil
+}
+
+// PostEvents implements the TraceCollectorServer interface.
+func (c *Collector) PostEvents(_ context.Context, req *pb.MessageRequest) (*pb.MessageResult, error) {
+	return c.postMessages(MethodPostEvents, req)
+}
+
+// PostMetrics implements the TraceCollectorServer interface.
+func (c *Collector) PostMetrics(_ context.Context, req *pb.MessageRequest) (*pb.MessageResult, error) {
+	return c.postMessages(MethodPostMetrics, req)
+}
+
+// PostStatus implements the TraceCollectorServer interface.
+func (c *Collector) PostStatus(_ context.Context, req *pb.MessageRequest) (*pb.MessageResult, error) {
+	return c.postMessages(MethodPostStatus, req)
+}
+
+// GetSettings implements the TraceCollectorServer interface.
+func (c *Collector) GetSettings(_ context.Context, req *pb.SettingsRequest) (*pb.SettingsResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := c.result(MethodGetSettings, req.ApiKey)
+	c.record(Request{
+		Method:   MethodGetSettings,
+		APIKey:   req.ApiKey,
+		Identity: req.Identity,
+		Result:   res,
+	})
+	sr := &pb.SettingsResult{Result: res.Code, Arg: res.Arg, Warning: res.Warning}
+	if res.Code != pb.ResultCode_OK {
+		return sr, nil
+	}
+	settings := c.settings
+	if len(c.queued) > 0 {
+		settings = c.queued[0]
+		c.queued = c.queued[1:]
+	}
+	for _, s := range settings {
+		sr.Settings = append(sr.Settings, s.proto())
+	}
+	return sr, nil
+}
+
+// Ping implements the TraceCollectorServer interface.
+func (c *Collector) Ping(_ context.Context, req *pb.PingRequest) (*pb.MessageResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := c.result(MethodPing, req.ApiKey)
+	c.record(Request{Method: MethodPing, APIKey: req.ApiKey, Result: res})
+	return &pb.MessageResult{Result: res.Code, Arg: res.Arg, Warning: res.Warning}, nil
+}
@@ -0,0 +1,169 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apmtest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/solarwinds/apm-go/apmtest"
+	"github.com/solarwinds/apm-go/swo"
+	pb "github.com/solarwinds/apm-proto/go/collectorpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newAgent(t *testing.T, c *apmtest.Collector) *swo.Agent {
+	a, err := swo.NewAgent(append(c.Options(),
+		swo.WithReporterProperties(func(r *swo.ReporterOptions) {
+			r.SetEventFlushInterval(1)
+		}),
+	)...)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = a.Shutdown(context.Background()) })
+	return a
+}
+
+func TestCollector(t *testing.T) {
+	c := apmtest.NewCollector(t)
+	a := newAgent(t, c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.True(t, a.WaitForReady(ctx))
+
+	_, span := a.TracerProvider().Tracer("apmtest").Start(ctx, "span")
+	require.True(t, span.SpanContext().IsSampled())
+	span.End()
+	require.NoError(t, a.Flush(ctx))
+
+	reqs, err := c.Wait(ctx, apmtest.MethodPostEvents, 1)
+	require.NoError(t, err)
+	assert.Equal(t, c.ServiceKey(), reqs[0].APIKey)
+	assert.NotEmpty(t, reqs[0].Identity.GetHostname())
+
+	events := c.Events()
+	require.NotEmpty(t, events)
+	assert.Equal(t, "entry", events[0]["Label"])
+	assert.Equal(t, "span", events[0]["TransactionName"])
+	assert.Contains(t, events[0]["sw.trace_context"], span.SpanContext().TraceID().String())
+
+	_, err = c.Wait(ctx, apmtest.MethodPostStatus, 1)
+	require.NoError(t, err)
+	require.NotEmpty(t, c.Status())
+	assert.Equal(t, true, c.Status()[0]["__Init"])
+
+	c.Reset()
+	assert.Empty(t, c.Events())
+}
+
+func TestCollectorTraces(t *testing.T) {
+	c := apmtest.NewCollector(t)
+	a := newAgent(t, c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.True(t, a.WaitForReady(ctx))
+
+	tr := a.TracerProvider().Tracer("apmtest")
+	sctx, server := tr.Start(ctx, "GET /users", trace.WithSpanKind(trace.SpanKindServer))
+	_, query := tr.Start(sctx, "query", trace.WithAttributes(attribute.String("db.system", "postgresql")))
+	query.RecordError(errors.New("no rows"))
+	query.End()
+	server.End()
+	require.NoError(t, a.Flush(ctx))
+
+	require.Eventually(t, func() bool {
+		return len(c.Traces()) == 1 && len(c.Traces()[0].Spans()) == 2
+	}, 10*time.Second, 50*time.Millisecond)
+	traces := c.Traces()
+	root := apmtest.RequireSpan(t, traces, apmtest.Root(), apmtest.WithKind("server"))
+	assert.Equal(t, "GET /users", root.TransactionName())
+	q := apmtest.RequireSpan(t, traces, apmtest.WithErrorEvent())
+	assert.Same(t, root, q.Parent)
+	assert.Equal(t, "query", q.Name())
+	assert.Equal(t, "no rows", q.ErrorEvents()[0].StringKV("ErrorMsg"))
+	apmtest.AssertSpan(t, traces, apmtest.WithAttributeValue("db.system", "postgresql"), apmtest.ChildOf(apmtest.WithTransactionName("GET /users")))
+}
+
+func TestCollectorSettings(t *testing.T) {
+	c := apmtest.NewCollector(t)
+	s := apmtest.DefaultSetting()
+	s.SampleRate = 0
+	s.Flags = apmtest.FlagSampleStart
+	c.QueueSettings(s)
+	a := newAgent(t, c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.True(t, a.WaitForReady(ctx))
+
+	_, span := a.TracerProvider().Tracer("apmtest").Start(ctx, "span")
+	assert.False(t, span.SpanContext().IsSampled())
+	span.End()
+}
+
+func TestCollectorNoSettings(t *testing.T) {
+	c := apmtest.NewCollector(t)
+	c.SetSettings()
+	a := newAgent(t, c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.False(t, a.WaitForReady(ctx))
+	assert.NotEmpty(t, c.Requests(apmtest.MethodGetSettings))
+}
+
+func TestCollectorTryLater(t *testing.T) {
+	c := apmtest.NewCollector(t)
+	c.Respond(apmtest.MethodPostStatus, apmtest.TryLater())
+	newAgent(t, c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	reqs, err := c.Wait(ctx, apmtest.MethodPostStatus, 2)
+	require.NoError(t, err)
+	assert.Equal(t, pb.ResultCode_TRY_LATER, reqs[0].Result.Code)
+	assert.Equal(t, pb.ResultCode_OK, reqs[1].Result.Code)
+	assert.Equal(t, reqs[0].Messages, reqs[1].Messages)
+	assert.Len(t, c.Status(), 1)
+}
+
+func TestCollectorRedirect(t *testing.T) {
+	c, other := apmtest.NewCollector(t), apmtest.NewCollector(t)
+	c.Respond(apmtest.MethodGetSettings, apmtest.RedirectTo(other.Addr()))
+	a := newAgent(t, c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.True(t, a.WaitForReady(ctx))
+	reqs, err := other.Wait(ctx, apmtest.MethodGetSettings, 1)
+	require.NoError(t, err)
+	assert.Equal(t, pb.ResultCode_OK, reqs[0].Result.Code)
+	assert.Equal(t, pb.ResultCode_REDIRECT, c.Requests(apmtest.MethodGetSettings)[0].Result.Code)
+}
+
+func TestCollectorInvalidKey(t *testing.T) {
+	c := apmtest.NewCollector(t)
+	c.RejectServiceKey(c.ServiceKey())
+	a := newAgent(t, c)
+
+	require.Eventually(t, a.Closed, 10*time.Second, 50*time.Millisecond)
+	assert.Equal(t, pb.ResultCode_INVALID_API_KEY, c.Requests(apmtest.MethodGetSettings)[0].Result.Code)
+}
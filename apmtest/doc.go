@@ -0,0 +1,39 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package apmtest provides a fake SolarWinds Observability collector for
+// integration tests. It runs an in-process gRPC server which implements the
+// TraceCollector service, records the requests with the BSON messages decoded,
+// and serves sampling settings which are scripted by the test. It can also
+// simulate the error responses of the collector, e.g., TRY_LATER, redirects
+// and invalid service keys.
+//
+// A typical test starts the agent against the collector and inspects the
+// requests it received:
+//
+//	c := apmtest.NewCollector(t)
+//	cb, err := swo.StartWithOptions(c.Options()...)
+//	require.NoError(t, err)
+//	defer cb()
+//	...
+//	_, err = c.Wait(ctx, apmtest.MethodPostEvents, 1)
+//
+// The events are decoded into Events, and the traces are rebuilt from them
+// with the spans linked by sw.parent_span_id, so that a test can look for the
+// spans with the matchers:
+//
+//	traces := c.Traces()
+//	span := apmtest.RequireSpan(t, traces, apmtest.Root(), apmtest.WithTransactionName("GET /users"))
+//	apmtest.AssertNoSpan(t, traces, apmtest.WithErrorEvent())
+package apmtest
@@ -0,0 +1,151 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apmtest
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The labels of the events
+const (
+	LabelEntry = "entry"
+	LabelExit  = "exit"
+	LabelInfo  = "info"
+	LabelError = "error"
+)
+
+// the keys of the event fields which are not KVs
+const (
+	keyTraceContext = "sw.trace_context"
+	keyXTrace       = "X-Trace"
+	keyTimestamp    = "Timestamp_u"
+	keyHostname     = "Hostname"
+	keyPID          = "PID"
+	keyLabel        = "Label"
+	keyLayer        = "Layer"
+	keyEdge         = "Edge"
+	keyParentSpanID = "sw.parent_span_id"
+)
+
+// Event is an event decoded from the BSON sent by the agent. There are an entry
+// and an exit event for each span, with the info and error events of the span
+// in between.
+type Event struct {
+	// One of the Label constants
+	Label string
+	// The layer in the form of <span kind>:<span name> of the entry event,
+	// which is empty for the info and error events
+	Layer string
+	// The lowercase hex trace ID
+	TraceID string
+	// The lowercase hex span ID of the entry event, or the random operation
+	// ID of the other events
+	SpanID string
+	// The lowercase hex span ID of the parent span of the entry event, or the
+	// span of the other events. It's empty for the root span.
+	ParentSpanID string
+	// The uppercase hex ParentSpanID
+	Edge      string
+	Timestamp time.Time
+	Hostname  string
+	PID       int
+	// The other key-values of the event, e.g., the span attributes, which are
+	// decoded as string, bool, int, int64, float64 or []interface{}
+	KVs map[string]interface{}
+}
+
+// DecodeEvent decodes an event from the BSON sent by the agent.
+func DecodeEvent(b []byte) (*Event, error) {
+	m := Message{}
+	if err := bson.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return NewEvent(m)
+}
+
+// NewEvent converts a decoded message to an event. It returns an error if the
+// message isn't an event, e.g., it's a metrics message.
+func NewEvent(m Message) (*Event, error) {
+	tc, ok := m[keyTraceContext].(string)
+	if !ok {
+		return nil, fmt.Errorf("not an event: %s is missing", keyTraceContext)
+	}
+	// 00-<trace id>-<span id>-<flags>
+	parts := strings.Split(tc, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return nil, fmt.Errorf("invalid %s: %s", keyTraceContext, tc)
+	}
+
+	e := &Event{
+		TraceID: parts[1],
+		SpanID:  parts[2],
+		KVs:     make(map[string]interface{}),
+	}
+	for k, v := range m {
+		switch k {
+		case keyTraceContext, keyXTrace:
+		case keyTimestamp:
+			if us, ok := toInt64(v); ok {
+				e.Timestamp = time.UnixMicro(us)
+			}
+		case keyHostname:
+			e.Hostname, _ = v.(string)
+		case keyPID:
+			pid, _ := toInt64(v)
+			e.PID = int(pid)
+		case keyLabel:
+			e.Label, _ = v.(string)
+		case keyLayer:
+			// the exit event carries the layer of its span as well
+			e.Layer, _ = v.(string)
+		case keyEdge:
+			e.Edge, _ = v.(string)
+		case keyParentSpanID:
+			e.ParentSpanID, _ = v.(string)
+		default:
+			e.KVs[k] = v
+		}
+	}
+	return e, nil
+}
+
+// KV returns the value of a key-value of the event.
+func (e *Event) KV(key string) (interface{}, bool) {
+	v, ok := e.KVs[key]
+	return v, ok
+}
+
+// StringKV returns the value of a string key-value, or an empty string if it's
+// absent or not a string.
+func (e *Event) StringKV(key string) string {
+	s, _ := e.KVs[key].(string)
+	return s
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	}
+	return 0, false
+}
@@ -0,0 +1,189 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apmtest
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Matcher is a condition on a span, used by FindSpans and the assertions.
+type Matcher interface {
+	Match(s *Span) bool
+	// String describes the condition for failure messages.
+	String() string
+}
+
+type matcher struct {
+	desc string
+	fn   func(s *Span) bool
+}
+
+func (m matcher) Match(s *Span) bool { return m.fn(s) }
+func (m matcher) String() string     { return m.desc }
+
+// MatchFunc creates a matcher from a function, with desc describing it.
+func MatchFunc(desc string, fn func(s *Span) bool) Matcher {
+	return matcher{desc: desc, fn: fn}
+}
+
+// WithName matches the spans with the name.
+func WithName(name string) Matcher {
+	return MatchFunc(fmt.Sprintf("name %q", name), func(s *Span) bool {
+		return s.Name() == name
+	})
+}
+
+// WithKind matches the spans of the kind, e.g., "server".
+func WithKind(kind string) Matcher {
+	return MatchFunc(fmt.Sprintf("kind %q", kind), func(s *Span) bool {
+		return s.Kind() == kind
+	})
+}
+
+// WithTransactionName matches the entry spans with the transaction name.
+func WithTransactionName(name string) Matcher {
+	return MatchFunc(fmt.Sprintf("transaction name %q", name), func(s *Span) bool {
+		return s.TransactionName() == name
+	})
+}
+
+// WithAttribute matches the spans which have the attribute, with any value.
+func WithAttribute(key string) Matcher {
+	return MatchFunc(fmt.Sprintf("attribute %q", key), func(s *Span) bool {
+		_, ok := s.Attribute(key)
+		return ok
+	})
+}
+
+// WithAttributeValue matches the spans whose attribute has the value. The
+// integers are compared regardless of their sizes, e.g., int(1) matches the
+// attribute set by attribute.Int64("key", 1).
+func WithAttributeValue(key string, value interface{}) Matcher {
+	return MatchFunc(fmt.Sprintf("attribute %q = %v", key, value), func(s *Span) bool {
+		v, ok := s.Attribute(key)
+		return ok && valuesEqual(v, value)
+	})
+}
+
+// WithErrorEvent matches the spans with at least one error event.
+func WithErrorEvent() Matcher {
+	return MatchFunc("an error event", func(s *Span) bool {
+		return len(s.ErrorEvents()) > 0
+	})
+}
+
+// WithErrorStatus matches the spans with the Error status.
+func WithErrorStatus() Matcher {
+	return WithAttributeValue("otel.status_code", "ERROR")
+}
+
+// Root matches the spans without a parent in the trace.
+func Root() Matcher {
+	return MatchFunc("root", func(s *Span) bool {
+		return s.Parent == nil
+	})
+}
+
+// ChildOf matches the spans whose parent satisfies all the matchers.
+func ChildOf(matchers ...Matcher) Matcher {
+	return MatchFunc(fmt.Sprintf("child of (%s)", describe(matchers)), func(s *Span) bool {
+		return s.Parent != nil && matchAll(s.Parent, matchers)
+	})
+}
+
+// RequireSpan asserts that exactly one span of the traces satisfies all the
+// matchers and returns it. It fails the test immediately otherwise.
+func RequireSpan(tb testing.TB, traces []*Trace, matchers ...Matcher) *Span {
+	tb.Helper()
+	found := FindSpans(traces, matchers...)
+	if len(found) != 1 {
+		tb.Fatalf("apmtest: expected 1 span with %s, found %d in:\n%s",
+			describe(matchers), len(found), dump(traces))
+	}
+	return found[0]
+}
+
+// AssertSpan is like RequireSpan, but it only marks the test as failed and
+// returns nil if there isn't exactly one span.
+func AssertSpan(tb testing.TB, traces []*Trace, matchers ...Matcher) *Span {
+	tb.Helper()
+	found := FindSpans(traces, matchers...)
+	if len(found) != 1 {
+		tb.Errorf("apmtest: expected 1 span with %s, found %d in:\n%s",
+			describe(matchers), len(found), dump(traces))
+		return nil
+	}
+	return found[0]
+}
+
+// AssertNoSpan asserts that none of the spans satisfies all the matchers.
+func AssertNoSpan(tb testing.TB, traces []*Trace, matchers ...Matcher) bool {
+	tb.Helper()
+	if found := FindSpans(traces, matchers...); len(found) > 0 {
+		tb.Errorf("apmtest: expected no span with %s, found %v", describe(matchers), found)
+		return false
+	}
+	return true
+}
+
+func matchAll(s *Span, matchers []Matcher) bool {
+	for _, m := range matchers {
+		if !m.Match(s) {
+			return false
+		}
+	}
+	return true
+}
+
+func describe(matchers []Matcher) string {
+	if len(matchers) == 0 {
+		return "anything"
+	}
+	descs := make([]string, len(matchers))
+	for i, m := range matchers {
+		descs[i] = m.String()
+	}
+	return strings.Join(descs, " and ")
+}
+
+// dump prints the trees of the traces.
+func dump(traces []*Trace) string {
+	var sb strings.Builder
+	for _, t := range traces {
+		fmt.Fprintf(&sb, "trace %s\n", t.TraceID)
+		for _, r := range t.Roots {
+			dumpSpan(&sb, r, 1)
+		}
+	}
+	return sb.String()
+}
+
+func dumpSpan(sb *strings.Builder, s *Span, depth int) {
+	fmt.Fprintf(sb, "%s%s\n", strings.Repeat("  ", depth), s)
+	for _, c := range s.Children {
+		dumpSpan(sb, c, depth+1)
+	}
+}
+
+func valuesEqual(a, b interface{}) bool {
+	if x, ok := toInt64(a); ok {
+		y, ok := toInt64(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,67 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apmtest
+
+import (
+	"context"
+	"os"
+
+	"github.com/solarwinds/apm-go/internal/reporter"
+	pb "github.com/solarwinds/apm-proto/go/collectorpb"
+	"google.golang.org/grpc"
+)
+
+// ReplayCapture replays a capture file, which is written by an agent with
+// swo.WithCaptureFile, into the collector without a network connection. The
+// records are replayed as fast as possible, with the captured service keys.
+// Use the swo-replay command to replay a capture against a remote collector.
+func (c *Collector) ReplayCapture(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := reporter.NewCaptureReader(f)
+	if err != nil {
+		return err
+	}
+	_, err = reporter.Replay(ctx, directClient{c}, r, reporter.ReplayOptions{})
+	return err
+}
+
+// directClient calls the methods of the collector directly.
+type directClient struct {
+	c *Collector
+}
+
+func (d directClient) PostEvents(ctx context.Context, in *pb.MessageRequest, _ ...grpc.CallOption) (*pb.MessageResult, error) {
+	return d.c.PostEvents(ctx, in)
+}
+
+func (d directClient) PostMetrics(ctx context.Context, in *pb.MessageRequest, _ ...grpc.CallOption) (*pb.MessageResult, error) {
+	return d.c.PostMetrics(ctx, in)
+}
+
+func (d directClient) PostStatus(ctx context.Context, in *pb.MessageRequest, _ ...grpc.CallOption) (*pb.MessageResult, error) {
+	return d.c.PostStatus(ctx, in)
+}
+
+func (d directClient) GetSettings(ctx context.Context, in *pb.SettingsRequest, _ ...grpc.CallOption) (*pb.SettingsResult, error) {
+	return d.c.GetSettings(ctx, in)
+}
+
+func (d directClient) Ping(ctx context.Context, in *pb.PingRequest, _ ...grpc.CallOption) (*pb.MessageResult, error) {
+	return d.c.Ping(ctx, in)
+}
@@ -0,0 +1,49 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apmtest_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/solarwinds/apm-go/apmtest"
+	"github.com/solarwinds/apm-go/swo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayCapture(t *testing.T) {
+	c := apmtest.NewCollector(t)
+	path := filepath.Join(t.TempDir(), "swo.capture")
+	a, err := swo.NewAgent(append(c.Options(), swo.WithCaptureFile(path))...)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.True(t, a.WaitForReady(ctx))
+	_, span := a.TracerProvider().Tracer("apmtest").Start(ctx, "captured")
+	span.End()
+	require.NoError(t, a.Flush(ctx))
+	require.NoError(t, a.Shutdown(ctx))
+
+	// replay the capture into another collector
+	other := apmtest.NewCollector(t)
+	require.NoError(t, other.ReplayCapture(ctx, path))
+	assert.Equal(t, c.Events(), other.Events())
+	assert.Equal(t, c.Status(), other.Status())
+	apmtest.RequireSpan(t, other.Traces(), apmtest.WithName("captured"))
+}
@@ -0,0 +1,117 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apmtest
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+
+	pb "github.com/solarwinds/apm-proto/go/collectorpb"
+)
+
+// The flags of a setting, which are joined by commas in Setting.Flags
+const (
+	FlagOverride            = "OVERRIDE"
+	FlagSampleStart         = "SAMPLE_START"
+	FlagSampleThrough       = "SAMPLE_THROUGH"
+	FlagSampleThroughAlways = "SAMPLE_THROUGH_ALWAYS"
+	FlagTriggerTrace        = "TRIGGER_TRACE"
+)
+
+// Setting is a sampling setting returned by GetSettings. The zero values of the
+// optional fields are not sent.
+type Setting struct {
+	// The sample rate, 1000000 means 100%
+	SampleRate int64
+	// The flags joined by commas, e.g., "SAMPLE_START,SAMPLE_THROUGH_ALWAYS"
+	Flags string
+	// Time to live in seconds
+	TTL int64
+
+	BucketCapacity               float64
+	BucketRate                   float64
+	TriggerRelaxedBucketCapacity float64
+	TriggerRelaxedBucketRate     float64
+	TriggerStrictBucketCapacity  float64
+	TriggerStrictBucketRate      float64
+
+	// The metrics flush interval in seconds
+	MetricsFlushInterval int32
+	// The events flush interval in seconds
+	EventsFlushInterval int32
+	// The maximum number of transactions and custom metrics per flush
+	MaxTransactions  int32
+	MaxCustomMetrics int32
+	// The key of the X-Trace-Options signatures
+	SignatureKey string
+}
+
+// DefaultSetting returns the setting which samples every request and allows
+// trigger traces, with the token buckets large enough not to limit a test.
+func DefaultSetting() Setting {
+	return Setting{
+		SampleRate:                   1000000,
+		Flags:                        FlagSampleStart + "," + FlagSampleThroughAlways + "," + FlagTriggerTrace,
+		TTL:                          120,
+		BucketCapacity:               1000000,
+		BucketRate:                   1000000,
+		TriggerRelaxedBucketCapacity: 1000000,
+		TriggerRelaxedBucketRate:     1000000,
+		TriggerStrictBucketCapacity:  1000000,
+		TriggerStrictBucketRate:      1000000,
+	}
+}
+
+func (s Setting) proto() *pb.OboeSetting {
+	args := make(map[string][]byte)
+	for k, v := range map[string]float64{
+		"BucketCapacity":               s.BucketCapacity,
+		"BucketRate":                   s.BucketRate,
+		"TriggerRelaxedBucketCapacity": s.TriggerRelaxedBucketCapacity,
+		"TriggerRelaxedBucketRate":     s.TriggerRelaxedBucketRate,
+		"TriggerStrictBucketCapacity":  s.TriggerStrictBucketCapacity,
+		"TriggerStrictBucketRate":      s.TriggerStrictBucketRate,
+	} {
+		if v != 0 {
+			b := make([]byte, 8)
+			binary.LittleEndian.PutUint64(b, math.Float64bits(v))
+			args[k] = b
+		}
+	}
+	for k, v := range map[string]int32{
+		"MetricsFlushInterval": s.MetricsFlushInterval,
+		"EventsFlushInterval":  s.EventsFlushInterval,
+		"MaxTransactions":      s.MaxTransactions,
+		"MaxCustomMetrics":     s.MaxCustomMetrics,
+	} {
+		if v != 0 {
+			b := make([]byte, 4)
+			binary.LittleEndian.PutUint32(b, uint32(v))
+			args[k] = b
+		}
+	}
+	if s.SignatureKey != "" {
+		args["SignatureKey"] = []byte(s.SignatureKey)
+	}
+	return &pb.OboeSetting{
+		Type:      pb.OboeSettingType_DEFAULT_SAMPLE_RATE,
+		Flags:     []byte(s.Flags),
+		Timestamp: time.Now().Unix(),
+		Value:     s.SampleRate,
+		Arguments: args,
+		Ttl:       s.TTL,
+	}
+}
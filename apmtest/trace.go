@@ -0,0 +1,165 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apmtest
+
+import (
+	"fmt"
+)
+
+// Span is a span rebuilt from its events.
+type Span struct {
+	Entry *Event
+	// The exit event, which is nil if it hasn't been received
+	Exit *Event
+	// The info and error events of the span in the order of receipt
+	Events []*Event
+	// The parent span, which is nil for the roots of a trace
+	Parent   *Span
+	Children []*Span
+}
+
+// SpanID returns the span ID of the span.
+func (s *Span) SpanID() string {
+	return s.Entry.SpanID
+}
+
+// Name returns the span name.
+func (s *Span) Name() string {
+	return s.Entry.StringKV("sw.span_name")
+}
+
+// Kind returns the span kind, e.g., "server".
+func (s *Span) Kind() string {
+	return s.Entry.StringKV("sw.span_kind")
+}
+
+// TransactionName returns the transaction name, which is only set on the
+// entry spans, i.e., the local roots.
+func (s *Span) TransactionName() string {
+	return s.Entry.StringKV("TransactionName")
+}
+
+// Attribute returns the value of the span attribute, or any other key-value of
+// the entry event.
+func (s *Span) Attribute(key string) (interface{}, bool) {
+	return s.Entry.KV(key)
+}
+
+// ErrorEvents returns the error events of the span, i.e., the exceptions
+// recorded by span.RecordError.
+func (s *Span) ErrorEvents() []*Event {
+	var errs []*Event
+	for _, e := range s.Events {
+		if e.Label == LabelError {
+			errs = append(errs, e)
+		}
+	}
+	return errs
+}
+
+// Walk calls fn for the span and its descendants, depth first.
+func (s *Span) Walk(fn func(*Span)) {
+	fn(s)
+	for _, c := range s.Children {
+		c.Walk(fn)
+	}
+}
+
+// String returns a readable description of the span for failure messages.
+func (s *Span) String() string {
+	return fmt.Sprintf("%s [%s]", s.Entry.Layer, s.SpanID())
+}
+
+// Trace is a trace rebuilt from its events.
+type Trace struct {
+	TraceID string
+	// The spans whose parents are not in the trace, i.e., the root span and
+	// the ones with a remote parent which isn't reported by the same agent
+	Roots []*Span
+}
+
+// Spans returns all the spans of the trace, depth first.
+func (t *Trace) Spans() []*Span {
+	var spans []*Span
+	for _, r := range t.Roots {
+		r.Walk(func(s *Span) { spans = append(spans, s) })
+	}
+	return spans
+}
+
+// BuildTraces rebuilds the traces from events, linking the spans with
+// sw.parent_span_id. The traces are ordered by the receipt of their first
+// events. The events whose spans have no entry events are dropped.
+func BuildTraces(events []*Event) []*Trace {
+	type key struct{ traceID, spanID string }
+	spans := make(map[key]*Span)
+	var order []key
+	var others []*Event
+	for _, e := range events {
+		if e.Label != LabelEntry {
+			others = append(others, e)
+			continue
+		}
+		k := key{e.TraceID, e.SpanID}
+		if _, ok := spans[k]; !ok {
+			order = append(order, k)
+		}
+		spans[k] = &Span{Entry: e}
+	}
+	// the exit, info and error events point to the spans they belong to
+	for _, e := range others {
+		s, ok := spans[key{e.TraceID, e.ParentSpanID}]
+		if !ok {
+			continue
+		}
+		if e.Label == LabelExit {
+			s.Exit = e
+		} else {
+			s.Events = append(s.Events, e)
+		}
+	}
+
+	var traces []*Trace
+	byID := make(map[string]*Trace)
+	for _, k := range order {
+		s := spans[k]
+		t, ok := byID[k.traceID]
+		if !ok {
+			t = &Trace{TraceID: k.traceID}
+			byID[k.traceID] = t
+			traces = append(traces, t)
+		}
+		if p, ok := spans[key{k.traceID, s.Entry.ParentSpanID}]; ok && s.Entry.ParentSpanID != "" {
+			s.Parent = p
+			p.Children = append(p.Children, s)
+		} else {
+			t.Roots = append(t.Roots, s)
+		}
+	}
+	return traces
+}
+
+// FindSpans returns the spans of the traces which satisfy all the matchers.
+func FindSpans(traces []*Trace, matchers ...Matcher) []*Span {
+	var found []*Span
+	for _, t := range traces {
+		for _, s := range t.Spans() {
+			if matchAll(s, matchers) {
+				found = append(found, s)
+			}
+		}
+	}
+	return found
+}
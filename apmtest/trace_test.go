@@ -0,0 +1,136 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apmtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/solarwinds/apm-go/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func spanContext(traceID byte, spanID byte) trace.SpanContext {
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{traceID},
+		SpanID:     trace.SpanID{spanID},
+		TraceFlags: trace.FlagsSampled,
+	})
+}
+
+// events returns the decoded entry, error and exit events of a span.
+func events(t *testing.T, sc, parent trace.SpanContext, name string, withError bool, kvs ...attribute.KeyValue) []*Event {
+	now := time.Now()
+	entry := reporter.CreateEntryEvent(sc, now, parent)
+	entry.SetLayer("internal:" + name)
+	entry.AddKV(attribute.String("sw.span_name", name))
+	entry.AddKV(attribute.String("sw.span_kind", "internal"))
+	entry.AddKVs(kvs)
+	evts := []reporter.Event{entry}
+	if withError {
+		e := reporter.CreateExceptionEvent(sc, now)
+		e.AddKV(attribute.String("ErrorMsg", "oops"))
+		evts = append(evts, e)
+	}
+	evts = append(evts, reporter.CreateExitEvent(sc, now.Add(time.Millisecond)))
+
+	var decoded []*Event
+	for _, e := range evts {
+		d, err := DecodeEvent(e.ToBson())
+		require.NoError(t, err)
+		decoded = append(decoded, d)
+	}
+	return decoded
+}
+
+func TestDecodeEvent(t *testing.T) {
+	now := time.Now()
+	evt := reporter.CreateEntryEvent(spanContext(1, 2), now, spanContext(1, 3))
+	evt.SetLayer("server:GET /")
+	evt.AddKVs([]attribute.KeyValue{
+		attribute.String("TransactionName", "GET /"),
+		attribute.Int64("http.status_code", 200),
+		attribute.Bool("ok", true),
+		attribute.Float64("ratio", 0.5),
+	})
+
+	e, err := DecodeEvent(evt.ToBson())
+	require.NoError(t, err)
+	assert.Equal(t, LabelEntry, e.Label)
+	assert.Equal(t, "server:GET /", e.Layer)
+	assert.Equal(t, "01000000000000000000000000000000", e.TraceID)
+	assert.Equal(t, "0200000000000000", e.SpanID)
+	assert.Equal(t, "0300000000000000", e.ParentSpanID)
+	assert.Equal(t, "0300000000000000", e.Edge)
+	assert.Equal(t, now.UnixMicro(), e.Timestamp.UnixMicro())
+	assert.NotEmpty(t, e.Hostname)
+	assert.NotZero(t, e.PID)
+	assert.Equal(t, "GET /", e.StringKV("TransactionName"))
+	assert.Equal(t, map[string]interface{}{
+		"TransactionName":  "GET /",
+		"http.status_code": int64(200),
+		"ok":               true,
+		"ratio":            0.5,
+	}, e.KVs)
+
+	_, err = NewEvent(Message{"Layer": "not an event"})
+	assert.Error(t, err)
+	_, err = DecodeEvent([]byte("invalid"))
+	assert.Error(t, err)
+}
+
+func TestBuildTraces(t *testing.T) {
+	root, child, grandchild := spanContext(1, 1), spanContext(1, 2), spanContext(1, 3)
+	remote, other := spanContext(1, 9), spanContext(2, 1)
+
+	var evts []*Event
+	// the spans are exported as they end, i.e., the children first
+	evts = append(evts, events(t, grandchild, child, "grandchild", true, attribute.Int("n", 1))...)
+	evts = append(evts, events(t, child, root, "child", false)...)
+	evts = append(evts, events(t, root, remote, "root", false, attribute.String("TransactionName", "txn"))...)
+	evts = append(evts, events(t, other, trace.SpanContext{}, "other", false)...)
+
+	traces := BuildTraces(evts)
+	require.Len(t, traces, 2)
+	require.Len(t, traces[0].Roots, 1)
+	r := traces[0].Roots[0]
+	assert.Equal(t, "root", r.Name())
+	assert.Equal(t, "internal", r.Kind())
+	assert.Equal(t, "txn", r.TransactionName())
+	assert.NotNil(t, r.Exit)
+	require.Len(t, r.Children, 1)
+	c := r.Children[0]
+	assert.Equal(t, "child", c.Name())
+	assert.Same(t, r, c.Parent)
+	require.Len(t, c.Children, 1)
+	g := c.Children[0]
+	assert.Equal(t, "grandchild", g.Name())
+	require.Len(t, g.ErrorEvents(), 1)
+	assert.Equal(t, "oops", g.ErrorEvents()[0].StringKV("ErrorMsg"))
+	assert.Len(t, traces[0].Spans(), 3)
+	assert.Equal(t, "other", traces[1].Roots[0].Name())
+
+	assert.Same(t, g, RequireSpan(t, traces, WithErrorEvent()))
+	assert.Same(t, g, RequireSpan(t, traces, WithAttributeValue("n", 1), ChildOf(WithName("child"))))
+	assert.Same(t, r, RequireSpan(t, traces, WithTransactionName("txn"), WithKind("internal")))
+	assert.Same(t, c, RequireSpan(t, traces, ChildOf(Root(), WithName("root"))))
+	assert.Len(t, FindSpans(traces, Root()), 2)
+	assert.Len(t, FindSpans(traces, WithAttribute("n")), 1)
+	AssertNoSpan(t, traces, WithName("child"), WithErrorEvent())
+	AssertNoSpan(t, traces, WithAttributeValue("n", "1"))
+}
@@ -0,0 +1,292 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/solarwinds/apm-go/internal/config"
+	"github.com/solarwinds/apm-go/internal/host"
+	"github.com/solarwinds/apm-go/internal/reporter"
+	"github.com/solarwinds/apm-go/internal/uams"
+	collector "github.com/solarwinds/apm-proto/go/collectorpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// the outcome of a check
+type status string
+
+const (
+	statusPass status = "PASS"
+	statusFail status = "FAIL"
+	statusSkip status = "SKIP"
+)
+
+// result is the result of a check, which is printed as
+//
+//	[PASS] name: summary
+//	    detail
+type result struct {
+	name    string
+	status  status
+	summary string
+	details []string
+}
+
+func pass(name, summary string, details ...string) result {
+	return result{name: name, status: statusPass, summary: summary, details: details}
+}
+
+func fail(name, summary string, details ...string) result {
+	return result{name: name, status: statusFail, summary: summary, details: details}
+}
+
+func skip(name, summary string) result {
+	return result{name: name, status: statusSkip, summary: summary}
+}
+
+// diagnoser runs the checks in order, as the later ones depend on the config
+// and the connection of the earlier ones.
+type diagnoser struct {
+	out     io.Writer
+	timeout time.Duration
+
+	cfg    *config.Config
+	conn   *grpc.ClientConn
+	failed bool
+}
+
+// run runs all the checks and returns false if any of them fails.
+func (d *diagnoser) run() bool {
+	d.report(d.checkConfig())
+	d.report(d.checkCollector())
+	d.report(d.checkSettings())
+	d.report(d.checkHost())
+	if d.conn != nil {
+		_ = d.conn.Close()
+	}
+	return !d.failed
+}
+
+func (d *diagnoser) report(r result) {
+	if r.status == statusFail {
+		d.failed = true
+	}
+	fmt.Fprintf(d.out, "[%s] %s: %s\n", r.status, r.name, r.summary)
+	for _, detail := range r.details {
+		fmt.Fprintf(d.out, "    %s\n", detail)
+	}
+}
+
+// checkConfig loads and validates the config, and lists the effective config
+// with the source of each value.
+func (d *diagnoser) checkConfig() result {
+	const name = "config"
+	cfg, err := config.Check()
+	if err != nil {
+		return fail(name, strings.TrimSpace(err.Error()))
+	}
+	if cfg.GetServiceKey() == "" && cfg.GetReporterType() != "serverless" {
+		return fail(name, "no service key, set SW_APM_SERVICE_KEY or ServiceKey in the config file")
+	}
+	d.cfg = cfg
+
+	items := cfg.Effective()
+	keys := make([]string, 0, len(items))
+	for k := range items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	details := make([]string, 0, len(keys))
+	for _, k := range keys {
+		details = append(details, fmt.Sprintf("%s: %s (%s)", k, items[k], cfg.Source(k)))
+	}
+
+	summary := "valid, no config file"
+	if f := cfg.GetConfigFile(); f != "" {
+		summary = "valid, loaded from " + f
+	}
+	return pass(name, summary, details...)
+}
+
+// checkCollector dials the collector and pings it.
+func (d *diagnoser) checkCollector() result {
+	const name = "collector"
+	switch {
+	case d.cfg == nil:
+		return skip(name, "no valid config")
+	case d.cfg.GetReporterType() != "ssl":
+		return skip(name, fmt.Sprintf("the reporter type is %s", d.cfg.GetReporterType()))
+	}
+
+	addr := d.cfg.GetCollector()
+	var details []string
+	if proxy := d.cfg.GetProxy(); proxy != "" {
+		details = append(details, "proxy: "+config.MaskProxy(proxy))
+	}
+	conn, err := reporter.DialCollector(d.cfg)
+	if err != nil {
+		return fail(name, fmt.Sprintf("failed to dial %s: %v", addr, err), details...)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	start := time.Now()
+	if err = waitForReady(ctx, conn); err != nil {
+		_ = conn.Close()
+		return fail(name, fmt.Sprintf("failed to connect to %s: %v", addr, err), details...)
+	}
+	details = append(details, fmt.Sprintf("connected in %v", time.Since(start).Round(time.Millisecond)))
+	d.conn = conn
+
+	client := collector.NewTraceCollectorClient(conn)
+	start = time.Now()
+	res, err := client.Ping(ctx, &collector.PingRequest{ApiKey: d.cfg.GetServiceKey()})
+	if err != nil {
+		return fail(name, fmt.Sprintf("Ping %s: %v", addr, err), details...)
+	}
+	details = append(details, fmt.Sprintf("Ping rtt: %v", time.Since(start).Round(time.Millisecond)))
+	if res.Warning != "" {
+		details = append(details, "warning: "+res.Warning)
+	}
+	if res.Result != collector.ResultCode_OK {
+		return fail(name, fmt.Sprintf("Ping %s: %s", addr, resultString(res.Result, res.Arg)), details...)
+	}
+	return pass(name, "reachable at "+addr, details...)
+}
+
+// waitForReady connects and waits until the connection is ready or ctx is
+// done.
+func waitForReady(ctx context.Context, conn *grpc.ClientConn) error {
+	conn.Connect()
+	for {
+		state := conn.GetState()
+		if state == connectivity.Ready {
+			return nil
+		}
+		if !conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("%v, the connection is %s", ctx.Err(), state)
+		}
+	}
+}
+
+// checkSettings retrieves and decodes the sampling settings.
+func (d *diagnoser) checkSettings() result {
+	const name = "settings"
+	if d.conn == nil {
+		return skip(name, "no connection to the collector")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	client := collector.NewTraceCollectorClient(d.conn)
+	res, err := client.GetSettings(ctx, reporter.NewSettingsRequest(d.cfg.GetServiceKey()))
+	if err != nil {
+		return fail(name, fmt.Sprintf("GetSettings: %v", err))
+	}
+
+	var details []string
+	if res.Warning != "" {
+		details = append(details, "warning: "+res.Warning)
+	}
+	if res.Result != collector.ResultCode_OK {
+		return fail(name, "GetSettings: "+resultString(res.Result, res.Arg), details...)
+	}
+	hasDefault := false
+	for _, s := range res.Settings {
+		if s.Type == collector.OboeSettingType_DEFAULT_SAMPLE_RATE {
+			hasDefault = true
+		}
+		details = append(details, fmt.Sprintf("%s: sample rate=%d, flags=%s, ttl=%ds",
+			s.Type, s.Value, s.Flags, s.Ttl))
+		for _, arg := range reporter.SettingArgs(s) {
+			details = append(details, "  "+arg)
+		}
+	}
+	if !hasDefault {
+		return fail(name, "no default sample rate setting, the agent won't trace", details...)
+	}
+	return pass(name, fmt.Sprintf("%d setting(s) received", len(res.Settings)), details...)
+}
+
+// checkHost detects the host identity which is sent to the collector.
+func (d *diagnoser) checkHost() result {
+	const name = "host"
+	uams.Start()
+	host.Start()
+
+	ids := make(chan *collector.HostID, 1)
+	go func() { ids <- reporter.HostIdentity() }()
+	var id *collector.HostID
+	select {
+	case id = <-ids:
+	case <-time.After(d.timeout):
+		return fail(name, "timed out detecting the host identity")
+	}
+
+	details := []string{
+		"hostname: " + id.Hostname,
+		fmt.Sprintf("pid: %d", id.Pid),
+		"uuid: " + id.Uuid,
+		"container ID: " + detected(id.DockerContainerID),
+		"UAMS client ID: " + detected(id.UamsClientID),
+		"Heroku dyno ID: " + detected(id.HerokuDynoID),
+		"Azure App Service instance ID: " + detected(id.AzAppServiceInstanceID),
+	}
+	if md := id.AwsMetadata; md != nil {
+		details = append(details, fmt.Sprintf("AWS: instance %s, region %s, zone %s",
+			md.HostId, md.CloudRegion, md.CloudAvailabilityZone))
+	} else {
+		details = append(details, "AWS: not detected")
+	}
+	if md := id.AzureMetadata; md != nil {
+		details = append(details, fmt.Sprintf("Azure: VM %s, region %s", md.AzureVmName, md.CloudRegion))
+	} else {
+		details = append(details, "Azure: not detected")
+	}
+	if md := id.K8SMetadata; md != nil {
+		details = append(details, fmt.Sprintf("Kubernetes: pod %s/%s (%s)", md.Namespace, md.PodName, md.PodUid))
+	} else {
+		details = append(details, "Kubernetes: not detected")
+	}
+	if len(id.MacAddresses) > 0 {
+		details = append(details, "MAC addresses: "+strings.Join(id.MacAddresses, ", "))
+	}
+
+	if id.Hostname == "" {
+		return fail(name, "no hostname", details...)
+	}
+	return pass(name, "detected", details...)
+}
+
+func detected(s string) string {
+	if s == "" {
+		return "not detected"
+	}
+	return s
+}
+
+func resultString(code collector.ResultCode, arg string) string {
+	if arg == "" {
+		return code.String()
+	}
+	return fmt.Sprintf("%s (%s)", code, arg)
+}
@@ -0,0 +1,82 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/solarwinds/apm-go/apmtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiagnoser(t *testing.T) {
+	c := apmtest.NewCollector(t)
+	c.Setenv(t)
+	s := apmtest.DefaultSetting()
+	s.SignatureKey = "secret"
+	c.SetSettings(s)
+
+	var out bytes.Buffer
+	d := &diagnoser{out: &out, timeout: 5 * time.Second}
+	require.True(t, d.run(), out.String())
+
+	assert.Contains(t, out.String(), "[PASS] config: valid")
+	assert.Contains(t, out.String(), "Collector: "+c.Addr()+" (env)")
+	assert.Contains(t, out.String(), "ServiceKey: ae38****")
+	assert.NotContains(t, out.String(), c.ServiceKey())
+	assert.Contains(t, out.String(), "[PASS] collector: reachable at "+c.Addr())
+	assert.Contains(t, out.String(), "[PASS] settings: 1 setting(s) received")
+	assert.Contains(t, out.String(), "flags=SAMPLE_START,SAMPLE_THROUGH_ALWAYS,TRIGGER_TRACE")
+	assert.Contains(t, out.String(), "BucketCapacity=1e+06")
+	assert.Contains(t, out.String(), "SignatureKey=<6 bytes>")
+	assert.NotContains(t, out.String(), "secret")
+	assert.Contains(t, out.String(), "[PASS] host: detected")
+	assert.Len(t, c.Requests(apmtest.MethodPing), 1)
+}
+
+func TestDiagnoserFailures(t *testing.T) {
+	c := apmtest.NewCollector(t)
+	c.Setenv(t)
+	c.RejectServiceKey(c.ServiceKey())
+
+	var out bytes.Buffer
+	d := &diagnoser{out: &out, timeout: 5 * time.Second}
+	require.False(t, d.run())
+	assert.Contains(t, out.String(), "[FAIL] collector: Ping "+c.Addr()+": INVALID_API_KEY")
+	assert.Contains(t, out.String(), "[FAIL] settings: GetSettings: INVALID_API_KEY")
+
+	t.Setenv("SW_APM_SERVICE_KEY", "invalid")
+	out.Reset()
+	d = &diagnoser{out: &out, timeout: 5 * time.Second}
+	require.False(t, d.run())
+	assert.Contains(t, out.String(), "[FAIL] config: validation error")
+	assert.Contains(t, out.String(), "[SKIP] collector: no valid config")
+	assert.Contains(t, out.String(), "[SKIP] settings: no connection to the collector")
+}
+
+func TestDiagnoserUnreachable(t *testing.T) {
+	c := apmtest.NewCollector(t)
+	c.Setenv(t)
+	c.Close()
+
+	var out bytes.Buffer
+	d := &diagnoser{out: &out, timeout: time.Second}
+	require.False(t, d.run())
+	assert.Contains(t, out.String(), "[FAIL] collector: failed to connect to "+c.Addr())
+	assert.Contains(t, out.String(), "[SKIP] settings: no connection to the collector")
+}
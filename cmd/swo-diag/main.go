@@ -0,0 +1,59 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command swo-diag checks the configuration of the agent and the connectivity
+// to the collector. It loads the configuration in the same way as the agent,
+// i.e., from the config file and the SW_APM_* environment variables, and
+// reports each check as PASS, FAIL or SKIP:
+//
+//   - config: the validation errors and the effective configuration
+//   - collector: dialing the collector with the trusted certificate and the
+//     proxy, and the result of Ping
+//   - settings: the result of GetSettings and the decoded settings
+//   - host: the host identity detected, e.g., AWS, Azure, Kubernetes, UAMS
+//     and the container ID
+//
+// It exits with status 1 if any of the checks fails.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/solarwinds/apm-go/internal/log"
+)
+
+func main() {
+	configFile := flag.String("config", "", "the path of the config file, overriding SW_APM_CONFIG_FILE")
+	timeout := flag.Duration("timeout", 10*time.Second, "the timeout of each network check")
+	verbose := flag.Bool("v", false, "print the debug logs of the agent")
+	flag.Parse()
+
+	if *configFile != "" {
+		if err := os.Setenv("SW_APM_CONFIG_FILE", *configFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	if *verbose {
+		log.SetLevel(log.DEBUG)
+	}
+
+	d := &diagnoser{out: os.Stdout, timeout: *timeout}
+	if !d.run() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,99 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command swo-replay replays a capture file, which is written by an agent with
+// SW_APM_CAPTURE_FILE, against a collector. The collector is configured in the
+// same way as the agent, i.e., by the config file and the SW_APM_* environment
+// variables, and can be overridden by the flags:
+//
+//	swo-replay -collector localhost:4567 -trustedpath ca.crt -speed 10 swo.capture
+//
+// The records are sent with their original intervals divided by the speed, or
+// as fast as possible if the speed is 0.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/solarwinds/apm-go/internal/config"
+	"github.com/solarwinds/apm-go/internal/reporter"
+	collector "github.com/solarwinds/apm-proto/go/collectorpb"
+)
+
+func main() {
+	addr := flag.String("collector", "", "the collector address, overriding SW_APM_COLLECTOR")
+	trustedPath := flag.String("trustedpath", "", "the certificate of the collector, overriding SW_APM_TRUSTEDPATH")
+	key := flag.String("key", "", "the service key replacing the captured ones")
+	speed := flag.Float64("speed", 1, "the replay speed relative to the capture, 0 for as fast as possible")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <capture file>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || *speed < 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	stats, err := replay(ctx, flag.Arg(0), *addr, *trustedPath, reporter.ReplayOptions{
+		Speed:      *speed,
+		ServiceKey: *key,
+	})
+	fmt.Printf("replayed %d records (%d messages), %d rejected\n", stats.Records, stats.Messages, stats.Rejected)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if stats.Rejected > 0 {
+		os.Exit(1)
+	}
+}
+
+// replay replays the capture file against the collector.
+func replay(ctx context.Context, path, addr, trustedPath string, opts reporter.ReplayOptions) (reporter.ReplayStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return reporter.ReplayStats{}, err
+	}
+	defer f.Close()
+	r, err := reporter.NewCaptureReader(f)
+	if err != nil {
+		return reporter.ReplayStats{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var cfgOpts []config.Option
+	if addr != "" {
+		cfgOpts = append(cfgOpts, config.WithCollector(addr))
+	}
+	if trustedPath != "" {
+		cfgOpts = append(cfgOpts, config.WithTrustedPath(trustedPath))
+	}
+	cfg, err := config.Check(cfgOpts...)
+	if err != nil {
+		return reporter.ReplayStats{}, err
+	}
+	conn, err := reporter.DialCollector(cfg)
+	if err != nil {
+		return reporter.ReplayStats{}, err
+	}
+	defer conn.Close()
+
+	return reporter.Replay(ctx, collector.NewTraceCollectorClient(conn), r, opts)
+}
@@ -0,0 +1,82 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/solarwinds/apm-go/apmtest"
+	"github.com/solarwinds/apm-go/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func writeCapture(t *testing.T, key string) string {
+	path := filepath.Join(t.TempDir(), "swo.capture")
+	w, err := reporter.NewCaptureWriter(path)
+	require.NoError(t, err)
+	msg := func(m bson.M) []byte {
+		b, err := bson.Marshal(m)
+		require.NoError(t, err)
+		return b
+	}
+	now := time.Now()
+	require.NoError(t, w.WriteRecord(&reporter.CaptureRecord{
+		Time: now, Method: reporter.CapturePostStatus, ServiceKey: key,
+		Messages: [][]byte{msg(bson.M{"__Init": true})},
+	}))
+	require.NoError(t, w.WriteRecord(&reporter.CaptureRecord{
+		Time: now.Add(400 * time.Millisecond), Method: reporter.CapturePostEvents, ServiceKey: key,
+		Messages: [][]byte{msg(bson.M{"Label": "entry"}), msg(bson.M{"Label": "exit"})},
+	}))
+	require.NoError(t, w.Close())
+	return path
+}
+
+func TestReplay(t *testing.T) {
+	c := apmtest.NewCollector(t)
+	path := writeCapture(t, "captured:key")
+
+	start := time.Now()
+	stats, err := replay(context.Background(), path, c.Addr(), c.CertFile(), reporter.ReplayOptions{
+		Speed:      2,
+		ServiceKey: c.ServiceKey(),
+	})
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	assert.Equal(t, reporter.ReplayStats{Records: 2, Messages: 3}, stats)
+
+	require.Len(t, c.Requests(apmtest.MethodPostEvents), 1)
+	assert.Equal(t, c.ServiceKey(), c.Requests(apmtest.MethodPostEvents)[0].APIKey)
+	assert.Equal(t, []apmtest.Message{{"Label": "entry"}, {"Label": "exit"}}, c.Events())
+	assert.Equal(t, []apmtest.Message{{"__Init": true}}, c.Status())
+}
+
+func TestReplayRejected(t *testing.T) {
+	c := apmtest.NewCollector(t)
+	c.RejectServiceKey("captured:key")
+	path := writeCapture(t, "captured:key")
+
+	stats, err := replay(context.Background(), path, c.Addr(), c.CertFile(), reporter.ReplayOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, reporter.ReplayStats{Records: 2, Messages: 3, Rejected: 2}, stats)
+
+	_, err = replay(context.Background(), c.CertFile(), c.Addr(), c.CertFile(), reporter.ReplayOptions{})
+	assert.Error(t, err)
+}
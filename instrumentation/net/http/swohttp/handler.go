@@ -33,11 +33,15 @@ const (
 // otelhttp instrumentation. It is intended for use with the base handler as
 // provided to a mux. Individual route handlers should use
 // `otelhttp.WithRouteTag` instead.
-func WrapBaseHandler(h http.Handler, operation string) http.Handler {
+//
+// The otelhttp options are passed through, e.g., `otelhttp.WithTracerProvider`
+// and `otelhttp.WithPropagators` to report the requests with an agent created
+// by `swo.NewAgent` rather than the global one.
+func WrapBaseHandler(h http.Handler, operation string, opts ...otelhttp.Option) http.Handler {
 	// Wrap with our instrumentation
 	h = NewBaseHandler(h)
 	// Wrap with Otel
-	return otelhttp.NewHandler(h, operation)
+	return otelhttp.NewHandler(h, operation, opts...)
 }
 
 // NewBaseHandler wraps a handler with our instrumentation. It is expected to
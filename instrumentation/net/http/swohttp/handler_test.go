@@ -19,6 +19,8 @@ import (
 	"github.com/solarwinds/apm-go/swo"
 	"github.com/stretchr/testify/require"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 	"regexp"
 
 	"net/http"
@@ -81,3 +83,18 @@ func doRequest(t *testing.T, xtOpts string) *http.Response {
 	handler.ServeHTTP(recorder, req)
 	return recorder.Result()
 }
+
+func TestWrapBaseHandlerWithTracerProvider(t *testing.T) {
+	// a TracerProvider other than the global one, e.g., of an agent created by
+	// swo.NewAgent
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.AlwaysSample()))
+	handler := WrapBaseHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.True(t, trace.SpanContextFromContext(r.Context()).IsSampled())
+	}), "foobar", otelhttp.WithTracerProvider(tp))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("", "/", http.NoBody))
+	resp := recorder.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Regexp(t, xtraceRegexp, resp.Header.Get(XTrace))
+}
@@ -76,6 +76,8 @@ var (
 	ErrUnsupportedFormat = errors.New("unsupported format")
 	ErrFileTooLarge      = errors.New("file size exceeds limit")
 	ErrInvalidServiceKey = errors.New(fullTextInvalidServiceKey)
+
+	errAgentDisabled = errors.New("the agent is disabled")
 )
 
 // Config is the struct to define the agent configuration. The configuration
@@ -127,18 +129,65 @@ type Config struct {
 	// The flag for trigger trace. It's enabled by default.
 	TriggerTrace bool `yaml:"TriggerTrace" env:"SW_APM_TRIGGER_TRACE" default:"true"`
 
-	// Url of the HTTP/HTTPS proxy in the format of "scheme://<username>:<password>@<host>:<port>"
+	// Url of the HTTP/HTTPS/SOCKS5 proxy in the format of "scheme://<username>:<password>@<host>:<port>"
 	Proxy string `yaml:"Proxy,omitempty" env:"SW_APM_PROXY"`
 	// Cert path for the HTTP/HTTPS proxy
 	ProxyCertPath string `yaml:"ProxyCertPath" env:"SW_APM_PROXY_CERT_PATH"`
 	// Report runtime metrics or not
 	RuntimeMetrics bool `yaml:"RuntimeMetrics" env:"SW_APM_RUNTIME_METRICS" default:"true"`
+	// Report each group of the host metrics or not, see HostMetricsGroups
+	HostMetricsCPU     bool `yaml:"HostMetricsCPU" env:"SW_APM_HOST_METRICS_CPU" default:"true"`
+	HostMetricsProcess bool `yaml:"HostMetricsProcess" env:"SW_APM_HOST_METRICS_PROCESS" default:"true"`
+	HostMetricsFDs     bool `yaml:"HostMetricsFDs" env:"SW_APM_HOST_METRICS_FDS" default:"true"`
+	HostMetricsNetwork bool `yaml:"HostMetricsNetwork" env:"SW_APM_HOST_METRICS_NETWORK" default:"true"`
+	HostMetricsDisk    bool `yaml:"HostMetricsDisk" env:"SW_APM_HOST_METRICS_DISK" default:"true"`
 	// ReportQueryString indicates if the query string should be reported as part of the URL
 	ReportQueryString bool    `yaml:"ReportQueryString" env:"SW_APM_REPORT_QUERY_STRING" default:"true"`
 	TokenBucketCap    float64 `yaml:"TokenBucketCap" env:"SW_APM_TOKEN_BUCKET_CAPACITY" default:"8"`
 	TokenBucketRate   float64 `yaml:"TokenBucketRate" env:"SW_APM_TOKEN_BUCKET_RATE" default:"0.17"`
 	// The user-defined transaction name. It's only available in the AWS Lambda environment.
 	TransactionName string `yaml:"TransactionName" env:"SW_APM_TRANSACTION_NAME"`
+
+	// The maximum number of transaction names reported in a metrics flush
+	// interval. The lower of it and the maximum set by the collector is used,
+	// so it can only lower the collector's one. Zero means the maximum set by
+	// the collector is used.
+	TransactionNameMax int `yaml:"TransactionNameMax,omitempty" env:"SW_APM_TRANSACTION_NAME_MAX" default:"0"`
+	// Whether the numeric and UUID path segments of the transaction names are
+	// replaced by {id}, e.g., /users/{id}/orders
+	TransactionNameCollapseIDs bool `yaml:"TransactionNameCollapseIDs,omitempty" env:"SW_APM_TRANSACTION_NAME_COLLAPSE_IDS"`
+	// The maximum number of path segments of the transaction names. Zero means
+	// the names are not truncated.
+	TransactionNamePathDepth int `yaml:"TransactionNamePathDepth,omitempty" env:"SW_APM_TRANSACTION_NAME_PATH_DEPTH" default:"0"`
+	// The comma-separated transaction names which are always reported, even if
+	// the maximum is exceeded
+	TransactionNameAllowList string `yaml:"TransactionNameAllowList,omitempty" env:"SW_APM_TRANSACTION_NAME_ALLOW_LIST"`
+
+	// The interval in seconds to check the config file for changes and reload
+	// it. Zero means the config file is not watched.
+	ConfigReloadInterval int `yaml:"ConfigReloadInterval,omitempty" env:"SW_APM_CONFIG_RELOAD_INTERVAL" default:"0"`
+	// Whether the config file should be reloaded on SIGHUP
+	ConfigReloadOnSIGHUP bool `yaml:"ConfigReloadOnSIGHUP,omitempty" env:"SW_APM_CONFIG_RELOAD_ON_SIGHUP"`
+
+	// The path of the file to capture the messages sent to the collector, which
+	// can be replayed by the swo-replay command. Empty means no capture.
+	CaptureFile string `yaml:"CaptureFile,omitempty" env:"SW_APM_CAPTURE_FILE"`
+
+	// the options applied on top of the config file and environment variables,
+	// which are kept for Reload
+	opts []Option
+	// where the value of each item comes from, keyed by the names used by GetDelta
+	sources map[string]Source
+}
+
+// HostMetricsGroups are the groups of the host metrics reported on Linux,
+// besides the load, the memory and the container metrics.
+type HostMetricsGroups struct {
+	CPU     bool // the CPU utilisation of the host
+	Process bool // the CPU time of the process
+	FDs     bool // the open file descriptors of the process and the limit
+	Network bool // the bytes and errors of the network interfaces
+	Disk    bool // the bytes read from and written to the disks
 }
 
 // SamplingConfig defines the configuration options for the sampling decision
@@ -202,20 +251,23 @@ func (f *TransactionFilter) UnmarshalYAML(unmarshal func(interface{}) error) err
 	if err := unmarshal(&aux); err != nil {
 		return errors.Wrap(err, "failed to unmarshal TransactionFilter")
 	}
-	if aux.Type != URL {
+	f.Type = aux.Type
+	f.RegEx = aux.RegEx
+	f.Extensions = aux.Extensions
+	f.Tracing = aux.Tracing
+	return f.validate()
+}
+
+func (f *TransactionFilter) validate() error {
+	if f.Type != URL {
 		return ErrTFInvalidType
 	}
-	if aux.Tracing != EnabledTracingMode && aux.Tracing != DisabledTracingMode {
+	if f.Tracing != EnabledTracingMode && f.Tracing != DisabledTracingMode {
 		return ErrTFInvalidTracing
 	}
-	if (aux.RegEx == "") == (aux.Extensions == nil) {
+	if (f.RegEx == "") == (f.Extensions == nil) {
 		return ErrTFInvalidRegExExt
 	}
-
-	f.Type = aux.Type
-	f.RegEx = aux.RegEx
-	f.Extensions = aux.Extensions
-	f.Tracing = aux.Tracing
 	return nil
 }
 
@@ -301,24 +353,6 @@ func getFieldDefaultValue(i interface{}, fieldName string) string {
 	return field.Tag.Get("default")
 }
 
-// Option is a function type that accepts a Config pointer and
-// applies the configuration option it defines.
-type Option func(c *Config)
-
-// WithCollector defines a Config option for collector address.
-func WithCollector(collector string) Option {
-	return func(c *Config) {
-		c.Collector = collector
-	}
-}
-
-// WithServiceKey defines a Config option for the service key.
-func WithServiceKey(key string) Option {
-	return func(c *Config) {
-		c.ServiceKey = key
-	}
-}
-
 // NewConfig initializes a Config object and override default values with options
 // provided as arguments. It may print errors if there are invalid values in the
 // configuration file or the environment variables.
@@ -329,6 +363,21 @@ func NewConfig(opts ...Option) *Config {
 	return newConfig().Load(opts...)
 }
 
+// Check loads the configuration in the same way as NewConfig, but returns the
+// error which disables the agent rather than logging it, e.g., an invalid
+// config file or service key. The config is a disabled one in that case.
+func Check(opts ...Option) (*Config, error) {
+	c := newConfig()
+	c.Lock()
+	defer c.Unlock()
+
+	if err := c.load(opts...); err != nil {
+		c.resetThenDisable()
+		return c, err
+	}
+	return c, nil
+}
+
 const (
 	fullTextInvalidServiceKey = `
 	    **No valid service key (defined as token:service_name) is found.** 
@@ -390,6 +439,16 @@ func (c *Config) validate() error {
 
 	c.Sampling.validate()
 
+	if c.TransactionNameMax < 0 {
+		log.Warning(InvalidEnv("TransactionNameMax", strconv.Itoa(c.TransactionNameMax)))
+		c.TransactionNameMax, _ = strconv.Atoi(getFieldDefaultValue(c, "TransactionNameMax"))
+	}
+
+	if c.TransactionNamePathDepth < 0 {
+		log.Warning(InvalidEnv("TransactionNamePathDepth", strconv.Itoa(c.TransactionNamePathDepth)))
+		c.TransactionNamePathDepth, _ = strconv.Atoi(getFieldDefaultValue(c, "TransactionNamePathDepth"))
+	}
+
 	if ok := IsValidHostnameAlias(c.HostAlias); !ok {
 		log.Warning(InvalidEnv("HostAlias", c.HostAlias))
 		c.HostAlias = getFieldDefaultValue(c, "HostAlias")
@@ -418,6 +477,21 @@ func (c *Config) validate() error {
 		}
 	}
 
+	var filters []TransactionFilter
+	for _, f := range c.TransactionSettings {
+		if err := f.validate(); err != nil {
+			log.Warning(InvalidEnv("TransactionSettings", fmt.Sprintf("%+v: %s", f, err)))
+			continue
+		}
+		filters = append(filters, f)
+	}
+	c.TransactionSettings = filters
+
+	if c.ConfigReloadInterval < 0 {
+		log.Warning(InvalidEnv("ConfigReloadInterval", strconv.Itoa(c.ConfigReloadInterval)))
+		c.ConfigReloadInterval = ToInteger(getFieldDefaultValue(c, "ConfigReloadInterval"))
+	}
+
 	return c.ReporterProperties.validate()
 }
 
@@ -426,38 +500,104 @@ func (c *Config) Load(opts ...Option) *Config {
 	c.Lock()
 	defer c.Unlock()
 
+	if err := c.load(opts...); err != nil {
+		if err != errAgentDisabled {
+			log.Warning(err.Error())
+		}
+		return c.resetThenDisable()
+	}
+
+	return c
+}
+
+// load reads configuration from config file and environment variables. The
+// config object should be discarded if an error is returned.
+func (c *Config) load(opts ...Option) error {
 	c.reset()
+	c.opts = opts
+	c.sources = make(map[string]Source)
 
+	base := c.flatten()
 	if err := c.loadConfigFile(); err != nil {
-		log.Warning(errors.Wrap(err, "config file load error").Error())
-		return c.resetThenDisable()
+		return errors.Wrap(err, "config file load error")
 	}
+	base = c.markSources(base, SourceFile)
+
 	c.loadEnvs()
+	base = c.markSources(base, SourceEnv)
 
 	for _, opt := range opts {
 		opt(c)
 	}
+	c.markSources(base, SourceOption)
 
 	if !c.Enabled {
-		return c.resetThenDisable()
+		return errAgentDisabled
 	}
 
 	if err := c.validate(); err != nil {
-		log.Warning(errors.Wrap(err, "validation error").Error())
-		return c.resetThenDisable()
+		return errors.Wrap(err, "validation error")
 	}
-
-	return c
+	return nil
 }
 
 func (c *Config) resetThenDisable() *Config {
 	c.reset()
+	c.sources = nil
 	c.Enabled = false
 	return c
 }
 
+// GetDelta returns the items which are different from the default values,
+// along with where the values come from.
 func (c *Config) GetDelta() *Delta {
-	return getDelta(newConfig().reset(), c, "").sanitize()
+	delta := getDelta(newConfig().reset(), c, "")
+	for idx := range delta.delta {
+		delta.delta[idx].source = c.Source(delta.delta[idx].key)
+	}
+	return delta.sanitize()
+}
+
+// Source is where the value of a configuration item comes from.
+type Source string
+
+// The sources of the configuration items, from the lowest precedence to the
+// highest.
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceEnv     Source = "env"
+	SourceOption  Source = "option"
+)
+
+// Source returns where the value of the configuration item comes from. The key
+// is the name used by GetDelta, e.g., "Sampling.SampleRate".
+func (c *Config) Source(key string) Source {
+	c.RLock()
+	defer c.RUnlock()
+	if src, ok := c.sources[key]; ok {
+		return src
+	}
+	return SourceDefault
+}
+
+// flatten returns the current value of every configuration item.
+func (c *Config) flatten() map[string]string {
+	items := make(map[string]string)
+	flattenConfig(c, "", items)
+	return items
+}
+
+// markSources records src as the source of the items which have been changed
+// since base was taken, and returns the current values.
+func (c *Config) markSources(base map[string]string, src Source) map[string]string {
+	current := c.flatten()
+	for key, val := range current {
+		if base[key] != val {
+			c.sources[key] = src
+		}
+	}
+	return current
 }
 
 // DeltaItem defines a delta item  of two Config objects
@@ -466,6 +606,7 @@ type DeltaItem struct {
 	env        string
 	value      string
 	defaultVal string
+	source     Source
 }
 
 // Delta defines the overall delta of two Config objects
@@ -483,9 +624,14 @@ func (d *Delta) items() []DeltaItem {
 
 func (d *Delta) sanitize() *Delta {
 	for idx := range d.delta {
-		// mask the sensitive service key
-		if d.delta[idx].key == "ServiceKey" {
+		switch d.delta[idx].key {
+		// mask the sensitive service key and proxy credentials
+		case "ServiceKey":
 			d.delta[idx].value = MaskServiceKey(d.delta[idx].value)
+			d.delta[idx].defaultVal = MaskServiceKey(d.delta[idx].defaultVal)
+		case "Proxy":
+			d.delta[idx].value = MaskProxy(d.delta[idx].value)
+			d.delta[idx].defaultVal = MaskProxy(d.delta[idx].defaultVal)
 		}
 	}
 	return d
@@ -494,6 +640,15 @@ func (d *Delta) sanitize() *Delta {
 func (d *Delta) String() string {
 	var s []string
 	for _, item := range d.delta {
+		if item.source != "" {
+			s = append(s, fmt.Sprintf(" - %s (%s) = %s (default: %s, source: %s)",
+				item.key,
+				item.env,
+				item.value,
+				item.defaultVal,
+				item.source))
+			continue
+		}
 		s = append(s, fmt.Sprintf(" - %s (%s) = %s (default: %s)",
 			item.key,
 			item.env,
@@ -553,6 +708,55 @@ func getDelta(base, changed interface{}, keyPrefix string) *Delta {
 	return delta
 }
 
+// Effective returns the current value of every configuration item, keyed by
+// the same dotted names used by GetDelta, e.g., "ReporterProperties.EventQueuePolicy".
+// Secrets such as the service key and the proxy credentials are masked.
+func (c *Config) Effective() map[string]string {
+	c.RLock()
+	defer c.RUnlock()
+
+	items := make(map[string]string)
+	flattenConfig(c, "", items)
+
+	if v, ok := items["ServiceKey"]; ok {
+		items["ServiceKey"] = MaskServiceKey(v)
+	}
+	if v, ok := items["Proxy"]; ok {
+		items["Proxy"] = MaskProxy(v)
+	}
+	return items
+}
+
+// flattenConfig collects the exported fields of a struct into the map.
+func flattenConfig(c interface{}, keyPrefix string, items map[string]string) {
+	val := reflect.Indirect(reflect.ValueOf(c))
+	if val.Kind() != reflect.Struct {
+		return
+	}
+
+	for i := 0; i < val.NumField(); i++ {
+		typeField := val.Type().Field(i)
+		if typeField.Anonymous || !typeField.IsExported() {
+			continue
+		}
+
+		keyName := typeField.Name
+		if keyPrefix != "" {
+			keyName = fmt.Sprintf("%s.%s", keyPrefix, typeField.Name)
+		}
+
+		field := reflect.Indirect(val.Field(i))
+		if field.Kind() == reflect.Struct {
+			flattenConfig(field.Interface(), keyName, items)
+			continue
+		}
+		if !field.IsValid() {
+			continue
+		}
+		items[keyName] = fmt.Sprintf("%+v", field.Interface())
+	}
+}
+
 func newConfig() *Config {
 	return &Config{
 		Sampling:           &SamplingConfig{},
@@ -864,7 +1068,7 @@ func (c *Config) GetTriggerTrace() bool {
 	return c.TriggerTrace
 }
 
-// GetProxy returns the HTTP/HTTPS proxy url
+// GetProxy returns the HTTP/HTTPS/SOCKS5 proxy url
 func (c *Config) GetProxy() string {
 	c.RLock()
 	defer c.RUnlock()
@@ -885,6 +1089,19 @@ func (c *Config) GetRuntimeMetrics() bool {
 	return c.RuntimeMetrics
 }
 
+// GetHostMetrics returns the groups of the host metrics to report
+func (c *Config) GetHostMetrics() HostMetricsGroups {
+	c.RLock()
+	defer c.RUnlock()
+	return HostMetricsGroups{
+		CPU:     c.HostMetricsCPU,
+		Process: c.HostMetricsProcess,
+		FDs:     c.HostMetricsFDs,
+		Network: c.HostMetricsNetwork,
+		Disk:    c.HostMetricsDisk,
+	}
+}
+
 // GetTokenBucketCap returns the token bucket capacity
 func (c *Config) GetTokenBucketCap() float64 {
 	c.RLock()
@@ -921,6 +1138,45 @@ func (c *Config) GetTransactionName() string {
 	return c.TransactionName
 }
 
+// GetTransactionNameMax returns the maximum number of transaction names in a
+// metrics flush interval, which only applies if it's lower than the maximum of
+// the collector, or zero if the maximum of the collector is used.
+func (c *Config) GetTransactionNameMax() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.TransactionNameMax
+}
+
+// GetTransactionNameCollapseIDs returns if the numeric and UUID path segments
+// of the transaction names are collapsed.
+func (c *Config) GetTransactionNameCollapseIDs() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.TransactionNameCollapseIDs
+}
+
+// GetTransactionNamePathDepth returns the maximum number of path segments of
+// the transaction names, or zero if they are not truncated.
+func (c *Config) GetTransactionNamePathDepth() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.TransactionNamePathDepth
+}
+
+// GetTransactionNameAllowList returns the transaction names which are always
+// reported.
+func (c *Config) GetTransactionNameAllowList() []string {
+	c.RLock()
+	defer c.RUnlock()
+	var names []string
+	for _, name := range strings.Split(c.TransactionNameAllowList, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 // GetSQLSanitize returns the SQL sanitization level.
 //
 // The meaning of each level:
@@ -934,3 +1190,31 @@ func (c *Config) GetSQLSanitize() int {
 	defer c.RUnlock()
 	return c.SQLSanitize
 }
+
+// GetConfigReloadInterval returns the interval in seconds to check the config file for changes
+func (c *Config) GetConfigReloadInterval() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ConfigReloadInterval
+}
+
+// GetConfigReloadOnSIGHUP returns whether the config file should be reloaded on SIGHUP
+func (c *Config) GetConfigReloadOnSIGHUP() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ConfigReloadOnSIGHUP
+}
+
+// GetCaptureFile returns the path of the file to capture the messages sent to
+// the collector
+func (c *Config) GetCaptureFile() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.CaptureFile
+}
+
+// GetConfigFile returns the absolute path of the config file, or an empty
+// string if there isn't one.
+func (c *Config) GetConfigFile() string {
+	return c.getConfigPath()
+}
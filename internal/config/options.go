@@ -0,0 +1,234 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import "strings"
+
+// Option is a function type that accepts a Config pointer and
+// applies the configuration option it defines.
+//
+// The options are applied after the config file and the environment variables
+// are loaded, so they take precedence over both. The values are validated in
+// the same way as those from the other sources.
+type Option func(c *Config)
+
+// WithCollector defines a Config option for collector address.
+func WithCollector(collector string) Option {
+	return func(c *Config) {
+		c.Collector = collector
+	}
+}
+
+// WithServiceKey defines a Config option for the service key.
+func WithServiceKey(key string) Option {
+	return func(c *Config) {
+		c.ServiceKey = key
+	}
+}
+
+// WithTrustedPath defines a Config option for the file path of the cert file
+// used by the gRPC connection.
+func WithTrustedPath(path string) Option {
+	return func(c *Config) {
+		c.TrustedPath = path
+	}
+}
+
+// WithReporterType defines a Config option for the reporter type.
+func WithReporterType(typ string) Option {
+	return func(c *Config) {
+		c.ReporterType = typ
+	}
+}
+
+// WithTracingMode defines a Config option for the local tracing mode.
+func WithTracingMode(mode TracingMode) Option {
+	return func(c *Config) {
+		c.Sampling.SetTracingMode(mode)
+	}
+}
+
+// WithSampleRate defines a Config option for the local sample rate.
+func WithSampleRate(rate int) Option {
+	return func(c *Config) {
+		c.Sampling.SetSampleRate(rate)
+	}
+}
+
+// WithPrependDomain defines a Config option for prepending the domain to the
+// transaction name.
+func WithPrependDomain(prepend bool) Option {
+	return func(c *Config) {
+		c.PrependDomain = prepend
+	}
+}
+
+// WithHostAlias defines a Config option for the alias of the hostname.
+func WithHostAlias(alias string) Option {
+	return func(c *Config) {
+		c.HostAlias = alias
+	}
+}
+
+// WithPrecision defines a Config option for the precision of the histogram.
+func WithPrecision(precision int) Option {
+	return func(c *Config) {
+		c.Precision = precision
+	}
+}
+
+// WithSQLSanitize defines a Config option for the SQL sanitization level.
+func WithSQLSanitize(level int) Option {
+	return func(c *Config) {
+		c.SQLSanitize = level
+	}
+}
+
+// WithTransactionSettings defines a Config option for the transaction filters.
+// It replaces the filters from the config file. Invalid filters are discarded.
+func WithTransactionSettings(filters ...TransactionFilter) Option {
+	return func(c *Config) {
+		c.TransactionSettings = filters
+	}
+}
+
+// WithEnabled defines a Config option for enabling or disabling the agent.
+func WithEnabled(enabled bool) Option {
+	return func(c *Config) {
+		c.Enabled = enabled
+	}
+}
+
+// WithEc2MetadataTimeout defines a Config option for the EC2 metadata
+// retrieval timeout in milliseconds.
+func WithEc2MetadataTimeout(timeout int) Option {
+	return func(c *Config) {
+		c.Ec2MetadataTimeout = timeout
+	}
+}
+
+// WithDebugLevel defines a Config option for the log level.
+func WithDebugLevel(level string) Option {
+	return func(c *Config) {
+		c.DebugLevel = level
+	}
+}
+
+// WithTriggerTrace defines a Config option for enabling or disabling trigger
+// trace.
+func WithTriggerTrace(enabled bool) Option {
+	return func(c *Config) {
+		c.TriggerTrace = enabled
+	}
+}
+
+// WithProxy defines a Config option for the url of the HTTP/HTTPS/SOCKS5 proxy.
+func WithProxy(proxy string) Option {
+	return func(c *Config) {
+		c.Proxy = proxy
+	}
+}
+
+// WithProxyCertPath defines a Config option for the cert path of the
+// HTTP/HTTPS proxy.
+func WithProxyCertPath(path string) Option {
+	return func(c *Config) {
+		c.ProxyCertPath = path
+	}
+}
+
+// WithRuntimeMetrics defines a Config option for reporting runtime metrics.
+func WithRuntimeMetrics(enabled bool) Option {
+	return func(c *Config) {
+		c.RuntimeMetrics = enabled
+	}
+}
+
+// WithHostMetrics defines a Config option for the groups of the host metrics
+// to report.
+func WithHostMetrics(groups HostMetricsGroups) Option {
+	return func(c *Config) {
+		c.HostMetricsCPU = groups.CPU
+		c.HostMetricsProcess = groups.Process
+		c.HostMetricsFDs = groups.FDs
+		c.HostMetricsNetwork = groups.Network
+		c.HostMetricsDisk = groups.Disk
+	}
+}
+
+// WithReportQueryString defines a Config option for reporting the query string
+// as part of the URL.
+func WithReportQueryString(enabled bool) Option {
+	return func(c *Config) {
+		c.ReportQueryString = enabled
+	}
+}
+
+// WithTransactionNameRules defines a Config option for the cardinality of the
+// transaction names: the maximum number of names in a metrics flush interval,
+// which only lowers the maximum of the collector, zero for the maximum of the
+// collector, whether the numeric and UUID path
+// segments are collapsed, the maximum number of path segments, zero for no
+// limit, and the names which are always reported.
+func WithTransactionNameRules(max int, collapseIDs bool, pathDepth int, allowList ...string) Option {
+	return func(c *Config) {
+		c.TransactionNameMax = max
+		c.TransactionNameCollapseIDs = collapseIDs
+		c.TransactionNamePathDepth = pathDepth
+		c.TransactionNameAllowList = strings.Join(allowList, ",")
+	}
+}
+
+// WithTokenBucket defines a Config option for the capacity and the rate of the
+// token bucket.
+func WithTokenBucket(capacity, rate float64) Option {
+	return func(c *Config) {
+		c.TokenBucketCap = capacity
+		c.TokenBucketRate = rate
+	}
+}
+
+// WithTransactionName defines a Config option for the user-defined transaction
+// name. It's only available in the AWS Lambda environment.
+func WithTransactionName(name string) Option {
+	return func(c *Config) {
+		c.TransactionName = name
+	}
+}
+
+// WithConfigReload defines a Config option for reloading the config file
+// every interval seconds, and/or on SIGHUP.
+func WithConfigReload(interval int, onSIGHUP bool) Option {
+	return func(c *Config) {
+		c.ConfigReloadInterval = interval
+		c.ConfigReloadOnSIGHUP = onSIGHUP
+	}
+}
+
+// WithCaptureFile defines a Config option for capturing the messages sent to
+// the collector into the file.
+func WithCaptureFile(path string) Option {
+	return func(c *Config) {
+		c.CaptureFile = path
+	}
+}
+
+// WithReporterProperties defines a Config option which modifies the reporter
+// options loaded from the config file and the environment variables.
+func WithReporterProperties(fn func(r *ReporterOptions)) Option {
+	return func(c *Config) {
+		fn(c.ReporterProperties)
+	}
+}
@@ -0,0 +1,159 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// The configuration items which are applied by Reload. A change of any other
+// item requires a restart of the process.
+const (
+	ReloadServiceKey          = "ServiceKey"
+	ReloadTracingMode         = "Sampling.TracingMode"
+	ReloadSampleRate          = "Sampling.SampleRate"
+	ReloadTransactionSettings = "TransactionSettings"
+	ReloadDebugLevel          = "DebugLevel"
+	ReloadSQLSanitize         = "SQLSanitize"
+	ReloadEventFlushInterval  = "ReporterProperties.EventFlushInterval"
+	ReloadMetricFlushInterval = "ReporterProperties.MetricFlushInterval"
+
+	ReloadTransactionNameMax         = "TransactionNameMax"
+	ReloadTransactionNameCollapseIDs = "TransactionNameCollapseIDs"
+	ReloadTransactionNamePathDepth   = "TransactionNamePathDepth"
+	ReloadTransactionNameAllowList   = "TransactionNameAllowList"
+)
+
+var reloadableItems = map[string]bool{
+	ReloadServiceKey:          true,
+	ReloadTracingMode:         true,
+	ReloadSampleRate:          true,
+	ReloadTransactionSettings: true,
+	ReloadDebugLevel:          true,
+	ReloadSQLSanitize:         true,
+	ReloadEventFlushInterval:  true,
+	ReloadMetricFlushInterval: true,
+
+	ReloadTransactionNameMax:         true,
+	ReloadTransactionNameCollapseIDs: true,
+	ReloadTransactionNamePathDepth:   true,
+	ReloadTransactionNameAllowList:   true,
+}
+
+// ReloadResult is the outcome of a successful Reload.
+type ReloadResult struct {
+	// the changes which have been applied
+	Applied *Delta
+	// the changes which are ignored as they require a restart
+	RestartRequired *Delta
+}
+
+// Reload reads the configuration from the config file and environment variables
+// again, and applies the changes which are safe at runtime. The changes of
+// other items are returned but not applied. The current configuration is kept
+// untouched if the new one is invalid. The options given to Load are applied
+// again, so they still take precedence.
+func (c *Config) Reload() (*ReloadResult, error) {
+	c.RLock()
+	opts := c.opts
+	c.RUnlock()
+
+	fresh := newConfig()
+	if err := fresh.load(opts...); err != nil {
+		return nil, errors.Wrap(err, "reload")
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	res := &ReloadResult{Applied: &Delta{}, RestartRequired: &Delta{}}
+	for _, item := range getDelta(c, fresh, "").items() {
+		if reloadableItems[item.key] {
+			res.Applied.add(item)
+		} else {
+			res.RestartRequired.add(item)
+		}
+	}
+
+	for _, key := range res.Applied.Keys() {
+		c.setSource(key, fresh.sources[key])
+		switch key {
+		case ReloadServiceKey:
+			c.ServiceKey = fresh.ServiceKey
+		case ReloadTracingMode, ReloadSampleRate:
+			c.Sampling = fresh.Sampling
+			c.setSource(ReloadTracingMode, fresh.sources[ReloadTracingMode])
+			c.setSource(ReloadSampleRate, fresh.sources[ReloadSampleRate])
+		case ReloadTransactionSettings:
+			c.TransactionSettings = fresh.TransactionSettings
+		case ReloadDebugLevel:
+			c.DebugLevel = fresh.DebugLevel
+		case ReloadSQLSanitize:
+			c.SQLSanitize = fresh.SQLSanitize
+		case ReloadEventFlushInterval:
+			c.ReporterProperties.SetEventFlushInterval(fresh.ReporterProperties.GetEventFlushInterval())
+		case ReloadMetricFlushInterval:
+			atomic.StoreInt64(&c.ReporterProperties.MetricFlushInterval, fresh.ReporterProperties.MetricFlushInterval)
+		case ReloadTransactionNameMax:
+			c.TransactionNameMax = fresh.TransactionNameMax
+		case ReloadTransactionNameCollapseIDs:
+			c.TransactionNameCollapseIDs = fresh.TransactionNameCollapseIDs
+		case ReloadTransactionNamePathDepth:
+			c.TransactionNamePathDepth = fresh.TransactionNamePathDepth
+		case ReloadTransactionNameAllowList:
+			c.TransactionNameAllowList = fresh.TransactionNameAllowList
+		}
+	}
+
+	res.Applied.sanitize()
+	res.RestartRequired.sanitize()
+	return res, nil
+}
+
+// setSource records the source of the item, or removes it if the item has its
+// default value. The caller must hold the lock.
+func (c *Config) setSource(key string, src Source) {
+	if c.sources == nil {
+		c.sources = make(map[string]Source)
+	}
+	if src == "" {
+		delete(c.sources, key)
+	} else {
+		c.sources[key] = src
+	}
+}
+
+// Keys returns the keys of the delta items
+func (d *Delta) Keys() []string {
+	var keys []string
+	for _, item := range d.delta {
+		keys = append(keys, item.key)
+	}
+	return keys
+}
+
+// Changes returns the delta in the format of "key: old -> new". It's used for
+// the delta of two configs loaded one after another, e.g., by Reload.
+func (d *Delta) Changes() string {
+	var s []string
+	for _, item := range d.delta {
+		s = append(s, fmt.Sprintf(" - %s: %s -> %s", item.key, item.defaultVal, item.value))
+	}
+	return strings.Join(s, "\n")
+}
@@ -14,9 +14,25 @@
 package config
 
 import (
+	"fmt"
+	"github.com/solarwinds/apm-go/internal/log"
+	"strings"
 	"sync/atomic"
 )
 
+// The policies applied when the event message queue is full
+const (
+	// EventQueueDropNewest drops the event being reported
+	EventQueueDropNewest = "drop-newest"
+	// EventQueueDropOldest drops the oldest event in the queue to make room
+	EventQueueDropOldest = "drop-oldest"
+	// EventQueueBlock waits for room in the queue up to EventQueueBlockTimeout
+	EventQueueBlock = "block"
+	// EventQueueDropTrace drops the event being reported, the queued events of
+	// the same trace and all the following ones
+	EventQueueDropTrace = "drop-trace"
+)
+
 // ReporterOptions defines the options of a reporter. The fields of it
 // must be accessed through atomic operators
 type ReporterOptions struct {
@@ -38,10 +54,11 @@ type ReporterOptions struct {
 	// Ping interval in seconds
 	PingInterval int64 `yaml:"PingInterval,omitempty" default:"20"`
 
-	// Retry backoff initial delay
+	// Retry backoff initial delay in milliseconds. The delays are randomized
+	// between zero and the exponential backoff (full jitter).
 	RetryDelayInitial int64 `yaml:"RetryDelayInitial,omitempty" default:"500"`
 
-	// Maximum retry delay
+	// Maximum retry delay in seconds
 	RetryDelayMax int `yaml:"RetryDelayMax,omitempty" default:"60"`
 
 	// Maximum redirect times
@@ -52,6 +69,38 @@ type ReporterOptions struct {
 
 	// The maximum retries
 	MaxRetries int `yaml:"MaxRetries,omitempty" default:"20"`
+
+	// The retry budget of each kind of requests (events, metrics, status and
+	// settings), i.e., the number of failed calls tolerated before the retries
+	// are throttled. Each successful call earns back a tenth of a retry. Zero
+	// means no budget.
+	RetryBudget int `yaml:"RetryBudget,omitempty" env:"SW_APM_RETRY_BUDGET" default:"10"`
+
+	// The number of consecutive failed requests, after their retries, to open
+	// the circuit breaker, which drops the events, metrics and status messages
+	// without calling the collector. The settings and the pings are not
+	// affected. Zero disables it.
+	CircuitBreakerThreshold int `yaml:"CircuitBreakerThreshold,omitempty" env:"SW_APM_CIRCUIT_BREAKER_THRESHOLD" default:"10"`
+
+	// The time in seconds the circuit breaker stays open before letting a probe
+	// request through (half-open)
+	CircuitBreakerCooldown int `yaml:"CircuitBreakerCooldown,omitempty" env:"SW_APM_CIRCUIT_BREAKER_COOLDOWN" default:"30"`
+
+	// The policy applied when the event queue is full: drop-newest, drop-oldest,
+	// block or drop-trace
+	EventQueuePolicy string `yaml:"EventQueuePolicy,omitempty" env:"SW_APM_EVENT_QUEUE_POLICY" default:"drop-newest"`
+
+	// The maximum time in milliseconds to wait for room in the event queue with
+	// the block policy
+	EventQueueBlockTimeout int64 `yaml:"EventQueueBlockTimeout,omitempty" env:"SW_APM_EVENT_QUEUE_BLOCK_TIMEOUT" default:"100"`
+
+	// The maximum bytes per second of the event messages sent to the collector.
+	// Zero means no limit.
+	EventBytesRateLimit int64 `yaml:"EventBytesRateLimit,omitempty" env:"SW_APM_EVENTS_BYTES_RATE_LIMIT" default:"0"`
+
+	// The maximum bytes of the event messages sent to the collector in a rolling
+	// 24-hour window. Sampling is stopped once it is exhausted. Zero means no budget.
+	EventDailyBytesBudget int64 `yaml:"EventDailyBytesBudget,omitempty" env:"SW_APM_EVENTS_DAILY_BYTES_BUDGET" default:"0"`
 }
 
 // SetEventFlushInterval sets the event flush interval to i
@@ -69,12 +118,75 @@ func (r *ReporterOptions) GetEventFlushInterval() int64 {
 	return atomic.LoadInt64(&r.EventFlushInterval)
 }
 
+// GetMetricFlushInterval returns the current metric flush interval
+func (r *ReporterOptions) GetMetricFlushInterval() int64 {
+	return atomic.LoadInt64(&r.MetricFlushInterval)
+}
+
 // GetMaxReqBytes returns the maximum RPC request size
 func (r *ReporterOptions) GetMaxReqBytes() int64 {
 	return atomic.LoadInt64(&r.MaxReqBytes)
 }
 
+// GetPingInterval returns the keep alive ping interval in seconds
+func (r *ReporterOptions) GetPingInterval() int64 {
+	return atomic.LoadInt64(&r.PingInterval)
+}
+
+// GetRetryDelayInitial returns the initial retry delay in milliseconds
+func (r *ReporterOptions) GetRetryDelayInitial() int64 {
+	return atomic.LoadInt64(&r.RetryDelayInitial)
+}
+
 func (r *ReporterOptions) validate() error {
-	// TODO
+	r.EventQueuePolicy = strings.ToLower(strings.TrimSpace(r.EventQueuePolicy))
+	if ok := IsValidEventQueuePolicy(r.EventQueuePolicy); !ok {
+		log.Warning(InvalidEnv("EventQueuePolicy", r.EventQueuePolicy))
+		r.EventQueuePolicy = getFieldDefaultValue(r, "EventQueuePolicy")
+	}
+
+	if r.EventQueueBlockTimeout < 0 {
+		log.Warning(InvalidEnv("EventQueueBlockTimeout", fmt.Sprintf("%d", r.EventQueueBlockTimeout)))
+		r.EventQueueBlockTimeout = int64(ToInteger(getFieldDefaultValue(r, "EventQueueBlockTimeout")))
+	}
+
+	if r.EventBytesRateLimit < 0 {
+		log.Warning(InvalidEnv("EventBytesRateLimit", fmt.Sprintf("%d", r.EventBytesRateLimit)))
+		r.EventBytesRateLimit = int64(ToInteger(getFieldDefaultValue(r, "EventBytesRateLimit")))
+	}
+
+	if r.EventDailyBytesBudget < 0 {
+		log.Warning(InvalidEnv("EventDailyBytesBudget", fmt.Sprintf("%d", r.EventDailyBytesBudget)))
+		r.EventDailyBytesBudget = int64(ToInteger(getFieldDefaultValue(r, "EventDailyBytesBudget")))
+	}
+
+	if r.PingInterval <= 0 {
+		log.Warning(InvalidEnv("PingInterval", fmt.Sprintf("%d", r.PingInterval)))
+		r.PingInterval = int64(ToInteger(getFieldDefaultValue(r, "PingInterval")))
+	}
+
+	if r.RetryDelayInitial <= 0 {
+		log.Warning(InvalidEnv("RetryDelayInitial", fmt.Sprintf("%d", r.RetryDelayInitial)))
+		r.RetryDelayInitial = int64(ToInteger(getFieldDefaultValue(r, "RetryDelayInitial")))
+	}
+
+	if r.RetryDelayMax <= 0 || int64(r.RetryDelayMax)*1000 < r.RetryDelayInitial {
+		log.Warning(InvalidEnv("RetryDelayMax", fmt.Sprintf("%d", r.RetryDelayMax)))
+		r.RetryDelayMax = ToInteger(getFieldDefaultValue(r, "RetryDelayMax"))
+	}
+
+	for name, v := range map[string]*int{
+		"RedirectMax":             &r.RedirectMax,
+		"MaxRetries":              &r.MaxRetries,
+		"RetryLogThreshold":       &r.RetryLogThreshold,
+		"RetryBudget":             &r.RetryBudget,
+		"CircuitBreakerThreshold": &r.CircuitBreakerThreshold,
+		"CircuitBreakerCooldown":  &r.CircuitBreakerCooldown,
+	} {
+		if *v < 0 {
+			log.Warning(InvalidEnv(name, fmt.Sprintf("%d", *v)))
+			*v = ToInteger(getFieldDefaultValue(r, name))
+		}
+	}
 	return nil
 }
@@ -29,4 +29,55 @@ func TestReporterOptions(t *testing.T) {
 	assert.Equal(t, r.GetMaxReqBytes(), int64(2000))
 
 	assert.Nil(t, r.validate())
+	assert.Equal(t, EventQueueDropNewest, r.EventQueuePolicy)
+
+	r.EventQueuePolicy = " Drop-Trace "
+	r.EventQueueBlockTimeout = -1
+	r.EventBytesRateLimit = -1
+	r.EventDailyBytesBudget = -1
+	assert.Nil(t, r.validate())
+	assert.Equal(t, EventQueueDropTrace, r.EventQueuePolicy)
+	assert.Equal(t, int64(100), r.EventQueueBlockTimeout)
+	assert.Equal(t, int64(0), r.EventBytesRateLimit)
+	assert.Equal(t, int64(0), r.EventDailyBytesBudget)
+}
+
+func TestReporterRetryOptions(t *testing.T) {
+	r := &ReporterOptions{
+		PingInterval:            10,
+		RetryDelayInitial:       200,
+		RetryDelayMax:           5,
+		RedirectMax:             0,
+		MaxRetries:              3,
+		RetryBudget:             0,
+		CircuitBreakerThreshold: 0,
+		CircuitBreakerCooldown:  15,
+	}
+	assert.Nil(t, r.validate())
+	assert.Equal(t, int64(10), r.GetPingInterval())
+	assert.Equal(t, int64(200), r.GetRetryDelayInitial())
+	assert.Equal(t, 5, r.RetryDelayMax)
+	assert.Equal(t, 0, r.RedirectMax)
+	assert.Equal(t, 0, r.RetryBudget)
+	assert.Equal(t, 0, r.CircuitBreakerThreshold)
+
+	r.PingInterval = 0
+	r.RetryDelayInitial = -1
+	r.MaxRetries = -1
+	r.RetryBudget = -1
+	r.CircuitBreakerThreshold = -1
+	r.CircuitBreakerCooldown = -1
+	assert.Nil(t, r.validate())
+	assert.Equal(t, int64(20), r.GetPingInterval())
+	assert.Equal(t, int64(500), r.GetRetryDelayInitial())
+	assert.Equal(t, 20, r.MaxRetries)
+	assert.Equal(t, 10, r.RetryBudget)
+	assert.Equal(t, 10, r.CircuitBreakerThreshold)
+	assert.Equal(t, 30, r.CircuitBreakerCooldown)
+
+	// the max delay is lower than the initial one
+	r.RetryDelayInitial = 2000
+	r.RetryDelayMax = 1
+	assert.Nil(t, r.validate())
+	assert.Equal(t, 60, r.RetryDelayMax)
 }
@@ -74,6 +74,9 @@ var GetProxyCertPath = conf.GetProxyCertPath
 // GetRuntimeMetrics is a wrapper to the method of the global config
 var GetRuntimeMetrics = conf.GetRuntimeMetrics
 
+// GetHostMetrics is a wrapper to the method of the global config
+var GetHostMetrics = conf.GetHostMetrics
+
 var GetTokenBucketCap = conf.GetTokenBucketCap
 var GetTokenBucketRate = conf.GetTokenBucketRate
 var GetReportQueryString = conf.GetReportQueryString
@@ -83,16 +86,48 @@ var GetTransactionFiltering = conf.GetTransactionFiltering
 
 var GetTransactionName = conf.GetTransactionName
 
+// GetTransactionNameMax is a wrapper to method GetTransactionNameMax of the global variable config.
+var GetTransactionNameMax = conf.GetTransactionNameMax
+
+// GetTransactionNameCollapseIDs is a wrapper to method GetTransactionNameCollapseIDs of the global variable config.
+var GetTransactionNameCollapseIDs = conf.GetTransactionNameCollapseIDs
+
+// GetTransactionNamePathDepth is a wrapper to method GetTransactionNamePathDepth of the global variable config.
+var GetTransactionNamePathDepth = conf.GetTransactionNamePathDepth
+
+// GetTransactionNameAllowList is a wrapper to method GetTransactionNameAllowList of the global variable config.
+var GetTransactionNameAllowList = conf.GetTransactionNameAllowList
+
 // GetSQLSanitize is a wrapper to method GetSQLSanitize of the global variable config.
 var GetSQLSanitize = conf.GetSQLSanitize
 
+// GetConfigReloadInterval is a wrapper to the method of the global config
+var GetConfigReloadInterval = conf.GetConfigReloadInterval
+
+// GetConfigReloadOnSIGHUP is a wrapper to the method of the global config
+var GetConfigReloadOnSIGHUP = conf.GetConfigReloadOnSIGHUP
+
+// GetConfigFile is a wrapper to the method of the global config
+var GetConfigFile = conf.GetConfigFile
+
 // Load reads the customized configurations
 var Load = conf.Load
 
+// Reload reads the configurations again and applies the changes which are safe at runtime
+var Reload = conf.Reload
+
 var GetDelta = conf.GetDelta
 
+// Effective is a wrapper to the method Effective of the global variable config.
+var Effective = conf.Effective
+
 func init() {
 	if conf.GetEnabled() {
 		log.Warningf("Accepted config items: \n%s", conf.GetDelta())
 	}
 }
+
+// Global returns the global config which the wrappers above delegate to.
+func Global() *Config {
+	return conf
+}
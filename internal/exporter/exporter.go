@@ -20,6 +20,7 @@ import (
 	"github.com/solarwinds/apm-go/internal/constants"
 	"github.com/solarwinds/apm-go/internal/entryspans"
 	"github.com/solarwinds/apm-go/internal/log"
+	"github.com/solarwinds/apm-go/internal/metrics"
 	"github.com/solarwinds/apm-go/internal/reporter"
 	"github.com/solarwinds/apm-go/internal/swotel/semconv"
 	"github.com/solarwinds/apm-go/internal/utils"
@@ -29,9 +30,20 @@ import (
 )
 
 type exporter struct {
+	// the reporter of a standalone agent, or nil for the global reporter
+	r reporter.Reporter
+	// the metrics of the reporter, holding the transaction name rules
+	apm *metrics.Measurements
 }
 
-func exportSpan(_ context.Context, s sdktrace.ReadOnlySpan) {
+func (e *exporter) reportEvent(evt reporter.Event) error {
+	if e.r == nil {
+		return reporter.ReportEvent(evt)
+	}
+	return e.r.ReportEvent(evt)
+}
+
+func (e *exporter) exportSpan(_ context.Context, s sdktrace.ReadOnlySpan) {
 	evt := reporter.CreateEntryEvent(s.SpanContext(), s.StartTime(), s.Parent())
 	layer := fmt.Sprintf("%s:%s", s.SpanKind().String(), s.Name())
 	evt.SetLayer(layer)
@@ -43,7 +55,7 @@ func exportSpan(_ context.Context, s sdktrace.ReadOnlySpan) {
 		attribute.String("otel.scope.version", s.InstrumentationScope().Version),
 	})
 	if entryspans.IsEntrySpan(s) {
-		evt.AddKV(attribute.String("TransactionName", utils.GetTransactionName(s)))
+		evt.AddKV(attribute.String("TransactionName", utils.GetTransactionName(s, e.apm.TransactionNameRules())))
 		// We MUST clear the entry span here. The SpanProcessor only clears entry spans when they are `RecordOnly`
 		if err := entryspans.Delete(s); err != nil {
 			log.Warningf(
@@ -67,7 +79,7 @@ func exportSpan(_ context.Context, s sdktrace.ReadOnlySpan) {
 
 	evt.AddKVs(s.Attributes())
 
-	if err := reporter.ReportEvent(evt); err != nil {
+	if err := e.reportEvent(evt); err != nil {
 		log.Warning("cannot send entry event", err)
 		return
 	}
@@ -87,7 +99,7 @@ func exportSpan(_ context.Context, s sdktrace.ReadOnlySpan) {
 			}
 		}
 		evt.AddKVs(otEvt.Attributes)
-		if err := reporter.ReportEvent(evt); err != nil {
+		if err := e.reportEvent(evt); err != nil {
 			log.Warningf("could not send %s event: %s", s.Name(), err)
 			continue
 		}
@@ -95,7 +107,7 @@ func exportSpan(_ context.Context, s sdktrace.ReadOnlySpan) {
 
 	evt = reporter.CreateExitEvent(s.SpanContext(), s.EndTime())
 	evt.AddKV(attribute.String(constants.Layer, layer))
-	if err := reporter.ReportEvent(evt); err != nil {
+	if err := e.reportEvent(evt); err != nil {
 		log.Warning("cannot send exit event", err)
 		return
 	}
@@ -103,17 +115,30 @@ func exportSpan(_ context.Context, s sdktrace.ReadOnlySpan) {
 }
 
 func (e *exporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
-	reporter.WaitForReady(ctx)
+	if e.r == nil {
+		reporter.WaitForReady(ctx)
+	} else {
+		e.r.WaitForReady(ctx)
+	}
 	for _, s := range spans {
-		exportSpan(ctx, s)
+		e.exportSpan(ctx, s)
 	}
 	return nil
 }
 
 func (e *exporter) Shutdown(ctx context.Context) error {
-	return reporter.Shutdown(ctx)
+	if e.r == nil {
+		return reporter.Shutdown(ctx)
+	}
+	return e.r.Shutdown(ctx)
 }
 
 func NewExporter() sdktrace.SpanExporter {
-	return &exporter{}
+	return &exporter{apm: metrics.ApmMetrics}
+}
+
+// NewExporterWithReporter returns an exporter which sends the spans to the
+// reporter r instead of the global reporter.
+func NewExporterWithReporter(r *reporter.Instance) sdktrace.SpanExporter {
+	return &exporter{r: r, apm: r.Metrics().ApmMetrics()}
 }
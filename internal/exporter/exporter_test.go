@@ -274,6 +274,10 @@ func (c *capturingReporter) ShutdownNow() {
 	panic("method should not be called")
 }
 
+func (c *capturingReporter) Flush(context.Context) error {
+	panic("method should not be called")
+}
+
 func (c *capturingReporter) Closed() bool {
 	return false
 }
@@ -0,0 +1,341 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package meter
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/solarwinds/apm-go/internal/log"
+	"github.com/solarwinds/apm-go/internal/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/embedded"
+)
+
+var errEmptyName = errors.New("empty instrument name")
+
+// meter creates the instruments recording into the custom metrics of its
+// provider.
+type meter struct {
+	embedded.Meter
+
+	p *MeterProvider
+}
+
+var _ metric.Meter = (*meter)(nil)
+
+func (m *meter) Int64Counter(name string, _ ...metric.Int64CounterOption) (metric.Int64Counter, error) {
+	return m.int64Inst(name, syncCounter)
+}
+
+func (m *meter) Int64UpDownCounter(name string, _ ...metric.Int64UpDownCounterOption) (metric.Int64UpDownCounter, error) {
+	return m.int64Inst(name, syncUpDownCounter)
+}
+
+func (m *meter) Int64Histogram(name string, options ...metric.Int64HistogramOption) (metric.Int64Histogram, error) {
+	inst, err := m.int64Inst(name, syncHistogram)
+	if err != nil {
+		return nil, err
+	}
+	if scale, ok := histogramScale(metric.NewInt64HistogramConfig(options...).Unit()); ok {
+		inst.scale = scale
+	}
+	return inst, nil
+}
+
+func (m *meter) Int64ObservableCounter(name string, options ...metric.Int64ObservableCounterOption) (metric.Int64ObservableCounter, error) {
+	cfg := metric.NewInt64ObservableCounterConfig(options...)
+	return m.int64Observable(name, observableCounter, cfg.Callbacks())
+}
+
+func (m *meter) Int64ObservableUpDownCounter(name string, options ...metric.Int64ObservableUpDownCounterOption) (metric.Int64ObservableUpDownCounter, error) {
+	cfg := metric.NewInt64ObservableUpDownCounterConfig(options...)
+	return m.int64Observable(name, observableUpDownCounter, cfg.Callbacks())
+}
+
+func (m *meter) Int64ObservableGauge(name string, options ...metric.Int64ObservableGaugeOption) (metric.Int64ObservableGauge, error) {
+	cfg := metric.NewInt64ObservableGaugeConfig(options...)
+	return m.int64Observable(name, observableGauge, cfg.Callbacks())
+}
+
+func (m *meter) Float64Counter(name string, _ ...metric.Float64CounterOption) (metric.Float64Counter, error) {
+	return m.float64Inst(name, syncCounter)
+}
+
+func (m *meter) Float64UpDownCounter(name string, _ ...metric.Float64UpDownCounterOption) (metric.Float64UpDownCounter, error) {
+	return m.float64Inst(name, syncUpDownCounter)
+}
+
+func (m *meter) Float64Histogram(name string, options ...metric.Float64HistogramOption) (metric.Float64Histogram, error) {
+	inst, err := m.float64Inst(name, syncHistogram)
+	if err != nil {
+		return nil, err
+	}
+	if scale, ok := histogramScale(metric.NewFloat64HistogramConfig(options...).Unit()); ok {
+		inst.scale = scale
+	} else {
+		// the fractions would be lost in the integer histograms
+		inst.summary = true
+	}
+	return inst, nil
+}
+
+func (m *meter) Float64ObservableCounter(name string, options ...metric.Float64ObservableCounterOption) (metric.Float64ObservableCounter, error) {
+	cfg := metric.NewFloat64ObservableCounterConfig(options...)
+	return m.float64Observable(name, observableCounter, cfg.Callbacks())
+}
+
+func (m *meter) Float64ObservableUpDownCounter(name string, options ...metric.Float64ObservableUpDownCounterOption) (metric.Float64ObservableUpDownCounter, error) {
+	cfg := metric.NewFloat64ObservableUpDownCounterConfig(options...)
+	return m.float64Observable(name, observableUpDownCounter, cfg.Callbacks())
+}
+
+func (m *meter) Float64ObservableGauge(name string, options ...metric.Float64ObservableGaugeOption) (metric.Float64ObservableGauge, error) {
+	cfg := metric.NewFloat64ObservableGaugeConfig(options...)
+	return m.float64Observable(name, observableGauge, cfg.Callbacks())
+}
+
+// RegisterCallback registers f to be called on each collection. It may only
+// observe the given instruments created by this package.
+func (m *meter) RegisterCallback(f metric.Callback, instruments ...metric.Observable) (metric.Registration, error) {
+	allowed := make(map[*observable]bool, len(instruments))
+	for _, inst := range instruments {
+		switch o := inst.(type) {
+		case *int64Observable:
+			allowed[o.observable] = true
+		case *float64Observable:
+			allowed[o.observable] = true
+		default:
+			return nil, errors.Errorf("invalid observable instrument: %T", inst)
+		}
+	}
+	unregister := m.p.register(func(ctx context.Context, into *metrics.Measurements) {
+		if err := f(ctx, &observer{allowed: allowed, into: into}); err != nil {
+			logCallbackErr(err)
+		}
+	})
+	return &registration{unregister: unregister}, nil
+}
+
+func (m *meter) int64Inst(name string, kind syncKind) (*int64Inst, error) {
+	inst, err := m.syncInst(name, kind)
+	if err != nil {
+		return nil, err
+	}
+	return &int64Inst{syncInst: inst}, nil
+}
+
+func (m *meter) float64Inst(name string, kind syncKind) (*float64Inst, error) {
+	inst, err := m.syncInst(name, kind)
+	if err != nil {
+		return nil, err
+	}
+	return &float64Inst{syncInst: inst}, nil
+}
+
+func (m *meter) syncInst(name string, kind syncKind) (*syncInst, error) {
+	if name == "" {
+		return nil, errEmptyName
+	}
+	inst := &syncInst{p: m.p, name: name, kind: kind, scale: 1}
+	if kind == syncUpDownCounter {
+		inst.totals = m.p.getUpDownCounter(name)
+	}
+	return inst, nil
+}
+
+func (m *meter) int64Observable(name string, kind observableKind, callbacks []metric.Int64Callback) (*int64Observable, error) {
+	if name == "" {
+		return nil, errEmptyName
+	}
+	o := &int64Observable{observable: m.p.getObservable(name, kind)}
+	for _, cb := range callbacks {
+		cb := cb
+		m.p.register(func(ctx context.Context, into *metrics.Measurements) {
+			if err := cb(ctx, &int64Observer{o: o.observable, into: into}); err != nil {
+				logCallbackErr(err)
+			}
+		})
+	}
+	return o, nil
+}
+
+func (m *meter) float64Observable(name string, kind observableKind, callbacks []metric.Float64Callback) (*float64Observable, error) {
+	if name == "" {
+		return nil, errEmptyName
+	}
+	o := &float64Observable{observable: m.p.getObservable(name, kind)}
+	for _, cb := range callbacks {
+		cb := cb
+		m.p.register(func(ctx context.Context, into *metrics.Measurements) {
+			if err := cb(ctx, &float64Observer{o: o.observable, into: into}); err != nil {
+				logCallbackErr(err)
+			}
+		})
+	}
+	return o, nil
+}
+
+type syncKind int
+
+const (
+	syncCounter syncKind = iota
+	syncUpDownCounter
+	syncHistogram
+)
+
+// syncInst is the state of a synchronous instrument.
+type syncInst struct {
+	p      *MeterProvider
+	name   string
+	kind   syncKind
+	totals *upDownCounter // the running totals of an up-down counter
+
+	scale   float64 // the multiplier of the values of a histogram, see histogramScale
+	summary bool    // record the values of a histogram as a summary instead
+}
+
+// histogramScale returns the multiplier which converts the values of a
+// duration histogram of the unit into microseconds, the unit of the response
+// time histograms. It returns false if the unit isn't a duration.
+func histogramScale(unit string) (float64, bool) {
+	switch unit {
+	case "s":
+		return 1e6, true
+	case "ms":
+		return 1e3, true
+	case "us", "µs":
+		return 1, true
+	case "ns":
+		return 1e-3, true
+	}
+	return 0, false
+}
+
+// add adds to a counter, ignoring the negative values, or to the running
+// total of an up-down counter.
+func (i *syncInst) add(incr float64, attrs attribute.Set) {
+	switch i.kind {
+	case syncCounter:
+		if incr >= 0 {
+			i.p.record(i.p.m, i.name, incr, attrs)
+		}
+	case syncUpDownCounter:
+		i.totals.add(incr, attrs)
+	}
+}
+
+// recordValue records a value of a histogram.
+func (i *syncInst) recordValue(value float64, attrs attribute.Set) {
+	if i.summary {
+		i.p.record(i.p.m, i.name, value, attrs)
+		return
+	}
+	i.p.recordHistogram(i.name, value*i.scale, attrs)
+}
+
+// int64Inst is a synchronous int64 instrument: a counter, an up-down counter
+// or a histogram.
+type int64Inst struct {
+	embedded.Int64Counter
+	embedded.Int64UpDownCounter
+	embedded.Int64Histogram
+
+	*syncInst
+}
+
+func (i *int64Inst) Add(_ context.Context, incr int64, options ...metric.AddOption) {
+	i.add(float64(incr), metric.NewAddConfig(options).Attributes())
+}
+
+func (i *int64Inst) Record(_ context.Context, value int64, options ...metric.RecordOption) {
+	i.recordValue(float64(value), metric.NewRecordConfig(options).Attributes())
+}
+
+// float64Inst is a synchronous float64 instrument: a counter, an up-down
+// counter or a histogram.
+type float64Inst struct {
+	embedded.Float64Counter
+	embedded.Float64UpDownCounter
+	embedded.Float64Histogram
+
+	*syncInst
+}
+
+func (i *float64Inst) Add(_ context.Context, incr float64, options ...metric.AddOption) {
+	i.add(incr, metric.NewAddConfig(options).Attributes())
+}
+
+func (i *float64Inst) Record(_ context.Context, value float64, options ...metric.RecordOption) {
+	i.recordValue(value, metric.NewRecordConfig(options).Attributes())
+}
+
+// upDownCounter keeps the running totals of an up-down counter, which are
+// reported as gauges on each collection. It is shared by all the meters so the
+// totals are not split between the instruments of the same name.
+type upDownCounter struct {
+	p    *MeterProvider
+	name string
+
+	lock   sync.Mutex
+	totals map[attribute.Distinct]*upDownTotal
+}
+
+type upDownTotal struct {
+	attrs attribute.Set
+	value float64
+}
+
+func newUpDownCounter(p *MeterProvider, name string) *upDownCounter {
+	return &upDownCounter{
+		p:      p,
+		name:   name,
+		totals: make(map[attribute.Distinct]*upDownTotal),
+	}
+}
+
+// add adds to the running total of the attributes. A new set of attributes is
+// dropped if it can't be kept, see checkNewAttrs.
+func (c *upDownCounter) add(incr float64, attrs attribute.Set) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := attrs.Equivalent()
+	t, ok := c.totals[key]
+	if !ok {
+		if err := c.p.checkNewAttrs(len(c.totals), attrs); err != nil {
+			log.Debugf("Dropped the measurement of %s: %v", c.name, err)
+			return
+		}
+		t = &upDownTotal{attrs: attrs}
+		c.totals[key] = t
+	}
+	t.value += incr
+}
+
+// collect records the running totals as gauges into the custom metrics given.
+func (c *upDownCounter) collect(into *metrics.Measurements) {
+	c.lock.Lock()
+	totals := make([]upDownTotal, 0, len(c.totals))
+	for _, t := range c.totals {
+		totals = append(totals, *t)
+	}
+	c.lock.Unlock()
+
+	for _, t := range totals {
+		c.p.recordGauge(into, c.name, t.value, t.attrs)
+	}
+}
@@ -0,0 +1,300 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package meter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/solarwinds/apm-go/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	mbson "gopkg.in/mgo.v2/bson"
+)
+
+type measurement struct {
+	Count int
+	Sum   float64
+	Value string // the encoded histogram
+}
+
+// flush flushes the measurements and decodes the PostMetrics message, keyed by
+// the name and the tags of the measurements.
+func flush(t *testing.T, m *metrics.Measurements) map[string]measurement {
+	res := make(map[string]measurement)
+	msg := metrics.BuildMessage(m.CopyAndReset(30), false)
+	if msg == nil {
+		return res
+	}
+	type entry struct {
+		Name  string
+		Count int
+		Sum   float64
+		Value string
+		Tags  map[string]string
+	}
+	var dec struct {
+		IsCustom     bool    `bson:"IsCustom"`
+		Measurements []entry `bson:"measurements"`
+		Histograms   []entry `bson:"histograms"`
+	}
+	require.NoError(t, mbson.Unmarshal(msg, &dec))
+	require.True(t, dec.IsCustom)
+	for _, me := range append(dec.Measurements, dec.Histograms...) {
+		key := me.Name
+		if len(me.Tags) > 0 {
+			key += strings.TrimPrefix(fmt.Sprint(me.Tags), "map")
+		}
+		res[key] = measurement{Count: me.Count, Sum: me.Sum, Value: me.Value}
+	}
+	return res
+}
+
+// histogram returns the encoded histogram of the values.
+func histogram(t *testing.T, name string, values ...int64) string {
+	m := metrics.NewMeasurements(true, 100)
+	for _, v := range values {
+		require.NoError(t, m.Histogram(name, v, metrics.MetricOptions{Count: 1}))
+	}
+	return flush(t, m)[name].Value
+}
+
+func TestSyncInstruments(t *testing.T) {
+	m := metrics.NewMeasurements(true, 100)
+	p := NewMeterProvider(m)
+	mt := p.Meter("test")
+	ctx := context.Background()
+	attrs := metric.WithAttributes(attribute.String("method", "GET"), attribute.Int("code", 200))
+
+	counter, err := mt.Int64Counter("requests")
+	require.NoError(t, err)
+	counter.Add(ctx, 2, attrs)
+	counter.Add(ctx, 3, attrs)
+	counter.Add(ctx, -1, attrs) // ignored
+	counter.Add(ctx, 1)
+
+	upDown, err := mt.Float64UpDownCounter("inflight")
+	require.NoError(t, err)
+	upDown.Add(ctx, 1.5)
+	upDown.Add(ctx, -0.5)
+
+	hist, err := mt.Int64Histogram("latency")
+	require.NoError(t, err)
+	for _, v := range []int64{10, 20, 60} {
+		hist.Record(ctx, v)
+	}
+	fhist, err := mt.Float64Histogram("size", metric.WithUnit("ms"))
+	require.NoError(t, err)
+	fhist.Record(ctx, 2.4)
+	fhist.Record(ctx, 2.6)
+	fhist.Record(ctx, -1) // out of range
+	ratio, err := mt.Float64Histogram("ratio")
+	require.NoError(t, err)
+	ratio.Record(ctx, 0.25)
+	ratio.Record(ctx, 0.5)
+
+	_, err = mt.Float64Counter("")
+	assert.Error(t, err)
+
+	assert.Equal(t, map[string]measurement{
+		"requests[code:200 method:GET]": {Count: 2, Sum: 5},
+		"requests":                      {Count: 1, Sum: 1},
+		"inflight":                      {Count: 1, Sum: 1},
+		"latency":                       {Value: histogram(t, "latency", 10, 20, 60)},
+		"size":                          {Value: histogram(t, "size", 2400, 2600)},
+		"ratio":                         {Count: 2, Sum: 0.75},
+	}, flush(t, m))
+
+	// reset after each flush, but the running total of the up-down counter
+	upDown.Add(ctx, 2)
+	assert.Equal(t, map[string]measurement{
+		"inflight": {Count: 1, Sum: 3},
+	}, flush(t, m))
+	assert.Equal(t, map[string]measurement{
+		"inflight": {Count: 1, Sum: 3},
+	}, flush(t, m))
+
+	// the totals are shared by the meters
+	upDown, err = p.Meter("other").Float64UpDownCounter("inflight")
+	require.NoError(t, err)
+	upDown.Add(ctx, -3)
+	assert.Equal(t, map[string]measurement{
+		"inflight": {Count: 1, Sum: 0},
+	}, flush(t, m))
+
+	// nothing is recorded after shutdown
+	p.Shutdown()
+	counter.Add(ctx, 1)
+	assert.Empty(t, flush(t, m))
+}
+
+func TestObservableInstruments(t *testing.T) {
+	m := metrics.NewMeasurements(true, 100)
+	p := NewMeterProvider(m)
+	mt := p.Meter("test")
+
+	var total int64
+	_, err := mt.Int64ObservableCounter("bytes", metric.WithInt64Callback(
+		func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(total)
+			return nil
+		}))
+	require.NoError(t, err)
+	_, err = mt.Float64ObservableGauge("temperature", metric.WithFloat64Callback(
+		func(_ context.Context, o metric.Float64Observer) error {
+			o.Observe(21.5, metric.WithAttributes(attribute.String("room", "a")))
+			return nil
+		}))
+	require.NoError(t, err)
+
+	queue, err := mt.Int64ObservableUpDownCounter("queue")
+	require.NoError(t, err)
+	other, err := mt.Int64ObservableGauge("other")
+	require.NoError(t, err)
+	reg, err := mt.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		o.ObserveInt64(queue, 7)
+		o.ObserveInt64(other, 1) // not registered
+		return nil
+	}, queue)
+	require.NoError(t, err)
+
+	total = 100
+	assert.Equal(t, map[string]measurement{
+		"bytes":               {Count: 1, Sum: 100},
+		"temperature[room:a]": {Count: 1, Sum: 21.5},
+		"queue":               {Count: 1, Sum: 7},
+	}, flush(t, m))
+
+	// the increase of the counter
+	total = 150
+	require.NoError(t, reg.Unregister())
+	assert.Equal(t, map[string]measurement{
+		"bytes":               {Count: 1, Sum: 50},
+		"temperature[room:a]": {Count: 1, Sum: 21.5},
+	}, flush(t, m))
+
+	// the counter is reset
+	total = 20
+	assert.Equal(t, measurement{Count: 1, Sum: 20}, flush(t, m)["bytes"])
+}
+
+func TestObservableInstrumentsScrape(t *testing.T) {
+	r := metrics.NewRegistry()
+	m := r.CustomMetrics()
+	p := NewMeterProvider(m)
+	mt := p.Meter("test")
+
+	var total int64
+	_, err := mt.Int64ObservableCounter("bytes", metric.WithInt64Callback(
+		func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(total)
+			return nil
+		}))
+	require.NoError(t, err)
+
+	total = 100
+	var buf bytes.Buffer
+	require.NoError(t, metrics.WritePrometheus(&buf, r, nil, nil))
+	assert.Contains(t, buf.String(), "sw_apm_custom_bytes_sum 100\n")
+
+	// the scrape doesn't take the increase from the flush
+	total = 150
+	assert.Equal(t, measurement{Count: 1, Sum: 150}, flush(t, m)["bytes"])
+	buf.Reset()
+	require.NoError(t, metrics.WritePrometheus(&buf, r, nil, nil))
+	assert.Contains(t, buf.String(), "sw_apm_custom_bytes_sum 0\n")
+}
+
+func TestDurationHistograms(t *testing.T) {
+	m := metrics.NewMeasurements(true, 100)
+	p := NewMeterProvider(m)
+	mt := p.Meter("test")
+	ctx := context.Background()
+
+	seconds, err := mt.Float64Histogram("http.server.request.duration", metric.WithUnit("s"))
+	require.NoError(t, err)
+	seconds.Record(ctx, 0.123)
+	seconds.Record(ctx, 0.123)
+	seconds.Record(ctx, 1.5)
+	millis, err := mt.Int64Histogram("db.duration", metric.WithUnit("ms"))
+	require.NoError(t, err)
+	millis.Record(ctx, 7)
+
+	// in microseconds, as the response time histograms
+	assert.Equal(t, map[string]measurement{
+		"http.server.request.duration": {Value: histogram(t, "http.server.request.duration", 123000, 123000, 1500000)},
+		"db.duration":                  {Value: histogram(t, "db.duration", 7000)},
+	}, flush(t, m))
+}
+
+func TestLimits(t *testing.T) {
+	m := metrics.NewMeasurements(true, 2)
+	p := NewMeterProvider(m)
+	counter, err := p.Meter("test").Float64Counter("counter")
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	var kvs []attribute.KeyValue
+	for i := 0; i <= metrics.MaxTagsCount; i++ {
+		kvs = append(kvs, attribute.Int(fmt.Sprintf("k%d", i), i))
+	}
+	counter.Add(ctx, 1, metric.WithAttributes(kvs...))
+	assert.Empty(t, flush(t, m))
+
+	for i := 0; i < 3; i++ {
+		counter.Add(ctx, 1, metric.WithAttributes(attribute.Int("i", i)))
+	}
+	assert.Len(t, flush(t, m), 2)
+
+	// the states kept for each set of attributes are capped too
+	upDown, err := p.Meter("test").Float64UpDownCounter("upDown")
+	require.NoError(t, err)
+	upDown.Add(ctx, 1, metric.WithAttributes(kvs...))
+	for i := 0; i < 3; i++ {
+		upDown.Add(ctx, 1, metric.WithAttributes(attribute.Int("i", i)))
+	}
+	c := p.getUpDownCounter("upDown")
+	c.lock.Lock()
+	assert.Len(t, c.totals, 2)
+	c.lock.Unlock()
+
+	var observed int
+	_, err = p.Meter("test").Int64ObservableCounter("observed", metric.WithInt64Callback(
+		func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(1, metric.WithAttributes(kvs...))
+			for i := 0; i < observed; i++ {
+				o.Observe(1, metric.WithAttributes(attribute.Int("i", i)))
+			}
+			return nil
+		}))
+	require.NoError(t, err)
+	observed = 3
+	flush(t, m)
+	o := p.getObservable("observed", observableCounter)
+	o.lock.Lock()
+	assert.Len(t, o.last, 2)
+	o.lock.Unlock()
+}
+
+func TestMeters(t *testing.T) {
+	p := NewMeterProvider(metrics.NewMeasurements(true, 100))
+	assert.Same(t, p.Meter("a"), p.Meter("a"))
+	assert.NotSame(t, p.Meter("a"), p.Meter("a", metric.WithInstrumentationVersion("v1")))
+}
@@ -0,0 +1,175 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package meter
+
+import (
+	"sync"
+
+	"github.com/solarwinds/apm-go/internal/log"
+	"github.com/solarwinds/apm-go/internal/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/embedded"
+)
+
+type observableKind int
+
+const (
+	observableCounter observableKind = iota
+	observableUpDownCounter
+	observableGauge
+)
+
+// observable is the state of an observable instrument.
+type observable struct {
+	p    *MeterProvider
+	name string
+	kind observableKind
+
+	lock sync.Mutex
+	last map[attribute.Distinct]float64 // the previous values of a counter
+}
+
+func newObservable(p *MeterProvider, name string, kind observableKind) *observable {
+	return &observable{
+		p:    p,
+		name: name,
+		kind: kind,
+		last: make(map[attribute.Distinct]float64),
+	}
+}
+
+// observe records an observed value into the custom metrics into. The value
+// of a counter is cumulative, so the increase since the previous observation
+// is recorded, or the value itself if the counter was reset. The previous
+// value is only kept on a flush, i.e., when recording into the custom metrics
+// of the provider, so a scrape doesn't take the increase from the next flush.
+// A new set of attributes is dropped if it can't be kept, see checkNewAttrs.
+// The values of the up-down counters and the gauges are recorded as gauges.
+func (o *observable) observe(into *metrics.Measurements, value float64, attrs attribute.Set) {
+	if o.kind != observableCounter {
+		o.p.recordGauge(into, o.name, value, attrs)
+		return
+	}
+	o.lock.Lock()
+	key := attrs.Equivalent()
+	last, ok := o.last[key]
+	if !ok {
+		if err := o.p.checkNewAttrs(len(o.last), attrs); err != nil {
+			o.lock.Unlock()
+			log.Debugf("Dropped the measurement of %s: %v", o.name, err)
+			return
+		}
+	}
+	if into == o.p.m {
+		o.last[key] = value
+	}
+	o.lock.Unlock()
+
+	if value >= last {
+		value -= last
+	}
+	o.p.record(into, o.name, value, attrs)
+}
+
+// int64Observable is an observable int64 instrument: a counter, an up-down
+// counter or a gauge.
+type int64Observable struct {
+	metric.Int64Observable
+	embedded.Int64ObservableCounter
+	embedded.Int64ObservableUpDownCounter
+	embedded.Int64ObservableGauge
+
+	*observable
+}
+
+// float64Observable is an observable float64 instrument: a counter, an up-down
+// counter or a gauge.
+type float64Observable struct {
+	metric.Float64Observable
+	embedded.Float64ObservableCounter
+	embedded.Float64ObservableUpDownCounter
+	embedded.Float64ObservableGauge
+
+	*observable
+}
+
+// int64Observer is the observer passed to the callbacks of an int64 instrument.
+type int64Observer struct {
+	embedded.Int64Observer
+
+	o    *observable
+	into *metrics.Measurements
+}
+
+func (obs *int64Observer) Observe(value int64, options ...metric.ObserveOption) {
+	obs.o.observe(obs.into, float64(value), metric.NewObserveConfig(options).Attributes())
+}
+
+// float64Observer is the observer passed to the callbacks of a float64
+// instrument.
+type float64Observer struct {
+	embedded.Float64Observer
+
+	o    *observable
+	into *metrics.Measurements
+}
+
+func (obs *float64Observer) Observe(value float64, options ...metric.ObserveOption) {
+	obs.o.observe(obs.into, value, metric.NewObserveConfig(options).Attributes())
+}
+
+// observer is the observer passed to the callbacks registered by
+// RegisterCallback. It only observes the instruments given at registration.
+type observer struct {
+	embedded.Observer
+
+	allowed map[*observable]bool
+	into    *metrics.Measurements
+}
+
+func (obs *observer) ObserveFloat64(inst metric.Float64Observable, value float64, options ...metric.ObserveOption) {
+	o, ok := inst.(*float64Observable)
+	if !ok || !obs.allowed[o.observable] {
+		log.Debugf("Ignored the observation of an unregistered instrument: %v", inst)
+		return
+	}
+	o.observe(obs.into, value, metric.NewObserveConfig(options).Attributes())
+}
+
+func (obs *observer) ObserveInt64(inst metric.Int64Observable, value int64, options ...metric.ObserveOption) {
+	o, ok := inst.(*int64Observable)
+	if !ok || !obs.allowed[o.observable] {
+		log.Debugf("Ignored the observation of an unregistered instrument: %v", inst)
+		return
+	}
+	o.observe(obs.into, float64(value), metric.NewObserveConfig(options).Attributes())
+}
+
+// registration unregisters a callback registered by RegisterCallback.
+type registration struct {
+	embedded.Registration
+
+	unregister func()
+}
+
+func (r *registration) Unregister() error {
+	r.unregister()
+	return nil
+}
+
+func logCallbackErr(err error) {
+	log.Warningf("Metric callback failed: %v", err)
+}
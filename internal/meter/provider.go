@@ -0,0 +1,236 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package meter implements the OpenTelemetry metrics API on top of the custom
+// metrics, so the measurements of the OpenTelemetry instruments are reported
+// along with the ones submitted by swo.SummaryMetric and swo.IncrementMetric.
+//
+// The measurements are mapped onto the custom metrics, for each name and set
+// of attributes in a flush interval:
+//   - the counters record each value added into a summary measurement, i.e., a
+//     count and a sum, and the observable counters record the increase since
+//     the previous collection
+//   - the up-down counters are reported as gauges of their running total, and
+//     the observable up-down counters and gauges as gauges of the last value
+//     observed
+//   - the histograms record the values, rounded to integers, into the custom
+//     histograms, so the values out of the range of a histogram are dropped.
+//     The values of the histograms with a duration unit, e.g., "s" or "ms",
+//     are converted into microseconds, as the response time histograms. The
+//     float64 histograms of other units record the values into a summary
+//     measurement instead, as the fractions would be lost.
+//
+// The attributes are reported as the tags. The limits of the custom metrics
+// apply: measurements with more than metrics.MaxTagsCount attributes, and new
+// measurements beyond the custom metrics cap, are dropped. The up-down counters
+// and the observable counters keep a state for each set of attributes, which
+// is capped the same way.
+package meter
+
+import (
+	"context"
+	"math"
+	"sync"
+
+	"github.com/solarwinds/apm-go/internal/log"
+	"github.com/solarwinds/apm-go/internal/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/embedded"
+)
+
+// MeterProvider is the metric.MeterProvider which records the measurements
+// into a set of custom metrics.
+type MeterProvider struct {
+	embedded.MeterProvider
+
+	m          *metrics.Measurements
+	unregister func()
+
+	lock        sync.Mutex
+	closed      bool
+	meters      map[string]*meter
+	observables map[string]*observable    // by name, see observable
+	upDowns     map[string]*upDownCounter // by name, see upDownCounter
+	callbacks   map[int]func(ctx context.Context, into *metrics.Measurements)
+	callbackID  int
+}
+
+var _ metric.MeterProvider = (*MeterProvider)(nil)
+
+// NewMeterProvider returns a MeterProvider recording into the measurements m.
+// The observable instruments are observed, and the running totals of the
+// up-down counters recorded, before m is flushed, and into a scratch copy of m
+// on each Prometheus scrape.
+func NewMeterProvider(m *metrics.Measurements) *MeterProvider {
+	p := &MeterProvider{
+		m:           m,
+		meters:      make(map[string]*meter),
+		observables: make(map[string]*observable),
+		upDowns:     make(map[string]*upDownCounter),
+		callbacks:   make(map[int]func(ctx context.Context, into *metrics.Measurements)),
+	}
+	p.unregister = m.OnCollect(p.collect)
+	return p
+}
+
+// Meter returns the meter of the instrumentation scope. The scope is not
+// reported.
+func (p *MeterProvider) Meter(name string, opts ...metric.MeterOption) metric.Meter {
+	cfg := metric.NewMeterConfig(opts...)
+	key := name + "|" + cfg.InstrumentationVersion() + "|" + cfg.SchemaURL()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	m, ok := p.meters[key]
+	if !ok {
+		m = &meter{p: p}
+		p.meters[key] = m
+	}
+	return m
+}
+
+// Shutdown stops recording the measurements. The instruments are no-ops
+// afterwards.
+func (p *MeterProvider) Shutdown() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	p.unregister()
+	p.callbacks = make(map[int]func(ctx context.Context, into *metrics.Measurements))
+}
+
+func (p *MeterProvider) isClosed() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.closed
+}
+
+// record records a value of the instrument into the custom metrics into,
+// which are p.m unless a scrape is being collected.
+func (p *MeterProvider) record(into *metrics.Measurements, name string, value float64, attrs attribute.Set) {
+	p.recordWith(into.Summary, name, value, attrs)
+}
+
+// recordGauge records the value of a gauge into the custom metrics into, the
+// last value of a flush interval is reported.
+func (p *MeterProvider) recordGauge(into *metrics.Measurements, name string, value float64, attrs attribute.Set) {
+	p.recordWith(into.Gauge, name, value, attrs)
+}
+
+// recordHistogram records a value of a histogram into the custom metrics.
+func (p *MeterProvider) recordHistogram(name string, value float64, attrs attribute.Set) {
+	p.recordWith(func(name string, value float64, opts metrics.MetricOptions) error {
+		return p.m.Histogram(name, int64(math.Round(value)), opts)
+	}, name, value, attrs)
+}
+
+func (p *MeterProvider) recordWith(f func(string, float64, metrics.MetricOptions) error,
+	name string, value float64, attrs attribute.Set) {
+	if p.isClosed() {
+		return
+	}
+	tags := make(map[string]string, attrs.Len())
+	for iter := attrs.Iter(); iter.Next(); {
+		kv := iter.Attribute()
+		tags[string(kv.Key)] = kv.Value.Emit()
+	}
+	err := f(name, value, metrics.MetricOptions{Count: 1, Tags: tags})
+	if err != nil {
+		log.Debugf("Dropped the measurement of %s: %v", name, err)
+	}
+}
+
+// checkNewAttrs returns an error if an instrument which keeps a state for each
+// set of attributes, e.g., the running totals of an up-down counter, can't
+// keep one more for attrs: it has more than metrics.MaxTagsCount attributes,
+// or the instrument already keeps n sets, the cap of the custom metrics.
+func (p *MeterProvider) checkNewAttrs(n int, attrs attribute.Set) error {
+	if attrs.Len() > metrics.MaxTagsCount {
+		return metrics.ErrExceedsTagsCountLimit
+	}
+	if int32(n) >= p.m.Cap() {
+		return metrics.ErrExceedsMetricsCountLimit
+	}
+	return nil
+}
+
+// register registers a callback called on collection with the custom metrics
+// to record into. It returns a function to unregister it.
+func (p *MeterProvider) register(f func(ctx context.Context, into *metrics.Measurements)) func() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.registerLocked(f)
+}
+
+// registerLocked is register with the lock held.
+func (p *MeterProvider) registerLocked(f func(ctx context.Context, into *metrics.Measurements)) func() {
+	if p.closed {
+		return func() {}
+	}
+	id := p.callbackID
+	p.callbackID++
+	p.callbacks[id] = f
+	return func() {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		delete(p.callbacks, id)
+	}
+}
+
+// collect calls the callbacks to observe the observable instruments into the
+// custom metrics given, which are p.m on a flush or a scratch copy of them on
+// a scrape.
+func (p *MeterProvider) collect(into *metrics.Measurements) {
+	p.lock.Lock()
+	callbacks := make([]func(ctx context.Context, into *metrics.Measurements), 0, len(p.callbacks))
+	for _, f := range p.callbacks {
+		callbacks = append(callbacks, f)
+	}
+	p.lock.Unlock()
+
+	for _, f := range callbacks {
+		f(context.Background(), into)
+	}
+}
+
+// getObservable returns the observable instrument of the name, which is shared
+// by all the meters so a counter keeps its previous values.
+func (p *MeterProvider) getObservable(name string, kind observableKind) *observable {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	o, ok := p.observables[name]
+	if !ok || o.kind != kind {
+		o = newObservable(p, name, kind)
+		p.observables[name] = o
+	}
+	return o
+}
+
+// getUpDownCounter returns the running totals of the up-down counter of the
+// name, which are shared by all the meters.
+func (p *MeterProvider) getUpDownCounter(name string) *upDownCounter {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	c, ok := p.upDowns[name]
+	if !ok {
+		c = newUpDownCounter(p, name)
+		p.registerLocked(func(_ context.Context, into *metrics.Measurements) { c.collect(into) })
+		p.upDowns[name] = c
+	}
+	return c
+}
@@ -0,0 +1,275 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/solarwinds/apm-go/internal/bson"
+	"github.com/solarwinds/apm-go/internal/log"
+)
+
+const (
+	cgroupRoot = "/sys/fs/cgroup"
+	cgroupSelf = "/proc/self/cgroup"
+
+	// the memory limit of cgroup v1 when there is none, rounded down to the page size
+	cgroupV1Unlimited = int64(1) << 62
+)
+
+// cgroup is the control group of the process. The files of the controllers
+// are read on each flush.
+type cgroup struct {
+	v2    bool
+	paths map[string]string // the directory of each controller, "" for cgroup v2
+}
+
+var (
+	selfCgroup     *cgroup
+	selfCgroupOnce sync.Once
+)
+
+// getSelfCgroup detects the cgroup of the process once, it returns nil if
+// there is none.
+func getSelfCgroup() *cgroup {
+	selfCgroupOnce.Do(func() {
+		selfCgroup = detectCgroup(cgroupRoot, cgroupSelf)
+		if selfCgroup != nil {
+			log.Debugf("Got cgroup (v2=%v): %v", selfCgroup.v2, selfCgroup.paths)
+		}
+	})
+	return selfCgroup
+}
+
+// detectCgroup returns the cgroup of the process described by the file self,
+// usually /proc/self/cgroup, with the hierarchies mounted at root. It returns
+// nil if the hierarchies are not mounted.
+func detectCgroup(root string, self string) *cgroup {
+	paths := make(map[string]string) // the cgroup path of each controller
+	if f, err := os.Open(self); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			// hierarchy-ID:controller-list:cgroup-path, e.g. 4:memory:/kubepods/pod1
+			fields := strings.SplitN(scanner.Text(), ":", 3)
+			if len(fields) != 3 {
+				continue
+			}
+			for _, controller := range strings.Split(fields[1], ",") {
+				paths[controller] = fields[2]
+			}
+		}
+		_ = f.Close()
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		return &cgroup{
+			v2:    true,
+			paths: map[string]string{"": cgroupDir(root, paths[""])},
+		}
+	}
+
+	cg := &cgroup{paths: make(map[string]string)}
+	for _, controller := range []string{"memory", "cpu", "cpuacct", "pids"} {
+		dir := filepath.Join(root, controller)
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		cg.paths[controller] = cgroupDir(dir, paths[controller])
+	}
+	if len(cg.paths) == 0 {
+		return nil
+	}
+	return cg
+}
+
+// cgroupDir returns the directory of the cgroup path under the mount point,
+// or the mount point itself if the path isn't visible, e.g., in a container
+// where the cgroup of the container is mounted as the root.
+func cgroupDir(mount string, path string) string {
+	if path != "" && path != "/" {
+		dir := filepath.Join(mount, path)
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+	}
+	return mount
+}
+
+// metrics returns the metrics of the cgroup available, the limits are left
+// out if there is none.
+func (cg *cgroup) metrics() []hostMetric {
+	if cg.v2 {
+		return cg.metricsV2()
+	}
+	return cg.metricsV1()
+}
+
+func (cg *cgroup) metricsV2() []hostMetric {
+	var ms []hostMetric
+	dir := cg.paths[""]
+
+	// memory
+	if limit, ok := readCgroupInt(filepath.Join(dir, "memory.max")); ok {
+		ms = append(ms, hostMetric{"ContainerMemoryLimit", limit})
+	}
+	if usage, ok := readCgroupInt(filepath.Join(dir, "memory.current")); ok {
+		ms = append(ms, hostMetric{"ContainerMemoryUsage", usage})
+		inactive := readCgroupStat(filepath.Join(dir, "memory.stat"))["inactive_file"]
+		ms = append(ms, hostMetric{"ContainerMemoryWorkingSet", workingSet(usage, inactive)})
+	}
+
+	// cpu
+	if fields := strings.Fields(readCgroupFile(filepath.Join(dir, "cpu.max"))); len(fields) == 2 {
+		// $MAX $PERIOD, e.g., 50000 100000
+		quota, err1 := strconv.ParseInt(fields[0], 10, 64)
+		period, err2 := strconv.ParseInt(fields[1], 10, 64)
+		if err1 == nil && err2 == nil && quota > 0 && period > 0 {
+			ms = append(ms, hostMetric{"ContainerCPUQuota", float64(quota) / float64(period)})
+		}
+	}
+	if stat := readCgroupStat(filepath.Join(dir, "cpu.stat")); stat != nil {
+		ms = append(ms,
+			hostMetric{"ContainerCPUUsageNs", stat["usage_usec"] * 1000},
+			hostMetric{"ContainerCPUPeriods", stat["nr_periods"]},
+			hostMetric{"ContainerCPUThrottledPeriods", stat["nr_throttled"]},
+		)
+	}
+
+	return append(ms, cg.pidsMetrics(dir)...)
+}
+
+func (cg *cgroup) metricsV1() []hostMetric {
+	var ms []hostMetric
+
+	// memory
+	if dir, ok := cg.paths["memory"]; ok {
+		if limit, ok := readCgroupInt(filepath.Join(dir, "memory.limit_in_bytes")); ok && limit < cgroupV1Unlimited {
+			ms = append(ms, hostMetric{"ContainerMemoryLimit", limit})
+		}
+		if usage, ok := readCgroupInt(filepath.Join(dir, "memory.usage_in_bytes")); ok {
+			ms = append(ms, hostMetric{"ContainerMemoryUsage", usage})
+			inactive := readCgroupStat(filepath.Join(dir, "memory.stat"))["total_inactive_file"]
+			ms = append(ms, hostMetric{"ContainerMemoryWorkingSet", workingSet(usage, inactive)})
+		}
+	}
+
+	// cpu
+	if dir, ok := cg.paths["cpu"]; ok {
+		quota, ok1 := readCgroupInt(filepath.Join(dir, "cpu.cfs_quota_us"))
+		period, ok2 := readCgroupInt(filepath.Join(dir, "cpu.cfs_period_us"))
+		if ok1 && ok2 && quota > 0 && period > 0 {
+			ms = append(ms, hostMetric{"ContainerCPUQuota", float64(quota) / float64(period)})
+		}
+	}
+	if dir, ok := cg.paths["cpuacct"]; ok {
+		if usage, ok := readCgroupInt(filepath.Join(dir, "cpuacct.usage")); ok {
+			ms = append(ms, hostMetric{"ContainerCPUUsageNs", usage})
+		}
+	}
+	if dir, ok := cg.paths["cpu"]; ok {
+		if stat := readCgroupStat(filepath.Join(dir, "cpu.stat")); stat != nil {
+			ms = append(ms,
+				hostMetric{"ContainerCPUPeriods", stat["nr_periods"]},
+				hostMetric{"ContainerCPUThrottledPeriods", stat["nr_throttled"]},
+			)
+		}
+	}
+
+	if dir, ok := cg.paths["pids"]; ok {
+		ms = append(ms, cg.pidsMetrics(dir)...)
+	}
+	return ms
+}
+
+// pidsMetrics returns the metrics of the pids controller, which has the same
+// files in cgroup v1 and v2.
+func (cg *cgroup) pidsMetrics(dir string) []hostMetric {
+	var ms []hostMetric
+	if current, ok := readCgroupInt(filepath.Join(dir, "pids.current")); ok {
+		ms = append(ms, hostMetric{"ContainerPidsCurrent", current})
+	}
+	if max, ok := readCgroupInt(filepath.Join(dir, "pids.max")); ok {
+		ms = append(ms, hostMetric{"ContainerPidsMax", max})
+	}
+	return ms
+}
+
+// workingSet returns the memory usage without the inactive file cache, which
+// the kernel can reclaim.
+func workingSet(usage, inactive int64) int64 {
+	if inactive > usage {
+		return 0
+	}
+	return usage - inactive
+}
+
+// readCgroupFile returns the content of a cgroup file without the trailing
+// line-feed, or an empty string if the file can't be read.
+func readCgroupFile(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// readCgroupInt reads a file of a single value. It returns false if the file
+// can't be read or has no limit, i.e., "max".
+func readCgroupInt(path string) (int64, bool) {
+	v, err := strconv.ParseInt(readCgroupFile(path), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// readCgroupStat reads a file of "key value" lines, e.g., memory.stat. It
+// returns nil if the file can't be read.
+func readCgroupStat(path string) map[string]int64 {
+	content := readCgroupFile(path)
+	if content == "" {
+		return nil
+	}
+	stat := make(map[string]int64)
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			stat[fields[0]] = v
+		}
+	}
+	return stat
+}
+
+// containerMetrics returns the metrics of the cgroup of the process.
+func containerMetrics() []hostMetric {
+	cg := getSelfCgroup()
+	if cg == nil {
+		return nil
+	}
+	return cg.metrics()
+}
+
+// addCgroupMetrics appends the metrics of the cgroup of the process.
+func addCgroupMetrics(bbuf *bson.Buffer, index *int) {
+	addHostMetricsValues(bbuf, index, containerMetrics())
+}
@@ -0,0 +1,91 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCgroupV1(t *testing.T) {
+	root := filepath.Join("testdata", "cgroup", "v1")
+	cg := detectCgroup(root, filepath.Join(root, "self_cgroup"))
+	require.NotNil(t, cg)
+	assert.False(t, cg.v2)
+	assert.Equal(t, map[string]string{
+		"memory":  filepath.Join(root, "memory", "kubepods", "pod1", "ctr"),
+		"cpu":     filepath.Join(root, "cpu"), // the path isn't visible, e.g., in a container
+		"cpuacct": filepath.Join(root, "cpuacct"),
+		"pids":    filepath.Join(root, "pids"),
+	}, cg.paths)
+
+	assert.Equal(t, []hostMetric{
+		{"ContainerMemoryLimit", int64(268435456)},
+		{"ContainerMemoryUsage", int64(104857600)},
+		{"ContainerMemoryWorkingSet", int64(83886080)},
+		{"ContainerCPUQuota", 0.5},
+		{"ContainerCPUUsageNs", int64(987654321)},
+		{"ContainerCPUPeriods", int64(1200)},
+		{"ContainerCPUThrottledPeriods", int64(30)},
+		{"ContainerPidsCurrent", int64(12)},
+		{"ContainerPidsMax", int64(100)},
+	}, cg.metrics())
+
+	// no limits
+	cg.paths["memory"] = filepath.Join(root, "memory")
+	delete(cg.paths, "cpu")
+	for _, m := range cg.metrics() {
+		assert.NotEqual(t, "ContainerMemoryLimit", m.name)
+		assert.NotEqual(t, "ContainerCPUQuota", m.name)
+		assert.NotEqual(t, "ContainerCPUPeriods", m.name)
+	}
+}
+
+func TestCgroupV2(t *testing.T) {
+	root := filepath.Join("testdata", "cgroup", "v2")
+	cg := detectCgroup(root, filepath.Join(root, "self_cgroup"))
+	require.NotNil(t, cg)
+	assert.True(t, cg.v2)
+	assert.Equal(t, map[string]string{"": filepath.Join(root, "kubepods", "pod1", "ctr")}, cg.paths)
+
+	// neither cpu quota nor pids limit
+	assert.Equal(t, []hostMetric{
+		{"ContainerMemoryLimit", int64(536870912)},
+		{"ContainerMemoryUsage", int64(209715200)},
+		{"ContainerMemoryWorkingSet", int64(167772160)},
+		{"ContainerCPUUsageNs", int64(2500000000)},
+		{"ContainerCPUPeriods", int64(800)},
+		{"ContainerCPUThrottledPeriods", int64(0)},
+		{"ContainerPidsCurrent", int64(7)},
+	}, cg.metrics())
+
+	// the cgroup of the container is mounted as the root
+	cg = detectCgroup(root, filepath.Join(root, "missing"))
+	require.NotNil(t, cg)
+	assert.Equal(t, map[string]string{"": root}, cg.paths)
+	assert.Empty(t, cg.metrics())
+}
+
+func TestCgroupNone(t *testing.T) {
+	assert.Nil(t, detectCgroup(t.TempDir(), filepath.Join("testdata", "cgroup", "v1", "self_cgroup")))
+}
+
+func TestWorkingSet(t *testing.T) {
+	assert.EqualValues(t, 60, workingSet(100, 40))
+	assert.EqualValues(t, 0, workingSet(100, 140))
+}
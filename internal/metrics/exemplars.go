@@ -0,0 +1,105 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/solarwinds/apm-go/internal/bson"
+	"github.com/solarwinds/apm-go/internal/rand"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// exemplarsPerRange is the maximum number of exemplars kept per value range of
+// a histogram in a flush interval.
+const exemplarsPerRange = 2
+
+// exemplar is a sampled span whose duration is recorded in a histogram.
+type exemplar struct {
+	traceID trace.TraceID
+	spanID  trace.SpanID
+	value   int64 // the duration in microseconds
+	time    time.Time
+}
+
+// exemplarReservoir keeps a uniform sample of the exemplars of a histogram per
+// value range, i.e., the classic buckets, so the slow requests are not crowded
+// out by the fast ones. It holds at most exemplarsPerRange exemplars per range
+// and is cleared with the histogram in each flush interval. It's protected by
+// the lock of the histograms.
+type exemplarReservoir struct {
+	ranges map[int]*exemplarRange
+}
+
+// exemplarRange is the reservoir of a value range
+type exemplarRange struct {
+	seen      int64 // the number of exemplars offered
+	exemplars []exemplar
+}
+
+// offer adds the exemplar to the reservoir of its value range, or replaces a
+// random one with the probability exemplarsPerRange/seen if it's full.
+func (r *exemplarReservoir) offer(e exemplar) {
+	if r.ranges == nil {
+		r.ranges = make(map[int]*exemplarRange)
+	}
+	i := sort.Search(len(classicBuckets), func(i int) bool { return classicBuckets[i] >= e.value })
+	rg, ok := r.ranges[i]
+	if !ok {
+		rg = &exemplarRange{}
+		r.ranges[i] = rg
+	}
+	rg.seen++
+	if len(rg.exemplars) < exemplarsPerRange {
+		rg.exemplars = append(rg.exemplars, e)
+	} else if j := rand.RandIntn(int(rg.seen)); j < exemplarsPerRange {
+		rg.exemplars[j] = e
+	}
+}
+
+// all returns the exemplars sorted by value.
+func (r *exemplarReservoir) all() []exemplar {
+	if r == nil {
+		return nil
+	}
+	var all []exemplar
+	for _, rg := range r.ranges {
+		all = append(all, rg.exemplars...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].value < all[j].value })
+	return all
+}
+
+// addExemplarsToBSON appends the exemplars of a histogram, if any, as an array
+// of the trace ID, span ID, value (microseconds) and timestamp (microseconds
+// since the epoch).
+func addExemplarsToBSON(bbuf *bson.Buffer, r *exemplarReservoir) {
+	exemplars := r.all()
+	if len(exemplars) == 0 {
+		return
+	}
+	start := bbuf.AppendStartArray("exemplars")
+	for i, e := range exemplars {
+		s := bbuf.AppendStartObject(strconv.Itoa(i))
+		bbuf.AppendString("trace_id", e.traceID.String())
+		bbuf.AppendString("span_id", e.spanID.String())
+		bbuf.AppendInt64("value", e.value)
+		bbuf.AppendInt64("timestamp", e.time.UnixMicro())
+		bbuf.AppendFinishObject(s)
+	}
+	bbuf.AppendFinishObject(start)
+}
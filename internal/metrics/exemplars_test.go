@@ -0,0 +1,99 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/solarwinds/apm-go/internal/bson"
+	"github.com/solarwinds/apm-go/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestExemplarReservoir(t *testing.T) {
+	var r *exemplarReservoir
+	assert.Nil(t, r.all())
+
+	r = &exemplarReservoir{}
+	for i := 0; i < 100; i++ {
+		r.offer(exemplar{spanID: trace.SpanID{byte(i)}, value: 150})
+		r.offer(exemplar{spanID: trace.SpanID{byte(i)}, value: 3000})
+	}
+	r.offer(exemplar{spanID: trace.SpanID{0xff}, value: 2})
+
+	// the memory is bounded per value range, and the slow ones are kept
+	all := r.all()
+	require.Len(t, all, 2*exemplarsPerRange+1)
+	assert.Equal(t, trace.SpanID{0xff}, all[0].spanID)
+	for _, e := range all[1 : exemplarsPerRange+1] {
+		assert.EqualValues(t, 150, e.value)
+	}
+	for _, e := range all[exemplarsPerRange+1:] {
+		assert.EqualValues(t, 3000, e.value)
+	}
+	var seen []int64
+	for _, rg := range r.ranges {
+		seen = append(seen, rg.seen)
+	}
+	assert.ElementsMatch(t, []int64{1, 100, 100}, seen)
+}
+
+func TestRecordSpanExemplars(t *testing.T) {
+	tr, teardown := testutils.TracerSetup()
+	defer teardown()
+
+	hi := &histograms{histograms: make(map[string]*histogram), precision: metricsHistPrecisionDefault}
+	now := time.Now()
+	_, span := tr.Start(context.Background(), "/slow", trace.WithTimestamp(now),
+		trace.WithSpanKind(trace.SpanKindServer))
+	span.End(trace.WithTimestamp(now.Add(2 * time.Second)))
+	recordSpan(span.(sdktrace.ReadOnlySpan), false, NewMeasurements(false, 10), hi)
+
+	for _, id := range []string{"", "/slow"} {
+		all := hi.histograms[id].exemplars.all()
+		require.Len(t, all, 1, id)
+		assert.Equal(t, span.SpanContext().TraceID(), all[0].traceID)
+		assert.Equal(t, span.SpanContext().SpanID(), all[0].spanID)
+		assert.EqualValues(t, 2000000, all[0].value)
+	}
+
+	// the spans not sampled have no exemplars
+	hi.recordHistogram("/unsampled", time.Second, trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x01},
+		SpanID:  trace.SpanID{0x02},
+	}))
+	assert.Nil(t, hi.histograms["/unsampled"].exemplars)
+
+	index := 0
+	bbuf := bson.NewBuffer()
+	addHistogramToBSON(bbuf, &index, hi.histograms["/slow"])
+	addHistogramToBSON(bbuf, &index, hi.histograms["/unsampled"])
+	bbuf.Finish()
+	m := bsonToMap(bbuf)
+
+	exemplars := m["0"].(map[string]interface{})["exemplars"].([]interface{})
+	require.Len(t, exemplars, 1)
+	e := exemplars[0].(map[string]interface{})
+	assert.Equal(t, span.SpanContext().TraceID().String(), e["trace_id"])
+	assert.Equal(t, span.SpanContext().SpanID().String(), e["span_id"])
+	assert.EqualValues(t, 2000000, e["value"])
+	assert.NotZero(t, e["timestamp"])
+	assert.NotContains(t, m["1"], "exemplars")
+}
@@ -0,0 +1,268 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/solarwinds/apm-go/internal/bson"
+	"github.com/solarwinds/apm-go/internal/host"
+	"github.com/solarwinds/apm-go/internal/log"
+	"github.com/solarwinds/apm-go/internal/utils"
+)
+
+const (
+	procStat       = "/proc/stat"
+	procNetDev     = "/proc/net/dev"
+	procDiskStats  = "/proc/diskstats"
+	procSelfFD     = "/proc/self/fd"
+	procSelfLimits = "/proc/self/limits"
+	sysBlock       = "/sys/block"
+
+	diskSectorSize = 512 // the unit of the sectors in /proc/diskstats
+)
+
+// cpuTimes is the time the CPUs spent in each mode, in USER_HZ, from the cpu
+// line of /proc/stat.
+type cpuTimes struct {
+	user, nice, system, idle, iowait, irq, softirq, steal uint64
+}
+
+func (t cpuTimes) total() uint64 {
+	return t.user + t.nice + t.system + t.idle + t.iowait + t.irq + t.softirq + t.steal
+}
+
+// parseCPUTimes parses the cpu line of /proc/stat, e.g.,
+// cpu  4705 356 584 3699176 23060 0 277 0 0 0
+func parseCPUTimes(line string) (cpuTimes, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 9 || fields[0] != "cpu" {
+		return cpuTimes{}, false
+	}
+	var vs [8]uint64
+	for i := range vs {
+		v, err := strconv.ParseUint(fields[i+1], 10, 64)
+		if err != nil {
+			return cpuTimes{}, false
+		}
+		vs[i] = v
+	}
+	return cpuTimes{
+		user: vs[0], nice: vs[1], system: vs[2], idle: vs[3],
+		iowait: vs[4], irq: vs[5], softirq: vs[6], steal: vs[7],
+	}, true
+}
+
+// hostSampler keeps the state of the host metrics between two flushes.
+type hostSampler struct {
+	prevCPU cpuTimes
+	lock    sync.Mutex
+}
+
+// cpuMetrics returns the CPU utilisation in percent since the last call, or
+// since the boot on the first call.
+func (s *hostSampler) cpuMetrics(t cpuTimes) []hostMetric {
+	s.lock.Lock()
+	prev := s.prevCPU
+	s.prevCPU = t
+	s.lock.Unlock()
+
+	if t.total() < prev.total() {
+		prev = cpuTimes{} // the counters were reset
+	}
+	total := t.total() - prev.total()
+	if total == 0 {
+		return nil
+	}
+	percent := func(cur, prev uint64) float64 {
+		if cur < prev {
+			return 0
+		}
+		return float64(cur-prev) * 100 / float64(total)
+	}
+	return []hostMetric{
+		{"HostCPUUser", percent(t.user+t.nice, prev.user+prev.nice)},
+		{"HostCPUSystem", percent(t.system+t.irq+t.softirq, prev.system+prev.irq+prev.softirq)},
+		{"HostCPUIOWait", percent(t.iowait, prev.iowait)},
+		{"HostCPUSteal", percent(t.steal, prev.steal)},
+	}
+}
+
+// processCPUMetrics returns the CPU time of the process in nanoseconds.
+func processCPUMetrics() []hostMetric {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		log.Debugf("Failed to get the resource usage: %v", err)
+		return nil
+	}
+	return []hostMetric{
+		{"ProcessCPUUserNs", ru.Utime.Nano()},
+		{"ProcessCPUSystemNs", ru.Stime.Nano()},
+	}
+}
+
+// fdMetrics returns the number of the file descriptors in the directory fdDir,
+// e.g., /proc/self/fd, and the soft limit in the limits file, e.g.,
+// /proc/self/limits, unless it is unlimited. The descriptor opened to read
+// fdDir is listed too, so it is not counted.
+func fdMetrics(fdDir string, limits string) []hostMetric {
+	var ms []hostMetric
+	if entries, err := os.ReadDir(fdDir); err == nil && len(entries) > 0 {
+		ms = append(ms, hostMetric{"ProcessOpenFDs", int64(len(entries) - 1)})
+	}
+	if max, ok := parseMaxOpenFiles(utils.GetStrByKeyword(limits, "Max open files")); ok {
+		ms = append(ms, hostMetric{"ProcessMaxFDs", max})
+	}
+	return ms
+}
+
+// parseMaxOpenFiles parses the soft limit of the open files in a line of
+// /proc/self/limits, e.g.,
+// Max open files            1024                 1048576              files
+func parseMaxOpenFiles(line string) (int64, bool) {
+	fields := strings.Fields(strings.TrimPrefix(line, "Max open files"))
+	if len(fields) < 1 {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, false // unlimited
+	}
+	return v, true
+}
+
+// netDevStats is the counters of a network interface in /proc/net/dev.
+type netDevStats struct {
+	rxBytes, rxErrors, txBytes, txErrors int64
+}
+
+// parseNetDev parses the content of /proc/net/dev, e.g.,
+//
+//	Inter-|   Receive                                                |  Transmit
+//	 face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+//	  eth0: 1500044    1219    0    0    0     0          0         0   114560     965    0    0    0     0       0          0
+func parseNetDev(content string) map[string]netDevStats {
+	stats := make(map[string]netDevStats)
+	for _, line := range strings.Split(content, "\n") {
+		name, counters, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		fields := strings.Fields(counters)
+		if len(fields) < 16 {
+			continue
+		}
+		var vs [4]int64
+		for i, f := range []int{0, 2, 8, 10} {
+			vs[i], _ = strconv.ParseInt(fields[f], 10, 64)
+		}
+		stats[strings.TrimSpace(name)] = netDevStats{
+			rxBytes: vs[0], rxErrors: vs[1], txBytes: vs[2], txErrors: vs[3],
+		}
+	}
+	return stats
+}
+
+// addNetworkMetrics appends the counters of the interfaces returned by
+// host.FilteredIfaces, tagged by the name of the interface.
+func addNetworkMetrics(bbuf *bson.Buffer, index *int) {
+	ifaces, err := host.FilteredIfaces()
+	if err != nil || len(ifaces) == 0 {
+		return
+	}
+	content, err := os.ReadFile(procNetDev)
+	if err != nil {
+		return
+	}
+	stats := parseNetDev(string(content))
+	for _, iface := range ifaces {
+		st, ok := stats[iface.Name]
+		if !ok {
+			continue
+		}
+		tags := map[string]string{"interface": iface.Name}
+		addMetricsValueWithTags(bbuf, index, "NetworkRxBytes", st.rxBytes, tags)
+		addMetricsValueWithTags(bbuf, index, "NetworkRxErrors", st.rxErrors, tags)
+		addMetricsValueWithTags(bbuf, index, "NetworkTxBytes", st.txBytes, tags)
+		addMetricsValueWithTags(bbuf, index, "NetworkTxErrors", st.txErrors, tags)
+	}
+}
+
+// diskStats is the counters of a block device in /proc/diskstats.
+type diskStats struct {
+	readBytes, writeBytes int64
+}
+
+// parseDiskStats parses the content of /proc/diskstats, e.g.,
+// 259       0 nvme0n1 40711 1205 3358236 9744 161404 80233 6128136 96530 0 71252 107713 0 0 0 0 2870 1439
+// and returns the counters of the devices accepted by the function disk.
+func parseDiskStats(content string, disk func(name string) bool) map[string]diskStats {
+	stats := make(map[string]diskStats)
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 10 || !disk(fields[2]) {
+			continue
+		}
+		read, err1 := strconv.ParseInt(fields[5], 10, 64)
+		written, err2 := strconv.ParseInt(fields[9], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		stats[fields[2]] = diskStats{
+			readBytes:  read * diskSectorSize,
+			writeBytes: written * diskSectorSize,
+		}
+	}
+	return stats
+}
+
+// isDisk returns a function which accepts the devices listed in the
+// directory sysBlock, e.g., /sys/block, which has the disks but not their
+// partitions, except the loop, ram and zram devices.
+func isDisk(sysBlock string) func(name string) bool {
+	return func(name string) bool {
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") || strings.HasPrefix(name, "zram") {
+			return false
+		}
+		_, err := os.Stat(filepath.Join(sysBlock, name))
+		return err == nil
+	}
+}
+
+// addDiskMetrics appends the counters of the disks, tagged by the name of the
+// device.
+func addDiskMetrics(bbuf *bson.Buffer, index *int) {
+	content, err := os.ReadFile(procDiskStats)
+	if err != nil {
+		return
+	}
+	stats := parseDiskStats(string(content), isDisk(sysBlock))
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tags := map[string]string{"device": name}
+		addMetricsValueWithTags(bbuf, index, "DiskReadBytes", stats[name].readBytes, tags)
+		addMetricsValueWithTags(bbuf, index, "DiskWriteBytes", stats[name].writeBytes, tags)
+	}
+}
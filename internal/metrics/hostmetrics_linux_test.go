@@ -0,0 +1,124 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/solarwinds/apm-go/internal/bson"
+	"github.com/solarwinds/apm-go/internal/config"
+	"github.com/solarwinds/apm-go/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCPUTimes(t *testing.T) {
+	times, ok := parseCPUTimes(utils.GetStrByKeyword(filepath.Join("testdata", "proc", "stat"), "cpu "))
+	require.True(t, ok)
+	assert.Equal(t, cpuTimes{user: 74610, nice: 120, system: 12645, idle: 435535,
+		iowait: 400, irq: 30, softirq: 12, steal: 420}, times)
+
+	_, ok = parseCPUTimes("cpu0 37305 60 6322 217767 200 15 6 210 0 0")
+	assert.False(t, ok)
+	_, ok = parseCPUTimes("cpu  1 2 3")
+	assert.False(t, ok)
+}
+
+func TestHostSamplerCPU(t *testing.T) {
+	s := &hostSampler{}
+	times := cpuTimes{user: 60, nice: 10, system: 20, idle: 900, iowait: 5, irq: 3, softirq: 2}
+	// since the boot on the first call
+	assert.Equal(t, []hostMetric{
+		{"HostCPUUser", 7.0},
+		{"HostCPUSystem", 2.5},
+		{"HostCPUIOWait", 0.5},
+		{"HostCPUSteal", 0.0},
+	}, s.cpuMetrics(times))
+
+	times.user += 50
+	times.idle += 40
+	times.steal += 10
+	assert.Equal(t, []hostMetric{
+		{"HostCPUUser", 50.0},
+		{"HostCPUSystem", 0.0},
+		{"HostCPUIOWait", 0.0},
+		{"HostCPUSteal", 10.0},
+	}, s.cpuMetrics(times))
+
+	// no time elapsed
+	assert.Nil(t, s.cpuMetrics(times))
+}
+
+func TestFDMetrics(t *testing.T) {
+	proc := filepath.Join("testdata", "proc", "self")
+	assert.Equal(t, []hostMetric{
+		{"ProcessOpenFDs", int64(3)},
+		{"ProcessMaxFDs", int64(20000)},
+	}, fdMetrics(filepath.Join(proc, "fd"), filepath.Join(proc, "limits")))
+
+	_, ok := parseMaxOpenFiles("Max open files            unlimited            unlimited            files")
+	assert.False(t, ok)
+	assert.Empty(t, fdMetrics(filepath.Join(proc, "missing"), filepath.Join(proc, "missing")))
+}
+
+func TestParseNetDev(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "proc", "net_dev"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]netDevStats{
+		"lo":   {rxBytes: 138314135, txBytes: 138314135},
+		"eth0": {rxBytes: 629166, rxErrors: 3, txBytes: 259398, txErrors: 1},
+		"eth1": {rxBytes: 12345678, txBytes: 9876543},
+	}, parseNetDev(string(content)))
+}
+
+func TestParseDiskStats(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "proc", "diskstats"))
+	require.NoError(t, err)
+	// neither the partitions nor the loop and zram devices
+	assert.Equal(t, map[string]diskStats{
+		"vda": {readBytes: 1366962 * 512, writeBytes: 698864 * 512},
+		"vdb": {readBytes: 17250 * 512, writeBytes: 3416 * 512},
+	}, parseDiskStats(string(content), isDisk(filepath.Join("testdata", "sys", "block"))))
+}
+
+func TestAddHostMetricsGroups(t *testing.T) {
+	names := func(groups config.HostMetricsGroups) map[string]bool {
+		bbuf := bson.NewBuffer()
+		start := bbuf.AppendStartArray("measurements")
+		index := 0
+		addHostMetrics(bbuf, &index, groups, &hostSampler{})
+		bbuf.AppendFinishObject(start)
+		bbuf.Finish()
+		ns := make(map[string]bool)
+		for _, m := range bsonToMap(bbuf)["measurements"].([]interface{}) {
+			ns[m.(map[string]interface{})["name"].(string)] = true
+		}
+		return ns
+	}
+
+	ns := names(config.HostMetricsGroups{})
+	assert.True(t, ns["Load1"])
+	assert.False(t, ns["HostCPUUser"])
+	assert.False(t, ns["ProcessCPUUserNs"])
+	assert.False(t, ns["ProcessOpenFDs"])
+
+	ns = names(config.HostMetricsGroups{CPU: true, Process: true, FDs: true, Network: true, Disk: true})
+	for _, name := range []string{"Load1", "HostCPUUser", "HostCPUSystem", "HostCPUIOWait", "HostCPUSteal",
+		"ProcessCPUUserNs", "ProcessCPUSystemNs", "ProcessOpenFDs", "ProcessMaxFDs"} {
+		assert.True(t, ns[name], name)
+	}
+}
@@ -16,6 +16,7 @@ package metrics
 
 import (
 	"github.com/solarwinds/apm-go/internal/bson"
+	"github.com/solarwinds/apm-go/internal/config"
 	"github.com/solarwinds/apm-go/internal/hdrhist"
 	"github.com/solarwinds/apm-go/internal/host"
 	"github.com/solarwinds/apm-go/internal/log"
@@ -25,7 +26,6 @@ import (
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace"
 	"os"
-	"runtime"
 	"sort"
 	"strconv"
 	"strings"
@@ -58,8 +58,13 @@ const (
 	TagsKVSeparator              = ":"
 	otherTagExistsVal            = TagsKVSeparator + OtherTransactionName + MetricIDSeparator
 	maxPathLenForTransactionName = 3
+	// the maximum number of overflowed transaction names kept in a cycle
+	maxOverflowedTransactions = 100
 )
 
+// transactionTagKeys are the tags of the transaction names, for SWO and AppOptics
+var transactionTagKeys = []string{"sw.transaction", "TransactionName"}
+
 // Request counters definition
 const (
 	RequestCount               = "RequestCount"
@@ -90,6 +95,9 @@ var (
 	ErrExceedsTagsCountLimit = errors.New("exceeds tags count limit")
 	// ErrMetricsWithNonPositiveCount indicates the count is negative or zero
 	ErrMetricsWithNonPositiveCount = errors.New("metrics with non-positive count")
+	// ErrHistogramValueOutOfRange indicates the value of a histogram is negative
+	// or too large
+	ErrHistogramValueOutOfRange = errors.New("histogram value out of range")
 )
 
 // Package-level state
@@ -100,6 +108,7 @@ var apmHistograms = &histograms{
 	histograms: make(map[string]*histogram),
 	precision:  metricsHistPrecisionDefault,
 }
+var apmHost = &hostSampler{}
 
 // SpanMessage defines a span message
 type SpanMessage interface {
@@ -129,21 +138,37 @@ type Measurement struct {
 	Count     int               // count of this measurement
 	Sum       float64           // sum for this measurement
 	ReportSum bool              // include the sum in the report?
+	Gauge     bool              // the sum is the last value recorded and the count is 1
+}
+
+// hostMetric is a named value of the host appended to the measurements
+type hostMetric struct {
+	name  string
+	value interface{}
 }
 
 // Measurements are a collection of mutex-protected measurements
 type Measurements struct {
 	m             map[string]*Measurement
+	h             map[string]*histogram // the custom histograms
 	transMap      *TransMap
+	outboundMap   *TransMap // the dependencies of the outbound metrics, see recordOutbound
+	txnNameRules  utils.TransactionNameRules
 	IsCustom      bool
 	FlushInterval int32
 	sync.Mutex    // protect access to this collection
+
+	collectors    map[int]func(into *Measurements) // called before the measurements are copied, see OnCollect
+	collectorID   int
+	collectorLock sync.Mutex
 }
 
 func NewMeasurements(isCustom bool, maxCount int32) *Measurements {
 	return &Measurements{
 		m:             make(map[string]*Measurement),
+		h:             make(map[string]*histogram),
 		transMap:      NewTransMap(maxCount),
+		outboundMap:   NewTransMap(maxCount),
 		IsCustom:      isCustom,
 		FlushInterval: ReportingIntervalDefault,
 	}
@@ -151,8 +176,11 @@ func NewMeasurements(isCustom bool, maxCount int32) *Measurements {
 
 // a single histogram
 type histogram struct {
+	name string            // the name of a custom histogram, TransactionResponseTime if empty
 	hist *hdrhist.Hist     // internal representation of a histogram (see hdrhist package)
 	tags map[string]string // map of KVs
+
+	exemplars *exemplarReservoir // the sampled spans recorded, nil if none
 }
 
 // a collection of histograms
@@ -170,6 +198,15 @@ type EventQueueStats struct {
 	numFailed     int64 // number of messages that failed to send
 	totalEvents   int64 // number of messages queued to send
 	queueLargest  int64 // maximum number of messages that were in the queue at one time
+
+	numDroppedOldest int64 // number of queued messages dropped to make room for new ones
+	numBlocked       int64 // number of times the reporting had to wait for room in the queue
+	numTraceDropped  int64 // number of messages dropped as their traces have been dropped
+
+	bytesSent        int64 // number of bytes of the messages that were successfully sent
+	numBudgetDropped int64 // number of messages dropped as the daily bytes budget is exhausted
+	budgetUsed       int64 // bytes consumed in the rolling daily window, not reset
+	budgetLimit      int64 // the daily bytes budget, zero means no budget, not reset
 }
 
 func (s *EventQueueStats) NumSentAdd(n int64) {
@@ -188,6 +225,80 @@ func (s *EventQueueStats) TotalEventsAdd(n int64) {
 	atomic.AddInt64(&s.totalEvents, n)
 }
 
+func (s *EventQueueStats) NumDroppedOldestAdd(n int64) {
+	atomic.AddInt64(&s.numDroppedOldest, n)
+}
+
+func (s *EventQueueStats) NumBlockedAdd(n int64) {
+	atomic.AddInt64(&s.numBlocked, n)
+}
+
+func (s *EventQueueStats) NumTraceDroppedAdd(n int64) {
+	atomic.AddInt64(&s.numTraceDropped, n)
+}
+
+func (s *EventQueueStats) BytesSentAdd(n int64) {
+	atomic.AddInt64(&s.bytesSent, n)
+}
+
+func (s *EventQueueStats) NumBudgetDroppedAdd(n int64) {
+	atomic.AddInt64(&s.numBudgetDropped, n)
+}
+
+// SetBudget records the consumption of the daily bytes budget.
+func (s *EventQueueStats) SetBudget(used, limit int64) {
+	atomic.StoreInt64(&s.budgetUsed, used)
+	atomic.StoreInt64(&s.budgetLimit, limit)
+}
+
+func (s *EventQueueStats) NumSent() int64 {
+	return atomic.LoadInt64(&s.numSent)
+}
+
+func (s *EventQueueStats) NumOverflowed() int64 {
+	return atomic.LoadInt64(&s.numOverflowed)
+}
+
+func (s *EventQueueStats) NumFailed() int64 {
+	return atomic.LoadInt64(&s.numFailed)
+}
+
+func (s *EventQueueStats) TotalEvents() int64 {
+	return atomic.LoadInt64(&s.totalEvents)
+}
+
+func (s *EventQueueStats) QueueLargest() int64 {
+	return atomic.LoadInt64(&s.queueLargest)
+}
+
+func (s *EventQueueStats) NumDroppedOldest() int64 {
+	return atomic.LoadInt64(&s.numDroppedOldest)
+}
+
+func (s *EventQueueStats) NumBlocked() int64 {
+	return atomic.LoadInt64(&s.numBlocked)
+}
+
+func (s *EventQueueStats) NumTraceDropped() int64 {
+	return atomic.LoadInt64(&s.numTraceDropped)
+}
+
+func (s *EventQueueStats) BytesSent() int64 {
+	return atomic.LoadInt64(&s.bytesSent)
+}
+
+func (s *EventQueueStats) NumBudgetDropped() int64 {
+	return atomic.LoadInt64(&s.numBudgetDropped)
+}
+
+func (s *EventQueueStats) BudgetUsed() int64 {
+	return atomic.LoadInt64(&s.budgetUsed)
+}
+
+func (s *EventQueueStats) BudgetLimit() int64 {
+	return atomic.LoadInt64(&s.budgetLimit)
+}
+
 // RateCounts is the rate counts reported by trace sampler
 type RateCounts struct{ requested, sampled, limited, traced, through int64 }
 
@@ -261,6 +372,11 @@ type TransMap struct {
 	// than the capacity defined by settings.
 	// This flag is cleared in every metrics cycle.
 	overflow bool
+	// The transaction names which are always stored, even if the map is full.
+	allowed map[string]struct{}
+	// The transaction names refused as the map is full, at most
+	// maxOverflowedTransactions. It's cleared in every metrics cycle.
+	overflowed map[string]struct{}
 	// The mutex to protect this whole struct. If the performance is a concern we should use separate
 	// mutexes for each of the fields. But for now it seems not necessary.
 	mutex sync.Mutex
@@ -273,6 +389,7 @@ func NewTransMap(cap int32) *TransMap {
 		currCap:          cap,
 		nextCap:          cap,
 		overflow:         false,
+		overflowed:       make(map[string]struct{}),
 	}
 }
 
@@ -298,6 +415,7 @@ func (t *TransMap) Reset() {
 	t.transactionNames = make(map[string]struct{})
 	t.currCap = t.nextCap
 	t.overflow = false
+	t.overflowed = make(map[string]struct{})
 }
 
 // Clone returns a shallow copy
@@ -307,7 +425,49 @@ func (t *TransMap) Clone() *TransMap {
 		currCap:          t.currCap,
 		nextCap:          t.nextCap,
 		overflow:         t.overflow,
+		allowed:          t.allowed,
+		overflowed:       t.overflowed,
+	}
+}
+
+// SetAllowed sets the transaction names which are always stored.
+func (t *TransMap) SetAllowed(names []string) {
+	allowed := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		allowed[name] = struct{}{}
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.allowed = allowed
+}
+
+// IsAllowed checks if the transaction name is always stored.
+func (t *TransMap) IsAllowed(name string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	_, ok := t.allowed[name]
+	return ok
+}
+
+// AddOverflowed records a transaction name refused as the map is full.
+func (t *TransMap) AddOverflowed(name string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(t.overflowed) < maxOverflowedTransactions {
+		t.overflowed[name] = struct{}{}
+	}
+}
+
+// Overflowed returns the sorted transaction names refused in this cycle.
+func (t *TransMap) Overflowed() []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	names := make([]string, 0, len(t.overflowed))
+	for name := range t.overflowed {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
 }
 
 // IsWithinLimit checks if the transaction name is stored in the TransMap. It will store this new
@@ -357,8 +517,21 @@ func init() {
 	}
 }
 
-// addRequestCounters add various request-related counters to the metrics message buffer.
-func addRequestCounters(bbuf *bson.Buffer, index *int, rcs map[string]*RateCounts) {
+// SetHistogramPrecision sets the precision of the histograms created afterwards.
+// It must be between 0 and 5, otherwise it's ignored.
+func SetHistogramPrecision(precision int) {
+	if precision < 0 || precision > 5 {
+		log.Errorf("histogram precision must be between 0 and 5: %v", precision)
+		return
+	}
+	apmHistograms.lock.Lock()
+	defer apmHistograms.lock.Unlock()
+	apmHistograms.precision = precision
+}
+
+// requestCounters returns the request-related counters aggregated from the
+// rate counts of the token buckets.
+func requestCounters(rcs map[string]*RateCounts) []hostMetric {
 	var requested, traced, limited, ttTraced int64
 
 	for _, rc := range rcs {
@@ -367,13 +540,16 @@ func addRequestCounters(bbuf *bson.Buffer, index *int, rcs map[string]*RateCount
 		limited += rc.Limited()
 	}
 
-	addMetricsValue(bbuf, index, RequestCount, requested)
-	addMetricsValue(bbuf, index, TraceCount, traced)
-	addMetricsValue(bbuf, index, TokenBucketExhaustionCount, limited)
+	counters := []hostMetric{
+		{RequestCount, requested},
+		{TraceCount, traced},
+		{TokenBucketExhaustionCount, limited},
+	}
 
 	if rcRegular, ok := rcs[RCRegular]; ok {
-		addMetricsValue(bbuf, index, SampleCount, rcRegular.Sampled())
-		addMetricsValue(bbuf, index, ThroughTraceCount, rcRegular.Through())
+		counters = append(counters,
+			hostMetric{SampleCount, rcRegular.Sampled()},
+			hostMetric{ThroughTraceCount, rcRegular.Through()})
 	}
 
 	if relaxed, ok := rcs[RCRelaxedTriggerTrace]; ok {
@@ -383,7 +559,7 @@ func addRequestCounters(bbuf *bson.Buffer, index *int, rcs map[string]*RateCount
 		ttTraced += strict.Traced()
 	}
 
-	addMetricsValue(bbuf, index, TriggeredTraceCount, ttTraced)
+	return append(counters, hostMetric{TriggeredTraceCount, ttTraced})
 }
 
 // BuildMessage creates and encodes the custom metrics message.
@@ -413,13 +589,26 @@ func BuildMessage(m *Measurements, serverless bool) []byte {
 
 	bbuf.AppendFinishObject(start)
 
+	if len(m.h) > 0 {
+		start = bbuf.AppendStartArray("histograms")
+		index = 0
+
+		for _, h := range m.h {
+			addHistogramToBSON(bbuf, &index, h)
+		}
+
+		bbuf.AppendFinishObject(start)
+	}
+
 	bbuf.Finish()
 	return bbuf.GetBuf()
 }
 
-// SetCap sets the maximum number of distinct metrics allowed.
+// SetCap sets the maximum number of distinct metrics allowed. The same number
+// of distinct dependencies is allowed in the outbound metrics.
 func (m *Measurements) SetCap(cap int32) {
 	m.transMap.SetCap(cap)
+	m.outboundMap.SetCap(cap)
 }
 
 // Cap returns the maximum number of distinct metrics allowed.
@@ -427,20 +616,95 @@ func (m *Measurements) Cap() int32 {
 	return m.transMap.Cap()
 }
 
+// Overflow returns true if there were more distinct metrics than allowed in
+// the current flush cycle.
+func (m *Measurements) Overflow() bool {
+	return m.transMap.Overflow()
+}
+
+// SetAllowedTransactions sets the transaction names which are always reported,
+// even if there are more distinct metrics than allowed.
+func (m *Measurements) SetAllowedTransactions(names []string) {
+	m.transMap.SetAllowed(names)
+}
+
+// SetTransactionNameRules sets the normalisation rules of the derived
+// transaction names recorded.
+func (m *Measurements) SetTransactionNameRules(rules utils.TransactionNameRules) {
+	m.Lock()
+	defer m.Unlock()
+	m.txnNameRules = rules
+}
+
+// TransactionNameRules returns the normalisation rules of the derived
+// transaction names recorded.
+func (m *Measurements) TransactionNameRules() utils.TransactionNameRules {
+	m.Lock()
+	defer m.Unlock()
+	return m.txnNameRules
+}
+
+// OverflowedTransactions returns the transaction names which were reported as
+// "other" in the current flush cycle.
+func (m *Measurements) OverflowedTransactions() []string {
+	return m.transMap.Overflowed()
+}
+
+// OnCollect registers a function which is called before the measurements are
+// copied and reset, e.g., to record the observations of the asynchronous
+// instruments. The function records into the measurements given, which are m
+// itself on a flush, or a scratch copy of m on a scrape, see WritePrometheus.
+// It returns a function to unregister it.
+func (m *Measurements) OnCollect(f func(into *Measurements)) (unregister func()) {
+	m.collectorLock.Lock()
+	defer m.collectorLock.Unlock()
+	if m.collectors == nil {
+		m.collectors = make(map[int]func(into *Measurements))
+	}
+	id := m.collectorID
+	m.collectorID++
+	m.collectors[id] = f
+	return func() {
+		m.collectorLock.Lock()
+		defer m.collectorLock.Unlock()
+		delete(m.collectors, id)
+	}
+}
+
+// collect calls the functions registered by OnCollect to record into the
+// measurements given.
+func (m *Measurements) collect(into *Measurements) {
+	m.collectorLock.Lock()
+	collectors := make([]func(into *Measurements), 0, len(m.collectors))
+	for _, f := range m.collectors {
+		collectors = append(collectors, f)
+	}
+	m.collectorLock.Unlock()
+
+	for _, f := range collectors {
+		f(into)
+	}
+}
+
 // CopyAndReset resets the custom metrics and return a copy of the old one.
 func (m *Measurements) CopyAndReset(flushInterval int32) *Measurements {
+	m.collect(m)
+
 	m.Lock()
 	defer m.Unlock()
 
-	if len(m.m) == 0 {
+	if len(m.m) == 0 && len(m.h) == 0 {
 		m.FlushInterval = flushInterval
 		m.transMap.Reset()
+		m.outboundMap.Reset()
 		return nil
 	}
 
 	clone := m.Clone()
 	m.m = make(map[string]*Measurement)
+	m.h = make(map[string]*histogram)
 	m.transMap.Reset()
+	m.outboundMap.Reset()
 	m.FlushInterval = flushInterval
 	return clone
 }
@@ -449,7 +713,9 @@ func (m *Measurements) CopyAndReset(flushInterval int32) *Measurements {
 func (m *Measurements) Clone() *Measurements {
 	return &Measurements{
 		m:             m.m,
+		h:             m.h,
 		transMap:      m.transMap.Clone(),
+		outboundMap:   m.outboundMap.Clone(),
 		IsCustom:      m.IsCustom,
 		FlushInterval: m.FlushInterval,
 	}
@@ -471,6 +737,65 @@ func (m *Measurements) Increment(name string, opts MetricOptions) error {
 	return m.recordWithSoloTags(name, opts.Tags, 0, opts.Count, false)
 }
 
+// Gauge submits the gauge measurement to the reporter. The last value
+// submitted in a flush interval is reported. The count of the options is
+// ignored.
+func (m *Measurements) Gauge(name string, value float64, opts MetricOptions) error {
+	if len(opts.Tags) > MaxTagsCount {
+		return ErrExceedsTagsCountLimit
+	}
+	id := metricID([]string{name, "gauge"}, opts.Tags)
+
+	m.Lock()
+	defer m.Unlock()
+	me, ok := m.m[id]
+	if !ok {
+		if !m.transMap.IsWithinLimit(id) {
+			return ErrExceedsMetricsCountLimit
+		}
+		me = &Measurement{
+			Name:      name,
+			Tags:      opts.Tags,
+			ReportSum: true,
+			Gauge:     true,
+		}
+		m.m[id] = me
+	}
+	me.Count = 1
+	me.Sum = value
+	return nil
+}
+
+// Histogram submits the value to the histogram of the name and tags, which
+// reports the distribution of the values in a flush interval. The value is
+// recorded Count times and must be between 0 and 3600000000.
+func (m *Measurements) Histogram(name string, value int64, opts MetricOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	if value < 0 || value > histogramHighestTrackable {
+		return ErrHistogramValueOutOfRange
+	}
+	id := metricID([]string{name, "histogram"}, opts.Tags)
+
+	m.Lock()
+	defer m.Unlock()
+	h, ok := m.h[id]
+	if !ok {
+		if !m.transMap.IsWithinLimit(id) {
+			return ErrExceedsMetricsCountLimit
+		}
+		h = &histogram{
+			name: name,
+			hist: newHist(getHistogramPrecision()),
+			tags: opts.Tags,
+		}
+		m.h[id] = h
+	}
+	h.hist.RecordN(value, int64(opts.Count))
+	return nil
+}
+
 // MetricOptions is a struct for the optional parameters of a measurement.
 type MetricOptions struct {
 	Count   int
@@ -490,47 +815,21 @@ func (mo *MetricOptions) validate() error {
 	return nil
 }
 
-func addRuntimeMetrics(bbuf *bson.Buffer, index *int) {
-	// category runtime
-	addMetricsValue(bbuf, index, "trace.go.runtime.NumGoroutine", runtime.NumGoroutine())
-	addMetricsValue(bbuf, index, "trace.go.runtime.NumCgoCall", runtime.NumCgoCall())
-
-	var mem runtime.MemStats
-	host.Mem(&mem)
-	// category gc
-	addMetricsValue(bbuf, index, "trace.go.gc.LastGC", int64(mem.LastGC))
-	addMetricsValue(bbuf, index, "trace.go.gc.NextGC", int64(mem.NextGC))
-	addMetricsValue(bbuf, index, "trace.go.gc.PauseTotalNs", int64(mem.PauseTotalNs))
-	addMetricsValue(bbuf, index, "trace.go.gc.NumGC", int64(mem.NumGC))
-	addMetricsValue(bbuf, index, "trace.go.gc.NumForcedGC", int64(mem.NumForcedGC))
-	addMetricsValue(bbuf, index, "trace.go.gc.GCCPUFraction", mem.GCCPUFraction)
-
-	// category memory
-	addMetricsValue(bbuf, index, "trace.go.memory.Alloc", int64(mem.Alloc))
-	addMetricsValue(bbuf, index, "trace.go.memory.TotalAlloc", int64(mem.TotalAlloc))
-	addMetricsValue(bbuf, index, "trace.go.memory.Sys", int64(mem.Sys))
-	addMetricsValue(bbuf, index, "trace.go.memory.Lookups", int64(mem.Lookups))
-	addMetricsValue(bbuf, index, "trace.go.memory.Mallocs", int64(mem.Mallocs))
-	addMetricsValue(bbuf, index, "trace.go.memory.Frees", int64(mem.Frees))
-	addMetricsValue(bbuf, index, "trace.go.memory.HeapAlloc", int64(mem.HeapAlloc))
-	addMetricsValue(bbuf, index, "trace.go.memory.HeapSys", int64(mem.HeapSys))
-	addMetricsValue(bbuf, index, "trace.go.memory.HeapIdle", int64(mem.HeapIdle))
-	addMetricsValue(bbuf, index, "trace.go.memory.HeapInuse", int64(mem.HeapInuse))
-	addMetricsValue(bbuf, index, "trace.go.memory.HeapReleased", int64(mem.HeapReleased))
-	addMetricsValue(bbuf, index, "trace.go.memory.HeapObjects", int64(mem.HeapObjects))
-	addMetricsValue(bbuf, index, "trace.go.memory.StackInuse", int64(mem.StackInuse))
-	addMetricsValue(bbuf, index, "trace.go.memory.StackSys", int64(mem.StackSys))
-}
-
 // BuildBuiltinMetricsMessage generates a metrics message in BSON format with all the currently available values
 // metricsFlushInterval	current metrics flush interval
 //
 // return				metrics message in BSON format
 func BuildBuiltinMetricsMessage(m *Measurements, qs *EventQueueStats,
-	rcs map[string]*RateCounts, runtimeMetrics bool) []byte {
+	rcs map[string]*RateCounts, runtimeMetrics bool, host config.HostMetricsGroups) []byte {
+	return DefaultRegistry().BuildBuiltinMetricsMessage(m, qs, rcs, runtimeMetrics, host)
+}
+
+func buildBuiltinMetricsMessage(m *Measurements, r *Registry, qs *EventQueueStats,
+	rcs map[string]*RateCounts, runtimeMetrics bool, host config.HostMetricsGroups) []byte {
 	if m == nil {
 		return nil
 	}
+	hi := r.histograms
 
 	bbuf := bson.NewBuffer()
 
@@ -545,22 +844,24 @@ func BuildBuiltinMetricsMessage(m *Measurements, qs *EventQueueStats,
 	index := 0
 
 	// request counters
-	addRequestCounters(bbuf, &index, rcs)
+	for _, c := range requestCounters(rcs) {
+		addMetricsValue(bbuf, &index, c.name, c.value)
+	}
 
 	// Queue states
-	if qs != nil {
-		addMetricsValue(bbuf, &index, "NumSent", qs.numSent)
-		addMetricsValue(bbuf, &index, "NumOverflowed", qs.numOverflowed)
-		addMetricsValue(bbuf, &index, "NumFailed", qs.numFailed)
-		addMetricsValue(bbuf, &index, "TotalEvents", qs.totalEvents)
-		addMetricsValue(bbuf, &index, "QueueLargest", qs.queueLargest)
+	for _, v := range qs.values() {
+		addMetricsValue(bbuf, &index, v.name, v.value)
 	}
 
-	addHostMetrics(bbuf, &index)
+	addHostMetrics(bbuf, &index, host, r.host)
 
+	var runtimeHists []*histogram
 	if runtimeMetrics {
 		// runtime stats
-		addRuntimeMetrics(bbuf, &index)
+		hi.lock.Lock()
+		precision := hi.precision
+		hi.lock.Unlock()
+		runtimeHists = addRuntimeMetrics(bbuf, &index, r.runtime, precision)
 	}
 
 	for _, measurement := range m.m {
@@ -575,14 +876,21 @@ func BuildBuiltinMetricsMessage(m *Measurements, qs *EventQueueStats,
 	start = bbuf.AppendStartArray("histograms")
 	index = 0
 
-	apmHistograms.lock.Lock()
+	hi.lock.Lock()
 
-	for _, h := range apmHistograms.histograms {
+	flushed := make([]*histogram, 0, len(hi.histograms))
+	for _, h := range hi.histograms {
 		addHistogramToBSON(bbuf, &index, h)
+		flushed = append(flushed, h)
 	}
-	apmHistograms.histograms = make(map[string]*histogram) // clear histograms
+	hi.histograms = make(map[string]*histogram) // clear histograms
+
+	hi.lock.Unlock()
+	r.totals.addHistograms(promPrefix, flushed, int(m.Cap()))
 
-	apmHistograms.lock.Unlock()
+	for _, h := range runtimeHists {
+		addHistogramToBSON(bbuf, &index, h)
+	}
 	bbuf.AppendFinishObject(start)
 	// ==========================================
 
@@ -647,6 +955,12 @@ func appendMACAddresses(bbuf *bson.Buffer, macs []string) {
 // name		key name
 // value	value (type: int, int64, float32, float64)
 func addMetricsValue(bbuf *bson.Buffer, index *int, name string, value interface{}) {
+	addMetricsValueWithTags(bbuf, index, name, value, nil)
+}
+
+// addMetricsValueWithTags is the same as addMetricsValue with the tags of the
+// value, e.g., the network interface.
+func addMetricsValueWithTags(bbuf *bson.Buffer, index *int, name string, value interface{}, tags map[string]string) {
 	start := bbuf.AppendStartObject(strconv.Itoa(*index))
 	defer func() {
 		if err := recover(); err != nil {
@@ -670,6 +984,14 @@ func addMetricsValue(bbuf *bson.Buffer, index *int, name string, value interface
 		bbuf.AppendString("value", "unknown")
 	}
 
+	if len(tags) > 0 {
+		start := bbuf.AppendStartObject("tags")
+		for k, v := range tags {
+			bbuf.AppendString(k, v)
+		}
+		bbuf.AppendFinishObject(start)
+	}
+
 	bbuf.AppendFinishObject(start)
 	*index += 1
 }
@@ -681,12 +1003,7 @@ func GetTransactionFromPath(path string) string {
 	if path == "" || path == "/" {
 		return "/"
 	}
-	p := strings.Split(path, "/")
-	lp := len(p)
-	if lp > maxPathLenForTransactionName {
-		lp = maxPathLenForTransactionName
-	}
-	return strings.Join(p[0:lp], "/")
+	return utils.TruncatePath(path, maxPathLenForTransactionName)
 }
 
 func (s *HTTPSpanMessage) appOpticsTagsList() []map[string]string {
@@ -747,22 +1064,7 @@ func (m *Measurements) record(name string, tagsList []map[string]string,
 	idPrefixList := []string{name, strconv.FormatBool(reportValue)}
 
 	for _, tags := range tagsList {
-		idList := append(idPrefixList[:0:0], idPrefixList...)
-		if tags != nil {
-			// tags are part of the ID but since there's no guarantee that the map items
-			// are always iterated in the same order, we need to sort them ourselves
-			var tagsSorted []string
-			for k, v := range tags {
-				tagsSorted = append(tagsSorted, k+TagsKVSeparator+v)
-			}
-			sort.Strings(tagsSorted)
-
-			idList = append(idList, tagsSorted...)
-		}
-		idList = append(idList, "")
-		id := strings.Join(idList, MetricIDSeparator)
-
-		idTagsMap[id] = tags
+		idTagsMap[metricID(idPrefixList, tags)] = tags
 	}
 
 	var me *Measurement
@@ -776,6 +1078,7 @@ func (m *Measurements) record(name string, tagsList []map[string]string,
 		if me, ok = m.m[id]; !ok {
 			// N.B. This overflow logic is a bit cumbersome and is ripe for a rewrite
 			if strings.Contains(id, otherTagExistsVal) ||
+				m.isAllowedTransaction(tags) ||
 				m.transMap.IsWithinLimit(id) {
 				me = &Measurement{
 					Name:      name,
@@ -784,6 +1087,9 @@ func (m *Measurements) record(name string, tagsList []map[string]string,
 				}
 				m.m[id] = me
 			} else {
+				if txn := transactionTag(tags); txn != "" {
+					m.transMap.AddOverflowed(txn)
+				}
 				return ErrExceedsMetricsCountLimit
 			}
 		}
@@ -795,11 +1101,68 @@ func (m *Measurements) record(name string, tagsList []map[string]string,
 	return nil
 }
 
+// isAllowedTransaction checks if the tags have a transaction name which is
+// always reported.
+func (m *Measurements) isAllowedTransaction(tags map[string]string) bool {
+	txn := transactionTag(tags)
+	return txn != "" && m.transMap.IsAllowed(txn)
+}
+
+// transactionTag returns the transaction name in the tags, if any.
+func transactionTag(tags map[string]string) string {
+	for _, k := range transactionTagKeys {
+		if txn, ok := tags[k]; ok {
+			return txn
+		}
+	}
+	return ""
+}
+
+// metricID returns the ID of a measurement, which is made of the prefix, e.g.,
+// the name, and the tags.
+func metricID(prefix []string, tags map[string]string) string {
+	idList := append(prefix[:0:0], prefix...)
+	if tags != nil {
+		// tags are part of the ID but since there's no guarantee that the map items
+		// are always iterated in the same order, we need to sort them ourselves
+		var tagsSorted []string
+		for k, v := range tags {
+			tagsSorted = append(tagsSorted, k+TagsKVSeparator+v)
+		}
+		sort.Strings(tagsSorted)
+
+		idList = append(idList, tagsSorted...)
+	}
+	idList = append(idList, "")
+	return strings.Join(idList, MetricIDSeparator)
+}
+
+// histogramHighestTrackable is the highest value of the histograms, i.e.,
+// an hour in microseconds.
+const histogramHighestTrackable = 3600000000
+
+// newHist returns a histogram of the precision.
+func newHist(precision int) *hdrhist.Hist {
+	return hdrhist.WithConfig(hdrhist.Config{
+		LowestDiscernible: 1,
+		HighestTrackable:  histogramHighestTrackable,
+		SigFigs:           int32(precision),
+	})
+}
+
+// getHistogramPrecision returns the precision of the histograms.
+func getHistogramPrecision() int {
+	apmHistograms.lock.Lock()
+	defer apmHistograms.lock.Unlock()
+	return apmHistograms.precision
+}
+
 // records a histogram
 // hi		collection of histograms that this histogram should be added to
 // name		key name
 // duration	span duration
-func (hi *histograms) recordHistogram(name string, duration time.Duration) {
+// sc		span context, kept as an exemplar if the span is sampled
+func (hi *histograms) recordHistogram(name string, duration time.Duration, sc trace.SpanContext) {
 	hi.lock.Lock()
 	defer func() {
 		hi.lock.Unlock()
@@ -822,17 +1185,44 @@ func (hi *histograms) recordHistogram(name string, duration time.Duration) {
 	// create a new histogram if it doesn't exist
 	if h, ok = histograms[id]; !ok {
 		h = &histogram{
-			hist: hdrhist.WithConfig(hdrhist.Config{
-				LowestDiscernible: 1,
-				HighestTrackable:  3600000000,
-				SigFigs:           int32(hi.precision),
-			}),
+			hist: newHist(hi.precision),
 			tags: tags,
 		}
 		histograms[id] = h
 	}
 
 	// record histogram
+	value := int64(duration / time.Microsecond)
+	h.hist.Record(value)
+	if sc.IsSampled() {
+		if h.exemplars == nil {
+			h.exemplars = &exemplarReservoir{}
+		}
+		h.exemplars.offer(exemplar{traceID: sc.TraceID(), spanID: sc.SpanID(), value: value, time: time.Now()})
+	}
+}
+
+// recordTaggedHistogram records a duration in the histogram of the name and
+// tags, e.g., the response time of a dependency.
+func (hi *histograms) recordTaggedHistogram(name string, tags map[string]string, duration time.Duration) {
+	hi.lock.Lock()
+	defer func() {
+		hi.lock.Unlock()
+		if err := recover(); err != nil {
+			log.Errorf("Failed to record histogram: %v", err)
+		}
+	}()
+
+	id := metricID([]string{name}, tags)
+	h, ok := hi.histograms[id]
+	if !ok {
+		h = &histogram{
+			name: name,
+			hist: newHist(hi.precision),
+			tags: tags,
+		}
+		hi.histograms[id] = h
+	}
 	h.hist.Record(int64(duration / time.Microsecond))
 }
 
@@ -881,8 +1271,13 @@ func addHistogramToBSON(bbuf *bson.Buffer, index *int, h *histogram) {
 
 	start := bbuf.AppendStartObject(strconv.Itoa(*index))
 
-	bbuf.AppendString("name", transactionResponseTime)
+	name := h.name
+	if name == "" {
+		name = transactionResponseTime
+	}
+	bbuf.AppendString("name", name)
 	bbuf.AppendString("value", string(data))
+	addExemplarsToBSON(bbuf, h.exemplars)
 
 	// append tags
 	if len(h.tags) > 0 {
@@ -903,6 +1298,32 @@ func addHistogramToBSON(bbuf *bson.Buffer, index *int, h *histogram) {
 	*index += 1
 }
 
+// values returns the queue stats reported in the builtin metrics, or nil if s
+// is nil. The budget is only reported if there is one.
+func (s *EventQueueStats) values() []hostMetric {
+	if s == nil {
+		return nil
+	}
+	values := []hostMetric{
+		{"NumSent", s.NumSent()},
+		{"NumOverflowed", s.NumOverflowed()},
+		{"NumFailed", s.NumFailed()},
+		{"TotalEvents", s.TotalEvents()},
+		{"QueueLargest", s.QueueLargest()},
+		{"NumDroppedOldest", s.NumDroppedOldest()},
+		{"NumBlocked", s.NumBlocked()},
+		{"NumTraceDropped", s.NumTraceDropped()},
+		{"EventBytesSent", s.BytesSent()},
+		{"NumBudgetDropped", s.NumBudgetDropped()},
+	}
+	if limit := s.BudgetLimit(); limit > 0 {
+		values = append(values,
+			hostMetric{"EventBudgetUsed", s.BudgetUsed()},
+			hostMetric{"EventBudgetLimit", limit})
+	}
+	return values
+}
+
 func (s *EventQueueStats) SetQueueLargest(count int64) {
 	newVal := count
 
@@ -926,6 +1347,13 @@ func (s *EventQueueStats) CopyAndReset() *EventQueueStats {
 	c.totalEvents = atomic.SwapInt64(&s.totalEvents, 0)
 	c.numOverflowed = atomic.SwapInt64(&s.numOverflowed, 0)
 	c.queueLargest = atomic.SwapInt64(&s.queueLargest, 0)
+	c.numDroppedOldest = atomic.SwapInt64(&s.numDroppedOldest, 0)
+	c.numBlocked = atomic.SwapInt64(&s.numBlocked, 0)
+	c.numTraceDropped = atomic.SwapInt64(&s.numTraceDropped, 0)
+	c.bytesSent = atomic.SwapInt64(&s.bytesSent, 0)
+	c.numBudgetDropped = atomic.SwapInt64(&s.numBudgetDropped, 0)
+	c.budgetUsed = atomic.LoadInt64(&s.budgetUsed)
+	c.budgetLimit = atomic.LoadInt64(&s.budgetLimit)
 
 	return c
 }
@@ -996,13 +1424,26 @@ func BuildServerlessMessage(span HTTPSpanMessage, rcs map[string]*RateCounts, ra
 
 // -- otel --
 
+// grpcServerErrors are the gRPC status codes which are errors of a server:
+// UNKNOWN, DEADLINE_EXCEEDED, UNIMPLEMENTED, INTERNAL, UNAVAILABLE and DATA_LOSS.
+// The others are caused by the client.
+var grpcServerErrors = map[int64]bool{2: true, 4: true, 12: true, 13: true, 14: true, 15: true}
+
+// RecordSpan records the inbound metrics of an entry span into the
+// package-level metrics.
 func RecordSpan(span sdktrace.ReadOnlySpan, isAppoptics bool) {
+	recordSpan(span, isAppoptics, ApmMetrics, apmHistograms)
+}
+
+func recordSpan(span sdktrace.ReadOnlySpan, isAppoptics bool, apm *Measurements, hi *histograms) {
 	method := ""
 	status := int64(0)
 	isError := span.Status().Code == codes.Error
 	attrs := span.Attributes()
 	swoTags := make(map[string]string)
 	httpRoute := ""
+	grpcStatus := int64(-1)
+	messagingSystem, destination := "", ""
 	for _, attr := range attrs {
 		if attr.Key == semconv.HTTPMethodKey {
 			method = attr.Value.AsString()
@@ -1010,9 +1451,16 @@ func RecordSpan(span sdktrace.ReadOnlySpan, isAppoptics bool) {
 			status = attr.Value.AsInt64()
 		} else if attr.Key == semconv.HTTPRouteKey {
 			httpRoute = attr.Value.AsString()
+		} else if attr.Key == semconv.RPCGRPCStatusCodeKey {
+			grpcStatus = attr.Value.AsInt64()
+		} else if attr.Key == semconv.MessagingSystemKey {
+			messagingSystem = attr.Value.AsString()
+		} else if attr.Key == semconv.MessagingDestinationNameKey {
+			destination = attr.Value.AsString()
 		}
 	}
 	isHttp := span.SpanKind() == trace.SpanKindServer && method != ""
+	isGrpc := span.SpanKind() == trace.SpanKindServer && grpcStatus >= 0
 
 	if isHttp {
 		if status > 0 {
@@ -1022,10 +1470,24 @@ func RecordSpan(span sdktrace.ReadOnlySpan, isAppoptics bool) {
 			}
 		}
 		swoTags["http.method"] = method
+	} else if isGrpc {
+		swoTags["rpc.status_code"] = strconv.FormatInt(grpcStatus, 10)
+		if !isError && grpcServerErrors[grpcStatus] {
+			isError = true
+		}
+	}
+
+	if span.SpanKind() == trace.SpanKindConsumer {
+		if messagingSystem != "" {
+			swoTags["messaging.system"] = messagingSystem
+		}
+		if destination != "" {
+			swoTags["messaging.destination.name"] = destination
+		}
 	}
 
 	swoTags["sw.is_error"] = strconv.FormatBool(isError)
-	txnName := utils.GetTransactionName(span)
+	txnName := utils.GetTransactionName(span, apm.TransactionNameRules())
 	swoTags["sw.transaction"] = txnName
 
 	duration := span.EndTime().Sub(span.StartTime())
@@ -1048,15 +1510,15 @@ func RecordSpan(span sdktrace.ReadOnlySpan, isAppoptics bool) {
 		metricName = transactionResponseTime
 	}
 
-	apmHistograms.recordHistogram("", duration)
-	if err := s.processMeasurements(metricName, tagsList, ApmMetrics); err == ErrExceedsMetricsCountLimit {
+	hi.recordHistogram("", duration, span.SpanContext())
+	if err := s.processMeasurements(metricName, tagsList, apm); err == ErrExceedsMetricsCountLimit {
 		if isAppoptics {
 			s.Transaction = OtherTransactionName
 			tagsList = s.appOpticsTagsList()
 		} else {
 			tagsList[0]["sw.transaction"] = OtherTransactionName
 		}
-		err := s.processMeasurements(metricName, tagsList, ApmMetrics)
+		err := s.processMeasurements(metricName, tagsList, apm)
 		// This should never happen since the only failure case _should_ be ErrExceedsMetricsCountLimit
 		// which is handled above, and the reason we retry here.
 		if err != nil {
@@ -1064,7 +1526,7 @@ func RecordSpan(span sdktrace.ReadOnlySpan, isAppoptics bool) {
 		}
 	} else {
 		// We didn't hit ErrExceedsMetricsCountLimit
-		apmHistograms.recordHistogram(txnName, duration)
+		hi.recordHistogram(txnName, duration, span.SpanContext())
 	}
 
 }
@@ -17,6 +17,7 @@ package metrics
 
 import (
 	"github.com/solarwinds/apm-go/internal/bson"
+	"github.com/solarwinds/apm-go/internal/config"
 	"github.com/solarwinds/apm-go/internal/utils"
 	"os"
 	"strconv"
@@ -36,7 +37,7 @@ func appendUname(bbuf *bson.Buffer) {
 	}
 }
 
-func addHostMetrics(bbuf *bson.Buffer, index *int) {
+func addHostMetrics(bbuf *bson.Buffer, index *int, groups config.HostMetricsGroups, s *hostSampler) {
 	// system load of last minute
 	if s := utils.GetStrByKeyword("/proc/loadavg", ""); s != "" {
 		load, err := strconv.ParseFloat(strings.Fields(s)[0], 64)
@@ -77,4 +78,31 @@ func addHostMetrics(bbuf *bson.Buffer, index *int) {
 			}
 		}
 	}
+
+	// the limits and usage of the container
+	addCgroupMetrics(bbuf, index)
+
+	if groups.CPU {
+		if t, ok := parseCPUTimes(utils.GetStrByKeyword(procStat, "cpu ")); ok {
+			addHostMetricsValues(bbuf, index, s.cpuMetrics(t))
+		}
+	}
+	if groups.Process {
+		addHostMetricsValues(bbuf, index, processCPUMetrics())
+	}
+	if groups.FDs {
+		addHostMetricsValues(bbuf, index, fdMetrics(procSelfFD, procSelfLimits))
+	}
+	if groups.Network {
+		addNetworkMetrics(bbuf, index)
+	}
+	if groups.Disk {
+		addDiskMetrics(bbuf, index)
+	}
+}
+
+func addHostMetricsValues(bbuf *bson.Buffer, index *int, ms []hostMetric) {
+	for _, m := range ms {
+		addMetricsValue(bbuf, index, m.name, m.value)
+	}
 }
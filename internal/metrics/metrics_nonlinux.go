@@ -17,8 +17,14 @@ package metrics
 
 import (
 	"github.com/solarwinds/apm-go/internal/bson"
+	"github.com/solarwinds/apm-go/internal/config"
 )
 
 func appendUname(bbuf *bson.Buffer) {}
 
-func addHostMetrics(bbuf *bson.Buffer, index *int) {}
+// hostSampler keeps the state of the host metrics between two flushes.
+type hostSampler struct{}
+
+func addHostMetrics(bbuf *bson.Buffer, index *int, groups config.HostMetricsGroups, s *hostSampler) {}
+
+func containerMetrics() []hostMetric { return nil }
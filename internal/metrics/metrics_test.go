@@ -19,11 +19,13 @@ import (
 	"context"
 	"fmt"
 	"github.com/solarwinds/apm-go/internal/bson"
+	"github.com/solarwinds/apm-go/internal/config"
 	"github.com/solarwinds/apm-go/internal/hdrhist"
 	"github.com/solarwinds/apm-go/internal/host"
 	"github.com/solarwinds/apm-go/internal/log"
 	"github.com/solarwinds/apm-go/internal/swotel/semconv"
 	"github.com/solarwinds/apm-go/internal/testutils"
+	"github.com/solarwinds/apm-go/internal/utils"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace"
 	"math"
@@ -35,6 +37,8 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
 	mbson "gopkg.in/mgo.v2/bson"
 )
 
@@ -215,6 +219,70 @@ func TestTransMap(t *testing.T) {
 	m.Reset()
 	assert.EqualValues(t, 4, m.Cap())
 	assert.False(t, m.Overflow())
+
+	m.SetAllowed([]string{"a1"})
+	assert.True(t, m.IsAllowed("a1"))
+	assert.False(t, m.IsAllowed("t1"))
+
+	m.AddOverflowed("t5")
+	m.AddOverflowed("t4")
+	m.AddOverflowed("t5")
+	assert.Equal(t, []string{"t4", "t5"}, m.Overflowed())
+	for i := 0; i < maxOverflowedTransactions*2; i++ {
+		m.AddOverflowed(strconv.Itoa(i))
+	}
+	assert.Len(t, m.Overflowed(), maxOverflowedTransactions)
+	m.Reset()
+	assert.Empty(t, m.Overflowed())
+	assert.True(t, m.IsAllowed("a1"))
+}
+
+func TestRecordSpanAllowedTransactions(t *testing.T) {
+	tr, teardown := testutils.TracerSetup()
+	defer teardown()
+
+	apm := NewMeasurements(false, 1)
+	apm.SetAllowedTransactions([]string{"/health"})
+	hi := &histograms{histograms: make(map[string]*histogram), precision: metricsHistPrecisionDefault}
+	for _, name := range []string{"/a", "/b", "/health", "/c", "/b"} {
+		_, span := tr.Start(context.Background(), name, trace.WithSpanKind(trace.SpanKindServer))
+		span.End()
+		recordSpan(span.(sdktrace.ReadOnlySpan), false, apm, hi)
+	}
+
+	var txns []string
+	for _, v := range apm.m {
+		txns = append(txns, v.Tags["sw.transaction"])
+	}
+	assert.ElementsMatch(t, []string{"/a", "/health", OtherTransactionName}, txns)
+	assert.True(t, apm.Overflow())
+
+	clone := apm.CopyAndReset(60)
+	assert.Equal(t, []string{"/b", "/c"}, clone.OverflowedTransactions())
+	assert.Empty(t, apm.OverflowedTransactions())
+}
+
+func TestRecordSpanTransactionNameRules(t *testing.T) {
+	tr, teardown := testutils.TracerSetup()
+	defer teardown()
+
+	apm := NewMeasurements(false, 100)
+	apm.SetTransactionNameRules(utils.TransactionNameRules{CollapseIDs: true, PathDepth: 2})
+	hi := &histograms{histograms: make(map[string]*histogram), precision: metricsHistPrecisionDefault}
+	for _, name := range []string{"/users/42/orders", "/users/7"} {
+		_, span := tr.Start(context.Background(), name, trace.WithSpanKind(trace.SpanKindServer))
+		span.End()
+		recordSpan(span.(sdktrace.ReadOnlySpan), false, apm, hi)
+	}
+
+	require.Len(t, apm.m, 1)
+	for _, v := range apm.m {
+		assert.Equal(t, "/users/{id}", v.Tags["sw.transaction"])
+		assert.Equal(t, 2, v.Count)
+	}
+
+	// the rules of the other measurements are not affected
+	assert.Equal(t, utils.TransactionNameRules{}, ApmMetrics.TransactionNameRules())
 }
 
 func TestRecordMeasurement(t *testing.T) {
@@ -249,15 +317,15 @@ func TestRecordHistogram(t *testing.T) {
 		histograms: make(map[string]*histogram),
 	}
 
-	hi.recordHistogram("", time.Duration(123))
-	hi.recordHistogram("", time.Duration(1554))
+	hi.recordHistogram("", time.Duration(123), trace.SpanContext{})
+	hi.recordHistogram("", time.Duration(1554), trace.SpanContext{})
 	assert.NotNil(t, hi.histograms[""])
 	h := hi.histograms[""]
 	assert.Empty(t, h.tags["TransactionName"])
 	encoded, _ := hdrhist.EncodeCompressed(h.hist)
 	assert.Equal(t, "HISTFAAAACR42pJpmSzMwMDAxIAKGEHEtclLGOw/QASYmAABAAD//1njBIo=", string(encoded))
 
-	hi.recordHistogram("hist1", time.Duration(453122))
+	hi.recordHistogram("hist1", time.Duration(453122), trace.SpanContext{})
 	assert.NotNil(t, hi.histograms["hist1"])
 	h = hi.histograms["hist1"]
 	assert.Equal(t, "hist1", h.tags["TransactionName"])
@@ -266,7 +334,7 @@ func TestRecordHistogram(t *testing.T) {
 
 	var buf bytes.Buffer
 	log.SetOutput(&buf)
-	hi.recordHistogram("hist2", time.Duration(4531224545454563))
+	hi.recordHistogram("hist2", time.Duration(4531224545454563), trace.SpanContext{})
 	log.SetOutput(os.Stderr)
 	assert.Contains(t, buf.String(), "Failed to record histogram: value to large")
 }
@@ -385,13 +453,33 @@ func TestAddHistogramToBSON(t *testing.T) {
 	assert.Equal(t, veryLongTagValueTrimmed, t2[veryLongTagNameTrimmed])
 }
 
+func TestGenerateMetricsMessageBudget(t *testing.T) {
+	qs := &EventQueueStats{}
+	qs.BytesSentAdd(300)
+	qs.NumBudgetDroppedAdd(2)
+	qs.SetBudget(400, 1000)
+
+	testMetrics := NewMeasurements(false, metricsTransactionsMaxDefault)
+	m := bsonToMap(bson.WithBuf(BuildBuiltinMetricsMessage(testMetrics, qs.CopyAndReset(), nil, false, config.HostMetricsGroups{})))
+
+	values := make(map[string]interface{})
+	for _, mt := range m["measurements"].([]interface{}) {
+		measurement := mt.(map[string]interface{})
+		values[measurement["name"].(string)] = measurement["value"]
+	}
+	assert.Equal(t, int64(300), values["EventBytesSent"])
+	assert.Equal(t, int64(2), values["NumBudgetDropped"])
+	assert.Equal(t, int64(400), values["EventBudgetUsed"])
+	assert.Equal(t, int64(1000), values["EventBudgetLimit"])
+}
+
 func TestGenerateMetricsMessage(t *testing.T) {
 	testMetrics := NewMeasurements(false, metricsTransactionsMaxDefault)
 	bbuf := bson.WithBuf(BuildBuiltinMetricsMessage(testMetrics, &EventQueueStats{},
 		map[string]*RateCounts{ // requested, sampled, limited, traced, through
 			RCRegular:             {10, 2, 5, 5, 1},
 			RCRelaxedTriggerTrace: {3, 0, 1, 2, 0},
-			RCStrictTriggerTrace:  {4, 0, 3, 1, 0}}, true))
+			RCStrictTriggerTrace:  {4, 0, 3, 1, 0}}, true, config.HostMetricsGroups{}))
 	m := bsonToMap(bbuf)
 
 	_, ok := m["Hostname"]
@@ -424,6 +512,11 @@ func TestGenerateMetricsMessage(t *testing.T) {
 		{"NumFailed", int64(1)},
 		{"TotalEvents", int64(1)},
 		{"QueueLargest", int64(1)},
+		{"NumDroppedOldest", int64(1)},
+		{"NumBlocked", int64(1)},
+		{"NumTraceDropped", int64(1)},
+		{"EventBytesSent", int64(1)},
+		{"NumBudgetDropped", int64(1)},
 	}
 	if runtime.GOOS == "linux" {
 		testCases = append(testCases, []testCase{
@@ -432,11 +525,15 @@ func TestGenerateMetricsMessage(t *testing.T) {
 			{"FreeRAM", int64(1)},
 			{"ProcessRAM", int(1)},
 		}...)
+		for _, m := range containerMetrics() {
+			testCases = append(testCases, testCase{m.name, m.value})
+		}
 	}
 	testCases = append(testCases, []testCase{
 		// runtime
 		{"trace.go.runtime.NumGoroutine", int(1)},
 		{"trace.go.runtime.NumCgoCall", int64(1)},
+		{"trace.go.runtime.GOMAXPROCS", int64(1)},
 		// gc
 		{"trace.go.gc.LastGC", int64(1)},
 		{"trace.go.gc.NextGC", int64(1)},
@@ -459,6 +556,8 @@ func TestGenerateMetricsMessage(t *testing.T) {
 		{"trace.go.memory.HeapObjects", int64(1)},
 		{"trace.go.memory.StackInuse", int64(1)},
 		{"trace.go.memory.StackSys", int64(1)},
+		// sync
+		{"trace.go.sync.MutexWaitTotalNs", int64(1)},
 	}...)
 
 	assert.Equal(t, len(testCases), len(mts))
@@ -468,6 +567,12 @@ func TestGenerateMetricsMessage(t *testing.T) {
 		assert.IsType(t, mts[i].(map[string]interface{})["value"], tc.value, tc.name)
 	}
 
+	var hists []string
+	for _, h := range m["histograms"].([]interface{}) {
+		hists = append(hists, h.(map[string]interface{})["name"].(string))
+	}
+	assert.Equal(t, []string{"trace.go.sched.Latency", "trace.go.gc.Pause", "trace.go.memory.AllocSize"}, hists)
+
 	assert.Nil(t, m["TransactionNameOverflow"])
 
 	testMetrics = NewMeasurements(false, metricsTransactionsMaxDefault)
@@ -478,7 +583,7 @@ func TestGenerateMetricsMessage(t *testing.T) {
 	}
 
 	m = bsonToMap(bson.WithBuf(BuildBuiltinMetricsMessage(testMetrics, &EventQueueStats{},
-		map[string]*RateCounts{RCRegular: {}, RCRelaxedTriggerTrace: {}, RCStrictTriggerTrace: {}}, true)))
+		map[string]*RateCounts{RCRegular: {}, RCRelaxedTriggerTrace: {}, RCStrictTriggerTrace: {}}, true, config.HostMetricsGroups{})))
 
 	assert.NotNil(t, m["TransactionNameOverflow"])
 	assert.True(t, m["TransactionNameOverflow"].(bool))
@@ -501,9 +606,29 @@ func TestEventQueueStats(t *testing.T) {
 	es.SetQueueLargest(10)
 	assert.EqualValues(t, 10, es.queueLargest)
 
+	es.NumDroppedOldestAdd(1)
+	assert.EqualValues(t, 1, es.numDroppedOldest)
+
+	es.NumBlockedAdd(1)
+	assert.EqualValues(t, 1, es.numBlocked)
+
+	es.NumTraceDroppedAdd(1)
+	assert.EqualValues(t, 1, es.numTraceDropped)
+
+	es.BytesSentAdd(100)
+	assert.EqualValues(t, 100, es.bytesSent)
+
+	es.NumBudgetDroppedAdd(1)
+	assert.EqualValues(t, 1, es.numBudgetDropped)
+
+	es.SetBudget(100, 1000)
+	assert.EqualValues(t, 100, es.budgetUsed)
+	assert.EqualValues(t, 1000, es.budgetLimit)
+
 	original := es
 	swapped := es.CopyAndReset()
-	assert.Equal(t, EventQueueStats{}, es)
+	// the budget consumption is a gauge and not reset
+	assert.Equal(t, EventQueueStats{budgetUsed: 100, budgetLimit: 1000}, es)
 	assert.Equal(t, original, *swapped)
 }
 
@@ -879,3 +1004,157 @@ func TestRecordSpanOverflowAppoptics(t *testing.T) {
 	assert.Equal(t, 1.001472e+06, granularHisto.hist.Mean())
 	assert.Equal(t, int64(1), granularHisto.hist.TotalCount())
 }
+
+func TestOnCollect(t *testing.T) {
+	m := NewMeasurements(true, 10)
+	calls := 0
+	unregister := m.OnCollect(func(into *Measurements) {
+		calls++
+		assert.Same(t, m, into)
+		assert.Nil(t, into.Increment("collected", MetricOptions{Count: 1}))
+	})
+	c := m.CopyAndReset(30)
+	assert.Equal(t, 1, calls)
+	assert.NotNil(t, c)
+	assert.Len(t, c.m, 1)
+
+	unregister()
+	assert.Nil(t, m.CopyAndReset(30))
+	assert.Equal(t, 1, calls)
+}
+
+func TestGaugeAndHistogram(t *testing.T) {
+	m := NewMeasurements(true, 3)
+	tags := map[string]string{"queue": "default"}
+
+	assert.Nil(t, m.Gauge("depth", 5, MetricOptions{Tags: tags}))
+	assert.Nil(t, m.Gauge("depth", 3, MetricOptions{Tags: tags}))
+	assert.Nil(t, m.Histogram("size", 100, MetricOptions{Count: 1, Tags: tags}))
+	assert.Nil(t, m.Histogram("size", 200, MetricOptions{Count: 2, Tags: tags}))
+
+	assert.Nil(t, m.Histogram("size", histogramHighestTrackable, MetricOptions{Count: 1, Tags: tags}))
+	assert.Equal(t, ErrHistogramValueOutOfRange, m.Histogram("size", -1, MetricOptions{Count: 1}))
+	assert.Equal(t, ErrHistogramValueOutOfRange, m.Histogram("size", histogramHighestTrackable+1, MetricOptions{Count: 1}))
+	assert.Equal(t, ErrMetricsWithNonPositiveCount, m.Histogram("size", 1, MetricOptions{}))
+	manyTags := make(map[string]string)
+	for i := 0; i <= MaxTagsCount; i++ {
+		manyTags[strconv.Itoa(i)] = "v"
+	}
+	assert.Equal(t, ErrExceedsTagsCountLimit, m.Gauge("depth", 1, MetricOptions{Tags: manyTags}))
+	assert.Equal(t, ErrExceedsTagsCountLimit, m.Histogram("size", 1, MetricOptions{Count: 1, Tags: manyTags}))
+
+	// the gauges and histograms count towards the cap
+	assert.Nil(t, m.Increment("count", MetricOptions{Count: 1}))
+	assert.Equal(t, ErrExceedsMetricsCountLimit, m.Gauge("other", 1, MetricOptions{}))
+	assert.Equal(t, ErrExceedsMetricsCountLimit, m.Histogram("other", 1, MetricOptions{Count: 1}))
+	assert.True(t, m.Overflow())
+
+	msg := map[string]interface{}{}
+	assert.Nil(t, mbson.Unmarshal(BuildMessage(m.CopyAndReset(30), false), msg))
+	byName := make(map[string]map[string]interface{})
+	for _, v := range msg["measurements"].([]interface{}) {
+		me := v.(map[string]interface{})
+		byName[me["name"].(string)] = me
+	}
+	assert.Len(t, byName, 2)
+	hists := msg["histograms"].([]interface{})
+	require.Len(t, hists, 1)
+	byName["size"] = hists[0].(map[string]interface{})
+
+	assert.Equal(t, 1, byName["depth"]["count"])
+	assert.Equal(t, float64(3), byName["depth"]["sum"])
+	assert.Equal(t, map[string]interface{}{"queue": "default"}, byName["depth"]["tags"])
+
+	assert.Equal(t, map[string]interface{}{"queue": "default"}, byName["size"]["tags"])
+	expected := newHist(getHistogramPrecision())
+	expected.Record(100)
+	expected.RecordN(200, 2)
+	expected.Record(histogramHighestTrackable)
+	data, err := hdrhist.EncodeCompressed(expected)
+	assert.Nil(t, err)
+	assert.Equal(t, string(data), byName["size"]["value"])
+
+	// reset after the flush
+	assert.Nil(t, m.CopyAndReset(30))
+}
+
+func TestRecordSpanGrpc(t *testing.T) {
+	tr, teardown := testutils.TracerSetup()
+	defer teardown()
+	for _, tc := range []struct {
+		code    int64
+		isError bool
+	}{
+		{0, false}, // OK
+		{3, false}, // INVALID_ARGUMENT, caused by the client
+		{5, false}, // NOT_FOUND
+		{2, true},  // UNKNOWN
+		{4, true},  // DEADLINE_EXCEEDED
+		{12, true}, // UNIMPLEMENTED
+		{13, true}, // INTERNAL
+		{14, true}, // UNAVAILABLE
+		{15, true}, // DATA_LOSS
+	} {
+		now := time.Now()
+		_, span := tr.Start(context.Background(), "/helloworld.Greeter/SayHello",
+			trace.WithTimestamp(now),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("rpc.system", "grpc"),
+				attribute.String("rpc.service", "helloworld.Greeter"),
+				attribute.String("rpc.method", "SayHello"),
+				attribute.Int64("rpc.grpc.status_code", tc.code),
+			),
+		)
+		span.End(trace.WithTimestamp(now.Add(time.Second)))
+
+		apm := NewMeasurements(false, metricsTransactionsMaxDefault)
+		hi := &histograms{histograms: make(map[string]*histogram), precision: metricsHistPrecisionDefault}
+		recordSpan(span.(sdktrace.ReadOnlySpan), false, apm, hi)
+
+		require.Len(t, apm.m, 1)
+		for _, v := range apm.m {
+			assert.Equal(t, map[string]string{
+				"rpc.status_code": strconv.FormatInt(tc.code, 10),
+				"sw.is_error":     strconv.FormatBool(tc.isError),
+				"sw.transaction":  "helloworld.Greeter/SayHello",
+			}, v.Tags, tc.code)
+		}
+	}
+}
+
+func TestRecordSpanConsumer(t *testing.T) {
+	tr, teardown := testutils.TracerSetup()
+	defer teardown()
+	attrs := []attribute.KeyValue{
+		attribute.String("messaging.system", "kafka"),
+		attribute.String("messaging.destination.name", "orders"),
+	}
+	_, span := tr.Start(context.Background(), "orders process",
+		trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(attrs...))
+	span.End()
+
+	apm := NewMeasurements(false, metricsTransactionsMaxDefault)
+	hi := &histograms{histograms: make(map[string]*histogram), precision: metricsHistPrecisionDefault}
+	recordSpan(span.(sdktrace.ReadOnlySpan), false, apm, hi)
+
+	require.Len(t, apm.m, 1)
+	for _, v := range apm.m {
+		assert.Equal(t, map[string]string{
+			"messaging.system":           "kafka",
+			"messaging.destination.name": "orders",
+			"sw.is_error":                "false",
+			"sw.transaction":             "orders process",
+		}, v.Tags)
+	}
+
+	// only for the consumers
+	_, span = tr.Start(context.Background(), "orders process",
+		trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+	span.End()
+	apm = NewMeasurements(false, metricsTransactionsMaxDefault)
+	recordSpan(span.(sdktrace.ReadOnlySpan), false, apm, hi)
+	for _, v := range apm.m {
+		assert.NotContains(t, v.Tags, "messaging.system")
+	}
+}
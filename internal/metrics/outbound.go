@@ -0,0 +1,125 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/solarwinds/apm-go/internal/swotel/semconv"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// outboundResponseTime is the name of the response time of the calls to the
+// dependencies, e.g., databases and downstream services.
+const outboundResponseTime = "OutboundResponseTime"
+
+// The tags of the outbound metrics
+const (
+	outboundDependencyTag = "sw.dependency"
+	outboundDBSystemTag   = "db.system"
+	outboundRPCMethodTag  = "rpc.method"
+	outboundIsErrorTag    = "sw.is_error"
+)
+
+// IsOutboundSpan returns if the span is a call to a dependency, i.e., a client
+// or a producer span.
+func IsOutboundSpan(span sdktrace.ReadOnlySpan) bool {
+	kind := span.SpanKind()
+	return kind == trace.SpanKindClient || kind == trace.SpanKindProducer
+}
+
+// RecordOutboundSpan records the outbound metrics of a client or producer span
+// into the package-level metrics.
+func RecordOutboundSpan(span sdktrace.ReadOnlySpan) {
+	recordOutboundSpan(span, ApmMetrics, apmHistograms)
+}
+
+// outboundTags returns the tags identifying the dependency of a span: the
+// peer.service, or the server.address (net.peer.name before semconv v1.21.0),
+// or the rpc.service, along with the db.system and the rpc.method if any.
+func outboundTags(span sdktrace.ReadOnlySpan) map[string]string {
+	var peerService, serverAddress, netPeerName, rpcService string
+	tags := make(map[string]string)
+	for _, attr := range span.Attributes() {
+		switch attr.Key {
+		case semconv.PeerServiceKey:
+			peerService = attr.Value.AsString()
+		case semconv.ServerAddressKey:
+			serverAddress = attr.Value.AsString()
+		case semconv.NetPeerNameKey:
+			netPeerName = attr.Value.AsString()
+		case semconv.RPCServiceKey:
+			rpcService = attr.Value.AsString()
+		case semconv.DBSystemKey:
+			tags[outboundDBSystemTag] = attr.Value.AsString()
+		case semconv.RPCMethodKey:
+			tags[outboundRPCMethodTag] = attr.Value.AsString()
+		}
+	}
+	for _, dependency := range []string{peerService, serverAddress, netPeerName, rpcService} {
+		if dependency != "" {
+			tags[outboundDependencyTag] = dependency
+			break
+		}
+	}
+	return tags
+}
+
+// recordOutboundSpan records the response time of a call to a dependency,
+// tagged with sw.is_error, and its histogram.
+func recordOutboundSpan(span sdktrace.ReadOnlySpan, apm *Measurements, hi *histograms) {
+	tags := outboundTags(span)
+	isError := span.Status().Code == codes.Error
+	duration := span.EndTime().Sub(span.StartTime())
+
+	apm.recordOutbound(tags, isError, duration)
+	hi.recordTaggedHistogram(outboundResponseTime, tags, duration)
+}
+
+// recordOutbound records the response time of a call to a dependency. The
+// dependencies have their own cap, so they don't use up the one of the
+// transactions, and the errors don't count as distinct dependencies: once the
+// cap is exceeded, the calls to the new dependencies are recorded as "other"
+// without the rpc.method, which is also removed from tags.
+func (m *Measurements) recordOutbound(tags map[string]string, isError bool, duration time.Duration) {
+	if !m.outboundMap.IsWithinLimit(metricID([]string{outboundResponseTime}, tags)) {
+		tags[outboundDependencyTag] = OtherTransactionName
+		delete(tags, outboundRPCMethodTag)
+	}
+
+	withError := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		withError[k] = v
+	}
+	withError[outboundIsErrorTag] = strconv.FormatBool(isError)
+	id := metricID([]string{outboundResponseTime, "true"}, withError)
+
+	m.Lock()
+	defer m.Unlock()
+	me, ok := m.m[id]
+	if !ok {
+		me = &Measurement{
+			Name:      outboundResponseTime,
+			Tags:      withError,
+			ReportSum: true,
+		}
+		m.m[id] = me
+	}
+	me.Count++
+	me.Sum += float64(duration / time.Microsecond)
+}
@@ -0,0 +1,132 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/solarwinds/apm-go/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func outboundSpan(t *testing.T, kind trace.SpanKind, isError bool, attrs ...attribute.KeyValue) sdktrace.ReadOnlySpan {
+	tr, teardown := testutils.TracerSetup()
+	t.Cleanup(teardown)
+	now := time.Now()
+	_, span := tr.Start(context.Background(), "call",
+		trace.WithTimestamp(now), trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+	if isError {
+		span.SetStatus(codes.Error, "failed")
+	}
+	span.End(trace.WithTimestamp(now.Add(20 * time.Millisecond)))
+	return span.(sdktrace.ReadOnlySpan)
+}
+
+func TestIsOutboundSpan(t *testing.T) {
+	assert.True(t, IsOutboundSpan(outboundSpan(t, trace.SpanKindClient, false)))
+	assert.True(t, IsOutboundSpan(outboundSpan(t, trace.SpanKindProducer, false)))
+	assert.False(t, IsOutboundSpan(outboundSpan(t, trace.SpanKindServer, false)))
+	assert.False(t, IsOutboundSpan(outboundSpan(t, trace.SpanKindConsumer, false)))
+	assert.False(t, IsOutboundSpan(outboundSpan(t, trace.SpanKindInternal, false)))
+}
+
+func TestOutboundTags(t *testing.T) {
+	for _, tc := range []struct {
+		attrs []attribute.KeyValue
+		tags  map[string]string
+	}{
+		{nil, map[string]string{}},
+		{
+			[]attribute.KeyValue{attribute.String("db.system", "redis"), attribute.String("net.peer.name", "cache")},
+			map[string]string{"sw.dependency": "cache", "db.system": "redis"},
+		},
+		{
+			[]attribute.KeyValue{attribute.String("server.address", "api.example.com"),
+				attribute.String("peer.service", "billing"), attribute.String("net.peer.name", "10.0.0.1")},
+			map[string]string{"sw.dependency": "billing"},
+		},
+		{
+			[]attribute.KeyValue{attribute.String("rpc.service", "users.Users"), attribute.String("rpc.method", "Get")},
+			map[string]string{"sw.dependency": "users.Users", "rpc.method": "Get"},
+		},
+	} {
+		assert.Equal(t, tc.tags, outboundTags(outboundSpan(t, trace.SpanKindClient, false, tc.attrs...)))
+	}
+}
+
+func TestRecordOutboundSpan(t *testing.T) {
+	apm := NewMeasurements(false, metricsTransactionsMaxDefault)
+	hi := &histograms{histograms: make(map[string]*histogram), precision: metricsHistPrecisionDefault}
+	attrs := []attribute.KeyValue{attribute.String("db.system", "postgresql"), attribute.String("peer.service", "users-db")}
+
+	recordOutboundSpan(outboundSpan(t, trace.SpanKindClient, false, attrs...), apm, hi)
+	recordOutboundSpan(outboundSpan(t, trace.SpanKindClient, false, attrs...), apm, hi)
+	recordOutboundSpan(outboundSpan(t, trace.SpanKindClient, true, attrs...), apm, hi)
+
+	ok := apm.m["OutboundResponseTime&true&db.system:postgresql&sw.dependency:users-db&sw.is_error:false&"]
+	require.NotNil(t, ok)
+	assert.Equal(t, 2, ok.Count)
+	assert.Equal(t, float64(40000), ok.Sum) // microseconds
+	failed := apm.m["OutboundResponseTime&true&db.system:postgresql&sw.dependency:users-db&sw.is_error:true&"]
+	require.NotNil(t, failed)
+	assert.Equal(t, 1, failed.Count)
+	assert.Equal(t, map[string]string{"db.system": "postgresql", "sw.dependency": "users-db", "sw.is_error": "true"}, failed.Tags)
+
+	h := hi.histograms["OutboundResponseTime&db.system:postgresql&sw.dependency:users-db&"]
+	require.NotNil(t, h)
+	assert.Equal(t, outboundResponseTime, h.name)
+	assert.Equal(t, map[string]string{"db.system": "postgresql", "sw.dependency": "users-db"}, h.tags)
+	assert.Equal(t, int64(3), h.hist.TotalCount())
+}
+
+func TestRecordOutboundSpanOverflow(t *testing.T) {
+	apm := NewMeasurements(false, 2)
+	hi := &histograms{histograms: make(map[string]*histogram), precision: metricsHistPrecisionDefault}
+	for i := 0; i < 4; i++ {
+		recordOutboundSpan(outboundSpan(t, trace.SpanKindClient, false,
+			attribute.String("peer.service", "svc-"+strconv.Itoa(i)), attribute.String("rpc.method", "Get")), apm, hi)
+	}
+
+	// the errors are not distinct dependencies
+	recordOutboundSpan(outboundSpan(t, trace.SpanKindClient, true,
+		attribute.String("peer.service", "svc-0"), attribute.String("rpc.method", "Get")), apm, hi)
+
+	assert.Len(t, apm.m, 4)
+	failed := apm.m["OutboundResponseTime&true&rpc.method:Get&sw.dependency:svc-0&sw.is_error:true&"]
+	require.NotNil(t, failed)
+	other := apm.m["OutboundResponseTime&true&sw.dependency:other&sw.is_error:false&"]
+	require.NotNil(t, other)
+	assert.Equal(t, 2, other.Count)
+	assert.True(t, apm.outboundMap.Overflow())
+
+	// the transactions have their own cap
+	assert.False(t, apm.transMap.Overflow())
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, apm.recordWithSoloTags(transactionResponseTime,
+			map[string]string{"TransactionName": "txn-" + strconv.Itoa(i)}, 1, 1, true))
+	}
+	assert.False(t, apm.transMap.Overflow())
+
+	assert.Len(t, hi.histograms, 3)
+	assert.NotNil(t, hi.histograms["OutboundResponseTime&sw.dependency:other&"])
+}
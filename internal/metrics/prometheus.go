@@ -0,0 +1,549 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/solarwinds/apm-go/internal/log"
+)
+
+const (
+	// the prefix of the metric names in the Prometheus text format
+	promPrefix = "sw_apm_"
+	// the prefix of the custom metrics, so they don't clash with the builtin ones
+	promCustomPrefix = promPrefix + "custom_"
+)
+
+// classicBuckets are the upper bounds of the classic buckets derived from the
+// histograms, 1-2-5 per decade up to histogramHighestTrackable. They are also
+// the value ranges of the exemplars.
+var classicBuckets = func() []int64 {
+	var b []int64
+	for d := int64(1); d <= histogramHighestTrackable; d *= 10 {
+		for _, m := range []int64{1, 2, 5} {
+			if d*m <= histogramHighestTrackable {
+				b = append(b, d*m)
+			}
+		}
+	}
+	return b
+}()
+
+// promFamily is the samples of a metric family of the same type
+type promFamily struct {
+	typ     string
+	samples []string
+}
+
+// promWriter groups the samples by metric family as the text format requires
+type promWriter struct {
+	families map[string]*promFamily
+}
+
+// WritePrometheus writes the metrics of the registry in the Prometheus text
+// format: the inbound and custom measurements and histograms, the event queue
+// stats and the request counters. The current event queue stats qs and rate
+// counts rcs may be nil.
+//
+// The counters, summaries and histograms are cumulative: the values flushed
+// to the collector are kept as running totals by the registry, see
+// BuildBuiltinMetricsMessage and BuildCustomMetricsMessage, and the values of
+// the current flush interval are added to them. The gauges are the current
+// values, or the last ones flushed. Nothing is reset, and the observable
+// instruments are observed into a scratch copy of the measurements, so the
+// values sent to the collector are not affected.
+func WritePrometheus(w io.Writer, r *Registry, qs *EventQueueStats, rcs map[string]*RateCounts) error {
+	pw := &promWriter{families: make(map[string]*promFamily)}
+
+	apm, apmHists := r.apm.snapshot()
+	apmHists = append(apmHists, r.histograms.snapshot()...)
+	custom, customHists := r.custom.snapshot()
+
+	apm, apmHists = r.totals.merge(promPrefix, apm, apmHists)
+	custom, customHists = r.totals.merge(promCustomPrefix, custom, customHists)
+	pw.addMeasurements(promPrefix, apm)
+	pw.addHistograms(promPrefix, apmHists)
+	pw.addMeasurements(promCustomPrefix, custom)
+	pw.addHistograms(promCustomPrefix, customHists)
+
+	for _, c := range r.totals.mergeCounters(append(requestCounters(rcs), qs.values()...)) {
+		typ := "counter"
+		if isPromQueueGauge(c.name) {
+			typ = "gauge"
+		}
+		pw.add(promPrefix+c.name, typ, promSample(promPrefix+c.name, nil, c.value))
+	}
+
+	return pw.write(w)
+}
+
+// isPromQueueGauge returns if an event queue stat is a gauge rather than a
+// counter.
+func isPromQueueGauge(name string) bool {
+	switch name {
+	case "QueueLargest", "EventBudgetUsed", "EventBudgetLimit":
+		return true
+	}
+	return false
+}
+
+// promTotals are the running totals of the metrics flushed to the collector,
+// so the counters, summaries and histograms served to Prometheus are
+// monotonic. Each set of measurements and histograms keeps at most as many
+// series as the cap of the measurements flushed, the new ones beyond are
+// dropped.
+type promTotals struct {
+	lock     sync.Mutex
+	series   map[string]*promSeries // by the prefix, promPrefix or promCustomPrefix
+	counters map[string]int64       // the request counters and the event queue counters
+}
+
+// promSeries are the totals of the measurements and the histograms of a prefix.
+type promSeries struct {
+	measurements map[string]*Measurement
+	histograms   map[string]*histogram
+}
+
+func newPromTotals() *promTotals {
+	return &promTotals{
+		series:   make(map[string]*promSeries),
+		counters: make(map[string]int64),
+	}
+}
+
+// promMeasurementID returns the ID of a measurement, which is distinct for
+// each type of the same name and tags.
+func promMeasurementID(m *Measurement) string {
+	return metricID([]string{m.Name, strconv.FormatBool(m.ReportSum), strconv.FormatBool(m.Gauge)}, m.Tags)
+}
+
+// promHistogramID returns the ID of a histogram.
+func promHistogramID(h *histogram) string {
+	return metricID([]string{h.name, "histogram"}, h.tags)
+}
+
+func (t *promTotals) seriesLocked(prefix string) *promSeries {
+	s, ok := t.series[prefix]
+	if !ok {
+		s = &promSeries{
+			measurements: make(map[string]*Measurement),
+			histograms:   make(map[string]*histogram),
+		}
+		t.series[prefix] = s
+	}
+	return s
+}
+
+// addMeasurements adds the measurements and the histograms of m, which are
+// flushed, to the totals.
+func (t *promTotals) addMeasurements(prefix string, m *Measurements) {
+	if m == nil {
+		return
+	}
+	hs := make([]*histogram, 0, len(m.h))
+	for _, h := range m.h {
+		hs = append(hs, h)
+	}
+	t.addFlushed(prefix, m.m, hs, int(m.Cap()))
+}
+
+// addHistograms adds the histograms flushed to the totals.
+func (t *promTotals) addHistograms(prefix string, hs []*histogram, cap int) {
+	t.addFlushed(prefix, nil, hs, cap)
+}
+
+func (t *promTotals) addFlushed(prefix string, ms map[string]*Measurement, hs []*histogram, cap int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	s := t.seriesLocked(prefix)
+	for _, m := range ms {
+		id := promMeasurementID(m)
+		total, ok := s.measurements[id]
+		switch {
+		case !ok && len(s.measurements) < cap:
+			c := *m
+			s.measurements[id] = &c
+		case !ok:
+		case m.Gauge:
+			total.Sum = m.Sum
+		default:
+			total.Count += m.Count
+			total.Sum += m.Sum
+		}
+	}
+	for _, h := range hs {
+		id := promHistogramID(h)
+		if total, ok := s.histograms[id]; ok {
+			total.hist.Add(h.hist)
+		} else if len(s.histograms) < cap {
+			s.histograms[id] = &histogram{name: h.name, hist: h.hist.Clone(), tags: h.tags}
+		}
+	}
+}
+
+// addCounters adds the flushed values of the counters to the totals. The
+// gauges are skipped.
+func (t *promTotals) addCounters(counters []hostMetric) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, c := range counters {
+		if v, ok := c.value.(int64); ok && !isPromQueueGauge(c.name) {
+			t.counters[c.name] += v
+		}
+	}
+}
+
+// merge returns the totals of the prefix with the current measurements and
+// histograms added. The current gauges replace the ones flushed.
+func (t *promTotals) merge(prefix string, ms []Measurement, hs []*histogram) ([]Measurement, []*histogram) {
+	t.lock.Lock()
+	s := t.seriesLocked(prefix)
+	measurements := make(map[string]*Measurement, len(s.measurements)+len(ms))
+	for id, m := range s.measurements {
+		c := *m
+		measurements[id] = &c
+	}
+	histograms := make(map[string]*histogram, len(s.histograms)+len(hs))
+	for id, h := range s.histograms {
+		histograms[id] = &histogram{name: h.name, hist: h.hist.Clone(), tags: h.tags}
+	}
+	t.lock.Unlock()
+
+	for i := range ms {
+		m := &ms[i]
+		id := promMeasurementID(m)
+		total, ok := measurements[id]
+		switch {
+		case !ok:
+			measurements[id] = m
+		case m.Gauge:
+			total.Sum = m.Sum
+		default:
+			total.Count += m.Count
+			total.Sum += m.Sum
+		}
+	}
+	for _, h := range hs {
+		id := promHistogramID(h)
+		if total, ok := histograms[id]; ok {
+			total.hist.Add(h.hist)
+		} else {
+			histograms[id] = h
+		}
+	}
+
+	mergedMs := make([]Measurement, 0, len(measurements))
+	for _, m := range measurements {
+		mergedMs = append(mergedMs, *m)
+	}
+	mergedHs := make([]*histogram, 0, len(histograms))
+	for _, h := range histograms {
+		mergedHs = append(mergedHs, h)
+	}
+	return mergedMs, mergedHs
+}
+
+// mergeCounters returns the current values of the counters added to their
+// totals. The gauges are the current values.
+func (t *promTotals) mergeCounters(current []hostMetric) []hostMetric {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	merged := make([]hostMetric, 0, len(current)+len(t.counters))
+	seen := make(map[string]bool, len(current))
+	for _, c := range current {
+		seen[c.name] = true
+		if v, ok := c.value.(int64); ok && !isPromQueueGauge(c.name) {
+			c.value = t.counters[c.name] + v
+		}
+		merged = append(merged, c)
+	}
+	// the totals of the counters which are not current, e.g., without the
+	// event queue stats
+	for name, v := range t.counters {
+		if !seen[name] {
+			merged = append(merged, hostMetric{name, v})
+		}
+	}
+	return merged
+}
+
+// add adds the samples to the family. The samples are prefixed by the family
+// name. If a family of another type already has the name, e.g., a custom gauge
+// and a custom summary of the same name, the type is appended to the family
+// name, or the samples are dropped if that name is taken too.
+func (pw *promWriter) add(family, typ string, samples ...string) {
+	f, ok := pw.families[family]
+	if ok && f.typ != typ {
+		renamed := family + "_" + typ
+		for i, s := range samples {
+			samples[i] = renamed + strings.TrimPrefix(s, family)
+		}
+		family = renamed
+		if f, ok = pw.families[family]; ok && f.typ != typ {
+			log.Debugf("Dropped the Prometheus samples of %s: the name is taken by a %s", family, f.typ)
+			return
+		}
+	}
+	if !ok {
+		f = &promFamily{typ: typ}
+		pw.families[family] = f
+	}
+	f.samples = append(f.samples, samples...)
+}
+
+// addMeasurements adds the measurements as summaries without quantiles, the
+// counts without sums as counters, and the gauges. They are sorted by name,
+// the gauges first, so the same family is renamed on a type clash in each
+// scrape, see add.
+func (pw *promWriter) addMeasurements(prefix string, ms []Measurement) {
+	sort.SliceStable(ms, func(i, j int) bool {
+		if ms[i].Name != ms[j].Name {
+			return ms[i].Name < ms[j].Name
+		}
+		return promTypeRank(ms[i]) < promTypeRank(ms[j])
+	})
+	for _, m := range ms {
+		name := prefix + promName(m.Name)
+		switch {
+		case m.Gauge:
+			pw.add(name, "gauge", promSample(name, m.Tags, m.Sum))
+		case m.ReportSum:
+			pw.add(name, "summary",
+				promSample(name+"_sum", m.Tags, m.Sum),
+				promSample(name+"_count", m.Tags, m.Count))
+		default:
+			name += "_total"
+			pw.add(name, "counter", promSample(name, m.Tags, m.Count))
+		}
+	}
+}
+
+// promTypeRank orders the measurements of the same name by type: gauges,
+// summaries and counters.
+func promTypeRank(m Measurement) int {
+	switch {
+	case m.Gauge:
+		return 0
+	case m.ReportSum:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// addHistograms adds the histograms with the classic buckets. The names have
+// the suffix _histogram as the measurements of the same names are summaries,
+// e.g., OutboundResponseTime. The sum is estimated from the mean of the
+// histogram.
+func (pw *promWriter) addHistograms(prefix string, hs []*histogram) {
+	for _, h := range hs {
+		name := h.name
+		if name == "" {
+			name = transactionResponseTime
+		}
+		name = prefix + promName(name) + "_histogram"
+
+		vals := h.hist.AllVals()
+		samples := make([]string, 0, len(classicBuckets)+3)
+		var cum int64
+		i := 0
+		for _, le := range classicBuckets {
+			for ; i < len(vals) && vals[i].Value <= le; i++ {
+				cum += vals[i].Count
+			}
+			samples = append(samples, promSample(name+"_bucket",
+				withLabel(h.tags, "le", strconv.FormatInt(le, 10)), cum))
+		}
+		total := h.hist.TotalCount()
+		samples = append(samples,
+			promSample(name+"_bucket", withLabel(h.tags, "le", "+Inf"), total),
+			promSample(name+"_sum", h.tags, h.hist.Mean()*float64(total)),
+			promSample(name+"_count", h.tags, total))
+		pw.add(name, "histogram", samples...)
+	}
+}
+
+// write writes the families sorted by name.
+func (pw *promWriter) write(w io.Writer) error {
+	names := make([]string, 0, len(pw.families))
+	for name := range pw.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		f := pw.families[name]
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, f.typ)
+		for _, s := range f.samples {
+			bw.WriteString(s)
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+// promSample formats a sample with the labels sorted by name.
+func promSample(name string, labels map[string]string, value interface{}) string {
+	var sb strings.Builder
+	sb.WriteString(name)
+	if len(labels) > 0 {
+		keys := make([]string, 0, len(labels))
+		for k := range labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		sb.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(promName(k))
+			sb.WriteString(`="`)
+			sb.WriteString(promLabelValueEscaper.Replace(labels[k]))
+			sb.WriteByte('"')
+		}
+		sb.WriteByte('}')
+	}
+	sb.WriteByte(' ')
+	switch v := value.(type) {
+	case int:
+		sb.WriteString(strconv.Itoa(v))
+	case int64:
+		sb.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		sb.WriteString(promFloat(v))
+	}
+	return sb.String()
+}
+
+func promFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var promLabelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promName replaces the characters which are not allowed in the metric and
+// label names with underscores, e.g., sw.transaction becomes sw_transaction.
+func promName(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if !(c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+			(c >= '0' && c <= '9' && i > 0)) {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// withLabel returns a copy of the labels with the extra one.
+func withLabel(labels map[string]string, k, v string) map[string]string {
+	l := make(map[string]string, len(labels)+1)
+	for lk, lv := range labels {
+		l[lk] = lv
+	}
+	l[k] = v
+	return l
+}
+
+// snapshot returns a copy of the measurements and the histograms, which are
+// not reset. The functions registered by OnCollect record into the copy, so
+// the observations are included without affecting the next flush.
+func (m *Measurements) snapshot() ([]Measurement, []*histogram) {
+	scratch := m.scratchCopy()
+	m.collect(scratch)
+
+	ms := make([]Measurement, 0, len(scratch.m))
+	for _, me := range scratch.m {
+		ms = append(ms, *me)
+	}
+	hs := make([]*histogram, 0, len(scratch.h))
+	for _, h := range scratch.h {
+		hs = append(hs, h)
+	}
+	return ms, hs
+}
+
+// scratchCopy returns a deep copy of the measurements, the histograms and the
+// transaction maps, which may be recorded into without affecting m.
+func (m *Measurements) scratchCopy() *Measurements {
+	m.Lock()
+	defer m.Unlock()
+	c := &Measurements{
+		m:             make(map[string]*Measurement, len(m.m)),
+		h:             make(map[string]*histogram, len(m.h)),
+		transMap:      m.transMap.copy(),
+		outboundMap:   m.outboundMap.copy(),
+		txnNameRules:  m.txnNameRules,
+		IsCustom:      m.IsCustom,
+		FlushInterval: m.FlushInterval,
+	}
+	for id, me := range m.m {
+		cme := *me
+		c.m[id] = &cme
+	}
+	for id, h := range m.h {
+		c.h[id] = &histogram{name: h.name, hist: h.hist.Clone(), tags: h.tags}
+	}
+	return c
+}
+
+// copy returns a deep copy of the transaction map.
+func (t *TransMap) copy() *TransMap {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	c := &TransMap{
+		transactionNames: make(map[string]struct{}, len(t.transactionNames)),
+		currCap:          t.currCap,
+		nextCap:          t.nextCap,
+		overflow:         t.overflow,
+		allowed:          t.allowed,
+		overflowed:       make(map[string]struct{}, len(t.overflowed)),
+	}
+	for name := range t.transactionNames {
+		c.transactionNames[name] = struct{}{}
+	}
+	for name := range t.overflowed {
+		c.overflowed[name] = struct{}{}
+	}
+	return c
+}
+
+// snapshot returns a copy of the histograms, which are not reset.
+func (hi *histograms) snapshot() []*histogram {
+	hi.lock.Lock()
+	defer hi.lock.Unlock()
+	hs := make([]*histogram, 0, len(hi.histograms))
+	for _, h := range hi.histograms {
+		hs = append(hs, &histogram{name: h.name, hist: h.hist.Clone(), tags: h.tags})
+	}
+	return hs
+}
@@ -0,0 +1,254 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/solarwinds/apm-go/internal/config"
+	"github.com/solarwinds/apm-go/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	tr, teardown := testutils.TracerSetup()
+	defer teardown()
+
+	r := NewRegistry()
+	now := time.Now()
+	_, span := tr.Start(context.Background(), "/users", trace.WithTimestamp(now),
+		trace.WithSpanKind(trace.SpanKindServer))
+	span.End(trace.WithTimestamp(now.Add(3 * time.Millisecond)))
+	r.RecordSpan(span.(sdktrace.ReadOnlySpan), false)
+
+	custom := r.CustomMetrics()
+	require.NoError(t, custom.Increment("hits", MetricOptions{Count: 2, Tags: map[string]string{"path": `a"b`}}))
+	require.NoError(t, custom.Summary("load", 1.5, MetricOptions{Count: 1}))
+	require.NoError(t, custom.Gauge("queue.size", 7, MetricOptions{Count: 1}))
+	require.NoError(t, custom.Histogram("size", 150, MetricOptions{Count: 1}))
+
+	qs := &EventQueueStats{}
+	qs.NumSentAdd(5)
+	qs.SetQueueLargest(3)
+	rcs := map[string]*RateCounts{RCRegular: {requested: 4, sampled: 2, traced: 1}}
+
+	var buf bytes.Buffer
+	require.NoError(t, WritePrometheus(&buf, r, qs, rcs))
+	out := buf.String()
+
+	for _, line := range []string{
+		"# TYPE sw_apm_ResponseTime summary",
+		`sw_apm_ResponseTime_count{sw_is_error="false",sw_transaction="/users"} 1`,
+		`sw_apm_ResponseTime_sum{sw_is_error="false",sw_transaction="/users"} 3000`,
+		"# TYPE sw_apm_TransactionResponseTime_histogram histogram",
+		`sw_apm_TransactionResponseTime_histogram_bucket{TransactionName="/users",le="2000"} 0`,
+		`sw_apm_TransactionResponseTime_histogram_bucket{TransactionName="/users",le="5000"} 1`,
+		`sw_apm_TransactionResponseTime_histogram_bucket{TransactionName="/users",le="+Inf"} 1`,
+		`sw_apm_TransactionResponseTime_histogram_count{TransactionName="/users"} 1`,
+		`sw_apm_TransactionResponseTime_histogram_bucket{le="+Inf"} 1`,
+		"# TYPE sw_apm_custom_hits_total counter",
+		`sw_apm_custom_hits_total{path="a\"b"} 2`,
+		`sw_apm_custom_load_sum 1.5`,
+		`sw_apm_custom_load_count 1`,
+		"# TYPE sw_apm_custom_queue_size gauge",
+		`sw_apm_custom_queue_size 7`,
+		`sw_apm_custom_size_histogram_bucket{le="100"} 0`,
+		`sw_apm_custom_size_histogram_bucket{le="200"} 1`,
+		"# TYPE sw_apm_RequestCount counter",
+		"sw_apm_RequestCount 4",
+		"sw_apm_SampleCount 2",
+		"sw_apm_TraceCount 1",
+		"sw_apm_NumSent 5",
+		"# TYPE sw_apm_QueueLargest gauge",
+		"sw_apm_QueueLargest 3",
+	} {
+		assert.Contains(t, strings.Split(out, "\n"), line)
+	}
+	assert.NotContains(t, out, "EventBudget")
+
+	// nothing is reset by the scrape
+	apm, _ := r.ApmMetrics().snapshot()
+	assert.Len(t, apm, 1)
+	assert.Len(t, r.histograms.snapshot(), 2)
+	assert.EqualValues(t, 5, qs.NumSent())
+	assert.EqualValues(t, 4, rcs[RCRegular].Requested())
+	m := r.ApmMetrics().CopyAndReset(60)
+	require.NotNil(t, m)
+	assert.Len(t, m.m, 1)
+}
+
+func TestWritePrometheusTotals(t *testing.T) {
+	tr, teardown := testutils.TracerSetup()
+	defer teardown()
+
+	r := NewRegistry()
+	recordSpan := func() {
+		now := time.Now()
+		_, span := tr.Start(context.Background(), "/users", trace.WithTimestamp(now),
+			trace.WithSpanKind(trace.SpanKindServer))
+		span.End(trace.WithTimestamp(now.Add(3 * time.Millisecond)))
+		r.RecordSpan(span.(sdktrace.ReadOnlySpan), false)
+	}
+	custom := r.CustomMetrics()
+	scrape := func(qs *EventQueueStats, rcs map[string]*RateCounts) []string {
+		var buf bytes.Buffer
+		require.NoError(t, WritePrometheus(&buf, r, qs, rcs))
+		return strings.Split(buf.String(), "\n")
+	}
+
+	// the first flush interval
+	recordSpan()
+	require.NoError(t, custom.Increment("hits", MetricOptions{Count: 2}))
+	require.NoError(t, custom.Gauge("queue.size", 7, MetricOptions{Count: 1}))
+	qs := &EventQueueStats{}
+	qs.NumSentAdd(5)
+	qs.SetQueueLargest(3)
+	rcs := map[string]*RateCounts{RCRegular: {requested: 4}}
+	require.NotNil(t, r.BuildBuiltinMetricsMessage(r.ApmMetrics().CopyAndReset(60), qs, rcs, false, config.HostMetricsGroups{}))
+	require.NotNil(t, r.BuildCustomMetricsMessage(60))
+
+	// the values flushed are kept
+	lines := scrape(&EventQueueStats{}, nil)
+	for _, line := range []string{
+		`sw_apm_ResponseTime_count{sw_is_error="false",sw_transaction="/users"} 1`,
+		`sw_apm_TransactionResponseTime_histogram_count{TransactionName="/users"} 1`,
+		"sw_apm_custom_hits_total 2",
+		"sw_apm_custom_queue_size 7",
+		"sw_apm_NumSent 5",
+		"sw_apm_QueueLargest 0",
+		"sw_apm_RequestCount 4",
+	} {
+		assert.Contains(t, lines, line)
+	}
+
+	// and the current values added to them
+	recordSpan()
+	require.NoError(t, custom.Increment("hits", MetricOptions{Count: 1}))
+	require.NoError(t, custom.Gauge("queue.size", 1, MetricOptions{Count: 1}))
+	qs = &EventQueueStats{}
+	qs.NumSentAdd(1)
+	qs.SetQueueLargest(2)
+	lines = scrape(qs, map[string]*RateCounts{RCRegular: {requested: 1}})
+	for _, line := range []string{
+		`sw_apm_ResponseTime_count{sw_is_error="false",sw_transaction="/users"} 2`,
+		`sw_apm_ResponseTime_sum{sw_is_error="false",sw_transaction="/users"} 6000`,
+		`sw_apm_TransactionResponseTime_histogram_count{TransactionName="/users"} 2`,
+		`sw_apm_TransactionResponseTime_histogram_bucket{TransactionName="/users",le="5000"} 2`,
+		"sw_apm_custom_hits_total 3",
+		"sw_apm_custom_queue_size 1",
+		"sw_apm_NumSent 6",
+		"sw_apm_QueueLargest 2",
+		"sw_apm_RequestCount 5",
+	} {
+		assert.Contains(t, lines, line)
+	}
+
+	// the totals aren't changed by the scrapes
+	lines = scrape(nil, nil)
+	assert.Contains(t, lines, "sw_apm_custom_hits_total 3")
+	assert.Contains(t, lines, "sw_apm_NumSent 5")
+}
+
+func TestPromTotalsCap(t *testing.T) {
+	tt := newPromTotals()
+	m := NewMeasurements(true, 2)
+	require.NoError(t, m.Increment("a", MetricOptions{Count: 1}))
+	require.NoError(t, m.Increment("b", MetricOptions{Count: 1}))
+	tt.addMeasurements(promCustomPrefix, m.CopyAndReset(60))
+
+	// the series beyond the cap across the flushes are dropped
+	require.NoError(t, m.Increment("a", MetricOptions{Count: 1}))
+	require.NoError(t, m.Increment("c", MetricOptions{Count: 1}))
+	tt.addMeasurements(promCustomPrefix, m.CopyAndReset(60))
+
+	ms, _ := tt.merge(promCustomPrefix, nil, nil)
+	counts := make(map[string]int)
+	for _, me := range ms {
+		counts[me.Name] = me.Count
+	}
+	assert.Equal(t, map[string]int{"a": 2, "b": 1}, counts)
+}
+
+func TestWritePrometheusCollects(t *testing.T) {
+	r := NewRegistry()
+	custom := r.CustomMetrics()
+	calls := 0
+	custom.OnCollect(func(into *Measurements) {
+		calls++
+		assert.Nil(t, into.Gauge("open.files", 3, MetricOptions{Count: 1}))
+	})
+
+	var buf bytes.Buffer
+	require.NoError(t, WritePrometheus(&buf, r, nil, nil))
+	assert.Contains(t, strings.Split(buf.String(), "\n"), "sw_apm_custom_open_files 3")
+	assert.Equal(t, 1, calls)
+
+	// the observation of the scrape isn't recorded into the measurements
+	ms, _ := custom.snapshot()
+	assert.Len(t, ms, 1)
+	assert.Equal(t, 2, calls)
+	assert.Empty(t, custom.m)
+
+	m := custom.CopyAndReset(60)
+	require.NotNil(t, m)
+	assert.Len(t, m.m, 1)
+	assert.Equal(t, 3, calls)
+}
+
+func TestWritePrometheusTypeClash(t *testing.T) {
+	r := NewRegistry()
+	custom := r.CustomMetrics()
+	require.NoError(t, custom.Summary("jobs", 2, MetricOptions{Count: 1}))
+	require.NoError(t, custom.Gauge("jobs", 5, MetricOptions{Count: 1}))
+	require.NoError(t, custom.Gauge("hits_total", 1, MetricOptions{Count: 1}))
+	require.NoError(t, custom.Increment("hits", MetricOptions{Count: 3}))
+
+	var buf bytes.Buffer
+	require.NoError(t, WritePrometheus(&buf, r, nil, nil))
+	lines := strings.Split(buf.String(), "\n")
+	for _, line := range []string{
+		"# TYPE sw_apm_custom_jobs gauge",
+		"sw_apm_custom_jobs 5",
+		"# TYPE sw_apm_custom_jobs_summary summary",
+		"sw_apm_custom_jobs_summary_sum 2",
+		"sw_apm_custom_jobs_summary_count 1",
+		"# TYPE sw_apm_custom_hits_total counter",
+		"sw_apm_custom_hits_total 3",
+		"# TYPE sw_apm_custom_hits_total_gauge gauge",
+		"sw_apm_custom_hits_total_gauge 1",
+	} {
+		assert.Contains(t, lines, line)
+	}
+
+	pw := &promWriter{families: make(map[string]*promFamily)}
+	pw.add("a", "gauge", "a 1")
+	pw.add("a_counter", "gauge", "a_counter 2")
+	pw.add("a", "counter", "a 3")
+	assert.Equal(t, []string{"a 1"}, pw.families["a"].samples)
+	assert.Equal(t, []string{"a_counter 2"}, pw.families["a_counter"].samples)
+}
+
+func TestPromName(t *testing.T) {
+	assert.Equal(t, "sw_transaction", promName("sw.transaction"))
+	assert.Equal(t, "_xx_y", promName("1xx-y"))
+	assert.Equal(t, "a:b_1", promName("a:b_1"))
+}
@@ -0,0 +1,106 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"github.com/solarwinds/apm-go/internal/config"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Registry is a set of the metrics collected between two flushes: the inbound
+// (APM) metrics, the custom metrics and the histograms. The package-level
+// metrics are used by the global reporter, while each standalone agent has
+// a registry of its own so they don't share the transaction names or caps.
+type Registry struct {
+	apm        *Measurements
+	custom     *Measurements
+	histograms *histograms
+	runtime    *runtimeSampler
+	host       *hostSampler
+	totals     *promTotals // the running totals of the metrics flushed, see WritePrometheus
+}
+
+// NewRegistry returns a registry with the default caps.
+func NewRegistry() *Registry {
+	return &Registry{
+		apm:    NewMeasurements(false, metricsTransactionsMaxDefault),
+		custom: NewMeasurements(true, metricsCustomMetricsMaxDefault),
+		histograms: &histograms{
+			histograms: make(map[string]*histogram),
+			precision:  apmHistograms.precision,
+		},
+		runtime: newRuntimeSampler(),
+		host:    &hostSampler{},
+		totals:  newPromTotals(),
+	}
+}
+
+// the registry backed by the package-level metrics
+var defaultRegistry = &Registry{
+	apm:        ApmMetrics,
+	custom:     CustomMetrics,
+	histograms: apmHistograms,
+	runtime:    apmRuntime,
+	host:       apmHost,
+	totals:     newPromTotals(),
+}
+
+// DefaultRegistry returns the registry backed by the package-level metrics.
+func DefaultRegistry() *Registry {
+	return defaultRegistry
+}
+
+// ApmMetrics returns the inbound metrics of the registry.
+func (r *Registry) ApmMetrics() *Measurements {
+	return r.apm
+}
+
+// CustomMetrics returns the custom metrics of the registry.
+func (r *Registry) CustomMetrics() *Measurements {
+	return r.custom
+}
+
+// RecordSpan records the inbound metrics of an entry span.
+func (r *Registry) RecordSpan(span sdktrace.ReadOnlySpan, isAppoptics bool) {
+	recordSpan(span, isAppoptics, r.apm, r.histograms)
+}
+
+// RecordOutboundSpan records the outbound metrics of a client or producer span.
+func (r *Registry) RecordOutboundSpan(span sdktrace.ReadOnlySpan) {
+	recordOutboundSpan(span, r.apm, r.histograms)
+}
+
+// BuildBuiltinMetricsMessage generates the builtin metrics message from the
+// measurements m, which are usually copied from ApmMetrics(), and the
+// histograms of the registry. The histograms are cleared afterwards, and the
+// runtime histograms hold the values recorded since the previous message.
+// The measurements, the histograms and the counters of qs and rcs are added to
+// the running totals served by WritePrometheus.
+func (r *Registry) BuildBuiltinMetricsMessage(m *Measurements, qs *EventQueueStats,
+	rcs map[string]*RateCounts, runtimeMetrics bool, host config.HostMetricsGroups) []byte {
+	r.totals.addCounters(append(requestCounters(rcs), qs.values()...))
+	r.totals.addMeasurements(promPrefix, m)
+	return buildBuiltinMetricsMessage(m, r, qs, rcs, runtimeMetrics, host)
+}
+
+// BuildCustomMetricsMessage generates the custom metrics message from the
+// custom metrics of the registry, which are reset, and adds them to the
+// running totals served by WritePrometheus. It returns nil if there are no
+// custom metrics.
+func (r *Registry) BuildCustomMetricsMessage(flushInterval int32) []byte {
+	m := r.custom.CopyAndReset(flushInterval)
+	r.totals.addMeasurements(promCustomPrefix, m)
+	return BuildMessage(m, false)
+}
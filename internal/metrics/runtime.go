@@ -0,0 +1,239 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"math"
+	"runtime"
+	"runtime/debug"
+	rtmetrics "runtime/metrics"
+	"sync"
+
+	"github.com/solarwinds/apm-go/internal/bson"
+)
+
+// The runtime metrics read by the agent. Unlike runtime.ReadMemStats, reading
+// them doesn't stop the world.
+const (
+	rtGoroutines    = "/sched/goroutines:goroutines"
+	rtGomaxprocs    = "/sched/gomaxprocs:threads"
+	rtSchedLatency  = "/sched/latencies:seconds"
+	rtGCPauses      = "/sched/pauses/total/gc:seconds"
+	rtGCPausesOld   = "/gc/pauses:seconds" // before Go 1.22
+	rtAllocsBySize  = "/gc/heap/allocs-by-size:bytes"
+	rtMutexWait     = "/sync/mutex/wait/total:seconds"
+	rtCgoCalls      = "/cgo/go-to-c-calls:calls"
+	rtGCCycles      = "/gc/cycles/total:gc-cycles"
+	rtGCForced      = "/gc/cycles/forced:gc-cycles"
+	rtGCGoal        = "/gc/heap/goal:bytes"
+	rtGCCPU         = "/cpu/classes/gc/total:cpu-seconds"
+	rtTotalCPU      = "/cpu/classes/total:cpu-seconds"
+	rtAllocBytes    = "/gc/heap/allocs:bytes"
+	rtAllocObjects  = "/gc/heap/allocs:objects"
+	rtFreeObjects   = "/gc/heap/frees:objects"
+	rtHeapObjects   = "/gc/heap/objects:objects"
+	rtMemTotal      = "/memory/classes/total:bytes"
+	rtHeapInObjects = "/memory/classes/heap/objects:bytes"
+	rtHeapUnused    = "/memory/classes/heap/unused:bytes"
+	rtHeapFree      = "/memory/classes/heap/free:bytes"
+	rtHeapReleased  = "/memory/classes/heap/released:bytes"
+	rtHeapStacks    = "/memory/classes/heap/stacks:bytes"
+	rtOSStacks      = "/memory/classes/os-stacks:bytes"
+)
+
+// the histograms of the runtime reported in the histograms array, and the
+// factor converting their buckets to the unit of the reported histogram.
+var runtimeHistograms = []struct {
+	name   string // the name of the reported histogram
+	metric string // the runtime metric
+	scale  float64
+}{
+	{"trace.go.sched.Latency", rtSchedLatency, 1e6}, // microseconds
+	{"trace.go.gc.Pause", rtGCPauses, 1e6},          // microseconds
+	{"trace.go.memory.AllocSize", rtAllocsBySize, 1},
+}
+
+var apmRuntime = newRuntimeSampler()
+
+// runtimeSampler reads the runtime metrics. The histograms of the runtime are
+// cumulative, so it keeps the counts of the last read to report the values
+// recorded since then.
+type runtimeSampler struct {
+	samples []rtmetrics.Sample
+	index   map[string]int      // the index of a metric in samples
+	prev    map[string][]uint64 // the counts of the histograms at the last read
+	lock    sync.Mutex
+}
+
+func newRuntimeSampler() *runtimeSampler {
+	supported := make(map[string]bool)
+	for _, d := range rtmetrics.All() {
+		supported[d.Name] = true
+	}
+	names := []string{rtGoroutines, rtGomaxprocs, rtSchedLatency, rtGCPauses,
+		rtAllocsBySize, rtMutexWait, rtCgoCalls, rtGCCycles, rtGCForced, rtGCGoal,
+		rtGCCPU, rtTotalCPU, rtAllocBytes, rtAllocObjects, rtFreeObjects,
+		rtHeapObjects, rtMemTotal, rtHeapInObjects, rtHeapUnused, rtHeapFree,
+		rtHeapReleased, rtHeapStacks, rtOSStacks}
+
+	s := &runtimeSampler{
+		index: make(map[string]int),
+		prev:  make(map[string][]uint64),
+	}
+	for _, name := range names {
+		metric := name
+		if name == rtGCPauses && !supported[name] {
+			metric = rtGCPausesOld
+		}
+		s.index[name] = len(s.samples)
+		s.samples = append(s.samples, rtmetrics.Sample{Name: metric})
+	}
+	return s
+}
+
+// read reads the current values of the runtime metrics.
+func (s *runtimeSampler) read() {
+	rtmetrics.Read(s.samples)
+}
+
+// uint64 returns the value of a counter or a gauge, or zero if the runtime
+// doesn't support it.
+func (s *runtimeSampler) uint64(name string) uint64 {
+	v := s.samples[s.index[name]].Value
+	if v.Kind() != rtmetrics.KindUint64 {
+		return 0
+	}
+	return v.Uint64()
+}
+
+// float64 is the same as uint64 for the float64 metrics.
+func (s *runtimeSampler) float64(name string) float64 {
+	v := s.samples[s.index[name]].Value
+	if v.Kind() != rtmetrics.KindFloat64 {
+		return 0
+	}
+	return v.Float64()
+}
+
+// histograms converts the runtime histograms into HDR histograms holding the
+// values recorded since the last call.
+func (s *runtimeSampler) histograms(precision int) []*histogram {
+	var hs []*histogram
+	for _, rh := range runtimeHistograms {
+		v := s.samples[s.index[rh.metric]].Value
+		if v.Kind() != rtmetrics.KindFloat64Histogram {
+			continue
+		}
+		fh := v.Float64Histogram()
+		prev := s.prev[rh.metric]
+		if len(prev) != len(fh.Counts) {
+			prev = nil
+		}
+
+		h := &histogram{name: rh.name, hist: newHist(precision)}
+		for i, count := range fh.Counts {
+			if prev != nil {
+				count -= prev[i]
+			}
+			if count == 0 {
+				continue
+			}
+			h.hist.RecordN(bucketValue(fh.Buckets[i], fh.Buckets[i+1], rh.scale), int64(count))
+		}
+		s.prev[rh.metric] = append(prev[:0:0], fh.Counts...)
+		hs = append(hs, h)
+	}
+	return hs
+}
+
+// bucketValue returns the value recorded for the bucket [lower, upper): its
+// midpoint, or the finite bound of an unbounded bucket, clamped to the range
+// of the histograms.
+func bucketValue(lower, upper, scale float64) int64 {
+	var v float64
+	switch {
+	case math.IsInf(lower, -1):
+		v = upper
+	case math.IsInf(upper, 1):
+		v = lower
+	default:
+		v = (lower + upper) / 2
+	}
+	v = math.Round(v * scale)
+	if v < 0 || math.IsNaN(v) {
+		return 0
+	}
+	if v > histogramHighestTrackable {
+		return histogramHighestTrackable
+	}
+	return int64(v)
+}
+
+// addRuntimeMetrics appends the runtime metrics to the measurements and returns
+// the runtime histograms, which are appended to the histograms.
+func addRuntimeMetrics(bbuf *bson.Buffer, index *int, s *runtimeSampler, precision int) []*histogram {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.read()
+
+	// category runtime
+	addMetricsValue(bbuf, index, "trace.go.runtime.NumGoroutine", int(s.uint64(rtGoroutines)))
+	addMetricsValue(bbuf, index, "trace.go.runtime.NumCgoCall", runtime.NumCgoCall())
+	addMetricsValue(bbuf, index, "trace.go.runtime.GOMAXPROCS", int64(s.uint64(rtGomaxprocs)))
+
+	// category gc
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+	var cpuFraction float64
+	if total := s.float64(rtTotalCPU); total > 0 {
+		cpuFraction = s.float64(rtGCCPU) / total
+	}
+	var lastGC int64
+	if !gc.LastGC.IsZero() {
+		lastGC = gc.LastGC.UnixNano()
+	}
+	addMetricsValue(bbuf, index, "trace.go.gc.LastGC", lastGC)
+	addMetricsValue(bbuf, index, "trace.go.gc.NextGC", int64(s.uint64(rtGCGoal)))
+	addMetricsValue(bbuf, index, "trace.go.gc.PauseTotalNs", int64(gc.PauseTotal))
+	addMetricsValue(bbuf, index, "trace.go.gc.NumGC", int64(s.uint64(rtGCCycles)))
+	addMetricsValue(bbuf, index, "trace.go.gc.NumForcedGC", int64(s.uint64(rtGCForced)))
+	addMetricsValue(bbuf, index, "trace.go.gc.GCCPUFraction", cpuFraction)
+
+	// category memory, see the runtime/metrics equivalents of runtime.MemStats
+	heapObjects := s.uint64(rtHeapInObjects)
+	heapUnused := s.uint64(rtHeapUnused)
+	heapFree := s.uint64(rtHeapFree)
+	heapReleased := s.uint64(rtHeapReleased)
+	heapStacks := s.uint64(rtHeapStacks)
+	addMetricsValue(bbuf, index, "trace.go.memory.Alloc", int64(heapObjects))
+	addMetricsValue(bbuf, index, "trace.go.memory.TotalAlloc", int64(s.uint64(rtAllocBytes)))
+	addMetricsValue(bbuf, index, "trace.go.memory.Sys", int64(s.uint64(rtMemTotal)))
+	addMetricsValue(bbuf, index, "trace.go.memory.Lookups", int64(0)) // no longer counted by the runtime
+	addMetricsValue(bbuf, index, "trace.go.memory.Mallocs", int64(s.uint64(rtAllocObjects)))
+	addMetricsValue(bbuf, index, "trace.go.memory.Frees", int64(s.uint64(rtFreeObjects)))
+	addMetricsValue(bbuf, index, "trace.go.memory.HeapAlloc", int64(heapObjects))
+	addMetricsValue(bbuf, index, "trace.go.memory.HeapSys", int64(heapObjects+heapUnused+heapFree+heapReleased))
+	addMetricsValue(bbuf, index, "trace.go.memory.HeapIdle", int64(heapFree+heapReleased))
+	addMetricsValue(bbuf, index, "trace.go.memory.HeapInuse", int64(heapObjects+heapUnused))
+	addMetricsValue(bbuf, index, "trace.go.memory.HeapReleased", int64(heapReleased))
+	addMetricsValue(bbuf, index, "trace.go.memory.HeapObjects", int64(s.uint64(rtHeapObjects)))
+	addMetricsValue(bbuf, index, "trace.go.memory.StackInuse", int64(heapStacks))
+	addMetricsValue(bbuf, index, "trace.go.memory.StackSys", int64(heapStacks+s.uint64(rtOSStacks)))
+
+	// category sync
+	addMetricsValue(bbuf, index, "trace.go.sync.MutexWaitTotalNs", int64(s.float64(rtMutexWait)*1e9))
+
+	return s.histograms(precision)
+}
@@ -0,0 +1,56 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"math"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBucketValue(t *testing.T) {
+	assert.EqualValues(t, 150, bucketValue(100, 200, 1))
+	assert.EqualValues(t, 1500, bucketValue(0.001, 0.002, 1e6))
+	assert.EqualValues(t, 200, bucketValue(math.Inf(-1), 200, 1))
+	assert.EqualValues(t, 100, bucketValue(100, math.Inf(1), 1))
+	assert.EqualValues(t, 0, bucketValue(-2, -1, 1))
+	assert.EqualValues(t, histogramHighestTrackable, bucketValue(7200, math.Inf(1), 1e6))
+}
+
+func TestRuntimeSamplerHistograms(t *testing.T) {
+	s := newRuntimeSampler()
+	runtime.GC()
+	s.read()
+
+	hs := s.histograms(metricsHistPrecisionDefault)
+	assert.Len(t, hs, len(runtimeHistograms))
+	for i, h := range hs {
+		assert.Equal(t, runtimeHistograms[i].name, h.name)
+	}
+	assert.Positive(t, hs[1].hist.TotalCount(), "gc pauses")
+	assert.Positive(t, hs[2].hist.TotalCount(), "allocations")
+
+	// only the values recorded since the last read are reported
+	for _, h := range s.histograms(metricsHistPrecisionDefault) {
+		assert.Zero(t, h.hist.TotalCount(), h.name)
+	}
+
+	runtime.GC()
+	s.read()
+	hs = s.histograms(metricsHistPrecisionDefault)
+	assert.Positive(t, hs[1].hist.TotalCount(), "gc pauses")
+}
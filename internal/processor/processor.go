@@ -28,12 +28,23 @@ func NewInboundMetricsSpanProcessor(isAppoptics bool) sdktrace.SpanProcessor {
 	}
 }
 
+// NewInboundMetricsSpanProcessorWithRegistry returns a processor which records
+// the inbound metrics into the registry instead of the package-level metrics.
+func NewInboundMetricsSpanProcessorWithRegistry(registry *metrics.Registry, isAppoptics bool) sdktrace.SpanProcessor {
+	return &inboundMetricsSpanProcessor{
+		isAppoptics: isAppoptics,
+		registry:    registry,
+	}
+}
+
 var _ sdktrace.SpanProcessor = &inboundMetricsSpanProcessor{}
 
 var recordFunc = metrics.RecordSpan
 
 type inboundMetricsSpanProcessor struct {
 	isAppoptics bool
+	// the metrics of a standalone agent, or nil for the package-level metrics
+	registry *metrics.Registry
 }
 
 func (s *inboundMetricsSpanProcessor) OnStart(_ context.Context, span sdktrace.ReadWriteSpan) {
@@ -62,7 +73,11 @@ func maybeClearEntrySpan(span sdktrace.ReadOnlySpan) {
 
 func (s *inboundMetricsSpanProcessor) OnEnd(span sdktrace.ReadOnlySpan) {
 	if entryspans.IsEntrySpan(span) {
-		recordFunc(span, s.isAppoptics)
+		if s.registry != nil {
+			s.registry.RecordSpan(span, s.isAppoptics)
+		} else {
+			recordFunc(span, s.isAppoptics)
+		}
 		maybeClearEntrySpan(span)
 	}
 }
@@ -74,3 +89,47 @@ func (s *inboundMetricsSpanProcessor) Shutdown(context.Context) error {
 func (s *inboundMetricsSpanProcessor) ForceFlush(context.Context) error {
 	return nil
 }
+
+// NewOutboundMetricsSpanProcessor returns a processor which records the
+// outbound metrics of the client and producer spans, i.e., the response time of
+// the calls to the databases, caches and downstream services. The sampler
+// records such spans of the unsampled traces too, see sampler.ShouldSample.
+func NewOutboundMetricsSpanProcessor() sdktrace.SpanProcessor {
+	return &outboundMetricsSpanProcessor{}
+}
+
+// NewOutboundMetricsSpanProcessorWithRegistry returns a processor which records
+// the outbound metrics into the registry instead of the package-level metrics.
+func NewOutboundMetricsSpanProcessorWithRegistry(registry *metrics.Registry) sdktrace.SpanProcessor {
+	return &outboundMetricsSpanProcessor{registry: registry}
+}
+
+var _ sdktrace.SpanProcessor = &outboundMetricsSpanProcessor{}
+
+var recordOutboundFunc = metrics.RecordOutboundSpan
+
+type outboundMetricsSpanProcessor struct {
+	// the metrics of a standalone agent, or nil for the package-level metrics
+	registry *metrics.Registry
+}
+
+func (s *outboundMetricsSpanProcessor) OnStart(context.Context, sdktrace.ReadWriteSpan) {}
+
+func (s *outboundMetricsSpanProcessor) OnEnd(span sdktrace.ReadOnlySpan) {
+	if !metrics.IsOutboundSpan(span) {
+		return
+	}
+	if s.registry != nil {
+		s.registry.RecordOutboundSpan(span)
+	} else {
+		recordOutboundFunc(span)
+	}
+}
+
+func (s *outboundMetricsSpanProcessor) Shutdown(context.Context) error {
+	return nil
+}
+
+func (s *outboundMetricsSpanProcessor) ForceFlush(context.Context) error {
+	return nil
+}
@@ -16,10 +16,12 @@ package processor
 
 import (
 	"context"
+	"github.com/solarwinds/apm-go/internal/config"
 	"github.com/solarwinds/apm-go/internal/entryspans"
 	"github.com/solarwinds/apm-go/internal/metrics"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace"
 	"testing"
@@ -164,3 +166,71 @@ func TestInboundMetricsSpanProcessorOnEndWithRemoteParent(t *testing.T) {
 
 	assert.True(t, mock.called)
 }
+
+func TestInboundMetricsSpanProcessorWithRegistry(t *testing.T) {
+	mock := &recordMock{}
+	recordFunc = func(span sdktrace.ReadOnlySpan, isAppoptics bool) {
+		mock.called = true
+	}
+	defer func() {
+		recordFunc = metrics.RecordSpan
+	}()
+	registry := metrics.NewRegistry()
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(NewInboundMetricsSpanProcessorWithRegistry(registry, false)),
+		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+	)
+	_, s := tp.Tracer("foo").Start(context.Background(), "span name")
+	s.End()
+
+	// the package-level metrics are not touched
+	assert.False(t, mock.called)
+	msg := registry.BuildBuiltinMetricsMessage(registry.ApmMetrics().CopyAndReset(60), nil, nil, false, config.HostMetricsGroups{})
+	assert.Contains(t, string(msg), "ResponseTime")
+	assert.Contains(t, string(msg), "span name")
+}
+
+func TestOutboundMetricsSpanProcessorOnEnd(t *testing.T) {
+	var recorded []string
+	recordOutboundFunc = func(span sdktrace.ReadOnlySpan) {
+		recorded = append(recorded, span.Name())
+	}
+	defer func() {
+		recordOutboundFunc = metrics.RecordOutboundSpan
+	}()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(NewOutboundMetricsSpanProcessor()))
+	tracer := tp.Tracer("foo")
+	ctx, s := tracer.Start(context.Background(), "server", trace.WithSpanKind(trace.SpanKindServer))
+	for _, kind := range []trace.SpanKind{trace.SpanKindClient, trace.SpanKindProducer,
+		trace.SpanKindConsumer, trace.SpanKindInternal} {
+		_, child := tracer.Start(ctx, kind.String(), trace.WithSpanKind(kind))
+		child.End()
+	}
+	s.End()
+
+	assert.Equal(t, []string{"client", "producer"}, recorded)
+}
+
+func TestOutboundMetricsSpanProcessorWithRegistry(t *testing.T) {
+	called := false
+	recordOutboundFunc = func(span sdktrace.ReadOnlySpan) {
+		called = true
+	}
+	defer func() {
+		recordOutboundFunc = metrics.RecordOutboundSpan
+	}()
+	registry := metrics.NewRegistry()
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(NewOutboundMetricsSpanProcessorWithRegistry(registry)),
+	)
+	_, s := tp.Tracer("foo").Start(context.Background(), "SELECT", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "postgresql"), attribute.String("peer.service", "users-db")))
+	s.End()
+
+	// the package-level metrics are not touched
+	assert.False(t, called)
+	msg := registry.BuildBuiltinMetricsMessage(registry.ApmMetrics().CopyAndReset(60), nil, nil, false, config.HostMetricsGroups{})
+	assert.Contains(t, string(msg), "OutboundResponseTime")
+	assert.Contains(t, string(msg), "users-db")
+	assert.Contains(t, string(msg), "postgresql")
+}
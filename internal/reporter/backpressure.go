@@ -0,0 +1,248 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"github.com/solarwinds/apm-go/internal/config"
+	"github.com/solarwinds/apm-go/internal/log"
+	"github.com/solarwinds/apm-go/internal/metrics"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+	"time"
+)
+
+// the maximum number of dropped traces remembered by the drop-trace policy
+const droppedTracesMax = 10000
+
+var (
+	errEventQueueFull = errors.New("event message queue is full")
+	errTraceDropped   = errors.New("the trace of the event has been dropped")
+)
+
+// eventQueuePolicy decides what to do when the event message queue is full.
+type eventQueuePolicy interface {
+	// enqueue puts the message of an event into the queue, dropping it or
+	// other messages if necessary. It returns an error if the message is
+	// dropped.
+	enqueue(q chan []byte, tid trace.TraceID, msg []byte) error
+}
+
+// newEventQueuePolicy returns the policy by its name. The stats are updated
+// by the policy for the messages dropped or delayed, and the done channel
+// unblocks the policy when the reporter is closed.
+func newEventQueuePolicy(name string, blockTimeout time.Duration,
+	stats *metrics.EventQueueStats, done chan struct{}) eventQueuePolicy {
+	switch name {
+	case config.EventQueueDropOldest:
+		return &dropOldestPolicy{stats: stats}
+	case config.EventQueueBlock:
+		return &blockPolicy{stats: stats, timeout: blockTimeout, done: done}
+	case config.EventQueueDropTrace:
+		return &dropTracePolicy{stats: stats, dropped: newTraceSet(droppedTracesMax)}
+	case config.EventQueueDropNewest:
+	default:
+		log.Warningf("Unknown event queue policy %s, using %s.", name, config.EventQueueDropNewest)
+	}
+	return &dropNewestPolicy{stats: stats}
+}
+
+// dropNewestPolicy drops the event being reported.
+type dropNewestPolicy struct {
+	stats *metrics.EventQueueStats
+}
+
+func (p *dropNewestPolicy) enqueue(q chan []byte, _ trace.TraceID, msg []byte) error {
+	select {
+	case q <- msg:
+		return nil
+	default:
+		p.stats.NumOverflowedAdd(1)
+		return errEventQueueFull
+	}
+}
+
+// dropOldestPolicy drops the oldest events in the queue to make room for the
+// event being reported.
+type dropOldestPolicy struct {
+	stats *metrics.EventQueueStats
+}
+
+func (p *dropOldestPolicy) enqueue(q chan []byte, _ trace.TraceID, msg []byte) error {
+	for {
+		select {
+		case q <- msg:
+			return nil
+		default:
+		}
+		// The room may be taken by others right after it is made, so try
+		// again until the message is queued.
+		select {
+		case <-q:
+			p.stats.NumOverflowedAdd(1)
+			p.stats.NumDroppedOldestAdd(1)
+		default:
+		}
+	}
+}
+
+// blockPolicy waits for room in the queue until the timeout, and drops the
+// event being reported after that.
+type blockPolicy struct {
+	stats   *metrics.EventQueueStats
+	timeout time.Duration
+	done    chan struct{}
+}
+
+func (p *blockPolicy) enqueue(q chan []byte, _ trace.TraceID, msg []byte) error {
+	select {
+	case q <- msg:
+		return nil
+	default:
+	}
+
+	p.stats.NumBlockedAdd(1)
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+
+	select {
+	case q <- msg:
+		return nil
+	case <-timer.C:
+	case <-p.done:
+	}
+	p.stats.NumOverflowedAdd(1)
+	return errEventQueueFull
+}
+
+// dropTracePolicy drops the event being reported and the events of the same
+// trace which are still in the queue, and also drops all the events of the
+// trace reported after it, so that the collector doesn't receive a truncated
+// trace. The events already taken from the queue by the sender can't be
+// recalled, so a trace is only guaranteed to be complete or dropped if it
+// overflows while its events are queued.
+type dropTracePolicy struct {
+	stats   *metrics.EventQueueStats
+	dropped *traceSet
+	// serializes the producers, so the queue is not refilled by the others
+	// while the events of an overflowed trace are purged
+	mut sync.Mutex
+}
+
+func (p *dropTracePolicy) enqueue(q chan []byte, tid trace.TraceID, msg []byte) error {
+	if p.dropped.Contains(tid) {
+		p.stats.NumTraceDroppedAdd(1)
+		return errTraceDropped
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	select {
+	case q <- msg:
+		return nil
+	default:
+		p.dropped.Add(tid)
+		p.stats.NumOverflowedAdd(1)
+		p.stats.NumTraceDroppedAdd(int64(p.purge(q, tid)))
+		return errEventQueueFull
+	}
+}
+
+// purge removes the queued events of the trace and puts the others back in
+// their order. It returns the number of events removed. The caller must hold
+// the lock.
+func (p *dropTracePolicy) purge(q chan []byte, tid trace.TraceID) int {
+	var kept [][]byte
+	purged := 0
+	for n := len(q); n > 0; n-- {
+		var msg []byte
+		select {
+		case msg = <-q:
+		default:
+			// taken by the sender
+		}
+		if msg == nil {
+			break
+		}
+		if id, ok := eventTraceID(msg); ok && id == tid {
+			purged++
+		} else {
+			kept = append(kept, msg)
+		}
+	}
+	for _, msg := range kept {
+		select {
+		case q <- msg:
+		default:
+			// unlikely as the producers are serialized
+			p.stats.NumOverflowedAdd(1)
+		}
+	}
+	return purged
+}
+
+// eventTraceKey is the first element of an event message, see event.ToBson
+var eventTraceKey = []byte("\x02sw.trace_context\x00")
+
+// eventTraceID returns the trace ID of an event message from its first element,
+// the W3C trace context, e.g., 00-<trace id>-<span id>-01.
+func eventTraceID(msg []byte) (trace.TraceID, bool) {
+	// the document length, the element key and the string length
+	start := 4 + len(eventTraceKey) + 4
+	if len(msg) < start+3+32 || !bytes.Equal(msg[4:4+len(eventTraceKey)], eventTraceKey) {
+		return trace.TraceID{}, false
+	}
+	tid, err := trace.TraceIDFromHex(string(msg[start+3 : start+3+32]))
+	return tid, err == nil
+}
+
+// traceSet is a concurrent-safe set of trace IDs with a limited capacity. The
+// earliest added trace ID is evicted when the capacity is reached.
+type traceSet struct {
+	mut  sync.Mutex
+	ids  map[trace.TraceID]struct{}
+	ring []trace.TraceID
+	next int
+}
+
+func newTraceSet(cap int) *traceSet {
+	return &traceSet{
+		ids:  make(map[trace.TraceID]struct{}, cap),
+		ring: make([]trace.TraceID, cap),
+	}
+}
+
+// Add adds the trace ID to the set.
+func (s *traceSet) Add(tid trace.TraceID) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if _, ok := s.ids[tid]; ok {
+		return
+	}
+	if old := s.ring[s.next]; old.IsValid() {
+		delete(s.ids, old)
+	}
+	s.ring[s.next] = tid
+	s.next = (s.next + 1) % len(s.ring)
+	s.ids[tid] = struct{}{}
+}
+
+// Contains checks if the trace ID is in the set.
+func (s *traceSet) Contains(tid trace.TraceID) bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	_, ok := s.ids[tid]
+	return ok
+}
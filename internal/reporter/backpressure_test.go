@@ -0,0 +1,242 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter
+
+import (
+	"github.com/solarwinds/apm-go/internal/config"
+	"github.com/solarwinds/apm-go/internal/metrics"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	mbson "gopkg.in/mgo.v2/bson"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newQueueTestReporter returns a reporter with a small event queue and no
+// senders draining it.
+func newQueueTestReporter(policy string, blockTimeout time.Duration) *grpcReporter {
+	r := &grpcReporter{
+		conn:          &grpcConnection{queueStats: &metrics.EventQueueStats{}},
+		eventMessages: make(chan []byte, 4),
+		done:          make(chan struct{}),
+	}
+	r.eventQueuePolicy = newEventQueuePolicy(policy, blockTimeout, r.conn.queueStats, r.done)
+	return r
+}
+
+func traceEvent(tid byte, layer string) Event {
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{tid},
+		SpanID:     trace.SpanID{0x01},
+		TraceFlags: trace.FlagsSampled,
+	})
+	e := CreateInfoEvent(sc, time.Now())
+	e.SetLayer(layer)
+	return e
+}
+
+func decodeEvent(t *testing.T, b []byte) mbson.M {
+	m := mbson.M{}
+	require.NoError(t, mbson.Unmarshal(b, m))
+	return m
+}
+
+func queuedLayers(t *testing.T, q chan []byte) []string {
+	var layers []string
+	for {
+		select {
+		case m := <-q:
+			layers = append(layers, decodeEvent(t, m)["Layer"].(string))
+		default:
+			return layers
+		}
+	}
+}
+
+func TestDropNewestPolicy(t *testing.T) {
+	r := newQueueTestReporter(config.EventQueueDropNewest, 0)
+	for i := 0; i < 10; i++ {
+		err := r.ReportEvent(traceEvent(byte(i), string(rune('a'+i))))
+		if i < 4 {
+			require.NoError(t, err)
+		} else {
+			require.Equal(t, errEventQueueFull, err)
+		}
+	}
+	require.Equal(t, []string{"a", "b", "c", "d"}, queuedLayers(t, r.eventMessages))
+	require.EqualValues(t, 4, r.conn.queueStats.TotalEvents())
+	require.EqualValues(t, 6, r.conn.queueStats.NumOverflowed())
+}
+
+func TestDropOldestPolicy(t *testing.T) {
+	r := newQueueTestReporter(config.EventQueueDropOldest, 0)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, r.ReportEvent(traceEvent(byte(i), string(rune('a'+i)))))
+	}
+	require.Equal(t, []string{"g", "h", "i", "j"}, queuedLayers(t, r.eventMessages))
+	require.EqualValues(t, 10, r.conn.queueStats.TotalEvents())
+	require.EqualValues(t, 6, r.conn.queueStats.NumOverflowed())
+	require.EqualValues(t, 6, r.conn.queueStats.NumDroppedOldest())
+}
+
+func TestBlockPolicy(t *testing.T) {
+	r := newQueueTestReporter(config.EventQueueBlock, time.Second)
+	for i := 0; i < 4; i++ {
+		require.NoError(t, r.ReportEvent(traceEvent(byte(i), "fill")))
+	}
+
+	// a slow consumer makes room for the blocked events
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 4; i++ {
+			time.Sleep(10 * time.Millisecond)
+			<-r.eventMessages
+		}
+	}()
+	for i := 0; i < 4; i++ {
+		require.NoError(t, r.ReportEvent(traceEvent(byte(i), "blocked")))
+	}
+	wg.Wait()
+	require.Equal(t, []string{"blocked", "blocked", "blocked", "blocked"}, queuedLayers(t, r.eventMessages))
+	require.EqualValues(t, 8, r.conn.queueStats.TotalEvents())
+	require.EqualValues(t, 4, r.conn.queueStats.NumBlocked())
+	require.EqualValues(t, 0, r.conn.queueStats.NumOverflowed())
+
+	// the events are dropped after the timeout
+	r = newQueueTestReporter(config.EventQueueBlock, 20*time.Millisecond)
+	for i := 0; i < 4; i++ {
+		require.NoError(t, r.ReportEvent(traceEvent(byte(i), "fill")))
+	}
+	start := time.Now()
+	require.Equal(t, errEventQueueFull, r.ReportEvent(traceEvent(5, "timeout")))
+	require.True(t, time.Since(start) >= 20*time.Millisecond)
+	require.EqualValues(t, 1, r.conn.queueStats.NumBlocked())
+	require.EqualValues(t, 1, r.conn.queueStats.NumOverflowed())
+
+	// the reporter being closed unblocks the policy
+	close(r.done)
+	require.Equal(t, errEventQueueFull, r.ReportEvent(traceEvent(5, "closed")))
+}
+
+func TestDropTracePolicy(t *testing.T) {
+	r := newQueueTestReporter(config.EventQueueDropTrace, 0)
+
+	// trace 1 fills the queue, then the entry of trace 2 is dropped
+	for i := 0; i < 4; i++ {
+		require.NoError(t, r.ReportEvent(traceEvent(1, "t1")))
+	}
+	require.Equal(t, errEventQueueFull, r.ReportEvent(traceEvent(2, "t2-entry")))
+
+	// the following events of trace 2 are dropped even if there is room
+	<-r.eventMessages
+	<-r.eventMessages
+	require.Equal(t, errTraceDropped, r.ReportEvent(traceEvent(2, "t2-info")))
+	require.Equal(t, errTraceDropped, r.ReportEvent(traceEvent(2, "t2-exit")))
+
+	// other traces are not affected
+	require.NoError(t, r.ReportEvent(traceEvent(3, "t3")))
+	require.NoError(t, r.ReportEvent(traceEvent(1, "t1")))
+
+	require.Equal(t, []string{"t1", "t1", "t3", "t1"}, queuedLayers(t, r.eventMessages))
+	require.EqualValues(t, 6, r.conn.queueStats.TotalEvents())
+	require.EqualValues(t, 1, r.conn.queueStats.NumOverflowed())
+	require.EqualValues(t, 2, r.conn.queueStats.NumTraceDropped())
+}
+
+func TestDropTracePolicyPurgesQueuedEvents(t *testing.T) {
+	r := newQueueTestReporter(config.EventQueueDropTrace, 0)
+
+	// trace 2 is partially queued when it overflows
+	require.NoError(t, r.ReportEvent(traceEvent(1, "t1")))
+	require.NoError(t, r.ReportEvent(traceEvent(2, "t2-entry")))
+	require.NoError(t, r.ReportEvent(traceEvent(3, "t3")))
+	require.NoError(t, r.ReportEvent(traceEvent(2, "t2-info")))
+	require.Equal(t, errEventQueueFull, r.ReportEvent(traceEvent(2, "t2-exit")))
+
+	// the queued events of trace 2 are purged, the others keep their order
+	require.Equal(t, []string{"t1", "t3"}, queuedLayers(t, r.eventMessages))
+	require.EqualValues(t, 1, r.conn.queueStats.NumOverflowed())
+	require.EqualValues(t, 2, r.conn.queueStats.NumTraceDropped())
+
+	// and the following ones are dropped
+	require.Equal(t, errTraceDropped, r.ReportEvent(traceEvent(2, "t2-late")))
+	require.Empty(t, queuedLayers(t, r.eventMessages))
+}
+
+func TestEventTraceID(t *testing.T) {
+	e := traceEvent(7, "layer")
+	tid, ok := eventTraceID(e.ToBson())
+	require.True(t, ok)
+	require.Equal(t, trace.TraceID{7}, tid)
+
+	_, ok = eventTraceID([]byte{5, 0, 0, 0, 0})
+	require.False(t, ok)
+}
+
+func TestEventQueuePolicyUnderLoad(t *testing.T) {
+	for _, policy := range []string{
+		config.EventQueueDropNewest,
+		config.EventQueueDropOldest,
+		config.EventQueueBlock,
+		config.EventQueueDropTrace,
+	} {
+		t.Run(policy, func(t *testing.T) {
+			r := newQueueTestReporter(policy, time.Millisecond)
+
+			stop := make(chan struct{})
+			consumed := make(chan int)
+			go func() {
+				n := 0
+				for {
+					select {
+					case <-r.eventMessages:
+						n++
+					case <-stop:
+						consumed <- n + len(r.eventMessages)
+						return
+					}
+				}
+			}()
+
+			var wg sync.WaitGroup
+			var mut sync.Mutex
+			var accepted int64
+			for p := 0; p < 8; p++ {
+				wg.Add(1)
+				go func(p int) {
+					defer wg.Done()
+					for i := 0; i < 500; i++ {
+						if r.ReportEvent(traceEvent(byte(p*16+i%16), "load")) == nil {
+							mut.Lock()
+							accepted++
+							mut.Unlock()
+						}
+					}
+				}(p)
+			}
+			wg.Wait()
+			close(stop)
+			n := <-consumed
+
+			stats := r.conn.queueStats
+			require.Equal(t, accepted, stats.TotalEvents())
+			// every event is either delivered or counted as dropped
+			require.EqualValues(t, 8*500, int64(n)+stats.NumOverflowed()+stats.NumTraceDropped())
+		})
+	}
+}
@@ -0,0 +1,177 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/solarwinds/apm-go/internal/log"
+)
+
+const (
+	budgetWindow = 24 * time.Hour // the rolling window of the bytes budget
+	budgetSlots  = 24             // the number of slots the window is divided into
+)
+
+// errBudgetExhausted means the event batch is dropped as the daily bytes budget
+// is exhausted.
+var errBudgetExhausted = errors.New("daily bytes budget is exhausted")
+
+// bytesBudget enforces the bytes-per-second rate and the rolling daily budget of
+// the event messages sent to the collector.
+type bytesBudget struct {
+	lock sync.Mutex
+
+	// the rate limiter. It allows a burst of up to one second of traffic, and
+	// the available bytes may go negative for a batch larger than that, in
+	// which case the next batch waits for the debt to be paid off.
+	rate      float64 // bytes per second, zero means unlimited
+	available float64
+	last      time.Time
+
+	// the rolling window, divided into slots of equal length. Each slot keeps
+	// the bytes consumed in it and the sequence number of the slot.
+	limit     int64 // bytes per window, zero means no budget
+	slotLen   time.Duration
+	slots     []int64
+	slotSeq   []int64
+	exhausted int32 // a batch has been refused, it's accessed atomically
+	usedAt    int64 // the consumption when the batch was refused
+
+	now func() time.Time
+}
+
+func newBytesBudget(rate int64, limit int64) *bytesBudget {
+	return &bytesBudget{
+		rate:      float64(rate),
+		available: float64(rate),
+		limit:     limit,
+		slotLen:   budgetWindow / budgetSlots,
+		slots:     make([]int64, budgetSlots),
+		slotSeq:   make([]int64, budgetSlots),
+		now:       time.Now,
+	}
+}
+
+// admit checks if a batch of size bytes can be sent. It consumes the budget and
+// blocks until the rate limiter allows the batch, or returns errBudgetExhausted
+// if the daily budget is exhausted. The wait is skipped if exit is closed.
+func (b *bytesBudget) admit(size int64, exit <-chan struct{}) error {
+	wait, err := b.reserve(size)
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-exit:
+		}
+	}
+	return nil
+}
+
+// reserve consumes size bytes of the budget and returns the duration to wait
+// before sending them.
+func (b *bytesBudget) reserve(size int64) (time.Duration, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := b.now()
+	if b.limit > 0 {
+		used := b.usedLocked(now)
+		if used+size > b.limit {
+			if atomic.LoadInt32(&b.exhausted) == 0 {
+				log.Warningf("The daily bytes budget (%d) of events is exhausted, sampling is stopped.", b.limit)
+				b.usedAt = used
+				atomic.StoreInt32(&b.exhausted, 1)
+			}
+			return 0, errBudgetExhausted
+		}
+		seq := now.UnixNano() / int64(b.slotLen)
+		idx := seq % int64(len(b.slots))
+		if b.slotSeq[idx] != seq {
+			b.slotSeq[idx] = seq
+			b.slots[idx] = 0
+		}
+		b.slots[idx] += size
+	}
+
+	if b.rate <= 0 {
+		return 0, nil
+	}
+	if !b.last.IsZero() {
+		if delta := now.Sub(b.last); delta > 0 {
+			b.available += b.rate * delta.Seconds()
+			if b.available > b.rate {
+				b.available = b.rate
+			}
+		}
+	}
+	b.last = now
+	b.available -= float64(size)
+	if b.available >= 0 {
+		return 0, nil
+	}
+	return time.Duration(-b.available / b.rate * float64(time.Second)), nil
+}
+
+// used returns the bytes consumed in the rolling window.
+func (b *bytesBudget) used() int64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.usedLocked(b.now())
+}
+
+func (b *bytesBudget) usedLocked(now time.Time) int64 {
+	seq := now.UnixNano() / int64(b.slotLen)
+	var used int64
+	for idx, s := range b.slotSeq {
+		if seq-s < int64(len(b.slots)) {
+			used += b.slots[idx]
+		}
+	}
+	return used
+}
+
+// isExhausted returns true if a batch has been refused and no budget has been
+// freed up by the rolling window since then. The sampler stops sampling
+// in this case so no events are generated only to be dropped.
+func (b *bytesBudget) isExhausted() bool {
+	// fast path as it's called for every sampling decision
+	if atomic.LoadInt32(&b.exhausted) == 0 {
+		return false
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.usedLocked(b.now()) < b.usedAt {
+		log.Warning("The daily bytes budget of events is available again, sampling is resumed.")
+		atomic.StoreInt32(&b.exhausted, 0)
+	}
+	return atomic.LoadInt32(&b.exhausted) == 1
+}
+
+// budgetExhausted returns true if the daily bytes budget of the current reporter
+// is exhausted.
+func budgetExhausted() bool {
+	if r, ok := globalReporter.(*grpcReporter); ok && r.eventBudget != nil {
+		return r.eventBudget.isExhausted()
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestBytesBudget returns a bytesBudget driven by a fake clock, which is
+// moved forward by the returned function.
+func newTestBytesBudget(rate int64, limit int64) (*bytesBudget, func(time.Duration)) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newBytesBudget(rate, limit)
+	b.now = func() time.Time { return now }
+	return b, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestBytesBudgetRateLimit(t *testing.T) {
+	b, advance := newTestBytesBudget(1000, 0)
+
+	// a burst of up to one second of traffic is allowed
+	wait, err := b.reserve(600)
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+	wait, err = b.reserve(400)
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+
+	wait, err = b.reserve(500)
+	require.NoError(t, err)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// the debt must be paid off first
+	advance(250 * time.Millisecond)
+	wait, err = b.reserve(250)
+	require.NoError(t, err)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// idle time doesn't accumulate more than a second of traffic
+	advance(time.Hour)
+	wait, err = b.reserve(1000)
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+
+	// a batch larger than the rate is allowed, but the next one waits
+	wait, err = b.reserve(3000)
+	require.NoError(t, err)
+	assert.Equal(t, 3*time.Second, wait)
+
+	assert.Zero(t, b.used())
+	assert.False(t, b.isExhausted())
+}
+
+func TestBytesBudgetUnlimited(t *testing.T) {
+	b, _ := newTestBytesBudget(0, 0)
+	for i := 0; i < 100; i++ {
+		wait, err := b.reserve(1 << 20)
+		require.NoError(t, err)
+		require.Zero(t, wait)
+	}
+	assert.False(t, b.isExhausted())
+
+	start := time.Now()
+	require.NoError(t, b.admit(1<<20, make(chan struct{})))
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestBytesBudgetDaily(t *testing.T) {
+	b, advance := newTestBytesBudget(0, 1000)
+
+	_, err := b.reserve(600)
+	require.NoError(t, err)
+	assert.EqualValues(t, 600, b.used())
+
+	advance(12 * time.Hour)
+	_, err = b.reserve(300)
+	require.NoError(t, err)
+	assert.EqualValues(t, 900, b.used())
+	assert.False(t, b.isExhausted())
+
+	_, err = b.reserve(200)
+	require.Equal(t, errBudgetExhausted, err)
+	assert.True(t, b.isExhausted())
+	assert.EqualValues(t, 900, b.used())
+
+	// the first 600 bytes are still in the rolling window
+	advance(11 * time.Hour)
+	assert.True(t, b.isExhausted())
+
+	// the first 600 bytes roll out of the window
+	advance(2 * time.Hour)
+	assert.False(t, b.isExhausted())
+	assert.EqualValues(t, 300, b.used())
+	_, err = b.reserve(200)
+	require.NoError(t, err)
+	assert.EqualValues(t, 500, b.used())
+
+	advance(48 * time.Hour)
+	assert.Zero(t, b.used())
+}
+
+func TestBytesBudgetAdmitWait(t *testing.T) {
+	b := newBytesBudget(1000, 0)
+	exit := make(chan struct{})
+
+	require.NoError(t, b.admit(1000, exit))
+	start := time.Now()
+	require.NoError(t, b.admit(100, exit))
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+
+	// the wait is skipped when the reporter is exiting
+	close(exit)
+	start = time.Now()
+	require.NoError(t, b.admit(10000, exit))
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestBudgetExhaustedStopsSampling(t *testing.T) {
+	r := SetTestReporter(TestReporterSettingType(DefaultST))
+	defer r.Close(0)
+
+	budget, _ := newTestBytesBudget(0, 100)
+	globalReporter = &grpcReporter{eventBudget: budget}
+	defer func() { globalReporter = r }()
+
+	dec := oboeSampleRequest(false, "url", ModeTriggerTraceNotPresent, sampledSwState)
+	require.True(t, dec.Trace())
+
+	_, err := budget.reserve(200)
+	require.Equal(t, errBudgetExhausted, err)
+
+	dec = oboeSampleRequest(false, "url", ModeTriggerTraceNotPresent, sampledSwState)
+	assert.False(t, dec.Trace())
+	assert.True(t, dec.Enabled())
+	dec = oboeSampleRequest(true, "url", ModeTriggerTraceNotPresent, sampledSwState)
+	assert.False(t, dec.Trace())
+	dec = oboeSampleRequest(false, "url", ModeRelaxedTriggerTrace, sampledSwState)
+	assert.False(t, dec.Trace())
+	assert.Equal(t, ttRateExceeded, dec.XTraceOptsRsp())
+}
@@ -60,6 +60,15 @@ type BytesBucket struct {
 
 	// the count of oversize water that gets dropped
 	droppedCount int
+
+	// the flush requests, see WithFlushSignal
+	flush <-chan chan error
+
+	// the flush requests received but not completed yet
+	flushWaiters []chan error
+
+	// the flush requests completed in the last PourIn call
+	flushed []chan error
 }
 
 // NewBytesBucket returns a new BytesBucket object with the options provided
@@ -111,6 +120,16 @@ func WithIntervalGetter(fn func() time.Duration) BucketOption {
 	}
 }
 
+// WithFlushSignal assigns the channel on which flush requests are received.
+// After receiving a request, the bucket stops waiting for the drain interval and
+// becomes drainable as soon as the source is empty. The requests completed are
+// returned by Flushed.
+func WithFlushSignal(flush <-chan chan error) BucketOption {
+	return func(b *BytesBucket) {
+		b.flush = flush
+	}
+}
+
 // PourIn pours as much water as possible from the source into the bucket and returns
 // the water it pours in.
 // This method blocks until it's either full or timeout.
@@ -137,31 +156,39 @@ func (b *BytesBucket) PourIn() int {
 
 outer:
 	for {
-	inner:
-		select {
-		case m := <-b.source:
-			if len(m) > b.HWM {
-				b.droppedCount++
-				break inner
-			}
-
-			if len(m) <= b.HWM-b.watermark {
-				b.watermark += len(m)
-				b.water = append(b.water, m)
-				if drainASAP {
-					b.full = true
+		if len(b.flushWaiters) != 0 {
+			select {
+			case m := <-b.source:
+				if !b.add(m, drainASAP) {
 					break outer
 				}
-			} else { // let's stop when the bucket is full
-				if len(b.waitingList) <= 100 {
-					b.waitingList = append(b.waitingList, m)
-				} else {
-					log.Debug("Dropping it as waiting list is full.")
-				}
+				continue outer
+			case w := <-b.flush:
+				b.flushWaiters = append(b.flushWaiters, w)
+				continue outer
+			default:
+			}
+			// Nothing left in the source, but the waiting list (if any) has
+			// to be poured in by the next call before the flush is done.
+			if len(b.waitingList) == 0 {
+				b.flushed = append(b.flushed, b.flushWaiters...)
+				b.flushWaiters = nil
+			}
+			if b.watermark != 0 {
 				b.full = true
+			}
+			break outer
+		}
+
+		select {
+		case m := <-b.source:
+			if !b.add(m, drainASAP) {
 				break outer
 			}
 
+		case w := <-b.flush:
+			b.flushWaiters = append(b.flushWaiters, w)
+
 		case <-drainTimeout:
 			if b.watermark != 0 {
 				b.full = true
@@ -181,6 +208,34 @@ outer:
 	return b.watermark - oldWM
 }
 
+// add pours a single drop of water into the bucket. It returns false if the
+// bucket becomes full and no more water should be poured in.
+func (b *BytesBucket) add(m []byte, drainASAP bool) bool {
+	if len(m) > b.HWM {
+		b.droppedCount++
+		return true
+	}
+
+	if len(m) <= b.HWM-b.watermark {
+		b.watermark += len(m)
+		b.water = append(b.water, m)
+		if drainASAP {
+			b.full = true
+			return false
+		}
+		return true
+	}
+
+	// let's stop when the bucket is full
+	if len(b.waitingList) <= 100 {
+		b.waitingList = append(b.waitingList, m)
+	} else {
+		log.Debug("Dropping it as waiting list is full.")
+	}
+	b.full = true
+	return false
+}
+
 // Drain pour all the water out and make the bucket empty.
 func (b *BytesBucket) Drain() [][]byte {
 	water := b.water
@@ -212,6 +267,15 @@ func (b *BytesBucket) DroppedCount() int {
 	return b.droppedCount
 }
 
+// Flushed returns the flush requests which have been completed, i.e., all the
+// water poured in before the request is either drained or in the bucket now.
+// The requests are returned only once.
+func (b *BytesBucket) Flushed() []chan error {
+	f := b.flushed
+	b.flushed = nil
+	return f
+}
+
 // Count returns the water count during a drain cycle
 func (b *BytesBucket) Count() int {
 	return len(b.water)
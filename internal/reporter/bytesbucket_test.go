@@ -107,3 +107,54 @@ func TestBytesBucket_OversizeCount(t *testing.T) {
 	assert.Equal(t, 0, poured)
 	assert.True(t, b.Full())
 }
+
+func TestBytesBucket_Flush(t *testing.T) {
+	source := make(chan []byte, 7)
+	flush := make(chan chan error, 1)
+
+	b := NewBytesBucket(source,
+		WithHWM(5),
+		WithIntervalGetter(func() time.Duration { return time.Hour }),
+		WithFlushSignal(flush))
+
+	// the first drop of water is drained ASAP
+	source <- []byte{1}
+	assert.Equal(t, 1, b.PourIn())
+	assert.True(t, b.Full())
+	assert.Empty(t, b.Flushed())
+	b.Drain()
+
+	// it's drainable right after the flush request without waiting for the
+	// drain interval
+	source <- []byte{2}
+	source <- []byte{3}
+	f1 := make(chan error, 1)
+	flush <- f1
+	assert.Equal(t, 2, b.PourIn())
+	assert.True(t, b.Full())
+	assert.Equal(t, []chan error{f1}, b.Flushed())
+	assert.Empty(t, b.Flushed())
+	b.Drain()
+
+	// the flush is not done until the waiting list is poured in
+	source <- []byte{4, 5, 6}
+	source <- []byte{7, 8, 9}
+	f2 := make(chan error, 1)
+	flush <- f2
+	assert.Equal(t, 3, b.PourIn())
+	assert.True(t, b.Full())
+	assert.Empty(t, b.Flushed())
+	b.Drain()
+
+	assert.Equal(t, 3, b.PourIn())
+	assert.True(t, b.Full())
+	assert.Equal(t, []chan error{f2}, b.Flushed())
+	b.Drain()
+
+	// flush an empty bucket
+	f3 := make(chan error, 1)
+	flush <- f3
+	assert.Equal(t, 0, b.PourIn())
+	assert.False(t, b.Full())
+	assert.Equal(t, []chan error{f3}, b.Flushed())
+}
@@ -0,0 +1,313 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/solarwinds/apm-go/internal/log"
+	collector "github.com/solarwinds/apm-proto/go/collectorpb"
+)
+
+// The capture file starts with the magic string, followed by the records, each
+// of which is prefixed by its length:
+//
+//	uint32  the length of the rest of the record
+//	int64   the time the method is invoked, in Unix nanoseconds
+//	uint8   the method, see captureMethods
+//	uint16  the length of the service key, followed by the key
+//	uint32  the number of the messages, followed by the messages
+//	        (uint32 length + BSON each)
+//
+// All the integers are big-endian.
+const captureMagic = "SWOCAPT1"
+
+// The methods with messages, which are captured
+const (
+	CapturePostEvents  = "PostEvents"
+	CapturePostMetrics = "PostMetrics"
+	CapturePostStatus  = "PostStatus"
+)
+
+var captureMethods = []string{CapturePostEvents, CapturePostMetrics, CapturePostStatus}
+
+// the maximum length of a record, to reject corrupted files
+const maxCaptureRecordLen = 1 << 30
+
+var (
+	errInvalidCaptureFile = errors.New("not a capture file")
+	errInvalidRecord      = errors.New("invalid capture record")
+)
+
+// CaptureRecord is a method invocation captured by a CaptureWriter.
+type CaptureRecord struct {
+	Time       time.Time
+	Method     string
+	ServiceKey string
+	Messages   [][]byte
+}
+
+// CaptureWriter writes the messages sent to the collector into a capture
+// file. It's safe for concurrent use.
+type CaptureWriter struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewCaptureWriter creates the capture file, or truncates it if it exists.
+// The file contains the service key so it's only readable by the owner.
+func NewCaptureWriter(path string) (*CaptureWriter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = f.WriteString(captureMagic); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &CaptureWriter{f: f}, nil
+}
+
+// Write captures the method if it has messages, i.e., PostEvents, PostMetrics
+// and PostStatus.
+func (w *CaptureWriter) Write(m Method) error {
+	var method string
+	switch m.(type) {
+	case *PostEventsMethod:
+		method = CapturePostEvents
+	case *PostMetricsMethod:
+		method = CapturePostMetrics
+	case *PostStatusMethod:
+		method = CapturePostStatus
+	default:
+		return nil
+	}
+	return w.WriteRecord(&CaptureRecord{
+		Time:       time.Now(),
+		Method:     method,
+		ServiceKey: m.ServiceKey(),
+		Messages:   m.Message(),
+	})
+}
+
+// WriteRecord writes the record into the capture file.
+func (w *CaptureWriter) WriteRecord(r *CaptureRecord) error {
+	code := -1
+	for i, name := range captureMethods {
+		if name == r.Method {
+			code = i
+		}
+	}
+	if code < 0 || len(r.ServiceKey) > 0xffff {
+		return errInvalidRecord
+	}
+
+	n := 8 + 1 + 2 + len(r.ServiceKey) + 4
+	for _, msg := range r.Messages {
+		n += 4 + len(msg)
+	}
+	buf := make([]byte, 0, 4+n)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(n))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(r.Time.UnixNano()))
+	buf = append(buf, byte(code))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(r.ServiceKey)))
+	buf = append(buf, r.ServiceKey...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(r.Messages)))
+	for _, msg := range r.Messages {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(msg)))
+		buf = append(buf, msg...)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return os.ErrClosed
+	}
+	_, err := w.f.Write(buf)
+	return err
+}
+
+// Close closes the capture file.
+func (w *CaptureWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+// CaptureReader reads the records of a capture file.
+type CaptureReader struct {
+	r *bufio.Reader
+}
+
+// NewCaptureReader checks the magic string of the capture file and returns a
+// reader of its records.
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(captureMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != captureMagic {
+		return nil, errInvalidCaptureFile
+	}
+	return &CaptureReader{r: br}, nil
+}
+
+// Next returns the next record, or io.EOF if there are no more records. A
+// record truncated at the end of the file, e.g., when the process is killed
+// while writing it, is reported as io.ErrUnexpectedEOF.
+func (cr *CaptureReader) Next() (*CaptureRecord, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(cr.r, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n < 8+1+2+4 || n > maxCaptureRecordLen {
+		return nil, errors.Wrap(errInvalidRecord, fmt.Sprintf("length %d", n))
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(cr.r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return parseCaptureRecord(buf)
+}
+
+func parseCaptureRecord(buf []byte) (*CaptureRecord, error) {
+	r := &CaptureRecord{}
+	r.Time = time.Unix(0, int64(binary.BigEndian.Uint64(buf)))
+	code := int(buf[8])
+	if code >= len(captureMethods) {
+		return nil, errors.Wrap(errInvalidRecord, fmt.Sprintf("method %d", code))
+	}
+	r.Method = captureMethods[code]
+	buf = buf[9:]
+
+	keyLen := int(binary.BigEndian.Uint16(buf))
+	buf = buf[2:]
+	if len(buf) < keyLen+4 {
+		return nil, errInvalidRecord
+	}
+	r.ServiceKey = string(buf[:keyLen])
+	buf = buf[keyLen:]
+
+	count := binary.BigEndian.Uint32(buf)
+	buf = buf[4:]
+	for i := uint32(0); i < count; i++ {
+		if len(buf) < 4 {
+			return nil, errInvalidRecord
+		}
+		l := binary.BigEndian.Uint32(buf)
+		buf = buf[4:]
+		if uint32(len(buf)) < l {
+			return nil, errInvalidRecord
+		}
+		r.Messages = append(r.Messages, buf[:l])
+		buf = buf[l:]
+	}
+	if len(buf) != 0 {
+		return nil, errInvalidRecord
+	}
+	return r, nil
+}
+
+// ReplayOptions are the options of Replay.
+type ReplayOptions struct {
+	// The speed relative to the capture, e.g., 1 for the original speed and 10
+	// for 10 times faster. Zero means as fast as possible.
+	Speed float64
+	// The service key replacing the captured ones, if not empty
+	ServiceKey string
+}
+
+// ReplayStats is the outcome of Replay.
+type ReplayStats struct {
+	Records  int
+	Messages int
+	// The number of the records not accepted by the collector, i.e., with a
+	// result code other than OK
+	Rejected int
+}
+
+// Replay sends the records of the capture to the collector, keeping the
+// intervals between them as adjusted by the speed. It stops at the first
+// transport error, or when ctx is done.
+func Replay(ctx context.Context, client collector.TraceCollectorClient, r *CaptureReader, opts ReplayOptions) (ReplayStats, error) {
+	var stats ReplayStats
+	var first time.Time
+	start := time.Now()
+	identity := buildBestEffortIdentity()
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return stats, nil
+		} else if err != nil {
+			return stats, err
+		}
+
+		if first.IsZero() {
+			first = rec.Time
+		}
+		if opts.Speed > 0 {
+			due := start.Add(time.Duration(float64(rec.Time.Sub(first)) / opts.Speed))
+			select {
+			case <-ctx.Done():
+				return stats, ctx.Err()
+			case <-time.After(time.Until(due)):
+			}
+		}
+
+		key := rec.ServiceKey
+		if opts.ServiceKey != "" {
+			key = opts.ServiceKey
+		}
+		req := &collector.MessageRequest{
+			ApiKey:   key,
+			Messages: rec.Messages,
+			Encoding: collector.EncodingType_BSON,
+			Identity: identity,
+		}
+		var res *collector.MessageResult
+		switch rec.Method {
+		case CapturePostEvents:
+			res, err = client.PostEvents(ctx, req)
+		case CapturePostMetrics:
+			res, err = client.PostMetrics(ctx, req)
+		case CapturePostStatus:
+			res, err = client.PostStatus(ctx, req)
+		}
+		if err != nil {
+			return stats, errors.Wrap(err, rec.Method)
+		}
+		stats.Records++
+		stats.Messages += len(rec.Messages)
+		if res.GetResult() != collector.ResultCode_OK {
+			stats.Rejected++
+			log.Warningf("%s is rejected by the collector: %s %s", rec.Method, res.GetResult(), res.GetArg())
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/solarwinds/apm-go/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+func TestCaptureRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture")
+	w, err := NewCaptureWriter(path)
+	require.NoError(t, err)
+
+	now := time.Now()
+	records := []*CaptureRecord{
+		{Time: now, Method: CapturePostEvents, ServiceKey: TestServiceKey, Messages: [][]byte{[]byte("a"), []byte("bc")}},
+		{Time: now.Add(time.Second), Method: CapturePostMetrics, ServiceKey: TestServiceKey, Messages: [][]byte{{}}},
+		{Time: now.Add(2 * time.Second), Method: CapturePostStatus, ServiceKey: "", Messages: nil},
+	}
+	for _, r := range records {
+		require.NoError(t, w.WriteRecord(r))
+	}
+	// the methods without messages are ignored
+	require.NoError(t, w.Write(newGetSettingsMethod(TestServiceKey)))
+	require.Error(t, w.WriteRecord(&CaptureRecord{Method: "GetSettings"}))
+	require.NoError(t, w.Close())
+	assert.ErrorIs(t, w.WriteRecord(records[0]), os.ErrClosed)
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	r, err := NewCaptureReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	for _, expected := range records {
+		got, err := r.Next()
+		require.NoError(t, err)
+		assert.Equal(t, expected.Time.UnixNano(), got.Time.UnixNano())
+		assert.Equal(t, expected.Method, got.Method)
+		assert.Equal(t, expected.ServiceKey, got.ServiceKey)
+		assert.Equal(t, len(expected.Messages), len(got.Messages))
+		for i := range expected.Messages {
+			assert.Equal(t, expected.Messages[i], got.Messages[i])
+		}
+	}
+	_, err = r.Next()
+	assert.Equal(t, io.EOF, err)
+
+	// truncated
+	r, err = NewCaptureReader(bytes.NewReader(data[:len(data)-3]))
+	require.NoError(t, err)
+	_, _ = r.Next()
+	_, _ = r.Next()
+	_, err = r.Next()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// corrupted
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(captureMagic)+4+8] = 0xff
+	r, err = NewCaptureReader(bytes.NewReader(corrupted))
+	require.NoError(t, err)
+	_, err = r.Next()
+	assert.ErrorIs(t, err, errInvalidRecord)
+
+	_, err = NewCaptureReader(bytes.NewReader([]byte("not a capture")))
+	assert.Equal(t, errInvalidCaptureFile, err)
+}
+
+func TestCaptureReporter(t *testing.T) {
+	addr := "localhost:4567"
+	server := StartTestGRPCServer(t, addr)
+	defer server.Stop()
+	t.Setenv("SW_APM_TRUSTEDPATH", testCertFile)
+
+	path := filepath.Join(t.TempDir(), "capture")
+	i := New(resource.Empty(), config.NewConfig(config.WithCollector(addr), config.WithCaptureFile(path)))
+	require.NoError(t, i.ReportEvent(CreateInfoEvent(validSpanContext, time.Now())))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, i.Flush(ctx))
+	i.ShutdownNow()
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	r, err := NewCaptureReader(f)
+	require.NoError(t, err)
+	captured := make(map[string][][]byte)
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		assert.Equal(t, TestServiceKey, rec.ServiceKey)
+		captured[rec.Method] = append(captured[rec.Method], rec.Messages...)
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	var sent [][]byte
+	for _, req := range server.events {
+		sent = append(sent, req.Messages...)
+	}
+	require.NotEmpty(t, sent)
+	assert.Equal(t, sent, captured[CapturePostEvents])
+	assert.NotEmpty(t, captured[CapturePostStatus])
+}
@@ -58,13 +58,17 @@ func (a AuthStatus) Msg() string {
 // TODO: the ability to extract the TT Token from oboe settings.
 // TODO: Determine a clean/elegant way to clean this up.
 func ValidateXTraceOptionsSignature(signature, ts, data string) AuthStatus {
+	return globalSettingsCfg.validateXTraceOptionsSignature(signature, ts, data)
+}
+
+func (sc *oboeSettingsCfg) validateXTraceOptionsSignature(signature, ts, data string) AuthStatus {
 	var err error
 	_, err = tsInScope(ts)
 	if err != nil {
 		return AuthBadTimestamp
 	}
 
-	token, err := getTriggerTraceToken()
+	token, err := sc.getTriggerTraceToken()
 	if err != nil {
 		return AuthNoSignatureKey
 	}
@@ -76,7 +80,7 @@ func ValidateXTraceOptionsSignature(signature, ts, data string) AuthStatus {
 }
 
 func HmacHashTT(data []byte) (string, error) {
-	token, err := getTriggerTraceToken()
+	token, err := globalSettingsCfg.getTriggerTraceToken()
 	if err != nil {
 		return "", err
 	}
@@ -90,8 +94,8 @@ func HmacHash(token, data []byte) string {
 	return sha
 }
 
-func getTriggerTraceToken() ([]byte, error) {
-	setting, ok := getSetting()
+func (sc *oboeSettingsCfg) getTriggerTraceToken() ([]byte, error) {
+	setting, ok := sc.getSetting()
 	if !ok {
 		return nil, errors.New("failed to get settings")
 	}
@@ -0,0 +1,207 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	collector "github.com/solarwinds/apm-proto/go/collectorpb"
+)
+
+// DebugInfo is a point-in-time snapshot of the reporter internals. It is
+// intended for troubleshooting only and the layout may change at any time.
+type DebugInfo struct {
+	Ready        bool                       `json:"ready"`
+	Closed       bool                       `json:"closed"`
+	Collector    string                     `json:"collector,omitempty"`
+	RPCs         map[string]RPCStats        `json:"rpcs,omitempty"`
+	Circuit      string                     `json:"circuit_breaker,omitempty"`
+	Settings     *SettingsInfo              `json:"settings"`
+	TokenBuckets map[string]TokenBucketInfo `json:"token_buckets"`
+	EventQueue   *EventQueueInfo            `json:"event_queue,omitempty"`
+	HostID       *collector.HostID          `json:"host_id"`
+}
+
+// RPCStats is the statistics of an RPC method type, e.g., PostEvents.
+type RPCStats struct {
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	Retries     int64      `json:"retries"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// SettingsInfo is the default sampling setting currently in use.
+type SettingsInfo struct {
+	Layer        string       `json:"layer"`
+	Flags        string       `json:"flags"`
+	SampleRate   int          `json:"sample_rate"`
+	SampleSource SampleSource `json:"sample_source"`
+	TTL          int64        `json:"ttl"`
+	Timestamp    time.Time    `json:"timestamp"`
+	ExpiresIn    string       `json:"expires_in"`
+}
+
+// TokenBucketInfo is the current state of a token bucket.
+type TokenBucketInfo struct {
+	Capacity   float64 `json:"capacity"`
+	RatePerSec float64 `json:"rate_per_sec"`
+	Available  float64 `json:"available"`
+}
+
+// EventQueueInfo is the event queue stats of the current metrics interval.
+type EventQueueInfo struct {
+	NumSent          int64 `json:"num_sent"`
+	NumOverflowed    int64 `json:"num_overflowed"`
+	NumFailed        int64 `json:"num_failed"`
+	TotalEvents      int64 `json:"total_events"`
+	QueueLargest     int64 `json:"queue_largest"`
+	NumDroppedOldest int64 `json:"num_dropped_oldest"`
+	NumBlocked       int64 `json:"num_blocked"`
+	NumTraceDropped  int64 `json:"num_trace_dropped"`
+	BytesSent        int64 `json:"bytes_sent"`
+	NumBudgetDropped int64 `json:"num_budget_dropped"`
+	BudgetUsed       int64 `json:"budget_used"`
+	BudgetLimit      int64 `json:"budget_limit"`
+}
+
+// Debug returns a snapshot of the internals of the current reporter.
+func Debug() *DebugInfo {
+	return debug(globalReporter, globalSettingsCfg)
+}
+
+// Debug returns a snapshot of the internals of this reporter.
+func (i *Instance) Debug() *DebugInfo {
+	return debug(i.Reporter, i.settings)
+}
+
+func debug(rep Reporter, settings *oboeSettingsCfg) *DebugInfo {
+	info := &DebugInfo{
+		Closed:       rep.Closed(),
+		TokenBuckets: make(map[string]TokenBucketInfo),
+		HostID:       buildBestEffortIdentity(),
+	}
+
+	if setting, ok := settings.getSetting(); ok {
+		info.Settings = setting.debugInfo()
+		info.TokenBuckets["regular"] = setting.bucket.debugInfo()
+		info.TokenBuckets["trigger_relaxed"] = setting.triggerTraceRelaxedBucket.debugInfo()
+		info.TokenBuckets["trigger_strict"] = setting.triggerTraceStrictBucket.debugInfo()
+	}
+
+	if r, ok := rep.(*grpcReporter); ok {
+		info.Ready = r.isReady()
+		info.Collector = r.conn.getAddress()
+		info.RPCs = r.conn.rpcStats.snapshot()
+		if r.conn.breaker != nil {
+			info.Circuit = r.conn.breaker.getState().String()
+		}
+		qs := r.conn.queueStats
+		info.EventQueue = &EventQueueInfo{
+			NumSent:          qs.NumSent(),
+			NumOverflowed:    qs.NumOverflowed(),
+			NumFailed:        qs.NumFailed(),
+			TotalEvents:      qs.TotalEvents(),
+			QueueLargest:     qs.QueueLargest(),
+			NumDroppedOldest: qs.NumDroppedOldest(),
+			NumBlocked:       qs.NumBlocked(),
+			NumTraceDropped:  qs.NumTraceDropped(),
+			BytesSent:        qs.BytesSent(),
+			NumBudgetDropped: qs.NumBudgetDropped(),
+		}
+		if r.eventBudget != nil {
+			info.EventQueue.BudgetUsed = r.eventBudget.used()
+			info.EventQueue.BudgetLimit = r.eventBudget.limit
+		}
+	}
+	return info
+}
+
+func (s *oboeSettings) debugInfo() *SettingsInfo {
+	expiresIn := time.Until(s.timestamp.Add(time.Duration(s.ttl) * time.Second))
+	return &SettingsInfo{
+		Layer:        s.layer,
+		Flags:        s.flags.String(),
+		SampleRate:   s.value,
+		SampleSource: s.source,
+		TTL:          s.ttl,
+		Timestamp:    s.timestamp,
+		ExpiresIn:    expiresIn.Round(time.Second).String(),
+	}
+}
+
+func (b *tokenBucket) debugInfo() TokenBucketInfo {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.update(time.Now())
+	return TokenBucketInfo{
+		Capacity:   b.capacity,
+		RatePerSec: b.ratePerSec,
+		Available:  b.available,
+	}
+}
+
+// rpcStats records the outcome of the RPC calls by method type.
+type rpcStats struct {
+	stats map[string]*RPCStats
+	lock  sync.Mutex
+}
+
+// methodType returns the type of the method, e.g., "Ping" for "Ping events channel".
+func methodType(m Method) string {
+	return strings.SplitN(m.String(), " ", 2)[0]
+}
+
+func (s *rpcStats) get(m Method) *RPCStats {
+	if s.stats == nil {
+		s.stats = make(map[string]*RPCStats)
+	}
+	name := methodType(m)
+	st, ok := s.stats[name]
+	if !ok {
+		st = &RPCStats{}
+		s.stats[name] = st
+	}
+	return st
+}
+
+func (s *rpcStats) succeeded(m Method) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	s.get(m).LastSuccess = &now
+}
+
+func (s *rpcStats) retried(m Method, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	st := s.get(m)
+	st.Retries++
+	if err != nil {
+		st.LastError = err.Error()
+	} else if code, _ := m.ResultCode(); code != collector.ResultCode_OK {
+		st.LastError = code.String()
+	}
+}
+
+func (s *rpcStats) snapshot() map[string]RPCStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	snapshot := make(map[string]RPCStats, len(s.stats))
+	for name, st := range s.stats {
+		snapshot[name] = *st
+	}
+	return snapshot
+}
@@ -0,0 +1,75 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter
+
+import (
+	"testing"
+	"time"
+
+	collector "github.com/solarwinds/apm-proto/go/collectorpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugSettings(t *testing.T) {
+	// the host identity may take a while to be detected in the first run
+	r := SetTestReporter(TestReporterSettingType(LimitedTriggerTraceST),
+		func(r *TestReporter) { r.Timeout = time.Minute })
+	defer r.Close(0)
+
+	info := Debug()
+	require.NotNil(t, info.Settings)
+	assert.Equal(t, "SAMPLE_START,SAMPLE_THROUGH_ALWAYS,TRIGGER_TRACE", info.Settings.Flags)
+	assert.Equal(t, 1000000, info.Settings.SampleRate)
+	assert.Equal(t, int64(120), info.Settings.TTL)
+	expiresIn, err := time.ParseDuration(info.Settings.ExpiresIn)
+	require.NoError(t, err)
+	assert.True(t, expiresIn > 0 && expiresIn <= 120*time.Second)
+
+	require.Len(t, info.TokenBuckets, 3)
+	assert.Equal(t, TokenBucketInfo{Capacity: 1, RatePerSec: 1, Available: 1},
+		info.TokenBuckets["trigger_relaxed"])
+	assert.NotNil(t, info.HostID)
+
+	// only available for the gRPC reporter
+	assert.False(t, info.Ready)
+	assert.Empty(t, info.Collector)
+	assert.Nil(t, info.EventQueue)
+}
+
+func TestRPCStats(t *testing.T) {
+	var s rpcStats
+	require.Empty(t, s.snapshot())
+
+	ping := newPingMethod("key", "events channel")
+	s.retried(ping, errConnStale)
+	ping.Resp.Result = collector.ResultCode_TRY_LATER
+	s.retried(ping, nil)
+	s.succeeded(ping)
+
+	events := newPostEventsMethod("key", nil)
+	s.retried(events, errConnStale)
+
+	snapshot := s.snapshot()
+	require.Len(t, snapshot, 2)
+
+	assert.Equal(t, int64(2), snapshot["Ping"].Retries)
+	assert.Equal(t, "TRY_LATER", snapshot["Ping"].LastError)
+	assert.NotNil(t, snapshot["Ping"].LastSuccess)
+
+	assert.Equal(t, int64(1), snapshot["PostEvents"].Retries)
+	assert.Equal(t, errConnStale.Error(), snapshot["PostEvents"].LastError)
+	assert.Nil(t, snapshot["PostEvents"].LastSuccess)
+}
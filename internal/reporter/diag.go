@@ -0,0 +1,95 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/solarwinds/apm-go/internal/config"
+	collector "github.com/solarwinds/apm-proto/go/collectorpb"
+	"google.golang.org/grpc"
+)
+
+// DialCollector dials the collector of the config in the same way as the
+// reporter, i.e., with the DefaultDialer, the trusted certificate and the
+// proxy. It's used by the diagnostic tools.
+func DialCollector(cfg *config.Config) (*grpc.ClientConn, error) {
+	p := DialParams{Address: cfg.GetCollector()}
+	if certPath := cfg.GetTrustedPath(); certPath != "" {
+		cert, err := os.ReadFile(certPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the trusted certificate")
+		}
+		p.Certificate = string(cert)
+	}
+	if proxy := cfg.GetProxy(); proxy != "" {
+		p.Proxy = proxy
+		p.ProxyCertPath = cfg.GetProxyCertPath()
+	}
+	return (&DefaultDialer{}).Dial(p)
+}
+
+// NewSettingsRequest returns the GetSettings request sent by the reporter.
+func NewSettingsRequest(key string) *collector.SettingsRequest {
+	return &collector.SettingsRequest{
+		ApiKey:        key,
+		ClientVersion: grpcReporterVersion,
+		Identity:      buildBestEffortIdentity(),
+	}
+}
+
+// HostIdentity returns the host identity sent with the events and metrics. It
+// blocks until the host metadata is initialized, see host.Start.
+func HostIdentity() *collector.HostID {
+	return buildIdentity()
+}
+
+// SettingArgs decodes the arguments of a setting returned by GetSettings, in
+// the order of the argument names. The signature key is masked.
+func SettingArgs(s *collector.OboeSetting) []string {
+	var args []string
+	for k, v := range s.Arguments {
+		var val string
+		switch k {
+		case kvBucketCapacity, kvBucketRate,
+			kvTriggerTraceRelaxedBucketCapacity, kvTriggerTraceRelaxedBucketRate,
+			kvTriggerTraceStrictBucketCapacity, kvTriggerTraceStrictBucketRate:
+			f, err := bytesToFloat64(v)
+			if err != nil {
+				val = err.Error()
+			} else {
+				val = fmt.Sprintf("%g", f)
+			}
+		case kvMetricsFlushInterval, kvEventsFlushInterval,
+			kvMaxTransactions, kvMaxCustomMetrics:
+			i, err := bytesToInt32(v)
+			if err != nil {
+				val = err.Error()
+			} else {
+				val = fmt.Sprintf("%d", i)
+			}
+		case kvSignatureKey:
+			val = fmt.Sprintf("<%d bytes>", len(v))
+		default:
+			val = fmt.Sprintf("%x", v)
+		}
+		args = append(args, fmt.Sprintf("%s=%s", k, val))
+	}
+	sort.Strings(args)
+	return args
+}
@@ -69,6 +69,7 @@ type Event interface {
 
 	GetXTrace() string
 	GetSwTraceContext() string
+	GetTraceID() trace.TraceID
 
 	ToBson() []byte
 }
@@ -123,6 +124,10 @@ func (e *event) GetSwTraceContext() string {
 	return fmt.Sprintf("00-%s-%s-01", e.taskID.String(), hex.EncodeToString(e.opID[:]))
 }
 
+func (e *event) GetTraceID() trace.TraceID {
+	return e.taskID
+}
+
 func (e *event) GetXTrace() string {
 	tid := strings.ToUpper(e.taskID.String())
 	oid := strings.ToUpper(hex.EncodeToString(e.opID[:]))
@@ -0,0 +1,114 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/solarwinds/apm-go/internal/config"
+	"github.com/solarwinds/apm-go/internal/host"
+	"github.com/solarwinds/apm-go/internal/log"
+	"github.com/solarwinds/apm-go/internal/metrics"
+	"github.com/solarwinds/apm-go/internal/uams"
+	"github.com/solarwinds/apm-go/internal/w3cfmt"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// Instance is a reporter which is independent of the global one: it has its
+// own config, collector connection, sampling settings, token buckets and
+// metrics. It's used by agents which don't touch the package-level state, so
+// several of them can run in the same process.
+//
+// The host observers (host ID, UAMS client ID) are shared by all the
+// reporters of the process.
+type Instance struct {
+	Reporter
+	settings *oboeSettingsCfg
+	metrics  *metrics.Registry
+}
+
+// New creates and starts a reporter with the config cfg. The resource r is
+// sent in the init message. A no-op reporter is returned if the agent is
+// disabled by the config.
+func New(r *resource.Resource, cfg *config.Config) *Instance {
+	i := &Instance{
+		settings: newOboeSettingsCfg(cfg, nil),
+		metrics:  metrics.NewRegistry(),
+	}
+	applyTransactionNameConfig(cfg, i.metrics.ApmMetrics())
+	if !cfg.GetEnabled() {
+		log.Warning("SolarWinds Observability APM agent is disabled.")
+		i.Reporter = newNullReporter()
+	} else if strings.ToLower(cfg.GetReporterType()) == "none" {
+		i.Reporter = newNullReporter()
+	} else {
+		i.Reporter = newGRPCReporterWith(cfg, i.settings, i.metrics, otelServiceName(r))
+		if gr, ok := i.Reporter.(*grpcReporter); ok {
+			i.settings.exhausted = gr.eventBudget.isExhausted
+		}
+	}
+	sendInitMessage(i.Reporter, r)
+	return i
+}
+
+// ShouldTraceRequestWithURL makes the sampling decision of a request based on
+// the settings retrieved by this reporter.
+func (i *Instance) ShouldTraceRequestWithURL(traced bool, url string, ttMode TriggerTraceMode, swState w3cfmt.SwTraceState) SampleDecision {
+	return i.settings.sampleRequest(traced, url, ttMode, swState)
+}
+
+// ValidateXTraceOptionsSignature validates the signature of the X-Trace-Options
+// header with the trigger trace token retrieved by this reporter.
+func (i *Instance) ValidateXTraceOptionsSignature(signature, ts, data string) AuthStatus {
+	return i.settings.validateXTraceOptionsSignature(signature, ts, data)
+}
+
+// Metrics returns the metrics registry reported by this reporter.
+func (i *Instance) Metrics() *metrics.Registry {
+	return i.metrics
+}
+
+// the number of running reporters which use the host observers
+var hostObservers struct {
+	sync.Mutex
+	users int
+}
+
+// acquireHostObservers starts the host observers for the first reporter.
+func acquireHostObservers() {
+	hostObservers.Lock()
+	defer hostObservers.Unlock()
+	if hostObservers.users == 0 {
+		host.Start()
+		uams.Start()
+	}
+	hostObservers.users++
+}
+
+// releaseHostObservers stops the host observers after the last reporter is
+// shut down.
+func releaseHostObservers() {
+	hostObservers.Lock()
+	defer hostObservers.Unlock()
+	if hostObservers.users == 0 {
+		return
+	}
+	hostObservers.users--
+	if hostObservers.users == 0 {
+		host.Stop()
+		uams.Stop()
+	}
+}
@@ -0,0 +1,187 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/solarwinds/apm-go/internal/config"
+	"github.com/solarwinds/apm-go/internal/metrics"
+	"github.com/solarwinds/apm-go/internal/utils"
+	"github.com/solarwinds/apm-go/internal/w3cfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestInstancesAreIndependent(t *testing.T) {
+	addr := "localhost:4567"
+	server := StartTestGRPCServer(t, addr)
+	defer server.Stop()
+	t.Setenv("SW_APM_TRUSTEDPATH", testCertFile)
+
+	otherKey := TestServiceKey[:len(TestServiceKey)-2] + "other"
+	enabled := func(c *config.Config) {
+		c.Sampling.SetTracingMode(config.EnabledTracingMode)
+	}
+	a := New(resource.Empty(), config.NewConfig(config.WithCollector(addr), enabled,
+		config.WithTransactionNameRules(0, true, 2)))
+	b := New(resource.Empty(), config.NewConfig(config.WithCollector(addr), config.WithServiceKey(otherKey)))
+	defer a.ShutdownNow()
+	defer b.ShutdownNow()
+	require.IsType(t, &grpcReporter{}, a.Reporter)
+	require.IsType(t, &grpcReporter{}, b.Reporter)
+	require.NotSame(t, a.metrics, b.metrics)
+	require.NotSame(t, a.settings.bucket, b.settings.bucket)
+	require.NotSame(t, globalTokenBucket, a.settings.bucket)
+
+	// only the first one gets the settings
+	resetSettings()
+	a.Reporter.(*grpcReporter).getSettings(make(chan bool, 1))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	require.True(t, a.WaitForReady(ctx))
+	require.False(t, b.WaitForReady(ctx))
+	_, ok := getSetting()
+	require.False(t, ok, "the global settings should not be touched")
+
+	dec := a.ShouldTraceRequestWithURL(false, "", ModeTriggerTraceNotPresent, w3cfmt.SwTraceState{})
+	assert.True(t, dec.Enabled())
+	assert.Equal(t, 1000000, dec.SampleRate())
+	dec = b.ShouldTraceRequestWithURL(false, "", ModeTriggerTraceNotPresent, w3cfmt.SwTraceState{})
+	assert.False(t, dec.Enabled())
+	assert.Equal(t, ttSettingsNotAvailable, dec.XTraceOptsRsp())
+	assert.EqualValues(t, AuthNoSignatureKey, b.ValidateXTraceOptionsSignature("sig", strconv.FormatInt(time.Now().Unix(), 10), "data"))
+
+	// the debug snapshots are those of each instance
+	info := a.Debug()
+	assert.True(t, info.Ready)
+	assert.Equal(t, addr, info.Collector)
+	require.NotNil(t, info.Settings)
+	assert.Nil(t, b.Debug().Settings)
+	assert.Nil(t, Debug().Settings)
+
+	rcs := a.settings.flushRateCounts()
+	assert.EqualValues(t, 1, rcs[metrics.RCRegular].Requested())
+	assert.Nil(t, FlushRateCounts())
+
+	a.Metrics().ApmMetrics().SetCap(1)
+	a.Metrics().ApmMetrics().CopyAndReset(60)
+	assert.EqualValues(t, 1, a.Metrics().ApmMetrics().Cap())
+	assert.NotEqual(t, int32(1), b.Metrics().ApmMetrics().Cap())
+	assert.NotEqual(t, int32(1), metrics.ApmMetrics.Cap())
+
+	assert.Equal(t, utils.TransactionNameRules{CollapseIDs: true, PathDepth: 2},
+		a.Metrics().ApmMetrics().TransactionNameRules())
+	assert.Equal(t, utils.TransactionNameRules{}, b.Metrics().ApmMetrics().TransactionNameRules())
+	assert.Equal(t, utils.TransactionNameRules{}, metrics.ApmMetrics.TransactionNameRules())
+
+	// each one sends the init message with its own service key
+	require.Eventually(t, func() bool {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		return len(server.status) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	keys := []string{server.status[0].ApiKey, server.status[1].ApiKey}
+	assert.ElementsMatch(t, []string{TestServiceKey, otherKey}, keys)
+
+	// shutting down one doesn't affect the other
+	require.NoError(t, a.Shutdown(context.Background()))
+	assert.True(t, a.Closed())
+	assert.False(t, b.Closed())
+	require.NoError(t, b.ReportStatus(CreateInfoEvent(validSpanContext, time.Now())))
+}
+
+func TestInstancesPostPerService(t *testing.T) {
+	addr := "localhost:4567"
+	server := StartTestGRPCServer(t, addr)
+	defer server.Stop()
+	t.Setenv("SW_APM_TRUSTEDPATH", testCertFile)
+
+	otherKey := TestServiceKey[:len(TestServiceKey)-2] + "other"
+	a := New(resource.Empty(), config.NewConfig(config.WithCollector(addr)))
+	b := New(resource.Empty(), config.NewConfig(config.WithCollector(addr), config.WithServiceKey(otherKey)))
+	defer a.ShutdownNow()
+	defer b.ShutdownNow()
+	assert.Equal(t, "go", a.GetServiceName())
+	assert.Equal(t, "other", b.GetServiceName())
+
+	now := time.Now()
+	span := tracetest.SpanStub{
+		Name:      "only-in-a",
+		SpanKind:  trace.SpanKindServer,
+		StartTime: now.Add(-time.Second),
+		EndTime:   now,
+	}.Snapshot()
+	a.Metrics().RecordSpan(span, false)
+	require.NoError(t, b.Metrics().CustomMetrics().Increment("only.in.b", metrics.MetricOptions{Count: 1}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, i := range []*Instance{a, b} {
+		require.NoError(t, i.ReportEvent(CreateInfoEvent(validSpanContext, now)))
+		require.NoError(t, i.Flush(ctx))
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	events := make(map[string]int)
+	for _, req := range server.events {
+		events[req.ApiKey] += len(req.Messages)
+	}
+	assert.Equal(t, map[string]int{TestServiceKey: 1, otherKey: 1}, events)
+
+	// the transaction maps and the custom metrics are partitioned per service
+	msgs := make(map[string]string)
+	for _, req := range server.metrics {
+		for _, m := range req.Messages {
+			msgs[req.ApiKey] += string(m)
+		}
+	}
+	require.Len(t, msgs, 2)
+	assert.Contains(t, msgs[TestServiceKey], "only-in-a")
+	assert.NotContains(t, msgs[TestServiceKey], "only.in.b")
+	assert.Contains(t, msgs[otherKey], "only.in.b")
+	assert.NotContains(t, msgs[otherKey], "only-in-a")
+}
+
+func TestNewInstanceDisabled(t *testing.T) {
+	i := New(resource.Empty(), config.NewConfig(func(c *config.Config) { c.Enabled = false }))
+	assert.IsType(t, &nullReporter{}, i.Reporter)
+	dec := i.ShouldTraceRequestWithURL(false, "", ModeTriggerTraceNotPresent, w3cfmt.SwTraceState{})
+	assert.False(t, dec.Enabled())
+}
+
+func TestHostObservers(t *testing.T) {
+	hostObservers.Lock()
+	users := hostObservers.users
+	hostObservers.Unlock()
+
+	acquireHostObservers()
+	acquireHostObservers()
+	releaseHostObservers()
+	hostObservers.Lock()
+	assert.Equal(t, users+1, hostObservers.users)
+	hostObservers.Unlock()
+	releaseHostObservers()
+	hostObservers.Lock()
+	assert.Equal(t, users, hostObservers.users)
+	hostObservers.Unlock()
+}
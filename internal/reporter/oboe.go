@@ -56,11 +56,44 @@ const (
 type oboeSettingsCfg struct {
 	settings map[oboeSettingKey]*oboeSettings
 	lock     sync.RWMutex
+
+	// the token buckets shared by the settings
+	bucket                    *tokenBucket
+	triggerTraceRelaxedBucket *tokenBucket
+	triggerTraceStrictBucket  *tokenBucket
+
+	// the local config merged into the settings, and the transaction filters
+	// built from it
+	cfg  *config.Config
+	urls *urlFilters
+
+	// exhausted reports if the daily bytes budget of events is exhausted
+	exhausted func() bool
+}
+
+// newOboeSettingsCfg returns an empty settings store with its own token buckets,
+// which merges the local config cfg.
+func newOboeSettingsCfg(cfg *config.Config, exhausted func() bool) *oboeSettingsCfg {
+	urls := newURLFilters()
+	urls.LoadConfig(cfg.GetTransactionFiltering())
+	return &oboeSettingsCfg{
+		settings:                  make(map[oboeSettingKey]*oboeSettings),
+		bucket:                    &tokenBucket{},
+		triggerTraceRelaxedBucket: &tokenBucket{},
+		triggerTraceStrictBucket:  &tokenBucket{},
+		cfg:                       cfg,
+		urls:                      urls,
+		exhausted:                 exhausted,
+	}
 }
 
 // FlushRateCounts collects the request counters values by categories.
 func FlushRateCounts() map[string]*metrics.RateCounts {
-	setting, ok := getSetting()
+	return globalSettingsCfg.flushRateCounts()
+}
+
+func (sc *oboeSettingsCfg) flushRateCounts() map[string]*metrics.RateCounts {
+	setting, ok := sc.getSetting()
 	if !ok {
 		return nil
 	}
@@ -72,6 +105,20 @@ func FlushRateCounts() map[string]*metrics.RateCounts {
 	return rcs
 }
 
+// rateCounts returns the request counters by categories without resetting
+// them.
+func (sc *oboeSettingsCfg) rateCounts() map[string]*metrics.RateCounts {
+	setting, ok := sc.getSetting()
+	if !ok {
+		return nil
+	}
+	return map[string]*metrics.RateCounts{
+		metrics.RCRegular:             &setting.bucket.RateCounts,
+		metrics.RCRelaxedTriggerTrace: &setting.triggerTraceRelaxedBucket.RateCounts,
+		metrics.RCStrictTriggerTrace:  &setting.triggerTraceStrictBucket.RateCounts,
+	}
+}
+
 type oboeSettings struct {
 	timestamp time.Time
 	// the flags which may be modified through merging local settings.
@@ -82,7 +129,10 @@ type oboeSettings struct {
 	// or a new value after negotiating with local config
 	value int
 	// The sample source after negotiating with local config
-	source                    SampleSource
+	source SampleSource
+	// the original sample rate and source retrieved from the remote collector.
+	originalValue             int
+	originalSource            SampleSource
 	ttl                       int64
 	layer                     string
 	triggerToken              []byte
@@ -95,11 +145,11 @@ func (s *oboeSettings) hasOverrideFlag() bool {
 	return s.originalFlags&FLAG_OVERRIDE != 0
 }
 
-func newOboeSettings() *oboeSettings {
+func (sc *oboeSettingsCfg) newOboeSettings() *oboeSettings {
 	return &oboeSettings{
-		bucket:                    globalTokenBucket,
-		triggerTraceRelaxedBucket: triggerTraceRelaxedBucket,
-		triggerTraceStrictBucket:  triggerTraceStrictBucket,
+		bucket:                    sc.bucket,
+		triggerTraceRelaxedBucket: sc.triggerTraceRelaxedBucket,
+		triggerTraceStrictBucket:  sc.triggerTraceStrictBucket,
 	}
 }
 
@@ -140,9 +190,15 @@ type oboeSettingKey struct {
 	layer string
 }
 
-// Global configuration settings
+// Global configuration settings, used by the global reporter
 var globalSettingsCfg = &oboeSettingsCfg{
-	settings: make(map[oboeSettingKey]*oboeSettings),
+	settings:                  make(map[oboeSettingKey]*oboeSettings),
+	bucket:                    globalTokenBucket,
+	triggerTraceRelaxedBucket: triggerTraceRelaxedBucket,
+	triggerTraceStrictBucket:  triggerTraceStrictBucket,
+	cfg:                       config.Global(),
+	urls:                      urls,
+	exhausted:                 budgetExhausted,
 }
 
 // The global token bucket. Trace decisions of all the requests are controlled
@@ -174,15 +230,15 @@ func createInitMessage(tid trace.TraceID, r *resource.Resource) Event {
 	return evt
 }
 
-func sendInitMessage(r *resource.Resource) {
-	if Closed() {
+func sendInitMessage(rep Reporter, r *resource.Resource) {
+	if rep.Closed() {
 		log.Info(errors.Wrap(ErrReporterIsClosed, "send init message"))
 		return
 	}
 	tid := trace.TraceID{0}
 	rand.Random(tid[:])
 	evt := createInitMessage(tid, r)
-	if err := ReportStatus(evt); err != nil {
+	if err := rep.ReportStatus(evt); err != nil {
 		log.Error("could not send init message", err)
 	}
 }
@@ -355,18 +411,25 @@ func oboeSampleRequest(continued bool, url string, triggerTrace TriggerTraceMode
 			}
 		}
 	}
+	return globalSettingsCfg.sampleRequest(continued, url, triggerTrace, swState)
+}
 
+func (sc *oboeSettingsCfg) sampleRequest(continued bool, url string, triggerTrace TriggerTraceMode, swState w3cfmt.SwTraceState) SampleDecision {
 	var setting *oboeSettings
 	var ok bool
 	diceRolled := false
-	if setting, ok = getSetting(); !ok {
+	if setting, ok = sc.getSetting(); !ok {
 		return SampleDecision{false, 0, SAMPLE_SOURCE_NONE, false, ttSettingsNotAvailable, 0, 0, diceRolled}
 	}
 
 	retval := false
 	doRateLimiting := false
 
-	sampleRate, flags, source := mergeURLSetting(setting, url)
+	sampleRate, flags, source := sc.mergeURLSetting(setting, url)
+
+	// Stop sampling if the daily bytes budget of events is exhausted, as the
+	// events would be dropped anyway.
+	exhausted := sc.exhausted != nil && sc.exhausted()
 
 	// Choose an appropriate bucket
 	bucket := setting.bucket
@@ -377,7 +440,7 @@ func oboeSampleRequest(continued bool, url string, triggerTrace TriggerTraceMode
 	}
 
 	if triggerTrace.Requested() && !continued {
-		sampled := (triggerTrace != ModeInvalidTriggerTrace) && (flags.TriggerTraceEnabled())
+		sampled := (triggerTrace != ModeInvalidTriggerTrace) && (flags.TriggerTraceEnabled()) && !exhausted
 		rsp := ttOK
 
 		ret := bucket.count(sampled, false, true)
@@ -427,6 +490,10 @@ func oboeSampleRequest(continued bool, url string, triggerTrace TriggerTraceMode
 		}
 	}
 
+	if exhausted {
+		retval, doRateLimiting = false, false
+	}
+
 	retval = bucket.count(retval, continued, doRateLimiting)
 
 	rsp := ttNotRequested
@@ -518,21 +585,26 @@ func parseInt32(args map[string][]byte, key string, fb int32) int32 {
 //
 // Note: This function modifies the argument in place.
 func mergeLocalSetting(remote *oboeSettings) *oboeSettings {
-	if remote.hasOverrideFlag() && config.SamplingConfigured() {
+	return globalSettingsCfg.mergeLocalSetting(remote)
+}
+
+func (sc *oboeSettingsCfg) mergeLocalSetting(remote *oboeSettings) *oboeSettings {
+	cfg := sc.cfg
+	if remote.hasOverrideFlag() && cfg.SamplingConfigured() {
 		// Choose the lower sample rate and merge the flags
-		if remote.value > config.GetSampleRate() {
-			remote.value = config.GetSampleRate()
+		if remote.value > cfg.GetSampleRate() {
+			remote.value = cfg.GetSampleRate()
 			remote.source = SAMPLE_SOURCE_FILE
 		}
-		remote.flags &= newTracingMode(config.GetTracingMode()).toFlags()
-	} else if config.SamplingConfigured() {
+		remote.flags &= newTracingMode(cfg.GetTracingMode()).toFlags()
+	} else if cfg.SamplingConfigured() {
 		// Use local sample rate and tracing mode config
-		remote.value = config.GetSampleRate()
-		remote.flags = newTracingMode(config.GetTracingMode()).toFlags()
+		remote.value = cfg.GetSampleRate()
+		remote.flags = newTracingMode(cfg.GetTracingMode()).toFlags()
 		remote.source = SAMPLE_SOURCE_FILE
 	}
 
-	if !config.GetTriggerTrace() {
+	if !cfg.GetTriggerTrace() {
 		remote.flags = remote.flags &^ (1 << FlagTriggerTraceOffset)
 	}
 	return remote
@@ -540,12 +612,12 @@ func mergeLocalSetting(remote *oboeSettings) *oboeSettings {
 
 // mergeURLSetting merges the service level setting (merged from remote and local
 // settings) and the per-URL sampling flags, if any.
-func mergeURLSetting(setting *oboeSettings, url string) (int, settingFlag, SampleSource) {
+func (sc *oboeSettingsCfg) mergeURLSetting(setting *oboeSettings, url string) (int, settingFlag, SampleSource) {
 	if url == "" {
 		return setting.value, setting.flags, setting.source
 	}
 
-	urlTracingMode := urls.getTracingMode(url)
+	urlTracingMode := sc.urls.getTracingMode(url)
 	if urlTracingMode.isUnknown() {
 		return setting.value, setting.flags, setting.source
 	}
@@ -574,13 +646,19 @@ func adjustSampleRate(rate int64) int {
 }
 
 func updateSetting(sType int32, layer string, flags []byte, value int64, ttl int64, args map[string][]byte) {
-	ns := newOboeSettings()
+	globalSettingsCfg.updateSetting(sType, layer, flags, value, ttl, args)
+}
+
+func (sc *oboeSettingsCfg) updateSetting(sType int32, layer string, flags []byte, value int64, ttl int64, args map[string][]byte) {
+	ns := sc.newOboeSettings()
 
 	ns.timestamp = time.Now()
 	ns.source = settingType(sType).toSampleSource()
 	ns.flags = flagStringToBin(string(flags))
 	ns.originalFlags = ns.flags
 	ns.value = adjustSampleRate(value)
+	ns.originalValue = ns.value
+	ns.originalSource = ns.source
 	ns.ttl = ttl
 	ns.layer = layer
 
@@ -598,16 +676,34 @@ func updateSetting(sType int32, layer string, flags []byte, value int64, ttl int
 	tStrictCapacity := parseFloat64(args, kvTriggerTraceStrictBucketCapacity, 0)
 	ns.triggerTraceStrictBucket.setRateCap(tStrictRate, tStrictCapacity)
 
-	merged := mergeLocalSetting(ns)
+	merged := sc.mergeLocalSetting(ns)
 
 	key := oboeSettingKey{
 		sType: settingType(sType),
 		layer: layer,
 	}
 
-	globalSettingsCfg.lock.Lock()
-	globalSettingsCfg.settings[key] = merged
-	globalSettingsCfg.lock.Unlock()
+	sc.lock.Lock()
+	sc.settings[key] = merged
+	sc.lock.Unlock()
+}
+
+// remergeLocalSettings merges the local sampling config into the settings
+// retrieved from the collector again. It's called when the local config is
+// reloaded, so the change takes effect without waiting for new settings.
+func remergeLocalSettings() {
+	globalSettingsCfg.remergeLocalSettings()
+}
+
+func (sc *oboeSettingsCfg) remergeLocalSettings() {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	for key, s := range sc.settings {
+		ns := *s
+		ns.value, ns.flags, ns.source = s.originalValue, s.originalFlags, s.originalSource
+		sc.settings[key] = sc.mergeLocalSetting(&ns)
+	}
 }
 
 // Used for tests only
@@ -639,15 +735,19 @@ func (sc *oboeSettingsCfg) checkSettingsTimeout() {
 }
 
 func getSetting() (*oboeSettings, bool) {
-	globalSettingsCfg.lock.RLock()
-	defer globalSettingsCfg.lock.RUnlock()
+	return globalSettingsCfg.getSetting()
+}
+
+func (sc *oboeSettingsCfg) getSetting() (*oboeSettings, bool) {
+	sc.lock.RLock()
+	defer sc.lock.RUnlock()
 
 	// for now only look up the default settings
 	key := oboeSettingKey{
 		sType: TYPE_DEFAULT,
 		layer: "",
 	}
-	if setting, ok := globalSettingsCfg.settings[key]; ok {
+	if setting, ok := sc.settings[key]; ok {
 		return setting, true
 	}
 
@@ -667,7 +767,11 @@ func removeSetting() {
 }
 
 func hasDefaultSetting() bool {
-	if _, ok := getSetting(); ok {
+	return globalSettingsCfg.hasDefaultSetting()
+}
+
+func (sc *oboeSettingsCfg) hasDefaultSetting() bool {
+	if _, ok := sc.getSetting(); ok {
 		return true
 	}
 	return false
@@ -775,6 +879,27 @@ const (
 	FLAG_TRIGGER_TRACE         settingFlag = 1 << FlagTriggerTraceOffset
 )
 
+// String returns the flags in the format used by the collector, e.g.,
+// "SAMPLE_START,SAMPLE_THROUGH_ALWAYS". It is the reverse of flagStringToBin.
+func (f settingFlag) String() string {
+	var names []string
+	for _, flag := range []struct {
+		f    settingFlag
+		name string
+	}{
+		{FLAG_OVERRIDE, "OVERRIDE"},
+		{FLAG_SAMPLE_START, "SAMPLE_START"},
+		{FLAG_SAMPLE_THROUGH, "SAMPLE_THROUGH"},
+		{FLAG_SAMPLE_THROUGH_ALWAYS, "SAMPLE_THROUGH_ALWAYS"},
+		{FLAG_TRIGGER_TRACE, "TRIGGER_TRACE"},
+	} {
+		if f&flag.f != 0 {
+			names = append(names, flag.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
 // Enabled returns if the trace is enabled or not.
 func (f settingFlag) Enabled() bool {
 	return f&(FLAG_SAMPLE_START|FLAG_SAMPLE_THROUGH_ALWAYS) != 0
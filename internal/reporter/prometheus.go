@@ -0,0 +1,41 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter
+
+import (
+	"io"
+
+	"github.com/solarwinds/apm-go/internal/metrics"
+)
+
+// WritePrometheus writes the cumulative metrics of the global reporter in the
+// Prometheus text format, see metrics.WritePrometheus. Nothing is reset.
+func WritePrometheus(w io.Writer) error {
+	return writePrometheus(w, globalReporter, metrics.DefaultRegistry(), globalSettingsCfg)
+}
+
+// WritePrometheus writes the cumulative metrics of this reporter in the
+// Prometheus text format. Nothing is reset.
+func (i *Instance) WritePrometheus(w io.Writer) error {
+	return writePrometheus(w, i.Reporter, i.metrics, i.settings)
+}
+
+func writePrometheus(w io.Writer, rep Reporter, registry *metrics.Registry, settings *oboeSettingsCfg) error {
+	var qs *metrics.EventQueueStats
+	if r, ok := rep.(*grpcReporter); ok {
+		qs = r.conn.queueStats
+	}
+	return metrics.WritePrometheus(w, registry, qs, settings.rateCounts())
+}
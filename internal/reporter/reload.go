@@ -0,0 +1,210 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter
+
+import (
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/solarwinds/apm-go/internal/config"
+	"github.com/solarwinds/apm-go/internal/log"
+	"github.com/solarwinds/apm-go/internal/metrics"
+	"github.com/solarwinds/apm-go/internal/rand"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ReloadConfig reloads the configuration from the config file and environment
+// variables, and applies the changes which are safe at runtime: the sampling
+// config, transaction filters, transaction name rules, log level, service key,
+// flush intervals and SQL sanitize level. The changes of other items are logged as they require a
+// restart. An audit status message is sent for each reload.
+func ReloadConfig() error {
+	res, err := config.Reload()
+	if err != nil {
+		log.Warningf("Failed to reload the config, the current one is kept: %v", err)
+		sendReloadMessage(nil, err)
+		return err
+	}
+
+	applyConfigChanges(res.Applied.Keys())
+
+	if keys := res.Applied.Keys(); len(keys) != 0 {
+		log.Warningf("Config reloaded, applied changes:\n%s", res.Applied.Changes())
+	} else {
+		log.Info("Config reloaded, no changes applied.")
+	}
+	if keys := res.RestartRequired.Keys(); len(keys) != 0 {
+		log.Warningf("Config changes ignored as they require a restart:\n%s", res.RestartRequired.Changes())
+	}
+
+	sendReloadMessage(res, nil)
+	return nil
+}
+
+// applyConfigChanges applies the reloaded config items to the components of the agent.
+func applyConfigChanges(keys []string) {
+	for _, key := range keys {
+		switch key {
+		case config.ReloadServiceKey:
+			if err := globalReporter.SetServiceKey(config.GetServiceKey()); err != nil {
+				log.Warningf("Failed to apply the reloaded service key: %v", err)
+			}
+		case config.ReloadTracingMode, config.ReloadSampleRate:
+			// both items are merged at once
+			remergeLocalSettings()
+		case config.ReloadTransactionSettings:
+			ReloadURLsConfig(config.GetTransactionFiltering())
+		case config.ReloadDebugLevel:
+			log.SetLevelFromStr(config.DebugLevel())
+		case config.ReloadSQLSanitize:
+			reloadSQLSanitizers()
+		case config.ReloadMetricFlushInterval:
+			if r, ok := globalReporter.(*grpcReporter); ok {
+				i := int32(config.ReporterOpts().GetMetricFlushInterval())
+				atomic.StoreInt32(&r.collectMetricInterval, i)
+			}
+		case config.ReloadEventFlushInterval:
+			// it's read from the config for each flush
+		case config.ReloadTransactionNameCollapseIDs, config.ReloadTransactionNamePathDepth,
+			config.ReloadTransactionNameAllowList:
+			applyTransactionNameConfig(config.Global(), metrics.ApmMetrics)
+		case config.ReloadTransactionNameMax:
+			// it's read from the config for each settings update
+		}
+	}
+}
+
+// sendReloadMessage sends the audit status message of a reload
+func sendReloadMessage(res *config.ReloadResult, reloadErr error) {
+	if Closed() {
+		return
+	}
+	tid := trace.TraceID{0}
+	rand.Random(tid[:])
+	evt := NewEventWithRandomOpID(tid, time.Now())
+	evt.SetLabel(LabelUnset)
+	evt.AddKV(attribute.Bool("__ConfigReload", true))
+	if res != nil {
+		evt.AddKVs([]attribute.KeyValue{
+			attribute.String("ConfigReload.Applied", strings.Join(res.Applied.Keys(), ",")),
+			attribute.String("ConfigReload.RestartRequired", strings.Join(res.RestartRequired.Keys(), ",")),
+		})
+	}
+	if reloadErr != nil {
+		evt.AddKV(attribute.String("ConfigReload.Error", reloadErr.Error()))
+	}
+	if err := ReportStatus(evt); err != nil {
+		log.Warningf("could not send config reload message: %v", err)
+	}
+}
+
+// configWatcher reloads the config when the config file is modified or the
+// process receives SIGHUP.
+type configWatcher struct {
+	path     string
+	interval time.Duration
+	sighup   bool
+
+	modTime time.Time
+	size    int64
+
+	done     chan struct{}
+	stopOnce sync.Once
+	exited   chan struct{}
+}
+
+// WatchConfig watches the config file for changes with the interval and, if
+// sighup is true, waits for SIGHUP, and reloads the config by ReloadConfig. The
+// file is not watched if the interval is zero. It returns a function to stop
+// watching.
+func WatchConfig(interval time.Duration, sighup bool) (stop func()) {
+	w := &configWatcher{
+		path:     config.GetConfigFile(),
+		interval: interval,
+		sighup:   sighup,
+		done:     make(chan struct{}),
+		exited:   make(chan struct{}),
+	}
+	w.modTime, w.size = w.stat()
+	go w.run()
+	return w.stop
+}
+
+func (w *configWatcher) stat() (time.Time, int64) {
+	if w.path == "" {
+		return time.Time{}, 0
+	}
+	fi, err := os.Stat(w.path)
+	if err != nil {
+		return time.Time{}, 0
+	}
+	return fi.ModTime(), fi.Size()
+}
+
+// modified checks if the config file has been modified since last check.
+func (w *configWatcher) modified() bool {
+	modTime, size := w.stat()
+	if modTime.Equal(w.modTime) && size == w.size {
+		return false
+	}
+	w.modTime, w.size = modTime, size
+	return true
+}
+
+func (w *configWatcher) run() {
+	defer close(w.exited)
+
+	var tick <-chan time.Time
+	if w.interval > 0 && w.path != "" {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+		log.Infof("Watching the config file %s for changes.", w.path)
+	}
+
+	var hup chan os.Signal
+	if w.sighup {
+		hup = make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+	}
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-tick:
+			if w.modified() {
+				log.Infof("The config file %s is modified, reloading.", w.path)
+				_ = ReloadConfig()
+			}
+		case <-hup:
+			log.Info("Received SIGHUP, reloading the config.")
+			w.modified()
+			_ = ReloadConfig()
+		}
+	}
+}
+
+func (w *configWatcher) stop() {
+	w.stopOnce.Do(func() { close(w.done) })
+	<-w.exited
+}
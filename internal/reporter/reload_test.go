@@ -0,0 +1,127 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/solarwinds/apm-go/internal/config"
+	"github.com/solarwinds/apm-go/internal/metrics"
+	"github.com/solarwinds/apm-go/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeReloadTestConfig(t *testing.T, path string, sampleRate int, sqlSanitize int) {
+	content := fmt.Sprintf(`
+ServiceKey: ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go
+SQLSanitize: %d
+Sampling:
+  SampleRate: %d
+`, sqlSanitize, sampleRate)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+}
+
+func setupReloadTest(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "solarwinds-apm-config.yaml")
+	writeReloadTestConfig(t, path, 1000000, 0)
+	t.Cleanup(func() {
+		config.Load()
+		reloadSQLSanitizers()
+	})
+	t.Setenv("SW_APM_CONFIG_FILE", path)
+	config.Load()
+	reloadSQLSanitizers()
+	return path
+}
+
+func TestReloadConfig(t *testing.T) {
+	path := setupReloadTest(t)
+
+	r := SetTestReporter(TestReporterSettingType(DefaultST))
+	defer r.Close(0)
+
+	sql := "SELECT name FROM users WHERE id = 1"
+	require.Equal(t, sql, SQLSanitize(MySQL, sql))
+	dec := oboeSampleRequest(false, "url", ModeTriggerTraceNotPresent, sampledSwState)
+	require.True(t, dec.Trace())
+
+	writeReloadTestConfig(t, path, 0, 2)
+	require.NoError(t, ReloadConfig())
+
+	assert.Equal(t, "SELECT name FROM users WHERE id = ?", SQLSanitize(MySQL, sql))
+	setting, ok := getSetting()
+	require.True(t, ok)
+	assert.EqualValues(t, 0, setting.value)
+	assert.Equal(t, SAMPLE_SOURCE_FILE, setting.source)
+
+	// back to the collector's sample rate
+	writeReloadTestConfig(t, path, 1000000, 0)
+	require.NoError(t, ReloadConfig())
+	setting, ok = getSetting()
+	require.True(t, ok)
+	assert.EqualValues(t, 1000000, setting.value)
+	assert.Equal(t, sql, SQLSanitize(MySQL, sql))
+
+	// an invalid config is ignored
+	require.NoError(t, os.WriteFile(path, []byte("Sampling: ["), 0644))
+	require.Error(t, ReloadConfig())
+	assert.Equal(t, 1000000, config.GetSampleRate())
+}
+
+func TestReloadTransactionNameRules(t *testing.T) {
+	// after the config is loaded again
+	t.Cleanup(func() { applyTransactionNameConfig(config.Global(), metrics.ApmMetrics) })
+	path := setupReloadTest(t)
+
+	r := SetTestReporter(TestReporterSettingType(DefaultST))
+	defer r.Close(0)
+	require.Equal(t, utils.TransactionNameRules{}, metrics.ApmMetrics.TransactionNameRules())
+
+	require.NoError(t, os.WriteFile(path, []byte(`
+ServiceKey: ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go
+TransactionNameCollapseIDs: true
+TransactionNamePathDepth: 3
+`), 0644))
+	require.NoError(t, ReloadConfig())
+	assert.Equal(t, utils.TransactionNameRules{CollapseIDs: true, PathDepth: 3},
+		metrics.ApmMetrics.TransactionNameRules())
+}
+
+func TestWatchConfig(t *testing.T) {
+	path := setupReloadTest(t)
+
+	r := SetTestReporter(TestReporterSettingType(DefaultST))
+	defer r.Close(0)
+
+	stop := WatchConfig(10*time.Millisecond, false)
+	defer stop()
+
+	// make sure the modification time is different
+	time.Sleep(20 * time.Millisecond)
+	writeReloadTestConfig(t, path, 1000000, 2)
+	require.Eventually(t, func() bool {
+		return config.GetSQLSanitize() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	stop()
+	writeReloadTestConfig(t, path, 1000000, 1)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, config.GetSQLSanitize())
+}
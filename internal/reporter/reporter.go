@@ -19,7 +19,9 @@ import (
 	"encoding/binary"
 	"github.com/solarwinds/apm-go/internal/config"
 	"github.com/solarwinds/apm-go/internal/log"
+	"github.com/solarwinds/apm-go/internal/metrics"
 	"github.com/solarwinds/apm-go/internal/swotel/semconv"
+	"github.com/solarwinds/apm-go/internal/utils"
 	"github.com/solarwinds/apm-go/internal/w3cfmt"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"math"
@@ -34,6 +36,9 @@ type Reporter interface {
 	Shutdown(ctx context.Context) error
 	// ShutdownNow closes the Reporter immediately, logs on error
 	ShutdownNow()
+	// Flush sends out the buffered events and metrics and waits for the
+	// collector's response or the context is canceled.
+	Flush(ctx context.Context) error
 	// Closed returns if the Reporter is already closed.
 	Closed() bool
 	// WaitForReady waits until the Reporter becomes ready or the context is canceled.
@@ -77,6 +82,7 @@ func (r *nullReporter) ReportEvent(Event) error           { return nil }
 func (r *nullReporter) ReportStatus(Event) error          { return nil }
 func (r *nullReporter) Shutdown(context.Context) error    { return nil }
 func (r *nullReporter) ShutdownNow()                      {}
+func (r *nullReporter) Flush(context.Context) error       { return nil }
 func (r *nullReporter) Closed() bool                      { return true }
 func (r *nullReporter) WaitForReady(context.Context) bool { return true }
 func (r *nullReporter) SetServiceKey(string) error        { return nil }
@@ -84,8 +90,12 @@ func (r *nullReporter) GetServiceName() string            { return "" }
 
 func Start(r *resource.Resource) {
 	log.SetLevelFromStr(config.DebugLevel())
+	// the config may have been loaded again with options since init
+	ReloadURLsConfig(config.GetTransactionFiltering())
+	reloadSQLSanitizers()
+	metrics.SetHistogramPrecision(config.GetPrecision())
 	initReporter(r)
-	sendInitMessage(r)
+	sendInitMessage(globalReporter, r)
 }
 
 func initReporter(r *resource.Resource) {
@@ -96,11 +106,27 @@ func initReporter(r *resource.Resource) {
 	} else {
 		rt = config.GetReporterType()
 	}
-	otelServiceName := ""
+	setGlobalReporter(rt, otelServiceName(r))
+}
+
+// otelServiceName returns the otel `service.name` of the resource, if any.
+func otelServiceName(r *resource.Resource) string {
 	if sn, ok := r.Set().Value(semconv.ServiceNameKey); ok {
-		otelServiceName = strings.TrimSpace(sn.AsString())
+		return strings.TrimSpace(sn.AsString())
 	}
-	setGlobalReporter(rt, otelServiceName)
+	return ""
+}
+
+// applyTransactionNameConfig applies the transaction name items of the config
+// to the APM metrics: the normalisation rules of the derived names and the
+// names which are always reported. The cap is applied by the gRPC reporter
+// along with the collector's one.
+func applyTransactionNameConfig(cfg *config.Config, apm *metrics.Measurements) {
+	apm.SetTransactionNameRules(utils.TransactionNameRules{
+		CollapseIDs: cfg.GetTransactionNameCollapseIDs(),
+		PathDepth:   cfg.GetTransactionNamePathDepth(),
+	})
+	apm.SetAllowedTransactions(cfg.GetTransactionNameAllowList())
 }
 
 func setGlobalReporter(reporterType string, otelServiceName string) {
@@ -108,6 +134,7 @@ func setGlobalReporter(reporterType string, otelServiceName string) {
 	if globalReporter != nil {
 		globalReporter.ShutdownNow()
 	}
+	applyTransactionNameConfig(config.Global(), metrics.ApmMetrics)
 
 	switch strings.ToLower(reporterType) {
 	case "none":
@@ -130,6 +157,12 @@ func Shutdown(ctx context.Context) error {
 	return globalReporter.Shutdown(ctx)
 }
 
+// Flush sends out the buffered events and metrics immediately. It blocks until
+// the collector has responded or the context is canceled.
+func Flush(ctx context.Context) error {
+	return globalReporter.Flush(ctx)
+}
+
 // Closed indicates if the reporter has been shutdown
 func Closed() bool {
 	return globalReporter.Closed()
@@ -28,15 +28,16 @@ import (
 	"github.com/solarwinds/apm-go/internal/host/k8s"
 	"github.com/solarwinds/apm-go/internal/log"
 	"github.com/solarwinds/apm-go/internal/metrics"
+	"github.com/solarwinds/apm-go/internal/rand"
 	"github.com/solarwinds/apm-go/internal/uams"
 	"github.com/solarwinds/apm-go/internal/utils"
 	"io"
-	"math"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -49,6 +50,8 @@ import (
 	"context"
 
 	collector "github.com/solarwinds/apm-proto/go/collectorpb"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	uatomic "go.uber.org/atomic"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -95,6 +98,8 @@ ftgwcxyEq5SkiR+6BCwdzAMqADV37TzXDHLjwSrMIrgLV5xZM20Kk6chxI5QAr/f
 	grpcCtxTimeout                          = 10 * time.Second // gRPC method invocation timeout in seconds
 	grpcRedirectMax                         = 20               // max allowed collector redirects
 	grpcRetryLogThreshold                   = 10               // log prints after this number of retries (about 56.7s)
+	txnOverflowMessageInterval              = 10 * time.Minute // min interval between transaction name overflow messages
+	txnOverflowMessageMaxBytes              = 1024             // max length of the names in a transaction name overflow message
 	grpcMaxRetries                          = 20               // The message will be dropped after this number of retries
 )
 
@@ -122,10 +127,21 @@ type grpcConnection struct {
 	backoff Backoff
 	Dialer
 
+	redirectMax       int           // max allowed collector redirects, grpcRedirectMax if zero
+	retryLogThreshold int           // log prints after this number of retries, grpcRetryLogThreshold if zero
+	pingInterval      time.Duration // keep alive ping interval, grpcPingIntervalDefault if zero
+
+	breaker *circuitBreaker // stops calling the collector after consecutive failures, if any
+	budgets *retryBudgets   // the retry budgets by method type, if any
+
 	// This channel is closed after flushing the metrics.
 	flushed     chan struct{}
 	flushedOnce sync.Once
 	maxReqBytes int64 // the maximum size for an RPC request body
+
+	rpcStats rpcStats // the outcome of RPC calls, see Debug()
+
+	capture *CaptureWriter // the capture of the messages sent, if enabled
 }
 
 // GrpcConnOpt defines the function type that sets an option of the grpcConnection
@@ -166,6 +182,14 @@ func WithDialer(d Dialer) GrpcConnOpt {
 	}
 }
 
+// WithCapture returns a function that sets the writer capturing the messages
+// sent. The writer is closed along with the connection.
+func WithCapture(w *CaptureWriter) GrpcConnOpt {
+	return func(c *grpcConnection) {
+		c.capture = w
+	}
+}
+
 // WithBackoff return a function that sets the backoff option
 func WithBackoff(b Backoff) GrpcConnOpt {
 	return func(c *grpcConnection) {
@@ -173,6 +197,21 @@ func WithBackoff(b Backoff) GrpcConnOpt {
 	}
 }
 
+// WithRetryOptions returns a function that sets the backoff, the redirect and
+// retry limits, the keep alive ping interval, the circuit breaker and the retry
+// budgets from the reporter options
+func WithRetryOptions(o *config.ReporterOptions) GrpcConnOpt {
+	return func(c *grpcConnection) {
+		c.backoff = newBackoffFromOptions(o)
+		c.redirectMax = o.RedirectMax
+		c.retryLogThreshold = o.RetryLogThreshold
+		c.pingInterval = time.Duration(o.GetPingInterval()) * time.Second
+		c.breaker = newCircuitBreaker(o.CircuitBreakerThreshold,
+			time.Duration(o.CircuitBreakerCooldown)*time.Second)
+		c.budgets = newRetryBudgets(o.RetryBudget)
+	}
+}
+
 func newGrpcConnection(name string, target string, opts ...GrpcConnOpt) (*grpcConnection, error) {
 	gc := &grpcConnection{
 		name:        name,
@@ -180,7 +219,6 @@ func newGrpcConnection(name string, target string, opts ...GrpcConnOpt) (*grpcCo
 		connection:  nil,
 		address:     target,
 		certificate: "",
-		pingTicker:  time.NewTimer(time.Duration(grpcPingIntervalDefault) * time.Second),
 		queueStats:  &metrics.EventQueueStats{},
 		backoff:     DefaultBackoff,
 		Dialer:      &DefaultDialer{},
@@ -192,6 +230,7 @@ func newGrpcConnection(name string, target string, opts ...GrpcConnOpt) (*grpcCo
 			opt(gc)
 		}
 	}
+	gc.pingTicker = time.NewTimer(gc.getPingInterval())
 
 	err := gc.connect()
 	if err != nil {
@@ -210,6 +249,11 @@ func (c *grpcConnection) Close() {
 		}
 	}
 	c.connection = nil
+	if c.capture != nil {
+		if err := c.capture.Close(); err != nil {
+			log.Warning("error when closing the capture file; ignoring", err)
+		}
+	}
 }
 
 type grpcReporter struct {
@@ -221,9 +265,29 @@ type grpcReporter struct {
 	serviceKey      *uatomic.String // service key
 	otelServiceName string
 
+	// the config of the reporter, the sampling settings retrieved by it and
+	// the metrics reported by it. They are the global ones for the global
+	// reporter.
+	cfg      *config.Config
+	settings *oboeSettingsCfg
+	metrics  *metrics.Registry
+
 	eventMessages  chan []byte // channel for event messages (sent from agent)
 	statusMessages chan []byte // channel for status messages (sent from agent)
 
+	// channel for flush requests of the event messages, see Flush()
+	eventFlushes chan chan error
+
+	// the policy applied when the event message queue is full
+	eventQueuePolicy eventQueuePolicy
+
+	// the bytes rate limit and daily budget of the event messages
+	eventBudget *bytesBudget
+
+	// the last time a transaction name overflow message was sent. It's only
+	// accessed by the metrics goroutine.
+	lastOverflowMessage time.Time
+
 	// The reporter is considered ready if there is a valid default setting for sampling.
 	// It should be accessed atomically.
 	ready int32
@@ -248,24 +312,23 @@ var (
 	ErrReporterIsClosed       = errors.New("the reporter is closed")
 )
 
-func getProxy() string {
-	return config.GetProxy()
-}
-
-func getProxyCertPath() string {
-	return config.GetProxyCertPath()
-}
-
 // initializes a new GRPC reporter from scratch (called once on program startup)
 //
 // returns	GRPC Reporter object
 func newGRPCReporter(otelServiceName string) Reporter {
+	return newGRPCReporterWith(config.Global(), globalSettingsCfg, metrics.DefaultRegistry(), otelServiceName)
+}
+
+// newGRPCReporterWith initializes a new GRPC reporter with the config, the
+// settings store and the metrics registry provided.
+func newGRPCReporterWith(cfg *config.Config, settings *oboeSettingsCfg,
+	registry *metrics.Registry, otelServiceName string) Reporter {
 	// collector address override
-	addr := config.GetCollector()
+	addr := cfg.GetCollector()
 
 	var opts []GrpcConnOpt
 	// certificate override
-	if certPath := config.GetTrustedPath(); certPath != "" {
+	if certPath := cfg.GetTrustedPath(); certPath != "" {
 		var err error
 		cert, err := os.ReadFile(certPath)
 		if err != nil {
@@ -275,11 +338,21 @@ func newGRPCReporter(otelServiceName string) Reporter {
 		opts = append(opts, WithCert(string(cert)))
 	}
 
-	opts = append(opts, WithMaxReqBytes(config.ReporterOpts().GetMaxReqBytes()))
+	opts = append(opts, WithMaxReqBytes(cfg.GetReporter().GetMaxReqBytes()))
+	opts = append(opts, WithRetryOptions(cfg.GetReporter()))
 
-	if proxy := getProxy(); proxy != "" {
+	if proxy := cfg.GetProxy(); proxy != "" {
 		opts = append(opts, WithProxy(proxy))
-		opts = append(opts, WithProxyCertPath(getProxyCertPath()))
+		opts = append(opts, WithProxyCertPath(cfg.GetProxyCertPath()))
+	}
+
+	if path := cfg.GetCaptureFile(); path != "" {
+		if w, err := NewCaptureWriter(path); err != nil {
+			log.Errorf("Failed to create the capture file %s: %v", path, err)
+		} else {
+			log.Warningf("Capturing the messages sent to the collector into %s", path)
+			opts = append(opts, WithCapture(w))
+		}
 	}
 
 	// create connection object for events client and metrics client
@@ -296,15 +369,36 @@ func newGRPCReporter(otelServiceName string) Reporter {
 		getSettingsInterval:          grpcGetSettingsIntervalDefault,
 		settingsTimeoutCheckInterval: grpcSettingsTimeoutCheckIntervalDefault,
 
-		serviceKey:      uatomic.NewString(config.GetServiceKey()),
+		serviceKey:      uatomic.NewString(cfg.GetServiceKey()),
 		otelServiceName: otelServiceName,
 
+		cfg:      cfg,
+		settings: settings,
+		metrics:  registry,
+
 		eventMessages:  make(chan []byte, 10000),
 		statusMessages: make(chan []byte, 100),
+		eventFlushes:   make(chan chan error),
 
 		cond: sync.NewCond(&sync.Mutex{}),
 		done: make(chan struct{}),
 	}
+	ro := cfg.GetReporter()
+	r.eventQueuePolicy = newEventQueuePolicy(
+		ro.EventQueuePolicy,
+		time.Duration(ro.EventQueueBlockTimeout)*time.Millisecond,
+		r.conn.queueStats,
+		r.done,
+	)
+	r.eventBudget = newBytesBudget(
+		ro.EventBytesRateLimit,
+		ro.EventDailyBytesBudget,
+	)
+
+	if mt := cfg.GetTransactionNameMax(); mt > 0 {
+		// it takes effect from the next flush interval, as the collector's one
+		registry.ApmMetrics().SetCap(int32(mt))
+	}
 
 	r.start()
 
@@ -347,8 +441,7 @@ func (r *grpcReporter) setGracefully(flag bool) {
 
 func (r *grpcReporter) start() {
 	// start up the host observer
-	host.Start()
-	uams.Start()
+	acquireHostObservers()
 	// start up long-running goroutine eventSender() which listens on the events message channel
 	// and reports incoming events to the collector using GRPC
 	go r.eventSender()
@@ -403,8 +496,7 @@ func (r *grpcReporter) Shutdown(ctx context.Context) error {
 
 			r.closeConns()
 			r.setReady(false)
-			host.Stop()
-			uams.Stop()
+			releaseHostObservers()
 			log.Warning("SolarWinds Observability APM agent is stopped.")
 		})
 	}
@@ -483,6 +575,12 @@ func (r *grpcReporter) WaitForReady(ctx context.Context) bool {
 	}
 }
 
+func (c *grpcConnection) getAddress() string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.address
+}
+
 func (c *grpcConnection) setAddress(addr string) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
@@ -633,18 +731,51 @@ func (r *grpcReporter) periodicTasks() {
 
 type Backoff func(retries int, wait func(d time.Duration)) error
 
-// DefaultBackoff calls the wait function to sleep for a certain time based on
-// the retries value. It returns immediately if the retries exceeds a threshold.
-func DefaultBackoff(retries int, wait func(d time.Duration)) error {
-	if retries > grpcMaxRetries {
-		return errGiveUpAfterRetries
+// DefaultBackoff calls the wait function to sleep for a random time based on
+// the retries value and the default delays, see NewBackoff. It returns
+// immediately if the retries exceeds a threshold.
+var DefaultBackoff = NewBackoff(grpcRetryDelayInitial*time.Millisecond,
+	grpcRetryDelayMax*time.Second, grpcMaxRetries)
+
+// Flush sends out the events queued and the metrics collected so far without
+// waiting for the flush intervals. It blocks until the collector has responded
+// to the RPC calls or the context is canceled, and the reporter keeps running
+// afterwards.
+func (r *grpcReporter) Flush(ctx context.Context) error {
+	if r.Closed() {
+		return ErrReporterIsClosed
 	}
-	delay := int(grpcRetryDelayInitial * math.Pow(grpcRetryDelayMultiplier, float64(retries-1)))
-	if delay > grpcRetryDelayMax*1000 {
-		delay = grpcRetryDelayMax * 1000
+
+	evtFlushed := make(chan error, 1)
+	select {
+	case r.eventFlushes <- evtFlushed:
+	case <-r.done:
+		return ErrReporterIsClosed
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 
-	wait(time.Duration(delay) * time.Millisecond)
+	metricsFlushed := make(chan error, 1)
+	go func() {
+		metricsFlushed <- r.sendMetrics(r.buildMetricsMessages())
+	}()
+
+	var errs []string
+	for _, ch := range []chan error{evtFlushed, metricsFlushed} {
+		select {
+		case err := <-ch:
+			if err != nil {
+				errs = append(errs, err.Error())
+			}
+		case <-r.done:
+			return ErrReporterIsClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if len(errs) != 0 {
+		return errors.Errorf("flush: %s", strings.Join(errs, "; "))
+	}
 	return nil
 }
 
@@ -654,14 +785,11 @@ func (r *grpcReporter) ReportEvent(e Event) error {
 	if e == nil {
 		return errors.New("cannot report nil event")
 	}
-	select {
-	case r.eventMessages <- e.ToBson():
-		r.conn.queueStats.TotalEventsAdd(int64(1))
-		return nil
-	default:
-		r.conn.queueStats.NumOverflowedAdd(int64(1))
-		return errors.New("event message queue is full")
+	if err := r.eventQueuePolicy.enqueue(r.eventMessages, e.GetTraceID(), e.ToBson()); err != nil {
+		return err
 	}
+	r.conn.queueStats.TotalEventsAdd(int64(1))
+	return nil
 }
 
 func (r *grpcReporter) ReportStatus(e Event) error {
@@ -679,8 +807,15 @@ func (r *grpcReporter) ReportStatus(e Event) error {
 // eventSender is a long-running goroutine that listens on the events message
 // channel, collects all messages on that channel and attempts to send them to
 // the collector using the gRPC method PostEvents()
+// eventBatch is a batch of event messages sent in a single RPC call. The flush
+// requests are notified after the call has finished.
+type eventBatch struct {
+	messages [][]byte
+	flushed  []chan error
+}
+
 func (r *grpcReporter) eventSender() {
-	batches := make(chan [][]byte, 10)
+	batches := make(chan eventBatch, 10)
 	defer func() {
 		close(batches)
 		log.Info("eventSender goroutine exiting.")
@@ -688,7 +823,7 @@ func (r *grpcReporter) eventSender() {
 
 	go r.eventBatchSender(batches)
 
-	opts := config.ReporterOpts()
+	opts := r.cfg.GetReporter()
 	hwm := int(opts.GetMaxReqBytes())
 	if hwm <= 0 {
 		log.Warningf("The event sender is disabled by setting hwm=%d", hwm)
@@ -704,6 +839,7 @@ func (r *grpcReporter) eventSender() {
 		WithIntervalGetter(func() time.Duration {
 			return time.Second * time.Duration(opts.GetEventFlushInterval())
 		}),
+		WithFlushSignal(r.eventFlushes),
 	)
 
 	for {
@@ -720,6 +856,7 @@ func (r *grpcReporter) eventSender() {
 		//
 		// If the reporter is closing, we may have the last chance to send all
 		// the queued events.
+		flushed := evtBucket.Flushed()
 		if evtBucket.Full() {
 			c := evtBucket.Count()
 			dropped := evtBucket.DroppedCount()
@@ -729,7 +866,11 @@ func (r *grpcReporter) eventSender() {
 				log.Debugf("Pushed %d events to the sender.", c)
 			}
 
-			batches <- evtBucket.Drain()
+			batches <- eventBatch{messages: evtBucket.Drain(), flushed: flushed}
+		} else if len(flushed) != 0 {
+			// nothing to send, but the flush requests still need to wait for
+			// the batches already in the channel.
+			batches <- eventBatch{flushed: flushed}
 		}
 
 		select {
@@ -742,25 +883,26 @@ func (r *grpcReporter) eventSender() {
 	}
 }
 
-func (r *grpcReporter) eventBatchSender(batches <-chan [][]byte) {
+func (r *grpcReporter) eventBatchSender(batches <-chan eventBatch) {
 	defer func() {
 		r.conn.setFlushed()
 		log.Info("eventBatchSender goroutine exiting.")
 	}()
 
 	var closing bool
-	var messages [][]byte
 
 	for {
+		var batch eventBatch
+		var ok bool
 		// this will block until a message arrives or the reporter is closed
 		select {
-		case messages = <-batches:
-			if len(messages) == 0 {
+		case batch, ok = <-batches:
+			if !ok {
 				batches = nil
 			}
 		case <-r.done:
 			select {
-			case messages = <-batches:
+			case batch = <-batches:
 			default:
 			}
 			if !r.isGracefully() {
@@ -769,19 +911,30 @@ func (r *grpcReporter) eventBatchSender(batches <-chan [][]byte) {
 			closing = true
 		}
 
-		if len(messages) != 0 {
-			method := newPostEventsMethod(r.serviceKey.Load(), messages)
-			err := r.conn.InvokeRPC(r.done, method)
+		var err error
+		if len(batch.messages) != 0 {
+			method := newPostEventsMethod(r.serviceKey.Load(), batch.messages)
+			if err = r.eventBudget.admit(method.RequestSize(), r.done); err != nil {
+				r.conn.queueStats.NumBudgetDroppedAdd(method.MessageLen())
+				log.Debugf("eventBatchSender: %s, %d events dropped", err, method.MessageLen())
+			} else {
+				err = r.conn.InvokeRPC(r.done, method)
+			}
 
 			switch err {
 			case errInvalidServiceKey:
 				r.ShutdownNow()
 			case nil:
+				r.conn.queueStats.BytesSentAdd(method.RequestSize())
 				log.Info(method.CallSummary())
+			case errBudgetExhausted, errCircuitOpen:
 			default:
 				log.Warningf("eventBatchSender: %s", err)
 			}
 		}
+		for _, ch := range batch.flushed {
+			ch <- err
+		}
 
 		if closing {
 			return
@@ -806,30 +959,91 @@ func (r *grpcReporter) collectMetrics(collectReady chan bool) {
 	// notify caller that this routine has terminated (defered to end of routine)
 	defer func() { collectReady <- true }()
 
+	_ = r.sendMetrics(r.buildMetricsMessages())
+}
+
+// buildMetricsMessages generates the builtin and custom metrics messages and
+// resets the metrics collected.
+func (r *grpcReporter) buildMetricsMessages() [][]byte {
 	i := atomic.LoadInt32(&r.collectMetricInterval)
 
 	var messages [][]byte
+	if r.eventBudget != nil {
+		r.conn.queueStats.SetBudget(r.eventBudget.used(), r.eventBudget.limit)
+	}
 	// generate a new metrics message
-	builtin := metrics.BuildBuiltinMetricsMessage(metrics.ApmMetrics.CopyAndReset(i),
-		r.conn.queueStats.CopyAndReset(), FlushRateCounts(), config.GetRuntimeMetrics())
+	apm := r.metrics.ApmMetrics().CopyAndReset(i)
+	builtin := r.metrics.BuildBuiltinMetricsMessage(apm,
+		r.conn.queueStats.CopyAndReset(), r.settings.flushRateCounts(), r.cfg.GetRuntimeMetrics(),
+		r.cfg.GetHostMetrics())
 	if builtin != nil {
 		messages = append(messages, builtin)
 	}
+	if apm != nil {
+		if names := apm.OverflowedTransactions(); len(names) != 0 {
+			r.sendTransactionOverflowMessage(names)
+		}
+	}
 
-	custom := metrics.BuildMessage(metrics.CustomMetrics.CopyAndReset(i), false)
+	custom := r.metrics.BuildCustomMetricsMessage(i)
 	if custom != nil {
 		messages = append(messages, custom)
 	}
+	return messages
+}
+
+// sendTransactionOverflowMessage sends the debug status message of the
+// transaction names which have been reported as "other" in a flush interval.
+// It sends at most one message every txnOverflowMessageInterval and the names
+// are truncated to txnOverflowMessageMaxBytes.
+func (r *grpcReporter) sendTransactionOverflowMessage(names []string) {
+	joined := joinNames(names, txnOverflowMessageMaxBytes)
+	log.Debugf("Transaction names reported as %s: %s", metrics.OtherTransactionName, joined)
+	now := time.Now()
+	if !r.lastOverflowMessage.IsZero() && now.Sub(r.lastOverflowMessage) < txnOverflowMessageInterval {
+		return
+	}
+	r.lastOverflowMessage = now
 
-	r.sendMetrics(messages)
+	tid := trace.TraceID{0}
+	rand.Random(tid[:])
+	evt := NewEventWithRandomOpID(tid, now)
+	evt.SetLabel(LabelUnset)
+	evt.AddKVs([]attribute.KeyValue{
+		attribute.Bool("__TransactionNameOverflow", true),
+		attribute.String("TransactionNameOverflow.Names", joined),
+	})
+	if err := r.ReportStatus(evt); err != nil {
+		log.Warningf("could not send transaction name overflow message: %v", err)
+	}
+}
+
+// joinNames joins the names with commas, dropping the names which don't fit
+// in limit bytes.
+func joinNames(names []string, limit int) string {
+	var b strings.Builder
+	for _, name := range names {
+		n := len(name)
+		if b.Len() > 0 {
+			n++
+		}
+		if b.Len()+n > limit {
+			break
+		}
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+	}
+	return b.String()
 }
 
 // listens on the metrics message channel, collects all messages on that channel and
 // attempts to send them to the collector using the GRPC method PostMetrics()
-func (r *grpcReporter) sendMetrics(msgs [][]byte) {
+func (r *grpcReporter) sendMetrics(msgs [][]byte) error {
 	// no messages on the channel so nothing to send, return
 	if len(msgs) == 0 {
-		return
+		return nil
 	}
 
 	method := newPostMetricsMethod(r.serviceKey.Load(), msgs)
@@ -843,6 +1057,7 @@ func (r *grpcReporter) sendMetrics(msgs [][]byte) {
 	default:
 		log.Warningf("sendMetrics: %s", err)
 	}
+	return err
 }
 
 // ================================ Settings Handling ====================================
@@ -875,26 +1090,31 @@ func (r *grpcReporter) getSettings(ready chan bool) {
 // settings	new settings
 func (r *grpcReporter) updateSettings(settings *collector.SettingsResult) {
 	for _, s := range settings.GetSettings() {
-		updateSetting(int32(s.Type), string(s.Layer), s.Flags, s.Value, s.Ttl, s.Arguments)
+		r.settings.updateSetting(int32(s.Type), string(s.Layer), s.Flags, s.Value, s.Ttl, s.Arguments)
 
 		// update MetricsFlushInterval
 		mi := parseInt32(s.Arguments, kvMetricsFlushInterval, r.collectMetricInterval)
 		atomic.StoreInt32(&r.collectMetricInterval, mi)
 
 		// update events flush interval
-		o := config.ReporterOpts()
+		o := r.cfg.GetReporter()
 		ei := parseInt32(s.Arguments, kvEventsFlushInterval, int32(o.GetEventFlushInterval()))
 		o.SetEventFlushInterval(int64(ei))
 
 		// update MaxTransactions
-		mt := parseInt32(s.Arguments, kvMaxTransactions, metrics.ApmMetrics.Cap())
-		metrics.ApmMetrics.SetCap(mt)
+		apm, custom := r.metrics.ApmMetrics(), r.metrics.CustomMetrics()
+		mt := parseInt32(s.Arguments, kvMaxTransactions, apm.Cap())
+		if max := int32(r.cfg.GetTransactionNameMax()); max > 0 && max < mt {
+			// the configured maximum can only lower the collector's one
+			mt = max
+		}
+		apm.SetCap(mt)
 
-		maxCustomMetrics := parseInt32(s.Arguments, kvMaxCustomMetrics, metrics.CustomMetrics.Cap())
-		metrics.CustomMetrics.SetCap(maxCustomMetrics)
+		maxCustomMetrics := parseInt32(s.Arguments, kvMaxCustomMetrics, custom.Cap())
+		custom.SetCap(maxCustomMetrics)
 	}
 
-	if !r.isReady() && hasDefaultSetting() {
+	if !r.isReady() && r.settings.hasDefaultSetting() {
 		r.cond.L.Lock()
 		r.setReady(true)
 		log.Warningf("Got dynamic settings. The SolarWinds Observability APM agent (%v) is ready.", r.done)
@@ -909,8 +1129,8 @@ func (r *grpcReporter) checkSettingsTimeout(ready chan bool) {
 	// notify caller that this routine has terminated (defered to end of routine)
 	defer func() { ready <- true }()
 
-	OboeCheckSettingsTimeout()
-	if r.isReady() && !hasDefaultSetting() {
+	r.settings.checkSettingsTimeout()
+	if r.isReady() && !r.settings.hasDefaultSetting() {
 		log.Warningf("Sampling setting expired. SolarWinds Observability APM library (%v) is not working.", r.done)
 		r.setReady(false)
 	}
@@ -985,10 +1205,31 @@ func (c *grpcConnection) resetPing() {
 	}
 	c.pingTickerLock.Lock()
 	// TODO: Reset may run into a race condition
-	c.pingTicker.Reset(time.Duration(grpcPingIntervalDefault) * time.Second)
+	c.pingTicker.Reset(c.getPingInterval())
 	c.pingTickerLock.Unlock()
 }
 
+func (c *grpcConnection) getPingInterval() time.Duration {
+	if c.pingInterval <= 0 {
+		return time.Duration(grpcPingIntervalDefault) * time.Second
+	}
+	return c.pingInterval
+}
+
+func (c *grpcConnection) getRedirectMax() int {
+	if c.redirectMax <= 0 {
+		return grpcRedirectMax
+	}
+	return c.redirectMax
+}
+
+func (c *grpcConnection) getRetryLogThreshold() int {
+	if c.retryLogThreshold <= 0 {
+		return grpcRetryLogThreshold
+	}
+	return c.retryLogThreshold
+}
+
 // send a keep alive (ping) request on a given GRPC connection
 func (c *grpcConnection) ping(exit chan struct{}, key string) error {
 	method := newPingMethod(key, c.name)
@@ -1025,6 +1266,14 @@ var (
 	// when an RPC call is timeout.
 	errConnStale     = errors.New("connection is stale")
 	errRequestTooBig = errors.New("RPC request is too big")
+
+	// The circuit breaker is open after consecutive failed calls and the
+	// message is dropped without calling the collector.
+	errCircuitOpen = errors.New("circuit breaker is open")
+
+	// The retry budget of the method type is used up by the failed calls and
+	// the message is dropped without retrying.
+	errRetryBudgetExhausted = errors.New("retry budget exhausted")
 )
 
 // InvokeRPC makes an RPC call and returns an error if something is broken and
@@ -1046,6 +1295,32 @@ func (c *grpcConnection) InvokeRPC(exit chan struct{}, m Method) error {
 	retriesNum := 0
 
 	printRPCMsg(m)
+	if c.capture != nil {
+		if err := c.capture.Write(m); err != nil {
+			log.Warningf("[%s] failed to capture %s: %v", c.name, m, err)
+		}
+	}
+
+	// The circuit breaker counts the outcome of the RPC rather than each of
+	// its attempts, so that a transient error retried successfully doesn't
+	// count as a failure.
+	breaker := c.breakerFor(m)
+	if !breaker.allow() {
+		c.queueStats.NumFailedAdd(m.MessageLen())
+		return errCircuitOpen
+	}
+	// whether the collector was called, and was available the last time
+	called, available := false, false
+	defer func() {
+		switch {
+		case !called:
+			breaker.cancel()
+		case available:
+			breaker.success()
+		default:
+			breaker.failure()
+		}
+	}()
 
 	for {
 		// Fail-fast in case the reporter has been closed, avoid retrying in
@@ -1068,11 +1343,12 @@ func (c *grpcConnection) InvokeRPC(exit chan struct{}, m Method) error {
 				v := fmt.Sprintf("%d|%d", m.RequestSize(), c.maxReqBytes)
 				err = errors.Wrap(errRequestTooBig, v)
 			} else {
-				if m.ServiceKey() != "" {
-					err = m.Call(ctx, c.client)
-				} else {
+				if m.ServiceKey() == "" {
 					err = nil
 					log.Infof("%s is skipped as no service key is assigned.", m.String())
+				} else {
+					err = m.Call(ctx, c.client)
+					called = true
 				}
 			}
 
@@ -1091,24 +1367,33 @@ func (c *grpcConnection) InvokeRPC(exit chan struct{}, m Method) error {
 		// the keepalive timer
 		c.resetPing()
 
+		// whether the collector redirected the call, which is retried
+		// without delay and without spending the retry budget
+		redirected := false
+
 		if err != nil {
 			// gRPC handles the reconnection automatically.
 			failsNum++
-			if failsNum == grpcRetryLogThreshold {
+			available = false
+			if failsNum == c.getRetryLogThreshold() {
 				log.Warningf("[%s] invocation error: %v.", m, err)
 			} else {
 				log.Debugf("[%s] (%v) invocation error: %v.", m, failsNum, err)
 			}
 		} else {
-			if failsNum >= grpcRetryLogThreshold {
+			if failsNum >= c.getRetryLogThreshold() {
 				log.Warningf("[%s] error recovered.", m)
 			}
 			failsNum = 0
 
 			// server responded, check the result code and perform actions accordingly
-			switch result, _ := m.ResultCode(); result {
+			result, _ := m.ResultCode()
+			available = collectorAvailable(result)
+			switch result {
 			case collector.ResultCode_OK:
 				c.queueStats.NumSentAdd(m.MessageLen())
+				c.rpcStats.succeeded(m)
+				c.budgets.success(m)
 				return nil
 
 			case collector.ResultCode_TRY_LATER:
@@ -1124,12 +1409,13 @@ func (c *grpcConnection) InvokeRPC(exit chan struct{}, m Method) error {
 				log.Warning(m.CallSummary())
 				redirects++
 
-				if redirects > grpcRedirectMax {
+				if redirects > c.getRedirectMax() {
 					return errTooManyRedirections
 				} else if m.Arg() != "" {
 					c.setAddress(m.Arg())
 					// a proper redirect shouldn't cause delays
 					retriesNum = 0
+					redirected = true
 				} else {
 					log.Warning(errors.Wrap(errInvalidRedirectTarget, c.name))
 				}
@@ -1138,6 +1424,9 @@ func (c *grpcConnection) InvokeRPC(exit chan struct{}, m Method) error {
 			}
 		}
 
+		// the outcome of the call, as err is overwritten by reconnect
+		callErr := err
+
 		if !c.isActive() {
 			if err = c.reconnect(); err != nil {
 				return err
@@ -1152,7 +1441,13 @@ func (c *grpcConnection) InvokeRPC(exit chan struct{}, m Method) error {
 			}
 		}
 
+		if !redirected && !c.budgets.retry(m) {
+			log.Debugf("[%s] %v: %v.", m, errRetryBudgetExhausted, callErr)
+			return errRetryBudgetExhausted
+		}
+
 		retriesNum++
+		c.rpcStats.retried(m, callErr)
 		err = c.backoff(retriesNum, func(d time.Duration) {
 			time.Sleep(d)
 		})
@@ -1162,6 +1457,31 @@ func (c *grpcConnection) InvokeRPC(exit chan struct{}, m Method) error {
 	}
 }
 
+// breakerFor returns the circuit breaker of the method, if any. The settings
+// and the pings are exempt so that they keep probing the collector and the
+// agent keeps getting the settings while the breaker is open.
+func (c *grpcConnection) breakerFor(m Method) *circuitBreaker {
+	switch methodType(m) {
+	case "GetSettings", "Ping":
+		return nil
+	default:
+		return c.breaker
+	}
+}
+
+// collectorAvailable reports whether the result of a call to the collector
+// shows it is available. It is deemed unavailable if it asked to try later.
+func collectorAvailable(code collector.ResultCode) bool {
+	switch code {
+	case collector.ResultCode_OK,
+		collector.ResultCode_INVALID_API_KEY,
+		collector.ResultCode_REDIRECT:
+		return true
+	default:
+		return false
+	}
+}
+
 func (c *grpcConnection) setFlushed() {
 	c.flushedOnce.Do(func() { close(c.flushed) })
 }
@@ -1295,20 +1615,14 @@ func (d *DefaultDialer) Dial(p DialParams) (*grpc.ClientConn, error) {
 }
 
 func newGRPCProxyDialer(p DialParams) func(context.Context, string) (net.Conn, error) {
-	return func(ctx context.Context, addr string) (conn net.Conn, err error) {
-		defer func() {
-			if err != nil && conn != nil {
-				if err2 := conn.Close(); err2 != nil {
-					log.Warning("error when closing connection", err2)
-				}
-			}
-		}()
-
+	return func(ctx context.Context, addr string) (net.Conn, error) {
 		proxy, err := url.Parse(p.Proxy)
 		if err != nil {
 			return nil, errors.Wrap(err, "error parsing the proxy url")
 		}
 
+		var conn net.Conn
+		handshake := httpConnectHandshake
 		if proxy.Scheme == "https" {
 			cert, err := os.ReadFile(p.ProxyCertPath)
 			if err != nil {
@@ -1328,11 +1642,25 @@ func newGRPCProxyDialer(p DialParams) func(context.Context, string) (net.Conn, e
 			if err != nil {
 				return nil, errors.Wrap(err, "failed to dial the http proxy")
 			}
+		} else if proxy.Scheme == "socks5" {
+			conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", proxy.Host)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to dial the socks5 proxy")
+			}
+			handshake = socks5Handshake
 		} else {
 			return nil, fmt.Errorf("proxy scheme not supported: %s", proxy.Scheme)
 		}
 
-		return httpConnectHandshake(ctx, conn, addr, proxy)
+		c, err := handshake(ctx, conn, addr, proxy)
+		if err != nil {
+			// the handshake returns no connection on failure, close the dialled one
+			if err2 := conn.Close(); err2 != nil {
+				log.Warning("error when closing connection", err2)
+			}
+			return nil, err
+		}
+		return c, nil
 	}
 }
 
@@ -1370,6 +1698,142 @@ func httpConnectHandshake(ctx context.Context, conn net.Conn, server string, pro
 	return &bufConn{Conn: conn, r: r}, nil
 }
 
+// SOCKS5 protocol constants, see RFC 1928 and RFC 1929
+const (
+	socks5Version          = 0x05
+	socks5AuthNone         = 0x00
+	socks5AuthUserPass     = 0x02
+	socks5AuthNoAcceptable = 0xff
+	socks5UserPassVersion  = 0x01
+	socks5CmdConnect       = 0x01
+	socks5AddrIPv4         = 0x01
+	socks5AddrDomain       = 0x03
+	socks5AddrIPv6         = 0x04
+	socks5ReplySucceeded   = 0x00
+)
+
+// socks5Handshake negotiates a CONNECT tunnel to the server through a SOCKS5
+// proxy. Host names are sent to the proxy as-is so that DNS resolution happens
+// at the proxy side.
+func socks5Handshake(ctx context.Context, conn net.Conn, server string, proxy *url.URL) (net.Conn, error) {
+	if d, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(d); err != nil {
+			return nil, errors.Wrap(err, "failed to set the socks5 handshake deadline")
+		}
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	hostStr, portStr, err := net.SplitHostPort(server)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid socks5 target address")
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 0xffff {
+		return nil, fmt.Errorf("invalid socks5 target port: %s", portStr)
+	}
+
+	methods := []byte{socks5AuthNone}
+	if proxy.User != nil {
+		methods = append(methods, socks5AuthUserPass)
+	}
+	greeting := append([]byte{socks5Version, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return nil, errors.Wrap(err, "failed to write the socks5 greeting")
+	}
+
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, errors.Wrap(err, "failed to read the socks5 greeting response")
+	}
+	if buf[0] != socks5Version {
+		return nil, fmt.Errorf("unexpected socks5 version: %d", buf[0])
+	}
+
+	switch buf[1] {
+	case socks5AuthNone:
+	case socks5AuthUserPass:
+		if proxy.User == nil {
+			return nil, errors.New("socks5 proxy requires authentication")
+		}
+		u := proxy.User.Username()
+		p, _ := proxy.User.Password()
+		if len(u) > 255 || len(p) > 255 {
+			return nil, errors.New("socks5 username or password is too long")
+		}
+		req := []byte{socks5UserPassVersion, byte(len(u))}
+		req = append(req, u...)
+		req = append(req, byte(len(p)))
+		req = append(req, p...)
+		if _, err := conn.Write(req); err != nil {
+			return nil, errors.Wrap(err, "failed to write the socks5 auth request")
+		}
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, errors.Wrap(err, "failed to read the socks5 auth response")
+		}
+		if buf[0] != socks5UserPassVersion {
+			return nil, fmt.Errorf("unexpected socks5 auth version: %d", buf[0])
+		}
+		if buf[1] != 0x00 {
+			return nil, errors.New("socks5 authentication failed")
+		}
+	case socks5AuthNoAcceptable:
+		return nil, errors.New("no acceptable socks5 authentication methods")
+	default:
+		return nil, fmt.Errorf("unsupported socks5 authentication method: %d", buf[1])
+	}
+
+	req := []byte{socks5Version, socks5CmdConnect, 0x00}
+	if ip := net.ParseIP(hostStr); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, socks5AddrIPv4)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, socks5AddrIPv6)
+			req = append(req, ip.To16()...)
+		}
+	} else {
+		if len(hostStr) > 255 {
+			return nil, fmt.Errorf("socks5 target host name is too long: %s", hostStr)
+		}
+		req = append(req, socks5AddrDomain, byte(len(hostStr)))
+		req = append(req, hostStr...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return nil, errors.Wrap(err, "failed to write the socks5 connect request")
+	}
+
+	// VER, REP, RSV, ATYP
+	hdr := make([]byte, 4)
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		return nil, errors.Wrap(err, "failed to read the socks5 connect response")
+	}
+	if hdr[1] != socks5ReplySucceeded {
+		return nil, fmt.Errorf("socks5 connect failed, reply code: %d", hdr[1])
+	}
+
+	// Skip the bound address and port, we don't need them.
+	var l int
+	switch hdr[3] {
+	case socks5AddrIPv4:
+		l = net.IPv4len
+	case socks5AddrIPv6:
+		l = net.IPv6len
+	case socks5AddrDomain:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return nil, errors.Wrap(err, "failed to read the socks5 bound address")
+		}
+		l = int(buf[0])
+	default:
+		return nil, fmt.Errorf("unknown socks5 address type: %d", hdr[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, l+2)); err != nil {
+		return nil, errors.Wrap(err, "failed to read the socks5 bound address")
+	}
+
+	return conn, nil
+}
+
 type bufConn struct {
 	net.Conn
 	r io.Reader
@@ -19,6 +19,8 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"github.com/solarwinds/apm-go/internal/config"
+	"github.com/solarwinds/apm-go/internal/metrics"
 	"github.com/solarwinds/apm-go/internal/utils"
 	"io"
 	"net"
@@ -158,6 +160,11 @@ type TestProxyServer struct {
 	pemFile   string
 	keyFile   string
 	closeFunc func() error
+
+	// the targets requested by the socks5 clients, in the form received
+	// by the proxy
+	mutex   sync.Mutex
+	targets []string
 }
 
 func NewTestProxyServer(rawUrl string, pem, key string) (*TestProxyServer, error) {
@@ -181,7 +188,13 @@ func (p *TestProxyServer) Start() error {
 	case "https":
 		go srv.ListenAndServeTLS(p.pemFile, p.keyFile)
 		p.closeFunc = closeFunc
-	// TODO: case "socks5":
+	case "socks5":
+		lis, err := net.Listen("tcp", p.url.Host)
+		if err != nil {
+			return errors.Wrap(err, "failed to start socks5 proxy")
+		}
+		go p.serveSocks5(lis)
+		p.closeFunc = lis.Close
 	default:
 		panic(fmt.Sprintf("Unsupported proxy type: %s", p.url.Scheme))
 	}
@@ -237,6 +250,137 @@ func (p *TestProxyServer) proxyHttpHandler(w http.ResponseWriter, r *http.Reques
 	go forward(clientConn, serverConn)
 }
 
+// Targets returns the targets requested by the socks5 clients.
+func (p *TestProxyServer) Targets() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]string(nil), p.targets...)
+}
+
+func (p *TestProxyServer) serveSocks5(lis net.Listener) {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		go p.socks5Handler(conn)
+	}
+}
+
+// socks5Handler implements the server side of a SOCKS5 CONNECT with optional
+// username/password authentication. Domain names are resolved by the proxy.
+func (p *TestProxyServer) socks5Handler(clientConn net.Conn) {
+	fail := func() { clientConn.Close() }
+
+	buf := make([]byte, 256)
+	if _, err := io.ReadFull(clientConn, buf[:2]); err != nil || buf[0] != 5 {
+		fail()
+		return
+	}
+	methods := make([]byte, buf[1])
+	if _, err := io.ReadFull(clientConn, methods); err != nil {
+		fail()
+		return
+	}
+
+	expectedUser := p.url.User.Username()
+	expectedPwd, _ := p.url.User.Password()
+	method := byte(0xff)
+	for _, m := range methods {
+		if (m == 0x02 && p.url.User != nil) || (m == 0x00 && p.url.User == nil) {
+			method = m
+		}
+	}
+	clientConn.Write([]byte{5, method})
+	if method == 0xff {
+		fail()
+		return
+	}
+
+	if method == 0x02 {
+		if _, err := io.ReadFull(clientConn, buf[:2]); err != nil {
+			fail()
+			return
+		}
+		user := make([]byte, buf[1])
+		if _, err := io.ReadFull(clientConn, user); err != nil {
+			fail()
+			return
+		}
+		if _, err := io.ReadFull(clientConn, buf[:1]); err != nil {
+			fail()
+			return
+		}
+		pwd := make([]byte, buf[0])
+		if _, err := io.ReadFull(clientConn, pwd); err != nil {
+			fail()
+			return
+		}
+		if subtle.ConstantTimeCompare(user, []byte(expectedUser)) != 1 ||
+			subtle.ConstantTimeCompare(pwd, []byte(expectedPwd)) != 1 {
+			clientConn.Write([]byte{1, 1})
+			fail()
+			return
+		}
+		clientConn.Write([]byte{1, 0})
+	}
+
+	if _, err := io.ReadFull(clientConn, buf[:4]); err != nil || buf[1] != 1 {
+		fail()
+		return
+	}
+	var host string
+	switch buf[3] {
+	case 1:
+		if _, err := io.ReadFull(clientConn, buf[:4]); err != nil {
+			fail()
+			return
+		}
+		host = net.IP(buf[:4]).String()
+	case 3:
+		if _, err := io.ReadFull(clientConn, buf[:1]); err != nil {
+			fail()
+			return
+		}
+		l := int(buf[0])
+		if _, err := io.ReadFull(clientConn, buf[:l]); err != nil {
+			fail()
+			return
+		}
+		host = string(buf[:l])
+	case 4:
+		if _, err := io.ReadFull(clientConn, buf[:16]); err != nil {
+			fail()
+			return
+		}
+		host = net.IP(buf[:16]).String()
+	default:
+		fail()
+		return
+	}
+	if _, err := io.ReadFull(clientConn, buf[:2]); err != nil {
+		fail()
+		return
+	}
+	target := net.JoinHostPort(host, fmt.Sprint(int(buf[0])<<8|int(buf[1])))
+
+	p.mutex.Lock()
+	p.targets = append(p.targets, target)
+	p.mutex.Unlock()
+
+	serverConn, err := net.DialTimeout("tcp", target, 1*time.Second)
+	if err != nil {
+		// host unreachable
+		clientConn.Write([]byte{5, 4, 0, 1, 0, 0, 0, 0, 0, 0})
+		fail()
+		return
+	}
+	clientConn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	go forward(serverConn, clientConn)
+	go forward(clientConn, serverConn)
+}
+
 func forward(dst io.WriteCloser, src io.ReadCloser) {
 	defer func() {
 		dst.Close()
@@ -250,3 +394,33 @@ func TestAppopticsCertificate(t *testing.T) {
 	cert := []byte(legacyAOcertificate)
 	require.True(t, certPool.AppendCertsFromPEM(cert))
 }
+
+func TestUpdateSettingsMaxTransactions(t *testing.T) {
+	for _, tc := range []struct {
+		configured int
+		expected   int32
+	}{
+		{0, 200},   // the collector's maximum
+		{50, 50},   // the configured one is lower
+		{500, 200}, // the configured one can't raise the collector's
+	} {
+		cfg := config.NewConfig(config.WithTransactionNameRules(tc.configured, false, 0))
+		registry := metrics.NewRegistry()
+		r := &grpcReporter{
+			cfg:      cfg,
+			settings: newOboeSettingsCfg(cfg, nil),
+			metrics:  registry,
+			cond:     sync.NewCond(&sync.Mutex{}),
+		}
+		r.updateSettings(&pb.SettingsResult{Settings: []*pb.OboeSetting{{
+			Type:      pb.OboeSettingType_DEFAULT_SAMPLE_RATE,
+			Flags:     []byte("SAMPLE_START"),
+			Value:     1000000,
+			Ttl:       120,
+			Arguments: argsToMap(-1, -1, -1, -1, -1, -1, -1, 200, nil),
+		}}})
+		// it takes effect from the next flush interval
+		registry.ApmMetrics().CopyAndReset(60)
+		assert.Equal(t, tc.expected, registry.ApmMetrics().Cap(), tc.configured)
+	}
+}
@@ -0,0 +1,257 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/solarwinds/apm-go/internal/config"
+	"github.com/solarwinds/apm-go/internal/log"
+	"github.com/solarwinds/apm-go/internal/rand"
+)
+
+// NewBackoff returns a Backoff with full jitter: the delay of a retry is a
+// random duration between zero and the exponential backoff, i.e. the initial
+// delay multiplied by grpcRetryDelayMultiplier for each previous retry and
+// capped at max. It gives up after maxRetries retries.
+func NewBackoff(initial time.Duration, max time.Duration, maxRetries int) Backoff {
+	return func(retries int, wait func(d time.Duration)) error {
+		if retries > maxRetries {
+			return errGiveUpAfterRetries
+		}
+		wait(jitter(backoffCap(initial, max, retries)))
+		return nil
+	}
+}
+
+// backoffCap returns the upper bound of the delay of the retry.
+func backoffCap(initial time.Duration, max time.Duration, retries int) time.Duration {
+	d := float64(initial) * math.Pow(grpcRetryDelayMultiplier, float64(retries-1))
+	if d > float64(max) {
+		return max
+	}
+	return time.Duration(d)
+}
+
+// jitter returns a random duration in [0, d], in milliseconds.
+func jitter(d time.Duration) time.Duration {
+	ms := int(d / time.Millisecond)
+	if ms <= 0 {
+		return 0
+	}
+	return time.Duration(rand.RandIntn(ms+1)) * time.Millisecond
+}
+
+// newBackoffFromOptions returns the backoff configured by the reporter options.
+func newBackoffFromOptions(o *config.ReporterOptions) Backoff {
+	return NewBackoff(
+		time.Duration(o.GetRetryDelayInitial())*time.Millisecond,
+		time.Duration(o.RetryDelayMax)*time.Second,
+		o.MaxRetries)
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker stops calling the collector after a number of consecutive
+// failed calls. Once open, it rejects the calls for the cooldown period and
+// then lets a single call through (half-open) to probe the collector: the
+// breaker is closed if it succeeds and opened again otherwise.
+//
+// A nil *circuitBreaker allows all the calls.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	lock     sync.Mutex
+	state    circuitState
+	failures int       // the consecutive failures
+	openedAt time.Time // the time the breaker was opened
+	probing  bool      // a probe call is in flight
+}
+
+// newCircuitBreaker returns a breaker which opens after threshold consecutive
+// failures, or nil if the threshold is zero.
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// allow reports whether a call can be made. The caller must report the
+// outcome of the call with success or failure if it is allowed.
+func (b *circuitBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = circuitHalfOpen
+		b.probing = true
+		return true
+	case circuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// success records a successful call and closes the breaker.
+func (b *circuitBreaker) success() {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.state != circuitClosed {
+		log.Warning("The collector is reachable again, circuit breaker closed.")
+	}
+	b.state = circuitClosed
+	b.failures = 0
+	b.probing = false
+}
+
+// failure records a failed call. It opens the breaker once the threshold is
+// reached, or if the probe call failed.
+func (b *circuitBreaker) failure() {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.failures++
+	b.probing = false
+	if b.state == circuitHalfOpen || (b.state == circuitClosed && b.failures >= b.threshold) {
+		if b.state == circuitClosed {
+			log.Warningf("Circuit breaker opened after %d consecutive failed calls, "+
+				"the requests are dropped for %v.", b.failures, b.cooldown)
+		}
+		b.state = circuitOpen
+		b.openedAt = b.now()
+	}
+}
+
+// cancel releases a call allowed by allow without recording its outcome, e.g.
+// if the collector was not called in the end.
+func (b *circuitBreaker) cancel() {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.probing = false
+}
+
+// getState returns the state of the breaker.
+func (b *circuitBreaker) getState() circuitState {
+	if b == nil {
+		return circuitClosed
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state
+}
+
+// retryBudgetRefill is the number of tokens earned back by a successful call.
+const retryBudgetRefill = 0.1
+
+// retryBudgets throttles the retries of each method type, so that a failing
+// type of request, e.g. PostEvents, does not use up the retries of the others.
+// Each failed call spends a token of its method type, each successful call
+// earns back retryBudgetRefill tokens and no retries are made while less than
+// one token is left.
+//
+// A nil *retryBudgets allows all the retries.
+type retryBudgets struct {
+	max    float64
+	lock   sync.Mutex
+	tokens map[string]float64
+}
+
+// newRetryBudgets returns the budgets with max tokens for each method type,
+// or nil if max is zero.
+func newRetryBudgets(max int) *retryBudgets {
+	if max <= 0 {
+		return nil
+	}
+	return &retryBudgets{
+		max:    float64(max),
+		tokens: make(map[string]float64),
+	}
+}
+
+func (b *retryBudgets) get(m Method) float64 {
+	t, ok := b.tokens[methodType(m)]
+	if !ok {
+		return b.max
+	}
+	return t
+}
+
+// success records a successful call of the method.
+func (b *retryBudgets) success(m Method) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.tokens[methodType(m)] = math.Min(b.max, b.get(m)+retryBudgetRefill)
+}
+
+// retry records a failed call of the method and reports whether it can be
+// retried.
+func (b *retryBudgets) retry(m Method) bool {
+	if b == nil {
+		return true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	t := math.Max(0, b.get(m)-1)
+	b.tokens[methodType(m)] = t
+	return t >= 1
+}
@@ -0,0 +1,283 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/solarwinds/apm-go/internal/config"
+	"github.com/solarwinds/apm-go/internal/metrics"
+	"github.com/solarwinds/apm-go/internal/reporter/mocks"
+	pb "github.com/solarwinds/apm-proto/go/collectorpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBackoff(t *testing.T) {
+	b := NewBackoff(100*time.Millisecond, time.Second, 5)
+	caps := []time.Duration{100, 150, 225, 337, 506}
+	for i, c := range caps {
+		var d time.Duration
+		require.NoError(t, b(i+1, func(w time.Duration) { d = w }))
+		assert.GreaterOrEqual(t, d, time.Duration(0))
+		assert.LessOrEqual(t, d, c*time.Millisecond, i)
+	}
+	assert.Equal(t, time.Second, backoffCap(100*time.Millisecond, time.Second, 10))
+	assert.Equal(t, errGiveUpAfterRetries, b(6, func(time.Duration) {}))
+
+	// the delays are spread out rather than in lockstep
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 20; i++ {
+		_ = b(5, func(w time.Duration) { seen[w] = true })
+	}
+	assert.Greater(t, len(seen), 1)
+
+	// no retries
+	assert.Equal(t, errGiveUpAfterRetries, NewBackoff(time.Second, time.Second, 0)(1, func(time.Duration) {}))
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	b := newCircuitBreaker(3, 10*time.Second)
+	b.now = func() time.Time { return now }
+
+	// opens after consecutive failures
+	b.failure()
+	b.failure()
+	b.success()
+	b.failure()
+	b.failure()
+	assert.True(t, b.allow())
+	assert.Equal(t, circuitClosed, b.getState())
+	b.failure()
+	assert.Equal(t, circuitOpen, b.getState())
+	assert.False(t, b.allow())
+
+	// a single probe after the cooldown, which fails
+	now = now.Add(10 * time.Second)
+	assert.True(t, b.allow())
+	assert.Equal(t, circuitHalfOpen, b.getState())
+	assert.False(t, b.allow())
+	b.failure()
+	assert.Equal(t, circuitOpen, b.getState())
+	assert.False(t, b.allow())
+
+	// the next probe succeeds
+	now = now.Add(10 * time.Second)
+	assert.True(t, b.allow())
+	b.success()
+	assert.Equal(t, circuitClosed, b.getState())
+	assert.True(t, b.allow())
+	assert.True(t, b.allow())
+
+	// disabled
+	var nb *circuitBreaker
+	assert.Nil(t, newCircuitBreaker(0, time.Second))
+	for i := 0; i < 10; i++ {
+		nb.failure()
+	}
+	assert.True(t, nb.allow())
+	assert.Equal(t, circuitClosed, nb.getState())
+}
+
+func TestRetryBudgets(t *testing.T) {
+	b := newRetryBudgets(3)
+	events := newPostEventsMethod(TestServiceKey, nil)
+	metrics := newPostMetricsMethod(TestServiceKey, nil)
+
+	assert.True(t, b.retry(events))
+	assert.True(t, b.retry(events))
+	assert.False(t, b.retry(events))
+	assert.False(t, b.retry(events))
+
+	// the budgets are separate
+	assert.True(t, b.retry(metrics))
+
+	// the successful calls earn back the budget
+	for i := 0; i < 25; i++ {
+		b.success(events)
+	}
+	assert.True(t, b.retry(events))
+	assert.False(t, b.retry(events))
+
+	// capped at the max
+	for i := 0; i < 100; i++ {
+		b.success(metrics)
+	}
+	assert.InDelta(t, 3.0, b.get(metrics), 1e-9)
+
+	// no budget
+	var nb *retryBudgets
+	assert.Nil(t, newRetryBudgets(0))
+	assert.True(t, nb.retry(events))
+}
+
+func newRetryTestConn(t *testing.T, opts ...GrpcConnOpt) *grpcConnection {
+	c := &grpcConnection{
+		name:        "events channel",
+		address:     "test-addr",
+		queueStats:  &metrics.EventQueueStats{},
+		backoff:     func(int, func(d time.Duration)) error { return nil },
+		Dialer:      &NoopDialer{},
+		flushed:     make(chan struct{}),
+		maxReqBytes: 6 * 1024 * 1024,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	require.NoError(t, c.connect())
+	return c
+}
+
+func newResultMethod(name string, code pb.ResultCode) *mocks.Method {
+	m := &mocks.Method{}
+	m.On("String").Return(name)
+	m.On("ServiceKey").Return(TestServiceKey)
+	m.On("Message").Return(nil)
+	m.On("MessageLen").Return(int64(1))
+	m.On("RequestSize").Return(int64(1))
+	m.On("CallSummary").Return("summary")
+	m.On("Arg").Return("")
+	m.On("RetryOnErr", mock.Anything).Return(true)
+	m.On("Call", mock.Anything, mock.Anything).Return(nil)
+	m.On("ResultCode").Return(code, nil)
+	return m
+}
+
+func TestInvokeRPCRetryBudget(t *testing.T) {
+	c := newRetryTestConn(t)
+	c.budgets = newRetryBudgets(3)
+
+	m := newResultMethod("PostEvents", pb.ResultCode_TRY_LATER)
+	require.Equal(t, errRetryBudgetExhausted, c.InvokeRPC(make(chan struct{}), m))
+	m.AssertNumberOfCalls(t, "Call", 3)
+
+	// the metrics are still retried
+	m = newResultMethod("PostMetrics", pb.ResultCode_LIMIT_EXCEEDED)
+	require.Equal(t, errRetryBudgetExhausted, c.InvokeRPC(make(chan struct{}), m))
+	m.AssertNumberOfCalls(t, "Call", 3)
+
+	// no retries left for the events
+	m = newResultMethod("PostEvents", pb.ResultCode_TRY_LATER)
+	require.Equal(t, errRetryBudgetExhausted, c.InvokeRPC(make(chan struct{}), m))
+	m.AssertNumberOfCalls(t, "Call", 1)
+}
+
+func TestInvokeRPCCircuitBreaker(t *testing.T) {
+	c := newRetryTestConn(t)
+	now := time.Now()
+	c.breaker = newCircuitBreaker(3, time.Minute)
+	c.breaker.now = func() time.Time { return now }
+
+	c.backoff = func(n int, _ func(d time.Duration)) error {
+		if n > 2 {
+			return errGiveUpAfterRetries
+		}
+		return nil
+	}
+
+	// one failure per RPC, however many attempts were made
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, circuitClosed, c.breaker.getState())
+		m := newResultMethod("PostEvents", pb.ResultCode_TRY_LATER)
+		require.Equal(t, errGiveUpAfterRetries, c.InvokeRPC(make(chan struct{}), m))
+		m.AssertNumberOfCalls(t, "Call", 3)
+	}
+	assert.Equal(t, circuitOpen, c.breaker.getState())
+
+	// the collector is not called while the breaker is open
+	m := newResultMethod("PostMetrics", pb.ResultCode_OK)
+	require.Equal(t, errCircuitOpen, c.InvokeRPC(make(chan struct{}), m))
+	m.AssertNotCalled(t, "Call", mock.Anything, mock.Anything)
+
+	// the probe succeeds and closes the breaker
+	now = now.Add(time.Minute)
+	m = newResultMethod("PostMetrics", pb.ResultCode_OK)
+	require.NoError(t, c.InvokeRPC(make(chan struct{}), m))
+	m.AssertNumberOfCalls(t, "Call", 1)
+	assert.Equal(t, circuitClosed, c.breaker.getState())
+}
+
+func TestInvokeRPCCircuitBreakerTransientFailure(t *testing.T) {
+	c := newRetryTestConn(t)
+	c.breaker = newCircuitBreaker(1, time.Minute)
+
+	// a failed attempt retried successfully doesn't open the breaker
+	m := &mocks.Method{}
+	m.On("String").Return("PostEvents")
+	m.On("ServiceKey").Return(TestServiceKey)
+	m.On("MessageLen").Return(int64(1))
+	m.On("RequestSize").Return(int64(1))
+	m.On("CallSummary").Return("summary")
+	m.On("RetryOnErr", mock.Anything).Return(true)
+	m.On("Call", mock.Anything, mock.Anything).Return(errors.New("unavailable")).Once()
+	m.On("Call", mock.Anything, mock.Anything).Return(nil)
+	m.On("ResultCode").Return(pb.ResultCode_OK, nil)
+	require.NoError(t, c.InvokeRPC(make(chan struct{}), m))
+	m.AssertNumberOfCalls(t, "Call", 2)
+	assert.Equal(t, circuitClosed, c.breaker.getState())
+	assert.Equal(t, 0, c.breaker.failures)
+
+	// the settings and the pings don't count
+	c.backoff = func(int, func(d time.Duration)) error { return errGiveUpAfterRetries }
+	for _, name := range []string{"GetSettings", "Ping events channel"} {
+		m := newResultMethod(name, pb.ResultCode_TRY_LATER)
+		require.Equal(t, errGiveUpAfterRetries, c.InvokeRPC(make(chan struct{}), m))
+	}
+	assert.Equal(t, circuitClosed, c.breaker.getState())
+
+	// and are not blocked by an open breaker
+	m = newResultMethod("PostStatus", pb.ResultCode_TRY_LATER)
+	require.Equal(t, errGiveUpAfterRetries, c.InvokeRPC(make(chan struct{}), m))
+	assert.Equal(t, circuitOpen, c.breaker.getState())
+	m = newResultMethod("GetSettings", pb.ResultCode_OK)
+	require.NoError(t, c.InvokeRPC(make(chan struct{}), m))
+	m.AssertNumberOfCalls(t, "Call", 1)
+}
+
+func TestWithRetryOptions(t *testing.T) {
+	o := &config.ReporterOptions{
+		PingInterval:            5,
+		RetryDelayInitial:       100,
+		RetryDelayMax:           1,
+		RedirectMax:             2,
+		RetryLogThreshold:       3,
+		MaxRetries:              4,
+		RetryBudget:             5,
+		CircuitBreakerThreshold: 6,
+		CircuitBreakerCooldown:  7,
+	}
+	c := newRetryTestConn(t, WithRetryOptions(o))
+	assert.Equal(t, 5*time.Second, c.getPingInterval())
+	assert.Equal(t, 2, c.getRedirectMax())
+	assert.Equal(t, 3, c.getRetryLogThreshold())
+	assert.Equal(t, errGiveUpAfterRetries, c.backoff(5, func(time.Duration) {}))
+	assert.NoError(t, c.backoff(4, func(d time.Duration) { assert.LessOrEqual(t, d, time.Second) }))
+	assert.Equal(t, 6, c.breaker.threshold)
+	assert.Equal(t, 7*time.Second, c.breaker.cooldown)
+	assert.Equal(t, 5.0, c.budgets.max)
+
+	// the defaults
+	c = newRetryTestConn(t)
+	assert.Equal(t, grpcPingIntervalDefault*time.Second, c.getPingInterval())
+	assert.Equal(t, grpcRedirectMax, c.getRedirectMax())
+	assert.Equal(t, grpcRetryLogThreshold, c.getRetryLogThreshold())
+	assert.Nil(t, c.breaker)
+	assert.Nil(t, c.budgets)
+
+}
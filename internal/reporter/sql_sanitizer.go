@@ -16,6 +16,7 @@ package reporter
 import (
 	"github.com/solarwinds/apm-go/internal/config"
 	"strings"
+	"sync"
 	"unicode"
 	"unicode/utf8"
 )
@@ -110,10 +111,21 @@ type SQLSanitizer struct {
 // function.
 var sanitizers map[string]*SQLSanitizer
 
+// protect the sanitizers from reloading
+var sanitizersLock sync.RWMutex
+
 func init() {
 	sanitizers = initSanitizersMap()
 }
 
+// reloadSQLSanitizers rebuilds the sanitizers with the current SQL sanitize level
+func reloadSQLSanitizers() {
+	ss := initSanitizersMap()
+	sanitizersLock.Lock()
+	sanitizers = ss
+	sanitizersLock.Unlock()
+}
+
 func initSanitizersMap() map[string]*SQLSanitizer {
 	sanitizeFlag := config.GetSQLSanitize()
 	if sanitizeFlag == Disabled {
@@ -368,7 +380,10 @@ func (s *SQLSanitizer) Sanitize(sql string) string {
 // SQLSanitize checks the sanitizer of the database type and does the sanitization
 // accordingly. It uses the default sanitizer if the type is not found.
 func SQLSanitize(dbType string, sql string) string {
-	return sqlSanitize(sanitizers, dbType, sql)
+	sanitizersLock.RLock()
+	ss := sanitizers
+	sanitizersLock.RUnlock()
+	return sqlSanitize(ss, dbType, sql)
 }
 
 func sqlSanitize(ss map[string]*SQLSanitizer, dbType string, sql string) string {
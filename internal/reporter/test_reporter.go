@@ -148,6 +148,11 @@ func (r *TestReporter) Shutdown(context.Context) error {
 // ShutdownNow closes the Test reporter immediately
 func (r *TestReporter) ShutdownNow() {}
 
+// Flush is a no-op as the TestReporter doesn't buffer events
+func (r *TestReporter) Flush(context.Context) error {
+	return nil
+}
+
 // Closed returns if the reporter is closed or not TODO: not supported
 func (r *TestReporter) Closed() bool {
 	return false
@@ -19,24 +19,23 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/coocood/freecache"
 	"github.com/pkg/errors"
 )
 
-var urls *urlFilters
+// the transaction filters of the global reporter
+var urls = newURLFilters()
 
 func init() {
-	urls = newURLFilters()
 	urls.LoadConfig(config.GetTransactionFiltering())
 }
 
 // ReloadURLsConfig reloads the configuration and build the transaction filtering
 // filters and cache.
-// This function is used for testing purpose only. It's not thread-safe.
 func ReloadURLsConfig(filters []config.TransactionFilter) {
 	urls.LoadConfig(filters)
-	urls.cache.Clear()
 }
 
 // urlCache is a cache to store the disabled url patterns
@@ -122,6 +121,7 @@ func (f *extensionFilter) tracingMode() tracingMode {
 type urlFilters struct {
 	cache   *urlCache
 	filters []urlFilter
+	lock    sync.RWMutex // protect the filters and cache from reloading
 }
 
 func newURLFilters() *urlFilters {
@@ -132,7 +132,10 @@ func newURLFilters() *urlFilters {
 
 // LoadConfig reads transaction filtering settings from the global configuration
 func (f *urlFilters) LoadConfig(filters []config.TransactionFilter) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
 	f.loadConfig(filters)
+	f.cache.Clear()
 }
 
 func (f *urlFilters) loadConfig(filters []config.TransactionFilter) {
@@ -155,6 +158,9 @@ func (f *urlFilters) loadConfig(filters []config.TransactionFilter) {
 // getTracingMode checks if the URL should be traced or not. It returns TraceUnknown
 // if the url is not found.
 func (f *urlFilters) getTracingMode(url string) tracingMode {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
 	if len(f.filters) == 0 || url == "" {
 		return TraceUnknown
 	}
@@ -15,6 +15,7 @@
 package sampler
 
 import (
+	"context"
 	"fmt"
 	"github.com/solarwinds/apm-go/internal/log"
 	"github.com/solarwinds/apm-go/internal/reporter"
@@ -26,13 +27,41 @@ import (
 	"go.opentelemetry.io/otel/trace"
 )
 
+// decider makes the sampling decisions, e.g., *reporter.Instance
+type decider interface {
+	ShouldTraceRequestWithURL(traced bool, url string, ttMode reporter.TriggerTraceMode, swState w3cfmt.SwTraceState) reporter.SampleDecision
+	ValidateXTraceOptionsSignature(signature, ts, data string) reporter.AuthStatus
+}
+
 type sampler struct {
+	// the decider of a standalone reporter, or nil for the global reporter
+	r decider
 }
 
 func NewSampler() sdktrace.Sampler {
 	return sampler{}
 }
 
+// NewSamplerWithReporter returns a sampler which makes the decisions with the
+// settings of the reporter r instead of the global reporter.
+func NewSamplerWithReporter(r *reporter.Instance) sdktrace.Sampler {
+	return sampler{r: r}
+}
+
+func (s sampler) getXTraceOptions(ctx context.Context) xtrace.Options {
+	if s.r == nil {
+		return xtrace.GetXTraceOptions(ctx)
+	}
+	return xtrace.GetXTraceOptionsWithValidator(ctx, s.r.ValidateXTraceOptionsSignature)
+}
+
+func (s sampler) shouldTraceRequestWithURL(traced bool, url string, ttMode reporter.TriggerTraceMode, swState w3cfmt.SwTraceState) reporter.SampleDecision {
+	if s.r == nil {
+		return reporter.ShouldTraceRequestWithURL(traced, url, ttMode, swState)
+	}
+	return s.r.ShouldTraceRequestWithURL(traced, url, ttMode, swState)
+}
+
 var _ sdktrace.Sampler = sampler{}
 
 func (s sampler) Description() string {
@@ -83,17 +112,25 @@ func (s sampler) ShouldSample(params sdktrace.SamplingParameters) sdktrace.Sampl
 	if psc.IsValid() && !psc.IsRemote() {
 		if psc.IsSampled() {
 			result = alwaysSampler.ShouldSample(params)
+		} else if trace.SpanFromContext(params.ParentContext).IsRecording() {
+			// The spans of a trace which is recorded but not sampled are
+			// recorded too, so the calls to the dependencies at any depth
+			// are in the outbound metrics.
+			result = sdktrace.SamplingResult{
+				Decision:   sdktrace.RecordOnly,
+				Tracestate: psc.TraceState(),
+			}
 		} else {
 			result = neverSampler.ShouldSample(params)
 		}
 	} else {
 		// TODO url
 		url := ""
-		xto := xtrace.GetXTraceOptions(params.ParentContext)
+		xto := s.getXTraceOptions(params.ParentContext)
 		ttMode := getTtMode(xto)
 		// If parent context is not valid, swState will also not be valid
 		swState := w3cfmt.GetSwTraceState(psc)
-		traceDecision := reporter.ShouldTraceRequestWithURL(swState.IsValid(), url, ttMode, swState)
+		traceDecision := s.shouldTraceRequestWithURL(swState.IsValid(), url, ttMode, swState)
 		var decision sdktrace.SamplingDecision
 		if !traceDecision.Enabled() {
 			decision = sdktrace.Drop
@@ -29,6 +29,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 var (
@@ -250,6 +251,56 @@ func TestLocalTraces(t *testing.T) {
 	scen.test(t)
 }
 
+// recordOnlySampler records the spans without sampling them.
+type recordOnlySampler struct{}
+
+func (recordOnlySampler) ShouldSample(params sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	return sdktrace.SamplingResult{Decision: sdktrace.RecordOnly}
+}
+
+func (recordOnlySampler) Description() string {
+	return "RecordOnly"
+}
+
+func TestUnsampledOutboundSpans(t *testing.T) {
+	for _, tc := range []struct {
+		parentSampler sdktrace.Sampler
+		recorded      []string
+	}{
+		// the descendants of an unsampled trace are recorded, including the
+		// client and producer children of an intermediate span
+		{recordOnlySampler{}, []string{"client", "producer", "consumer", "internal", "nested client", "intermediate"}},
+		// but not if the parent is dropped, e.g., the tracing is disabled
+		{sdktrace.NeverSample(), nil},
+	} {
+		parentTracer := sdktrace.NewTracerProvider(sdktrace.WithSampler(tc.parentSampler)).Tracer("test")
+		ctx, parent := parentTracer.Start(context.Background(), "server", trace.WithSpanKind(trace.SpanKindServer))
+		require.False(t, parent.SpanContext().IsSampled())
+
+		sr := tracetest.NewSpanRecorder()
+		tracer := sdktrace.NewTracerProvider(sdktrace.WithSampler(NewSampler()),
+			sdktrace.WithSpanProcessor(sr)).Tracer("test")
+		for _, kind := range []trace.SpanKind{trace.SpanKindClient, trace.SpanKindProducer,
+			trace.SpanKindConsumer, trace.SpanKindInternal} {
+			_, child := tracer.Start(ctx, kind.String(), trace.WithSpanKind(kind))
+			assert.False(t, child.SpanContext().IsSampled())
+			child.End()
+		}
+		ictx, intermediate := tracer.Start(ctx, "intermediate", trace.WithSpanKind(trace.SpanKindInternal))
+		_, nested := tracer.Start(ictx, "nested client", trace.WithSpanKind(trace.SpanKindClient))
+		assert.False(t, nested.SpanContext().IsSampled())
+		nested.End()
+		intermediate.End()
+		parent.End()
+
+		var recorded []string
+		for _, span := range sr.Ended() {
+			recorded = append(recorded, span.Name())
+		}
+		assert.Equal(t, tc.recorded, recorded)
+	}
+}
+
 type SamplingScenario struct {
 	// inputs
 	validTraceParent        bool
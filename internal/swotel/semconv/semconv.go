@@ -15,10 +15,13 @@
 package semconv
 
 import (
+	"go.opentelemetry.io/otel/attribute"
 	otelconv "go.opentelemetry.io/otel/semconv/v1.20.0"
 )
 
 const (
+	DBSystemKey = otelconv.DBSystemKey
+
 	ExceptionEventName     = otelconv.ExceptionEventName
 	ExceptionMessageKey    = otelconv.ExceptionMessageKey
 	ExceptionTypeKey       = otelconv.ExceptionTypeKey
@@ -33,8 +36,22 @@ const (
 	K8SPodNameKey       = otelconv.K8SPodNameKey
 	K8SPodUIDKey        = otelconv.K8SPodUIDKey
 
+	MessagingDestinationNameKey = otelconv.MessagingDestinationNameKey
+	MessagingSystemKey          = otelconv.MessagingSystemKey
+
+	NetPeerNameKey = otelconv.NetPeerNameKey
+
 	OTelStatusDescriptionKey = otelconv.OTelStatusDescriptionKey
 
+	PeerServiceKey = otelconv.PeerServiceKey
+
+	RPCGRPCStatusCodeKey = otelconv.RPCGRPCStatusCodeKey
+	RPCMethodKey         = otelconv.RPCMethodKey
+	RPCServiceKey        = otelconv.RPCServiceKey
+
+	// ServerAddressKey replaces NetPeerNameKey as of semconv v1.21.0
+	ServerAddressKey = attribute.Key("server.address")
+
 	ServiceNameKey = otelconv.ServiceNameKey
 )
 
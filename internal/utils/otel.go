@@ -21,31 +21,81 @@ import (
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"log"
 	"net/url"
+	"regexp"
 	"strings"
 )
 
+// TransactionNameRules are the normalisation rules applied to the derived
+// transaction names to keep their cardinality under control.
+type TransactionNameRules struct {
+	// CollapseIDs replaces the numeric and UUID path segments with {id}
+	CollapseIDs bool
+	// PathDepth is the maximum number of segments of a path, zero for no limit
+	PathDepth int
+}
+
+const collapsedIDSegment = "{id}"
+
+var idSegment = regexp.MustCompile(`^(\d+|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12})$`)
+
+// TruncatePath returns the first n parts of a path split by slashes, e.g.,
+// /solarwinds/apm-go/blob becomes /solarwinds/apm-go with n = 3.
+func TruncatePath(path string, n int) string {
+	p := strings.Split(path, "/")
+	if len(p) > n {
+		p = p[:n]
+	}
+	return strings.Join(p, "/")
+}
+
+// normalizeTransactionName applies the rules to a transaction name.
+func normalizeTransactionName(name string, rules TransactionNameRules) string {
+	if rules.CollapseIDs {
+		p := strings.Split(name, "/")
+		for i, seg := range p {
+			if idSegment.MatchString(seg) {
+				p[i] = collapsedIDSegment
+			}
+		}
+		name = strings.Join(p, "/")
+	}
+	// only the paths are truncated, the leading slash adds an empty part
+	if rules.PathDepth > 0 && strings.HasPrefix(name, "/") {
+		name = TruncatePath(name, rules.PathDepth+1)
+	}
+	return name
+}
+
 // GetTransactionName retrieves the custom transaction name if it exists, otherwise calls deriveTransactionName
-func GetTransactionName(span sdktrace.ReadOnlySpan) string {
+// and normalises the derived name with the rules. The custom transaction names are not normalised.
+func GetTransactionName(span sdktrace.ReadOnlySpan, rules TransactionNameRules) string {
 	if txnName := entryspans.GetTransactionName(span.SpanContext().TraceID()); txnName != "" {
 		return txnName
 	} else {
-		return deriveTransactionName(span.Name(), span.Attributes())
+		return normalizeTransactionName(deriveTransactionName(span.Name(), span.Attributes()), rules)
 	}
 }
 
 // deriveTransactionName returns transaction name from given span name and attributes, falling back to "unknown"
 func deriveTransactionName(name string, attrs []attribute.KeyValue) string {
-	var httpRoute, httpUrl, txnName = "", "", ""
+	var httpRoute, httpUrl, rpcService, rpcMethod, txnName = "", "", "", "", ""
 	for _, attr := range attrs {
 		if attr.Key == semconv.HTTPRouteKey {
 			httpRoute = attr.Value.AsString()
 		} else if attr.Key == semconv.HTTPURLKey {
 			httpUrl = attr.Value.AsString()
+		} else if attr.Key == semconv.RPCServiceKey {
+			rpcService = attr.Value.AsString()
+		} else if attr.Key == semconv.RPCMethodKey {
+			rpcMethod = attr.Value.AsString()
 		}
 	}
 
 	if httpRoute != "" {
 		txnName = httpRoute
+	} else if rpcService != "" && rpcMethod != "" {
+		// e.g., helloworld.Greeter/SayHello
+		txnName = rpcService + "/" + rpcMethod
 	} else if name != "" {
 		txnName = name
 	}
@@ -35,10 +35,10 @@ func TestGetTransactionName(t *testing.T) {
 	err := entryspans.Push(roSpan)
 	require.NoError(t, err)
 	require.True(t, ok)
-	require.Equal(t, "derived", GetTransactionName(roSpan))
+	require.Equal(t, "derived", GetTransactionName(roSpan, TransactionNameRules{}))
 	err = entryspans.SetTransactionName(span.SpanContext().TraceID(), "custom")
 	require.NoError(t, err)
-	require.Equal(t, "custom", GetTransactionName(roSpan))
+	require.Equal(t, "custom", GetTransactionName(roSpan, TransactionNameRules{}))
 }
 
 func TestDeriveTransactionName(t *testing.T) {
@@ -75,6 +75,23 @@ func TestDeriveTransactionName(t *testing.T) {
 	}
 	require.Equal(t, "unknown", deriveTransactionName(name, attrs))
 
+	// Favors `rpc.service` and `rpc.method` over the span name
+	name = "/helloworld.Greeter/SayHello"
+	attrs = []attribute.KeyValue{
+		attribute.String("rpc.system", "grpc"),
+		attribute.String("rpc.service", "helloworld.Greeter"),
+		attribute.String("rpc.method", "SayHello"),
+	}
+	require.Equal(t, "helloworld.Greeter/SayHello", deriveTransactionName(name, attrs))
+
+	// Favors `http.route` over `rpc.service` and `rpc.method`
+	attrs = append(attrs, attribute.String("http.route", "/greet"))
+	require.Equal(t, "/greet", deriveTransactionName(name, attrs))
+
+	// Uses the span name without `rpc.method`
+	attrs = []attribute.KeyValue{attribute.String("rpc.service", "helloworld.Greeter")}
+	require.Equal(t, name, deriveTransactionName(name, attrs))
+
 	// Trims spaces
 	name = " my transaction "
 	attrs = []attribute.KeyValue{
@@ -87,3 +104,40 @@ func TestDeriveTransactionName(t *testing.T) {
 	expected := strings.Repeat("a", 255)
 	require.Equal(t, expected, deriveTransactionName(name, attrs))
 }
+
+func TestNormalizeTransactionName(t *testing.T) {
+	name := "/users/123/orders/1b4e28ba-2fa1-11d2-883f-0016d3cca427/items"
+
+	require.Equal(t, name, normalizeTransactionName(name, TransactionNameRules{}))
+	require.Equal(t, "/users/{id}/orders/{id}/items",
+		normalizeTransactionName(name, TransactionNameRules{CollapseIDs: true}))
+	require.Equal(t, "/users/123",
+		normalizeTransactionName(name, TransactionNameRules{PathDepth: 2}))
+	require.Equal(t, "/users/{id}/orders",
+		normalizeTransactionName(name, TransactionNameRules{CollapseIDs: true, PathDepth: 3}))
+
+	// Only the whole segments are collapsed
+	require.Equal(t, "/v2/abc123/{id}",
+		normalizeTransactionName("/v2/abc123/42", TransactionNameRules{CollapseIDs: true}))
+
+	// Only the paths are truncated
+	require.Equal(t, "helloworld.Greeter/SayHello",
+		normalizeTransactionName("helloworld.Greeter/SayHello", TransactionNameRules{PathDepth: 1}))
+}
+
+func TestGetTransactionNameRules(t *testing.T) {
+	tr, teardown := testutils.TracerSetup()
+	defer teardown()
+
+	rules := TransactionNameRules{CollapseIDs: true, PathDepth: 2}
+
+	_, span := tr.Start(context.Background(), "/users/42/orders/7")
+	roSpan := span.(trace.ReadOnlySpan)
+	require.NoError(t, entryspans.Push(roSpan))
+	require.Equal(t, "/users/{id}", GetTransactionName(roSpan, rules))
+	require.Equal(t, "/users/42/orders/7", GetTransactionName(roSpan, TransactionNameRules{}))
+
+	// The custom transaction names are not normalised
+	require.NoError(t, entryspans.SetTransactionName(span.SpanContext().TraceID(), "/custom/42/name"))
+	require.Equal(t, "/custom/42/name", GetTransactionName(roSpan, rules))
+}
@@ -46,7 +46,19 @@ const (
 var optRegex = regexp.MustCompile(";+")
 var customKeyRegex = regexp.MustCompile(`^custom-[^\s]*$`)
 
+// SignatureValidator validates the signature of the X-Trace-Options header,
+// e.g., reporter.ValidateXTraceOptionsSignature.
+type SignatureValidator func(signature, ts, data string) reporter.AuthStatus
+
+// GetXTraceOptions parses the X-Trace-Options stored in the context and
+// validates its signature with the settings of the global reporter.
 func GetXTraceOptions(ctx context.Context) Options {
+	return GetXTraceOptionsWithValidator(ctx, reporter.ValidateXTraceOptionsSignature)
+}
+
+// GetXTraceOptionsWithValidator parses the X-Trace-Options stored in the
+// context and validates its signature with validate.
+func GetXTraceOptionsWithValidator(ctx context.Context, validate SignatureValidator) Options {
 	xtoStr, ok := ctx.Value(OptionsKey).(string)
 	if !ok {
 		xtoStr = ""
@@ -56,10 +68,14 @@ func GetXTraceOptions(ctx context.Context) Options {
 		xtoSig = ""
 	}
 
-	return parseXTraceOptions(xtoStr, xtoSig)
+	return parseXTraceOptionsWithValidator(xtoStr, xtoSig, validate)
 }
 
 func parseXTraceOptions(opts string, sig string) Options {
+	return parseXTraceOptionsWithValidator(opts, sig, reporter.ValidateXTraceOptionsSignature)
+}
+
+func parseXTraceOptionsWithValidator(opts string, sig string, validate SignatureValidator) Options {
 	x := Options{
 		opts:        opts,
 		sig:         sig,
@@ -107,7 +123,7 @@ func parseXTraceOptions(opts string, sig string) Options {
 	if sig == "" {
 		x.sigState = NoSignature
 	} else {
-		x.authStatus = reporter.ValidateXTraceOptionsSignature(sig, strconv.FormatInt(x.timestamp, 10), opts)
+		x.authStatus = validate(sig, strconv.FormatInt(x.timestamp, 10), opts)
 		if x.authStatus.IsError() {
 			log.Warning("Invalid xtrace options signature", x.authStatus.Msg())
 			x.sigState = InvalidSignature
@@ -18,21 +18,18 @@ import (
 	"context"
 	"github.com/solarwinds/apm-go/internal/config"
 	"github.com/solarwinds/apm-go/internal/entryspans"
-	"github.com/solarwinds/apm-go/internal/exporter"
 	"github.com/solarwinds/apm-go/internal/log"
-	"github.com/solarwinds/apm-go/internal/processor"
-	"github.com/solarwinds/apm-go/internal/propagator"
+	"github.com/solarwinds/apm-go/internal/meter"
+	"github.com/solarwinds/apm-go/internal/metrics"
 	"github.com/solarwinds/apm-go/internal/reporter"
-	"github.com/solarwinds/apm-go/internal/sampler"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace"
 	"io"
-	stdlog "log"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -41,6 +38,10 @@ var (
 	errInvalidLogLevel = errors.New("invalid log level")
 )
 
+// tracerProvider is the TracerProvider created by Start. It is nil if the agent
+// is not started.
+var tracerProvider *sdktrace.TracerProvider
+
 // WaitForReady checks if the agent is ready. It returns true is the agent is ready,
 // or false if it is not.
 //
@@ -64,6 +65,23 @@ func Shutdown(ctx context.Context) error {
 	return reporter.Shutdown(ctx)
 }
 
+// ForceFlush sends out the spans, events and metrics buffered by the agent
+// without waiting for the flush intervals. Unlike Shutdown, the agent keeps
+// running afterwards, so it can be called at checkpoints of batch jobs or
+// command line tools.
+//
+// The call blocks until the collector has acknowledged the data or the context
+// is canceled. It returns an error if the context is canceled, or any of the
+// data failed to be sent.
+func ForceFlush(ctx context.Context) error {
+	if tracerProvider != nil {
+		if err := tracerProvider.ForceFlush(ctx); err != nil {
+			return err
+		}
+	}
+	return reporter.Flush(ctx)
+}
+
 // Closed denotes if the agent is closed (by either calling Shutdown explicitly
 // or being triggered from some internal error).
 func Closed() bool {
@@ -96,6 +114,17 @@ func SetServiceKey(key string) {
 	reporter.SetServiceKey(key)
 }
 
+// ReloadConfig reloads the configuration from the config file and environment
+// variables, and applies the changes which are safe at runtime: the sampling
+// config, transaction filters, transaction name rules, log level, service key,
+// flush intervals and SQL sanitize level. Changes of other items are logged and require a restart.
+//
+// The agent can also reload the config automatically, see the config items
+// ConfigReloadInterval and ConfigReloadOnSIGHUP.
+func ReloadConfig() error {
+	return reporter.ReloadConfig()
+}
+
 func createResource(resourceAttrs ...attribute.KeyValue) (*resource.Resource, error) {
 	return resource.New(context.Background(),
 		resource.WithContainer(),
@@ -112,38 +141,54 @@ func createResource(resourceAttrs ...attribute.KeyValue) (*resource.Resource, er
 }
 
 // Start bootstraps otel requirements and starts the agent. The given `resourceAttrs` are added to the otel
-// `resource.Resource` that is supplied to the otel `TracerProvider`
+// `resource.Resource` that is supplied to the otel `TracerProvider`. The otel
+// `MeterProvider` is set to the one reporting custom metrics, see NewMeterProvider.
 func Start(resourceAttrs ...attribute.KeyValue) (func(), error) {
-	resrc, err := createResource(resourceAttrs...)
+	return StartWithOptions(WithResourceAttributes(resourceAttrs...))
+}
+
+// StartWithOptions is like Start, but accepts options to configure the agent
+// programmatically. The configuration items set by the options take precedence
+// over the config file and the environment variables, including when the config
+// is reloaded.
+func StartWithOptions(opts ...Option) (func(), error) {
+	o := newOptions(opts...)
+	resrc, err := createResource(o.resourceAttrs...)
 	if err != nil {
 		return func() {
 			// return a no-op func so that we don't cause a nil-deref for the end-user
 		}, err
 	}
+	config.Load(o.configOpts...)
+	if len(o.configOpts) > 0 && config.GetEnabled() {
+		log.Warningf("Accepted config items: \n%s", config.GetDelta())
+	}
 	reporter.Start(resrc)
 
-	exprtr := exporter.NewExporter()
-	smplr := sampler.NewSampler()
-	config.Load()
-	isAppoptics := strings.Contains(strings.ToLower(config.GetCollector()), "appoptics.com")
-	proc := processor.NewInboundMetricsSpanProcessor(isAppoptics)
-	prop := propagation.NewCompositeTextMapPropagator(
-		&propagation.TraceContext{},
-		&propagation.Baggage{},
-		&propagator.SolarwindsPropagator{},
-	)
-	otel.SetTextMapPropagator(prop)
+	otel.SetTextMapPropagator(NewPropagator())
 	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(exprtr),
+		sdktrace.WithBatcher(NewExporter()),
 		sdktrace.WithResource(resrc),
-		sdktrace.WithSampler(smplr),
-		sdktrace.WithSpanProcessor(proc),
+		sdktrace.WithSampler(NewSampler()),
+		sdktrace.WithSpanProcessor(NewSpanProcessor()),
+		sdktrace.WithSpanProcessor(NewOutboundSpanProcessor()),
 	)
 	otel.SetTracerProvider(tp)
+	tracerProvider = tp
+
+	mp := meter.NewMeterProvider(metrics.CustomMetrics)
+	otel.SetMeterProvider(mp)
+
+	stopWatch := func() {}
+	if interval, sighup := config.GetConfigReloadInterval(), config.GetConfigReloadOnSIGHUP(); interval > 0 || sighup {
+		stopWatch = reporter.WatchConfig(time.Duration(interval)*time.Second, sighup)
+	}
 	return func() {
+		stopWatch()
 		if err := tp.Shutdown(context.Background()); err != nil {
-			stdlog.Fatal(err)
+			log.Errorf("failed to shut down the agent: %v", err)
 		}
+		mp.Shutdown()
 	}, nil
 
 }
@@ -16,11 +16,14 @@ package swo
 
 import (
 	"context"
+	"github.com/solarwinds/apm-go/internal/config"
 	"github.com/solarwinds/apm-go/internal/entryspans"
 	"github.com/solarwinds/apm-go/internal/log"
+	"github.com/solarwinds/apm-go/internal/metrics"
 	"github.com/solarwinds/apm-go/internal/testutils"
 	"github.com/solarwinds/apm-go/internal/utils"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/sdk/trace"
 	"os"
@@ -111,3 +114,36 @@ func TestSetTransactionName(t *testing.T) {
 	require.Equal(t, "this should work", entryspans.GetTransactionName(s.SpanContext().TraceID()))
 
 }
+
+func TestStartWithOptions(t *testing.T) {
+	defer config.Load()
+
+	cb, err := StartWithOptions(
+		WithServiceKey(testServiceKey),
+		WithCollector("localhost:4567"),
+		WithTracingMode(DisabledTracingMode),
+		WithSampleRate(100),
+		WithTransactionSettings(TransactionFilter{Type: URLFilter, Extensions: []string{".png"}, Tracing: DisabledTracingMode}),
+		WithSQLSanitize(2),
+		WithReporterProperties(func(r *ReporterOptions) {
+			r.SetEventFlushInterval(7)
+		}),
+		WithResourceAttributes(attribute.String("service.name", "options")),
+	)
+	require.NoError(t, err)
+	defer cb()
+
+	assert.Equal(t, "localhost:4567", config.GetCollector())
+	assert.Equal(t, DisabledTracingMode, config.GetTracingMode())
+	assert.Equal(t, 100, config.GetSampleRate())
+	assert.Len(t, config.GetTransactionFiltering(), 1)
+	assert.Equal(t, 2, config.GetSQLSanitize())
+	assert.Equal(t, int64(7), config.ReporterOpts().GetEventFlushInterval())
+	assert.Contains(t, config.GetDelta().String(), "SQLSanitize (SW_APM_SQL_SANITIZE) = 2 (default: 0, source: option)")
+
+	// the measurements of the global meter provider are custom metrics
+	counter, err := otel.Meter("test").Int64Counter("otel.counter")
+	require.NoError(t, err)
+	counter.Add(context.Background(), 1)
+	require.NotNil(t, metrics.CustomMetrics.CopyAndReset(30))
+}
@@ -0,0 +1,149 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package swo
+
+import (
+	"strings"
+
+	"github.com/solarwinds/apm-go/internal/config"
+	"github.com/solarwinds/apm-go/internal/exporter"
+	"github.com/solarwinds/apm-go/internal/meter"
+	"github.com/solarwinds/apm-go/internal/metrics"
+	"github.com/solarwinds/apm-go/internal/processor"
+	"github.com/solarwinds/apm-go/internal/propagator"
+	"github.com/solarwinds/apm-go/internal/sampler"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// The components below are what Start and NewAgent install into their
+// TracerProviders. They can be used to build a TracerProvider of your own, e.g.,
+// with extra span processors, custom span limits or a second exporter.
+//
+// By default, the components work with the agent started by Start. They can be
+// created before Start is called, and are no-ops until the agent is started.
+// Use WithAgent to work with an agent created by NewAgent instead.
+
+// ComponentOption configures the components returned by NewSampler, NewExporter,
+// NewSpanProcessor, NewOutboundSpanProcessor and NewMeterProvider.
+type ComponentOption func(o *componentOptions)
+
+type componentOptions struct {
+	agent *Agent
+}
+
+func newComponentOptions(opts ...ComponentOption) *componentOptions {
+	o := &componentOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithAgent binds the component to the agent created by NewAgent rather than
+// the one started by Start.
+func WithAgent(a *Agent) ComponentOption {
+	return func(o *componentOptions) {
+		o.agent = a
+	}
+}
+
+// NewSampler returns the sampler which makes the sampling decisions with the
+// settings from the collector, the local config and the X-Trace-Options
+// header. It should be the sampler of the TracerProvider, as the exporter
+// relies on the trace state it sets.
+func NewSampler(opts ...ComponentOption) sdktrace.Sampler {
+	if o := newComponentOptions(opts...); o.agent != nil {
+		return sampler.NewSamplerWithReporter(o.agent.rep)
+	}
+	return sampler.NewSampler()
+}
+
+// NewExporter returns the exporter which sends the spans to the collector. It's
+// usually wrapped by sdktrace.WithBatcher. Shutting down the exporter shuts
+// down the agent it works with.
+func NewExporter(opts ...ComponentOption) sdktrace.SpanExporter {
+	if o := newComponentOptions(opts...); o.agent != nil {
+		return exporter.NewExporterWithReporter(o.agent.rep)
+	}
+	return exporter.NewExporter()
+}
+
+// NewSpanProcessor returns the span processor which records the inbound
+// metrics, i.e., the response time and the request count of the transactions,
+// and tracks the entry spans for SetTransactionName.
+func NewSpanProcessor(opts ...ComponentOption) sdktrace.SpanProcessor {
+	if o := newComponentOptions(opts...); o.agent != nil {
+		return processor.NewInboundMetricsSpanProcessorWithRegistry(o.agent.rep.Metrics(), o.agent.isAppoptics)
+	}
+	return processor.NewInboundMetricsSpanProcessor(isAppopticsCollector(config.GetCollector()))
+}
+
+// NewOutboundSpanProcessor returns the span processor which records the
+// outbound metrics, i.e., the response time and the errors of the calls to the
+// dependencies, e.g., databases, caches and downstream services, from the client
+// and producer spans. The dependencies are tagged by the peer.service or the
+// server.address, the db.system and the rpc.method, and are capped like the
+// transactions, separately from them. The calls of the unsampled traces are
+// recorded too, as the sampler records all the spans of the traces it doesn't
+// sample, unless the tracing is disabled.
+func NewOutboundSpanProcessor(opts ...ComponentOption) sdktrace.SpanProcessor {
+	if o := newComponentOptions(opts...); o.agent != nil {
+		return processor.NewOutboundMetricsSpanProcessorWithRegistry(o.agent.rep.Metrics())
+	}
+	return processor.NewOutboundMetricsSpanProcessor()
+}
+
+// NewMeterProvider returns a MeterProvider which reports the measurements of
+// the OpenTelemetry instruments as custom metrics. The counters report the
+// count and the sum of the values added in each flush interval, see
+// SummaryMetric, and the observable counters the increase since the previous
+// flush. The up-down counters report their running total as a gauge, see
+// GaugeMetric, and the observable up-down counters and gauges the value
+// observed at the flush. The histograms record the values, rounded to
+// integers, as HistogramMetric does, and the values of the durations, e.g.,
+// with the unit "s" or "ms", in microseconds. The float64 histograms of other
+// units are reported as summaries. The attributes are reported as the tags
+// and are subject to MaxTagsCount.
+//
+// Start registers such a MeterProvider as the global one.
+func NewMeterProvider(opts ...ComponentOption) metric.MeterProvider {
+	if o := newComponentOptions(opts...); o.agent != nil {
+		return o.agent.mp
+	}
+	return meter.NewMeterProvider(metrics.CustomMetrics)
+}
+
+// NewPropagator returns the propagator of the W3C trace context, baggage and
+// the SolarWinds headers. It's stateless and isn't bound to any agent.
+func NewPropagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(
+		&propagation.TraceContext{},
+		&propagation.Baggage{},
+		NewSolarWindsPropagator(),
+	)
+}
+
+// NewSolarWindsPropagator returns the propagator of the SolarWinds headers
+// only. It must come after propagation.TraceContext in a composite
+// propagator, as it amends the tracestate header and the trace state extracted.
+func NewSolarWindsPropagator() propagation.TextMapPropagator {
+	return &propagator.SolarwindsPropagator{}
+}
+
+func isAppopticsCollector(collector string) bool {
+	return strings.Contains(strings.ToLower(collector), "appoptics.com")
+}
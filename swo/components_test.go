@@ -0,0 +1,93 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package swo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestComponentsWithAgent(t *testing.T) {
+	a, err := NewAgent(WithServiceKey(testServiceKey), WithCollector("localhost:4567"))
+	require.NoError(t, err)
+	assert.Equal(t, a.MeterProvider(), NewMeterProvider(WithAgent(a)))
+
+	other := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(NewSampler(WithAgent(a))),
+		sdktrace.WithSpanProcessor(NewSpanProcessor(WithAgent(a))),
+		sdktrace.WithSpanProcessor(NewOutboundSpanProcessor(WithAgent(a))),
+		sdktrace.WithBatcher(NewExporter(WithAgent(a))),
+		sdktrace.WithSyncer(other),
+		sdktrace.WithSpanLimits(sdktrace.SpanLimits{AttributeCountLimit: 1}),
+	)
+
+	// dropped as there are no settings from the collector of the agent
+	_, span := tp.Tracer("test").Start(context.Background(), "components")
+	assert.False(t, span.SpanContext().IsSampled())
+	span.End()
+	assert.Empty(t, other.GetSpans())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, tp.Shutdown(ctx))
+	// the exporter shuts down the agent it works with
+	assert.True(t, a.Closed())
+}
+
+func TestComponentsWithoutStart(t *testing.T) {
+	other := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(NewSampler()),
+		sdktrace.WithSpanProcessor(NewSpanProcessor()),
+		sdktrace.WithSpanProcessor(NewOutboundSpanProcessor()),
+		sdktrace.WithBatcher(NewExporter()),
+		sdktrace.WithSyncer(other),
+	)
+	_, span := tp.Tracer("test").Start(context.Background(), "no start")
+	span.End()
+	assert.Equal(t, "SolarWinds APM Sampler", NewSampler().Description())
+	require.NoError(t, tp.ForceFlush(context.Background()))
+}
+
+func TestNewPropagator(t *testing.T) {
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x01},
+		SpanID:     trace.SpanID{0x02},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+
+	carrier := propagation.MapCarrier{}
+	NewPropagator().Inject(ctx, carrier)
+	assert.Equal(t, "00-01000000000000000000000000000000-0200000000000000-01", carrier.Get("traceparent"))
+	assert.Equal(t, "sw=0200000000000000-01", carrier.Get("tracestate"))
+
+	// composed with the W3C propagator by the user
+	carrier = propagation.MapCarrier{}
+	propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		NewSolarWindsPropagator(),
+	).Inject(ctx, carrier)
+	assert.Equal(t, "sw=0200000000000000-01", carrier.Get("tracestate"))
+}
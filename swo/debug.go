@@ -0,0 +1,82 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package swo
+
+import (
+	"encoding/json"
+	"github.com/solarwinds/apm-go/internal/config"
+	"github.com/solarwinds/apm-go/internal/log"
+	"github.com/solarwinds/apm-go/internal/metrics"
+	"github.com/solarwinds/apm-go/internal/reporter"
+	"net/http"
+)
+
+// debugInfo is the document served by DebugHandler
+type debugInfo struct {
+	*reporter.DebugInfo
+	Transactions  transMapInfo      `json:"transactions"`
+	CustomMetrics transMapInfo      `json:"custom_metrics"`
+	Config        map[string]string `json:"config"`
+}
+
+type transMapInfo struct {
+	Cap      int32 `json:"cap"`
+	Overflow bool  `json:"overflow"`
+}
+
+// DebugHandler returns an http.Handler which serves a JSON snapshot of the
+// agent internals: the reporter state, the collector address, the outcome of
+// the RPC calls, the sampling settings and token buckets, the event queue stats,
+// the host identity and the effective configuration (with secrets masked).
+//
+// The handler is not registered anywhere by the agent. Mount it on an internal
+// port only, for example:
+//
+//	http.Handle("/debug/swo", swo.DebugHandler())
+func DebugHandler() http.Handler {
+	return debugHandler(reporter.Debug, metrics.DefaultRegistry(), config.Effective)
+}
+
+// DebugHandler returns an http.Handler which serves a JSON snapshot of the
+// internals of the agent, see the package-level DebugHandler.
+func (a *Agent) DebugHandler() http.Handler {
+	return debugHandler(a.rep.Debug, a.rep.Metrics(), a.cfg.Effective)
+}
+
+func debugHandler(debug func() *reporter.DebugInfo, registry *metrics.Registry,
+	effective func() map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		apm, custom := registry.ApmMetrics(), registry.CustomMetrics()
+		info := debugInfo{
+			DebugInfo: debug(),
+			Transactions: transMapInfo{
+				Cap:      apm.Cap(),
+				Overflow: apm.Overflow(),
+			},
+			CustomMetrics: transMapInfo{
+				Cap:      custom.Cap(),
+				Overflow: custom.Overflow(),
+			},
+			Config: effective(),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(info); err != nil {
+			log.Warningf("failed to write the debug info: %v", err)
+		}
+	})
+}
@@ -0,0 +1,94 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package swo_test
+
+import (
+	"context"
+	"log"
+
+	"github.com/solarwinds/apm-go/swo"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// This example builds a TracerProvider which sends the spans to both SolarWinds
+// Observability and another exporter, with custom span limits.
+func ExampleNewExporter() {
+	// The agent connects to the collector. Its own TracerProvider isn't used.
+	agent, err := swo.NewAgent(swo.WithServiceKey("<api token>:my-service"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Any other exporter, e.g., OTLP or stdout.
+	other := tracetest.NewInMemoryExporter()
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(swo.NewSampler(swo.WithAgent(agent))),
+		sdktrace.WithSpanProcessor(swo.NewSpanProcessor(swo.WithAgent(agent))),
+		sdktrace.WithSpanProcessor(swo.NewOutboundSpanProcessor(swo.WithAgent(agent))),
+		sdktrace.WithBatcher(swo.NewExporter(swo.WithAgent(agent))),
+		sdktrace.WithBatcher(other),
+		sdktrace.WithSpanLimits(sdktrace.SpanLimits{AttributeCountLimit: 64}),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(swo.NewPropagator())
+
+	// Shutting down the TracerProvider shuts down the agent as well.
+	defer func() {
+		if err := tp.Shutdown(context.Background()); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	_, span := otel.Tracer("example").Start(context.Background(), "work")
+	span.End()
+}
+
+// This example samples the traces with the settings from SolarWinds
+// Observability and reports the inbound and outbound metrics of the spans,
+// while the spans themselves are only sent to another exporter.
+func ExampleNewSampler() {
+	agent, err := swo.NewAgent(swo.WithServiceKey("<api token>:my-service"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Deferred first, so the agent is shut down after the TracerProvider.
+	defer func() {
+		if err := agent.Shutdown(context.Background()); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(swo.NewSampler(swo.WithAgent(agent))),
+		sdktrace.WithSpanProcessor(swo.NewSpanProcessor(swo.WithAgent(agent))),
+		sdktrace.WithSpanProcessor(swo.NewOutboundSpanProcessor(swo.WithAgent(agent))),
+		sdktrace.WithBatcher(tracetest.NewInMemoryExporter()),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(agent.Propagator())
+
+	// Flushes the spans batched before the agent is shut down.
+	defer func() {
+		if err := tp.Shutdown(context.Background()); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	_, span := otel.Tracer("example").Start(context.Background(), "work")
+	span.End()
+}
@@ -0,0 +1,159 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package swo
+
+import (
+	"context"
+
+	"github.com/solarwinds/apm-go/internal/config"
+	"github.com/solarwinds/apm-go/internal/meter"
+	"github.com/solarwinds/apm-go/internal/reporter"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Agent is an instance of the agent created by NewAgent. It has its own
+// config, collector connection, sampling settings and metrics, and it doesn't
+// modify the OpenTelemetry globals or the package-level state of this library
+// (used by Start, Shutdown, ForceFlush, SummaryMetric, etc.) unless WithGlobal
+// is given. Several agents can run in the same process independently.
+//
+// The config of an agent is read from the config file and the environment
+// variables, as Start does, and overridden by the options. It isn't reloaded
+// at runtime.
+type Agent struct {
+	cfg         *config.Config
+	rep         *reporter.Instance
+	tp          *sdktrace.TracerProvider
+	mp          *meter.MeterProvider
+	prop        propagation.TextMapPropagator
+	isAppoptics bool
+}
+
+// NewAgent creates and starts an agent. The agent is a no-op one if it's
+// disabled by the config. The caller should call Shutdown to stop it.
+func NewAgent(opts ...Option) (*Agent, error) {
+	o := newOptions(opts...)
+
+	resrc, err := createResource(o.resourceAttrs...)
+	if err != nil {
+		return nil, err
+	}
+	cfg := config.NewConfig(o.configOpts...)
+	rep := reporter.New(resrc, cfg)
+
+	a := &Agent{
+		cfg:         cfg,
+		rep:         rep,
+		prop:        NewPropagator(),
+		isAppoptics: isAppopticsCollector(cfg.GetCollector()),
+		mp:          meter.NewMeterProvider(rep.Metrics().CustomMetrics()),
+	}
+	a.tp = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(NewExporter(WithAgent(a))),
+		sdktrace.WithResource(resrc),
+		sdktrace.WithSampler(NewSampler(WithAgent(a))),
+		sdktrace.WithSpanProcessor(NewSpanProcessor(WithAgent(a))),
+		sdktrace.WithSpanProcessor(NewOutboundSpanProcessor(WithAgent(a))),
+	)
+	if o.global {
+		otel.SetTextMapPropagator(a.prop)
+		otel.SetTracerProvider(a.tp)
+		otel.SetMeterProvider(a.mp)
+	}
+	return a, nil
+}
+
+// TracerProvider returns the TracerProvider of the agent.
+func (a *Agent) TracerProvider() *sdktrace.TracerProvider {
+	return a.tp
+}
+
+// MeterProvider returns the MeterProvider of the agent, which reports the
+// measurements as custom metrics, see NewMeterProvider.
+func (a *Agent) MeterProvider() metric.MeterProvider {
+	return a.mp
+}
+
+// Propagator returns the propagator of the agent, which handles the W3C trace
+// context, baggage and the SolarWinds headers.
+func (a *Agent) Propagator() propagation.TextMapPropagator {
+	return a.prop
+}
+
+// WaitForReady blocks until the agent is ready, the context is canceled, or
+// the agent is closed. It returns true if the agent is ready.
+func (a *Agent) WaitForReady(ctx context.Context) bool {
+	if a.Closed() {
+		return false
+	}
+	return a.rep.WaitForReady(ctx)
+}
+
+// Flush sends out the spans, events and metrics buffered by the agent, see
+// ForceFlush.
+func (a *Agent) Flush(ctx context.Context) error {
+	if err := a.tp.ForceFlush(ctx); err != nil {
+		return err
+	}
+	return a.rep.Flush(ctx)
+}
+
+// Shutdown flushes the data buffered and stops the agent. It blocks until the
+// agent is stopped or the context is canceled.
+func (a *Agent) Shutdown(ctx context.Context) error {
+	err := a.tp.Shutdown(ctx)
+	// after the final flush, which collects the observable instruments
+	a.mp.Shutdown()
+	return err
+}
+
+// ServiceName returns the service name of the agent, i.e., the otel
+// `service.name` resource attribute if given, or the part after the colon of
+// its service key.
+func (a *Agent) ServiceName() string {
+	return a.rep.GetServiceName()
+}
+
+// Closed denotes if the agent is closed.
+func (a *Agent) Closed() bool {
+	return a.rep.Closed()
+}
+
+// SummaryMetric submits a summary type measurement to the agent, see the
+// package-level SummaryMetric.
+func (a *Agent) SummaryMetric(name string, value float64, opts MetricOptions) error {
+	return a.rep.Metrics().CustomMetrics().Summary(name, value, opts)
+}
+
+// IncrementMetric submits an incremental measurement to the agent, see the
+// package-level IncrementMetric.
+func (a *Agent) IncrementMetric(name string, opts MetricOptions) error {
+	return a.rep.Metrics().CustomMetrics().Increment(name, opts)
+}
+
+// GaugeMetric submits a gauge measurement to the agent, see the package-level
+// GaugeMetric.
+func (a *Agent) GaugeMetric(name string, value float64, opts MetricOptions) error {
+	return a.rep.Metrics().CustomMetrics().Gauge(name, value, opts)
+}
+
+// HistogramMetric submits a value to a histogram of the agent, see the
+// package-level HistogramMetric.
+func (a *Agent) HistogramMetric(name string, value int64, opts MetricOptions) error {
+	return a.rep.Metrics().CustomMetrics().Histogram(name, value, opts)
+}
@@ -0,0 +1,110 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package swo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const testServiceKey = "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go"
+
+func TestNewAgent(t *testing.T) {
+	globalTP := otel.GetTracerProvider()
+	globalProp := otel.GetTextMapPropagator()
+
+	newAgent := func(name string) *Agent {
+		a, err := NewAgent(
+			WithServiceKey(testServiceKey),
+			WithCollector("localhost:4567"),
+			WithResourceAttributes(attribute.String("service.name", name)),
+		)
+		require.NoError(t, err)
+		return a
+	}
+	a, b := newAgent("a"), newAgent("b")
+	require.NotSame(t, a.TracerProvider(), b.TracerProvider())
+	require.NotSame(t, a.MeterProvider(), b.MeterProvider())
+	assert.Equal(t, "a", a.ServiceName())
+	assert.Equal(t, "b", b.ServiceName())
+
+	// the otel globals are not touched
+	assert.Equal(t, globalTP, otel.GetTracerProvider())
+	assert.Equal(t, globalProp, otel.GetTextMapPropagator())
+
+	// the propagator handles the W3C and SolarWinds headers
+	carrier := propagation.MapCarrier{}
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x01},
+		SpanID:     trace.SpanID{0x02},
+		TraceFlags: trace.FlagsSampled,
+	})
+	a.Propagator().Inject(trace.ContextWithSpanContext(context.Background(), sc), carrier)
+	assert.Equal(t, "00-01000000000000000000000000000000-0200000000000000-01", carrier.Get("traceparent"))
+	assert.Contains(t, carrier.Get("tracestate"), "sw=0200000000000000-01")
+
+	// not ready as there are no settings from the collector
+	_, span := a.TracerProvider().Tracer("test").Start(context.Background(), "span")
+	assert.False(t, span.SpanContext().IsSampled())
+	span.End()
+
+	require.NoError(t, a.IncrementMetric("counter", MetricOptions{Count: 1}))
+	require.NoError(t, b.SummaryMetric("summary", 1, MetricOptions{Count: 1}))
+	require.NoError(t, a.GaugeMetric("gauge", 3, MetricOptions{}))
+	require.NoError(t, b.HistogramMetric("histogram", 42, MetricOptions{Count: 1}))
+	counter, err := a.MeterProvider().Meter("test").Int64Counter("otel.counter")
+	require.NoError(t, err)
+	counter.Add(context.Background(), 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, a.Shutdown(ctx))
+	assert.True(t, a.Closed())
+	assert.False(t, a.WaitForReady(ctx))
+
+	// the other agent keeps running
+	assert.False(t, b.Closed())
+	require.NoError(t, b.Shutdown(ctx))
+	assert.True(t, b.Closed())
+}
+
+func TestNewAgentWithGlobal(t *testing.T) {
+	globalTP := otel.GetTracerProvider()
+	globalProp := otel.GetTextMapPropagator()
+	globalMP := otel.GetMeterProvider()
+	defer func() {
+		otel.SetTracerProvider(globalTP)
+		otel.SetTextMapPropagator(globalProp)
+		otel.SetMeterProvider(globalMP)
+	}()
+
+	a, err := NewAgent(WithServiceKey(testServiceKey), WithCollector("localhost:4567"), WithGlobal())
+	require.NoError(t, err)
+	assert.Equal(t, a.TracerProvider(), otel.GetTracerProvider())
+	assert.Equal(t, a.Propagator(), otel.GetTextMapPropagator())
+	assert.Equal(t, a.MeterProvider(), otel.GetMeterProvider())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, a.Shutdown(ctx))
+}
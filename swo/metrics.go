@@ -34,6 +34,8 @@ var (
 	ErrExceedsMetricsCountLimit = metrics.ErrExceedsMetricsCountLimit
 	// ErrMetricsWithNonPositiveCount indicates the count is negative or zero
 	ErrMetricsWithNonPositiveCount = metrics.ErrMetricsWithNonPositiveCount
+	// ErrHistogramValueOutOfRange indicates the value of a histogram is negative or too large
+	ErrHistogramValueOutOfRange = metrics.ErrHistogramValueOutOfRange
 )
 
 // SummaryMetric submits a summary type measurement to the reporter. The measurements
@@ -47,3 +49,17 @@ func SummaryMetric(name string, value float64, opts MetricOptions) error {
 func IncrementMetric(name string, opts MetricOptions) error {
 	return metrics.CustomMetrics.Increment(name, opts)
 }
+
+// GaugeMetric submits a gauge measurement to the reporter, e.g., the depth of
+// a queue. The last value submitted in a flush interval is reported, and the
+// Count of the options is ignored.
+func GaugeMetric(name string, value float64, opts MetricOptions) error {
+	return metrics.CustomMetrics.Gauge(name, value, opts)
+}
+
+// HistogramMetric submits a value to a histogram, e.g., the size of a payload.
+// The distribution of the values submitted in a flush interval is reported.
+// The value is recorded opts.Count times and must be between 0 and 3600000000.
+func HistogramMetric(name string, value int64, opts MetricOptions) error {
+	return metrics.CustomMetrics.Histogram(name, value, opts)
+}
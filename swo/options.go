@@ -0,0 +1,250 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package swo
+
+import (
+	"github.com/solarwinds/apm-go/internal/config"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Option configures the agent started by StartWithOptions or created by
+// NewAgent.
+//
+// The options which set configuration items take precedence over the config
+// file and the environment variables. The values are validated in the same
+// way, i.e., an invalid value is discarded and the default value is used
+// instead. The source of each value is logged on startup.
+type Option func(o *options)
+
+type options struct {
+	resourceAttrs []attribute.KeyValue
+	configOpts    []config.Option
+	global        bool
+}
+
+func newOptions(opts ...Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func withConfig(opt config.Option) Option {
+	return func(o *options) {
+		o.configOpts = append(o.configOpts, opt)
+	}
+}
+
+// TracingMode is the local tracing mode, either EnabledTracingMode or
+// DisabledTracingMode.
+type TracingMode = config.TracingMode
+
+const (
+	// EnabledTracingMode means tracing is enabled
+	EnabledTracingMode = config.EnabledTracingMode
+	// DisabledTracingMode means tracing is disabled
+	DisabledTracingMode = config.DisabledTracingMode
+)
+
+// TransactionFilter enables or disables tracing of the requests whose URL
+// matches either RegEx or one of the Extensions. Type must be URLFilter.
+type TransactionFilter = config.TransactionFilter
+
+// URLFilter is the type of the TransactionFilter based on the request URL.
+const URLFilter = config.URL
+
+// ReporterOptions are the options of the reporter, see WithReporterProperties.
+type ReporterOptions = config.ReporterOptions
+
+// WithResourceAttributes adds the attributes to the otel `resource.Resource`
+// supplied to the TracerProvider of the agent.
+func WithResourceAttributes(attrs ...attribute.KeyValue) Option {
+	return func(o *options) {
+		o.resourceAttrs = append(o.resourceAttrs, attrs...)
+	}
+}
+
+// WithGlobal registers the TracerProvider and the propagator of the agent
+// created by NewAgent as the OpenTelemetry globals. StartWithOptions always
+// does so.
+func WithGlobal() Option {
+	return func(o *options) {
+		o.global = true
+	}
+}
+
+// WithCollector sets the host and port of the collector (SW_APM_COLLECTOR).
+func WithCollector(collector string) Option {
+	return withConfig(config.WithCollector(collector))
+}
+
+// WithServiceKey sets the service key in the form of `<api token>:<service
+// name>` (SW_APM_SERVICE_KEY).
+func WithServiceKey(key string) Option {
+	return withConfig(config.WithServiceKey(key))
+}
+
+// WithTrustedPath sets the path of the cert file used to connect to the
+// collector (SW_APM_TRUSTEDPATH).
+func WithTrustedPath(path string) Option {
+	return withConfig(config.WithTrustedPath(path))
+}
+
+// WithReporterType sets the reporter type, ssl, serverless or none
+// (SW_APM_REPORTER).
+func WithReporterType(typ string) Option {
+	return withConfig(config.WithReporterType(typ))
+}
+
+// WithTracingMode sets the local tracing mode (SW_APM_TRACING_MODE).
+func WithTracingMode(mode TracingMode) Option {
+	return withConfig(config.WithTracingMode(mode))
+}
+
+// WithSampleRate sets the local sample rate, from 0 to 1000000
+// (SW_APM_SAMPLE_RATE).
+func WithSampleRate(rate int) Option {
+	return withConfig(config.WithSampleRate(rate))
+}
+
+// WithTransactionSettings sets the transaction filters, replacing the ones
+// from the config file.
+func WithTransactionSettings(filters ...TransactionFilter) Option {
+	return withConfig(config.WithTransactionSettings(filters...))
+}
+
+// WithPrependDomain sets whether the domain is prepended to the transaction
+// name (SW_APM_PREPEND_DOMAIN).
+func WithPrependDomain(prepend bool) Option {
+	return withConfig(config.WithPrependDomain(prepend))
+}
+
+// WithHostAlias sets the alias of the hostname (SW_APM_HOSTNAME_ALIAS).
+func WithHostAlias(alias string) Option {
+	return withConfig(config.WithHostAlias(alias))
+}
+
+// WithHistogramPrecision sets the precision of the histograms
+// (SW_APM_HISTOGRAM_PRECISION).
+func WithHistogramPrecision(precision int) Option {
+	return withConfig(config.WithPrecision(precision))
+}
+
+// WithSQLSanitize sets the SQL sanitization level (SW_APM_SQL_SANITIZE).
+func WithSQLSanitize(level int) Option {
+	return withConfig(config.WithSQLSanitize(level))
+}
+
+// WithEnabled enables or disables the agent (SW_APM_ENABLED).
+func WithEnabled(enabled bool) Option {
+	return withConfig(config.WithEnabled(enabled))
+}
+
+// WithEc2MetadataTimeout sets the EC2 metadata retrieval timeout in
+// milliseconds (SW_APM_EC2_METADATA_TIMEOUT).
+func WithEc2MetadataTimeout(timeout int) Option {
+	return withConfig(config.WithEc2MetadataTimeout(timeout))
+}
+
+// WithDebugLevel sets the log level (SW_APM_DEBUG_LEVEL).
+func WithDebugLevel(level string) Option {
+	return withConfig(config.WithDebugLevel(level))
+}
+
+// WithTriggerTrace enables or disables trigger trace (SW_APM_TRIGGER_TRACE).
+func WithTriggerTrace(enabled bool) Option {
+	return withConfig(config.WithTriggerTrace(enabled))
+}
+
+// WithProxy sets the url of the HTTP/HTTPS/SOCKS5 proxy in the format of
+// `scheme://<username>:<password>@<host>:<port>` (SW_APM_PROXY).
+func WithProxy(proxy string) Option {
+	return withConfig(config.WithProxy(proxy))
+}
+
+// WithProxyCertPath sets the cert path of the HTTP/HTTPS proxy
+// (SW_APM_PROXY_CERT_PATH).
+func WithProxyCertPath(path string) Option {
+	return withConfig(config.WithProxyCertPath(path))
+}
+
+// WithRuntimeMetrics enables or disables the runtime metrics
+// (SW_APM_RUNTIME_METRICS).
+func WithRuntimeMetrics(enabled bool) Option {
+	return withConfig(config.WithRuntimeMetrics(enabled))
+}
+
+// HostMetricsGroups are the groups of the host metrics reported on Linux.
+type HostMetricsGroups = config.HostMetricsGroups
+
+// WithHostMetrics sets the groups of the host metrics to report
+// (SW_APM_HOST_METRICS_CPU, SW_APM_HOST_METRICS_PROCESS, SW_APM_HOST_METRICS_FDS,
+// SW_APM_HOST_METRICS_NETWORK and SW_APM_HOST_METRICS_DISK).
+func WithHostMetrics(groups HostMetricsGroups) Option {
+	return withConfig(config.WithHostMetrics(groups))
+}
+
+// WithTransactionNameRules sets the cardinality controls of the transaction
+// names: the maximum number of names per metrics flush interval, which can only
+// lower the collector's maximum, zero for the collector's maximum
+// (SW_APM_TRANSACTION_NAME_MAX), whether the numeric and
+// UUID path segments are collapsed into {id}
+// (SW_APM_TRANSACTION_NAME_COLLAPSE_IDS), the maximum number of path segments,
+// zero for no limit (SW_APM_TRANSACTION_NAME_PATH_DEPTH), and the names which
+// are always reported (SW_APM_TRANSACTION_NAME_ALLOW_LIST).
+func WithTransactionNameRules(max int, collapseIDs bool, pathDepth int, allowList ...string) Option {
+	return withConfig(config.WithTransactionNameRules(max, collapseIDs, pathDepth, allowList...))
+}
+
+// WithReportQueryString sets whether the query string is reported as part of
+// the URL (SW_APM_REPORT_QUERY_STRING).
+func WithReportQueryString(enabled bool) Option {
+	return withConfig(config.WithReportQueryString(enabled))
+}
+
+// WithTokenBucket sets the capacity and the rate of the token bucket
+// (SW_APM_TOKEN_BUCKET_CAPACITY and SW_APM_TOKEN_BUCKET_RATE).
+func WithTokenBucket(capacity, rate float64) Option {
+	return withConfig(config.WithTokenBucket(capacity, rate))
+}
+
+// WithTransactionName sets the transaction name of all the requests. It's only
+// available in the AWS Lambda environment (SW_APM_TRANSACTION_NAME).
+func WithTransactionName(name string) Option {
+	return withConfig(config.WithTransactionName(name))
+}
+
+// WithConfigReload reloads the config file every interval seconds, and/or on
+// SIGHUP (SW_APM_CONFIG_RELOAD_INTERVAL and SW_APM_CONFIG_RELOAD_ON_SIGHUP).
+// It has no effect on the agents created by NewAgent.
+func WithConfigReload(interval int, onSIGHUP bool) Option {
+	return withConfig(config.WithConfigReload(interval, onSIGHUP))
+}
+
+// WithCaptureFile captures the events, metrics and status messages sent to
+// the collector into the file (SW_APM_CAPTURE_FILE), which can be replayed by
+// the swo-replay command. The file contains the service key and is only
+// readable by the owner.
+func WithCaptureFile(path string) Option {
+	return withConfig(config.WithCaptureFile(path))
+}
+
+// WithReporterProperties modifies the reporter options, e.g., the flush
+// intervals and the event queue policy, after they are loaded from the config
+// file and the environment variables. Only the fields set by fn are changed.
+func WithReporterProperties(fn func(r *ReporterOptions)) Option {
+	return withConfig(config.WithReporterProperties(fn))
+}
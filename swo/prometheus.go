@@ -0,0 +1,61 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package swo
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/solarwinds/apm-go/internal/log"
+	"github.com/solarwinds/apm-go/internal/reporter"
+)
+
+// prometheusContentType is the content type of the Prometheus text format
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// PrometheusHandler returns an http.Handler which serves the metrics of the
+// agent in the Prometheus text format: the inbound and custom metrics, the
+// histograms with classic buckets, the event queue stats and the request
+// counters.
+//
+// The counters, summaries and histograms are cumulative since the agent was
+// started: the values sent to the collector are kept as running totals, and
+// the values of the current metrics flush interval are added to them, so
+// nothing is lost when the metrics are flushed. The gauges are the current
+// values. Scraping doesn't reset anything, so the metrics sent to the
+// collector are not affected.
+//
+// The handler is not registered anywhere by the agent, for example:
+//
+//	http.Handle("/metrics", swo.PrometheusHandler())
+func PrometheusHandler() http.Handler {
+	return prometheusHandler(reporter.WritePrometheus)
+}
+
+// PrometheusHandler returns an http.Handler which serves the metrics of the
+// agent in the Prometheus text format, see the package-level
+// PrometheusHandler.
+func (a *Agent) PrometheusHandler() http.Handler {
+	return prometheusHandler(a.rep.WritePrometheus)
+}
+
+func prometheusHandler(write func(io.Writer) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", prometheusContentType)
+		if err := write(w); err != nil {
+			log.Warningf("failed to write the Prometheus metrics: %v", err)
+		}
+	})
+}
@@ -0,0 +1,47 @@
+// © 2023 SolarWinds Worldwide, LLC. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package swo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusHandler(t *testing.T) {
+	a, err := NewAgent(WithServiceKey(testServiceKey), WithCollector("localhost:4567"))
+	require.NoError(t, err)
+	defer func() { _ = a.Shutdown(context.Background()) }()
+	require.NoError(t, a.IncrementMetric("hits", MetricOptions{Count: 3}))
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		a.PrometheusHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, prometheusContentType, rec.Header().Get("Content-Type"))
+		// scraping doesn't reset the metrics
+		assert.Contains(t, rec.Body.String(), "sw_apm_custom_hits_total 3\n")
+		assert.Contains(t, rec.Body.String(), "# TYPE sw_apm_NumSent counter\n")
+	}
+
+	rec := httptest.NewRecorder()
+	PrometheusHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "sw_apm_custom_hits_total")
+}